@@ -0,0 +1,103 @@
+// Command rerate recomputes the cost of stored consumption records after a
+// price change and reports the difference per organization and day.
+//
+//	go run ./cmd/rerate -org acme -from 2024-05-01 -to 2024-06-01          # dry run
+//	go run ./cmd/rerate -org acme -from 2024-05-01 -to 2024-06-01 -apply   # update records, adjust wallets
+//	go run ./cmd/rerate -resume <rerating id>                              # finish an interrupted -apply run
+//
+// It reads the same environment as the server (MONGODB_*).
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"freedom-ai/management-server/internal/config"
+	"freedom-ai/management-server/internal/database"
+	"freedom-ai/management-server/internal/services/aggregation"
+	"freedom-ai/management-server/internal/services/consumption"
+	"freedom-ai/management-server/internal/services/rerating"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+func main() {
+	orgFlag := flag.String("org", "", "organization to re-rate (default: all)")
+	fromFlag := flag.String("from", "", "first day to re-rate in each organization's billing timezone (YYYY-MM-DD, inclusive)")
+	toFlag := flag.String("to", "", "last day to re-rate in each organization's billing timezone (YYYY-MM-DD, exclusive)")
+	priceFlag := flag.String("price", "", "model_prices entry to apply to its model (default: the entry valid at each record's time)")
+	rateCardFlag := flag.Int("rate-card-version", 0, "rate card version of -org to apply (default: the version in force at each record's time)")
+	apply := flag.Bool("apply", false, "update records and issue wallet adjustments (default is a dry run)")
+	resumeFlag := flag.String("resume", "", "pending rerating_runs entry to finish; the other flags are ignored")
+	flag.Parse()
+
+	var opts rerating.Options
+	var resumeID primitive.ObjectID
+	var err error
+	if *resumeFlag != "" {
+		if resumeID, err = primitive.ObjectIDFromHex(*resumeFlag); err != nil {
+			log.Fatalf("Invalid -resume: %v", err)
+		}
+	} else {
+		from, err := time.Parse("2006-01-02", *fromFlag)
+		if err != nil {
+			log.Fatalf("Invalid -from: %v", err)
+		}
+		to, err := time.Parse("2006-01-02", *toFlag)
+		if err != nil {
+			log.Fatalf("Invalid -to: %v", err)
+		}
+		if !from.Before(to) {
+			log.Fatal("-from must be before -to")
+		}
+
+		opts = rerating.Options{
+			OrgID:           *orgFlag,
+			From:            from,
+			To:              to,
+			RateCardVersion: *rateCardFlag,
+			Apply:           *apply,
+		}
+		if *priceFlag != "" {
+			if opts.PriceID, err = primitive.ObjectIDFromHex(*priceFlag); err != nil {
+				log.Fatalf("Invalid -price: %v", err)
+			}
+		}
+	}
+
+	cfg := config.Load()
+	logger, err := zap.NewProduction()
+	if err != nil {
+		log.Fatalf("Failed to initialize logger: %v", err)
+	}
+	defer logger.Sync()
+
+	db, err := database.NewMongoDB(cfg.MongoDBURI, cfg.MongoDBDatabase, logger)
+	if err != nil {
+		logger.Fatal("Failed to connect to MongoDB", zap.Error(err))
+	}
+	defer db.Disconnect(context.Background())
+
+	consumptionService := consumption.NewService(db.Database, cfg, logger)
+	aggregationService := aggregation.NewService(db.Database, logger)
+	reratingService := rerating.NewService(db.Database, consumptionService, aggregationService, logger)
+
+	var report *rerating.Report
+	if resumeID.IsZero() {
+		report, err = reratingService.Run(context.Background(), opts)
+	} else {
+		report, err = reratingService.Resume(context.Background(), resumeID)
+	}
+	if err != nil {
+		logger.Fatal("Re-rating failed", zap.Error(err))
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(report)
+}
@@ -0,0 +1,293 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"freedom-ai/management-server/internal/config"
+	"freedom-ai/management-server/internal/metrics"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+const (
+	// maxBufferedEvents bounds memory while the store is unavailable; Record
+	// blocks beyond it
+	maxBufferedEvents = 100000
+
+	maxLineSize = 16 * 1024 * 1024
+)
+
+// Entry is one archived raw event, stored as a line of NDJSON
+type Entry struct {
+	ReceivedAt    time.Time       `json:"receivedAt"`
+	Source        string          `json:"source"`
+	Kind          string          `json:"kind"`
+	SchemaVersion int             `json:"schemaVersion,omitempty"`
+	Body          json.RawMessage `json:"body"`
+}
+
+// Archive buffers raw events and writes them as gzip-compressed NDJSON chunks
+// partitioned by date and hour: events/dt=YYYY-MM-DD/hour=HH/<id>.ndjson.gz
+type Archive struct {
+	store         Store
+	flushInterval time.Duration
+	flushSize     int
+	logger        *zap.Logger
+
+	mu      sync.Mutex
+	space   *sync.Cond // signalled when a flush takes events from pending
+	pending []pendingEntry
+}
+
+// pendingEntry is a buffered event and the channel closed once it is written
+type pendingEntry struct {
+	entry   Entry
+	written chan struct{}
+}
+
+// New returns the archive configured by ARCHIVE_BACKEND, or nil when archiving is disabled
+func New(cfg *config.Config, logger *zap.Logger) (*Archive, error) {
+	var store Store
+	var err error
+	switch cfg.ArchiveBackend {
+	case "", "none":
+		return nil, nil
+	case "local":
+		store, err = NewLocalStore(cfg.ArchiveDir)
+	case "s3":
+		store, err = NewS3Store(cfg.ArchiveS3Endpoint, cfg.ArchiveS3AccessKey, cfg.ArchiveS3SecretKey, cfg.ArchiveS3Bucket, cfg.ArchiveS3Prefix, cfg.ArchiveS3UseSSL)
+	default:
+		return nil, fmt.Errorf("unknown archive backend %q", cfg.ArchiveBackend)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	a := &Archive{
+		store:         store,
+		flushInterval: time.Duration(cfg.ArchiveFlushSeconds) * time.Second,
+		flushSize:     cfg.ArchiveFlushSize,
+		logger:        logger,
+	}
+	a.space = newCond(a)
+	return a, nil
+}
+
+func newCond(a *Archive) *sync.Cond {
+	return sync.NewCond(&a.mu)
+}
+
+// Record queues a raw event for archiving and returns a channel that is closed
+// once the event has been written to the store. Callers must not acknowledge
+// the event's source before then. The body is copied. While maxBufferedEvents
+// events are waiting, Record blocks until a flush takes some, so an
+// unavailable store slows ingestion down instead of losing events.
+func (a *Archive) Record(source, kind string, schemaVersion int, body []byte) <-chan struct{} {
+	pending := pendingEntry{
+		entry: Entry{
+			ReceivedAt:    time.Now().UTC(),
+			Source:        source,
+			Kind:          kind,
+			SchemaVersion: schemaVersion,
+			Body:          archivedBody(body),
+		},
+		written: make(chan struct{}),
+	}
+
+	a.mu.Lock()
+	if len(a.pending) >= maxBufferedEvents {
+		metrics.Archive.Add("blocked", 1)
+		for len(a.pending) >= maxBufferedEvents {
+			a.space.Wait()
+		}
+	}
+	a.pending = append(a.pending, pending)
+	full := a.flushSize > 0 && len(a.pending) >= a.flushSize
+	a.mu.Unlock()
+
+	if full {
+		go a.Flush(context.Background())
+	}
+	return pending.written
+}
+
+// Wait blocks until every channel returned by Record is closed, or ctx is done
+func Wait(ctx context.Context, written ...<-chan struct{}) error {
+	for _, ch := range written {
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return fmt.Errorf("event archive not written: %w", ctx.Err())
+		}
+	}
+	return nil
+}
+
+// archivedBody keeps valid JSON as-is and wraps anything else in a JSON string,
+// so malformed events are archived too
+func archivedBody(body []byte) json.RawMessage {
+	if json.Valid(body) {
+		return append(json.RawMessage(nil), body...)
+	}
+	quoted, _ := json.Marshal(string(body))
+	return quoted
+}
+
+// Run flushes the buffer on the flush interval until ctx is cancelled, then
+// flushes once more
+func (a *Archive) Run(ctx context.Context) {
+	interval := a.flushInterval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := a.Flush(context.Background()); err != nil {
+				a.logger.Error("Failed to flush event archive on shutdown", zap.Error(err))
+			}
+			return
+		case <-ticker.C:
+			if err := a.Flush(ctx); err != nil {
+				a.logger.Error("Failed to flush event archive", zap.Error(err))
+			}
+		}
+	}
+}
+
+// Flush writes buffered events, one chunk per hour partition. Events that fail
+// to write are kept for the next flush.
+func (a *Archive) Flush(ctx context.Context) error {
+	a.mu.Lock()
+	entries := a.pending
+	a.pending = nil
+	a.space.Broadcast()
+	a.mu.Unlock()
+
+	if len(entries) == 0 {
+		return nil
+	}
+
+	partitions := make(map[string][]pendingEntry)
+	var order []string
+	for _, pending := range entries {
+		prefix := partitionPrefix(pending.entry.ReceivedAt)
+		if _, ok := partitions[prefix]; !ok {
+			order = append(order, prefix)
+		}
+		partitions[prefix] = append(partitions[prefix], pending)
+	}
+
+	var failed []pendingEntry
+	var errs []error
+	for _, prefix := range order {
+		chunk := partitions[prefix]
+		if err := a.writeChunk(ctx, prefix, chunk); err != nil {
+			failed = append(failed, chunk...)
+			errs = append(errs, err)
+			continue
+		}
+		for _, pending := range chunk {
+			close(pending.written)
+		}
+		metrics.Archive.Add("events", int64(len(chunk)))
+		metrics.Archive.Add("chunks", 1)
+	}
+
+	if len(failed) > 0 {
+		metrics.Archive.Add("flush_errors", 1)
+		a.mu.Lock()
+		a.pending = append(failed, a.pending...)
+		a.mu.Unlock()
+	}
+	return errors.Join(errs...)
+}
+
+func (a *Archive) writeChunk(ctx context.Context, prefix string, entries []pendingEntry) error {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	encoder := json.NewEncoder(gz)
+	for _, pending := range entries {
+		if err := encoder.Encode(pending.entry); err != nil {
+			return fmt.Errorf("failed to encode archive entry: %w", err)
+		}
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to compress archive chunk: %w", err)
+	}
+
+	// Chunk names sort by creation time within a partition
+	key := fmt.Sprintf("%s%s.ndjson.gz", prefix, primitive.NewObjectID().Hex())
+	if err := a.store.Write(ctx, key, buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to write archive chunk %s: %w", key, err)
+	}
+	return nil
+}
+
+func partitionPrefix(t time.Time) string {
+	t = t.UTC()
+	return fmt.Sprintf("events/dt=%s/hour=%02d/", t.Format("2006-01-02"), t.Hour())
+}
+
+// Scan calls fn for every archived event received in [from, to), in the
+// order the chunks were written
+func (a *Archive) Scan(ctx context.Context, from, to time.Time, fn func(Entry) error) error {
+	for hour := from.UTC().Truncate(time.Hour); hour.Before(to); hour = hour.Add(time.Hour) {
+		keys, err := a.store.List(ctx, partitionPrefix(hour))
+		if err != nil {
+			return fmt.Errorf("failed to list archive partition %s: %w", partitionPrefix(hour), err)
+		}
+
+		for _, key := range keys {
+			if err := a.scanChunk(ctx, key, from, to, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (a *Archive) scanChunk(ctx context.Context, key string, from, to time.Time, fn func(Entry) error) error {
+	r, err := a.store.Open(ctx, key)
+	if err != nil {
+		return fmt.Errorf("failed to open archive chunk %s: %w", key, err)
+	}
+	defer r.Close()
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("failed to read archive chunk %s: %w", key, err)
+	}
+	defer gz.Close()
+
+	scanner := bufio.NewScanner(gz)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return fmt.Errorf("failed to decode archive entry in %s: %w", key, err)
+		}
+		if entry.ReceivedAt.Before(from) || !entry.ReceivedAt.Before(to) {
+			continue
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read archive chunk %s: %w", key, err)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// failingStore fails writes while down is set
+type failingStore struct {
+	*LocalStore
+	down atomic.Bool
+}
+
+func (s *failingStore) Write(ctx context.Context, key string, data []byte) error {
+	if s.down.Load() {
+		return errors.New("store unavailable")
+	}
+	return s.LocalStore.Write(ctx, key, data)
+}
+
+func newTestArchive(t *testing.T) (*Archive, *failingStore) {
+	t.Helper()
+	local, err := NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStore: %v", err)
+	}
+	store := &failingStore{LocalStore: local}
+	a := &Archive{store: store, logger: zap.NewNop()}
+	a.space = newCond(a)
+	return a, store
+}
+
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestRecordSignalsOnlyAfterWrite(t *testing.T) {
+	a, store := newTestArchive(t)
+	store.down.Store(true)
+
+	written := a.Record("test", "request", 1, []byte(`{"requestId":"r1"}`))
+	if isClosed(written) {
+		t.Fatal("event reported written before any flush")
+	}
+
+	if err := a.Flush(context.Background()); err == nil {
+		t.Fatal("Flush succeeded against an unavailable store")
+	}
+	if isClosed(written) {
+		t.Fatal("event reported written after a failed flush")
+	}
+
+	store.down.Store(false)
+	if err := a.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if !isClosed(written) {
+		t.Fatal("event not reported written after a successful flush")
+	}
+
+	var scanned int
+	err := a.Scan(context.Background(), time.Now().Add(-time.Hour), time.Now().Add(time.Hour), func(Entry) error {
+		scanned++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if scanned != 1 {
+		t.Fatalf("scanned %d events, want 1", scanned)
+	}
+}
+
+func TestRecordBlocksWhenBufferFull(t *testing.T) {
+	a, store := newTestArchive(t)
+	store.down.Store(true)
+
+	a.mu.Lock()
+	a.pending = make([]pendingEntry, maxBufferedEvents)
+	a.mu.Unlock()
+
+	recorded := make(chan struct{})
+	go func() {
+		a.Record("test", "request", 1, []byte(`{}`))
+		close(recorded)
+	}()
+
+	select {
+	case <-recorded:
+		t.Fatal("Record did not block on a full buffer")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// A flush takes the buffered events and makes room, even if it then fails
+	a.mu.Lock()
+	for i := range a.pending {
+		a.pending[i].written = make(chan struct{})
+	}
+	a.mu.Unlock()
+	store.down.Store(false)
+	if err := a.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	select {
+	case <-recorded:
+	case <-time.After(time.Second):
+		t.Fatal("Record still blocked after a flush")
+	}
+}
@@ -0,0 +1,84 @@
+// Package databasetest connects tests to a throwaway MongoDB database.
+//
+// Tests that need MongoDB are skipped unless TEST_MONGODB_URI is set, e.g.
+//
+//	TEST_MONGODB_URI="mongodb://localhost:27017/?replicaSet=rs0" go test ./...
+package databasetest
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// URIEnv points at the server used for tests
+	URIEnv = "TEST_MONGODB_URI"
+	// StandaloneURIEnv points at a server without replica set, for tests of
+	// the non-transactional fallback
+	StandaloneURIEnv = "TEST_MONGODB_STANDALONE_URI"
+)
+
+// Connect returns an empty database on the TEST_MONGODB_URI server, dropped when
+// the test ends. The test is skipped when the variable is unset.
+func Connect(tb testing.TB) *mongo.Database {
+	tb.Helper()
+	return connect(tb, URIEnv)
+}
+
+// ConnectStandalone is Connect for the TEST_MONGODB_STANDALONE_URI server
+func ConnectStandalone(tb testing.TB) *mongo.Database {
+	tb.Helper()
+	return connect(tb, StandaloneURIEnv)
+}
+
+// ConnectReplicaSet is Connect, skipping the test unless the server is a
+// replica set member and so supports transactions
+func ConnectReplicaSet(tb testing.TB) *mongo.Database {
+	tb.Helper()
+	db := Connect(tb)
+
+	var hello bson.M
+	if err := db.RunCommand(context.Background(), bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		tb.Fatalf("hello: %v", err)
+	}
+	if _, ok := hello["setName"]; !ok {
+		tb.Skipf("%s is not a replica set member", URIEnv)
+	}
+	return db
+}
+
+func connect(tb testing.TB, env string) *mongo.Database {
+	tb.Helper()
+	uri := os.Getenv(env)
+	if uri == "" {
+		tb.Skipf("%s not set", env)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		tb.Fatalf("connect to %s: %v", env, err)
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		tb.Fatalf("ping %s: %v", env, err)
+	}
+
+	db := client.Database(fmt.Sprintf("test_%s", primitive.NewObjectID().Hex()))
+	tb.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = db.Drop(ctx)
+		_ = client.Disconnect(ctx)
+	})
+	return db
+}
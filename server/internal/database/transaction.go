@@ -0,0 +1,55 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+// illegalOperation is returned by standalone servers for transactional commands
+const illegalOperation = 20
+
+// standalone is set once the server turned out not to support transactions
+var standalone atomic.Bool
+
+// Transact runs fn in a multi-document transaction. fn must use the context it
+// is given. Called inside a transaction, fn joins it. On a standalone server,
+// which has no transactions, fn runs without one and a warning is logged once.
+func Transact(ctx context.Context, db *mongo.Database, logger *zap.Logger, fn func(ctx context.Context) error) error {
+	if standalone.Load() || InTransaction(ctx) {
+		return fn(ctx)
+	}
+
+	session, err := db.Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	if isTransactionUnsupported(err) {
+		if standalone.CompareAndSwap(false, true) {
+			logger.Warn("MongoDB does not support transactions; multi-document writes run without them")
+		}
+		return fn(ctx)
+	}
+	return err
+}
+
+// InTransaction reports whether ctx carries a session with an open transaction
+func InTransaction(ctx context.Context) bool {
+	return mongo.SessionFromContext(ctx) != nil && !standalone.Load()
+}
+
+func isTransactionUnsupported(err error) bool {
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) {
+		return cmdErr.Code == illegalOperation
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"freedom-ai/management-server/internal/database/databasetest"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+func TestIsTransactionUnsupported(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{mongo.CommandError{Code: illegalOperation, Message: "Transaction numbers are only allowed on a replica set member or mongos"}, true},
+		{fmt.Errorf("commit: %w", mongo.CommandError{Code: illegalOperation}), true},
+		{mongo.CommandError{Code: 112, Message: "WriteConflict"}, false},
+		{errors.New("connection refused"), false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := isTransactionUnsupported(tt.err); got != tt.want {
+			t.Errorf("isTransactionUnsupported(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestTransactFallsBackOnStandalone(t *testing.T) {
+	db := databasetest.ConnectStandalone(t)
+	t.Cleanup(func() { standalone.Store(false) })
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		err := Transact(ctx, db, zap.NewNop(), func(ctx context.Context) error {
+			if InTransaction(ctx) {
+				return errors.New("standalone server reported an open transaction")
+			}
+			_, err := db.Collection("items").InsertOne(ctx, bson.M{"n": i})
+			return err
+		})
+		if err != nil {
+			t.Fatalf("Transact %d: %v", i, err)
+		}
+		if !standalone.Load() {
+			t.Fatal("Transact did not remember that the server is standalone")
+		}
+	}
+
+	count, err := db.Collection("items").CountDocuments(ctx, bson.M{})
+	if err != nil {
+		t.Fatalf("count: %v", err)
+	}
+	if count != 2 {
+		t.Fatalf("stored %d documents, want 2", count)
+	}
+}
@@ -0,0 +1,68 @@
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// contentFields are the event fields that carry customer prompt or completion
+// content, in any schema version and at any depth
+var contentFields = map[string]bool{
+	"messages":     true,
+	"systemPrompt": true,
+	"tools":        true,
+	"response":     true,
+	"prompt":       true,
+	"completion":   true,
+	"content":      true,
+}
+
+// StripContent removes prompt and completion content from a raw event body,
+// including content nested in objects and arrays, leaving every field
+// consumption processing needs. Bodies that are not JSON objects are replaced
+// by an empty object.
+func StripContent(body []byte) []byte {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	// Keep numbers exactly as sent
+	decoder.UseNumber()
+	var fields map[string]interface{}
+	if err := decoder.Decode(&fields); err != nil || fields == nil {
+		return []byte("{}")
+	}
+
+	if !stripFields(fields) {
+		return body
+	}
+
+	out, err := json.Marshal(fields)
+	if err != nil {
+		return []byte("{}")
+	}
+	return out
+}
+
+// stripFields deletes content fields from value and everything below it, and
+// reports whether it deleted any
+func stripFields(value interface{}) bool {
+	stripped := false
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if contentFields[key] {
+				delete(v, key)
+				stripped = true
+				continue
+			}
+			if stripFields(child) {
+				stripped = true
+			}
+		}
+	case []interface{}:
+		for _, child := range v {
+			if stripFields(child) {
+				stripped = true
+			}
+		}
+	}
+	return stripped
+}
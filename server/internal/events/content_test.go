@@ -0,0 +1,94 @@
+package events
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const secret = "my password is hunter2"
+
+func TestStripContent(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		// keep are fields, as dotted paths, that must survive stripping
+		keep []string
+	}{
+		{
+			name: "v1 request",
+			body: `{"requestId":"r1","organizationId":"org-1","model":"gpt-4","systemPrompt":"` + secret + `",
+				"messages":[{"role":"user","content":"` + secret + `"}],"tools":[{"name":"` + secret + `"}],"tokenCount":12}`,
+			keep: []string{"requestId", "organizationId", "model", "tokenCount"},
+		},
+		{
+			name: "v2 response",
+			body: `{"schemaVersion":2,"requestId":"r1","orgId":"org-1","response":"` + secret + `",
+				"usage":{"inputTokens":10,"outputTokens":5,"totalTokens":15}}`,
+			keep: []string{"schemaVersion", "orgId", "usage.inputTokens", "usage.totalTokens"},
+		},
+		{
+			name: "v2 nested request and response bodies",
+			body: `{"schemaVersion":2,"requestId":"r1","orgId":"org-1",
+				"request":{"model":"gpt-4","messages":[{"role":"user","content":"` + secret + `"}],"systemPrompt":"` + secret + `"},
+				"output":{"choices":[{"finishReason":"stop","content":"` + secret + `"}]},
+				"usage":{"inputTokens":10,"outputTokens":5,"totalTokens":15}}`,
+			keep: []string{"requestId", "request.model", "output.choices", "usage.outputTokens"},
+		},
+		{
+			name: "content nested in arrays",
+			body: `{"requestId":"r1","events":[{"delta":{"completion":"` + secret + `"}},{"prompt":"` + secret + `","tokens":3}]}`,
+			keep: []string{"requestId", "events"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := StripContent([]byte(tt.body))
+			if strings.Contains(string(out), secret) {
+				t.Fatalf("stripped body still contains content: %s", out)
+			}
+
+			var fields map[string]interface{}
+			if err := json.Unmarshal(out, &fields); err != nil {
+				t.Fatalf("stripped body is not JSON: %v", err)
+			}
+			for _, path := range tt.keep {
+				if lookup(fields, path) == nil {
+					t.Fatalf("%s missing from stripped body: %s", path, out)
+				}
+			}
+		})
+	}
+}
+
+func TestStripContentKeepsBodiesWithoutContent(t *testing.T) {
+	// Returned unchanged, so large numbers are not rounded through float64
+	body := `{"requestId":"r1","usage":{"totalTokens":9007199254740993}}`
+	if out := StripContent([]byte(body)); string(out) != body {
+		t.Fatalf("StripContent = %s, want the body unchanged", out)
+	}
+
+	stripped := StripContent([]byte(`{"requestId":"r1","response":"x","usage":{"totalTokens":9007199254740993}}`))
+	if !strings.Contains(string(stripped), "9007199254740993") {
+		t.Fatalf("StripContent = %s, want token counts kept exactly", stripped)
+	}
+
+	for _, body := range []string{`not json`, `[{"messages":[]}]`, `null`} {
+		if out := StripContent([]byte(body)); string(out) != "{}" {
+			t.Fatalf("StripContent(%s) = %s, want {}", body, out)
+		}
+	}
+}
+
+// lookup returns the value at a dotted path, or nil
+func lookup(fields map[string]interface{}, path string) interface{} {
+	var value interface{} = fields
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[key]
+	}
+	return value
+}
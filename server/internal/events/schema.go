@@ -0,0 +1,292 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"freedom-ai/management-server/internal/models"
+)
+
+// SchemaVersionHeader carries the event schema version. When it is absent the
+// schemaVersion body field is used, and events without either are version 1.
+const SchemaVersionHeader = "x-schema-version"
+
+const (
+	// SchemaV1 is the original collector format (organizationId, usage.promptTokens, ...)
+	SchemaV1 = 1
+	// SchemaV2 renames organizationId to orgId and reports usage as inputTokens/outputTokens
+	SchemaV2 = 2
+
+	LatestSchemaVersion = SchemaV2
+)
+
+// Rejection reasons reported in logs and on the rejected_events metric
+const (
+	ReasonMalformed          = "malformed"
+	ReasonUnsupportedVersion = "unsupported_schema_version"
+	ReasonMissingRequestID   = "missing_request_id"
+	ReasonMissingOrgID       = "missing_organization_id"
+	ReasonMissingUserID      = "missing_user_id"
+	ReasonMissingModel       = "missing_model"
+	ReasonInvalidUsage       = "invalid_usage"
+	// ReasonOrgMismatch rejects HTTP events for an organization other than the API key's
+	ReasonOrgMismatch = "organization_mismatch"
+)
+
+// ValidationError is returned for events that cannot be decoded or fail validation
+type ValidationError struct {
+	Reason string
+	Detail string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Detail == "" {
+		return e.Reason
+	}
+	return e.Reason + ": " + e.Detail
+}
+
+func reject(reason, format string, args ...interface{}) *ValidationError {
+	return &ValidationError{Reason: reason, Detail: fmt.Sprintf(format, args...)}
+}
+
+// VersionFromHeader parses a schema version header value. It returns 0 when the
+// header is absent so the body field can decide.
+func VersionFromHeader(value interface{}) int {
+	switch v := value.(type) {
+	case int:
+		return v
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case string:
+		n, err := strconv.Atoi(strings.TrimPrefix(strings.TrimSpace(v), "v"))
+		if err == nil {
+			return n
+		}
+		return -1
+	case nil:
+		return 0
+	}
+	return -1
+}
+
+// v2Request is the version 2 request layout
+type v2Request struct {
+	RequestID      string        `json:"requestId"`
+	Timestamp      time.Time     `json:"timestamp"`
+	UserID         string        `json:"userId"`
+	OrgID          string        `json:"orgId"`
+	Model          string        `json:"model"`
+	Messages       []interface{} `json:"messages"`
+	SystemPrompt   string        `json:"systemPrompt"`
+	Tools          []interface{} `json:"tools"`
+	CharacterCount int           `json:"characterCount"`
+	TokenCount     int           `json:"tokenCount"`
+	RequestType    string        `json:"requestType"`
+	ToolCount      int           `json:"toolCount"`
+	MessageCount   int           `json:"messageCount"`
+	Temperature    float64       `json:"temperature"`
+	MaxTokens      int           `json:"maxTokens"`
+	TopP           float64       `json:"topP"`
+	AssistantType  string        `json:"assistantType"`
+	DocumentID     string        `json:"documentId"`
+	ConversationID string        `json:"conversationId"`
+}
+
+// v2Usage is the version 2 usage block
+type v2Usage struct {
+	InputTokens       int `json:"inputTokens"`
+	OutputTokens      int `json:"outputTokens"`
+	TotalTokens       int `json:"totalTokens"`
+	CachedInputTokens int `json:"cachedInputTokens"`
+	ReasoningTokens   int `json:"reasoningTokens"`
+	ImageTokens       int `json:"imageTokens"`
+	AudioTokens       int `json:"audioTokens"`
+}
+
+// v2Response is the version 2 response layout
+type v2Response struct {
+	RequestID      string    `json:"requestId"`
+	Timestamp      time.Time `json:"timestamp"`
+	UserID         string    `json:"userId"`
+	OrgID          string    `json:"orgId"`
+	Model          string    `json:"model"`
+	Response       string    `json:"response"`
+	CharacterCount int       `json:"characterCount"`
+	TokenCount     int       `json:"tokenCount"`
+	FinishReason   string    `json:"finishReason"`
+	Usage          *v2Usage  `json:"usage"`
+	ResponseTimeMs int64     `json:"responseTimeMs"`
+	HasToolCalls   bool      `json:"hasToolCalls"`
+	ToolCallCount  int       `json:"toolCallCount"`
+	AssistantType  string    `json:"assistantType"`
+	DocumentID     string    `json:"documentId"`
+	ConversationID string    `json:"conversationId"`
+}
+
+// DecodeRequest decodes and validates a request event. headerVersion is the
+// value of SchemaVersionHeader, or 0 when the transport has no header.
+func DecodeRequest(body []byte, headerVersion int) (*models.LLMRequestData, error) {
+	version, err := resolveVersion(body, headerVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	var request models.LLMRequestData
+	switch version {
+	case SchemaV1:
+		if err := json.Unmarshal(body, &request); err != nil {
+			return nil, reject(ReasonMalformed, "%v", err)
+		}
+	case SchemaV2:
+		var v2 v2Request
+		if err := json.Unmarshal(body, &v2); err != nil {
+			return nil, reject(ReasonMalformed, "%v", err)
+		}
+		request = models.LLMRequestData{
+			RequestID:      v2.RequestID,
+			Timestamp:      v2.Timestamp,
+			UserID:         v2.UserID,
+			OrganizationID: v2.OrgID,
+			Model:          v2.Model,
+			Messages:       v2.Messages,
+			SystemPrompt:   v2.SystemPrompt,
+			Tools:          v2.Tools,
+			CharacterCount: v2.CharacterCount,
+			TokenCount:     v2.TokenCount,
+			RequestType:    v2.RequestType,
+			ToolCount:      v2.ToolCount,
+			MessageCount:   v2.MessageCount,
+			Temperature:    v2.Temperature,
+			MaxTokens:      v2.MaxTokens,
+			TopP:           v2.TopP,
+			AssistantType:  v2.AssistantType,
+			DocumentID:     v2.DocumentID,
+			ConversationID: v2.ConversationID,
+		}
+	}
+	request.SchemaVersion = version
+
+	if err := validateIdentity(request.RequestID, request.OrganizationID, request.UserID, request.Model); err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+// DecodeResponse decodes and validates a response event
+func DecodeResponse(body []byte, headerVersion int) (*models.LLMResponseData, error) {
+	version, err := resolveVersion(body, headerVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	var response models.LLMResponseData
+	switch version {
+	case SchemaV1:
+		if err := json.Unmarshal(body, &response); err != nil {
+			return nil, reject(ReasonMalformed, "%v", err)
+		}
+	case SchemaV2:
+		var v2 v2Response
+		if err := json.Unmarshal(body, &v2); err != nil {
+			return nil, reject(ReasonMalformed, "%v", err)
+		}
+		response = models.LLMResponseData{
+			RequestID:      v2.RequestID,
+			Timestamp:      v2.Timestamp,
+			UserID:         v2.UserID,
+			OrganizationID: v2.OrgID,
+			Model:          v2.Model,
+			Response:       v2.Response,
+			CharacterCount: v2.CharacterCount,
+			TokenCount:     v2.TokenCount,
+			FinishReason:   v2.FinishReason,
+			ResponseTimeMs: v2.ResponseTimeMs,
+			HasToolCalls:   v2.HasToolCalls,
+			ToolCallCount:  v2.ToolCallCount,
+			AssistantType:  v2.AssistantType,
+			DocumentID:     v2.DocumentID,
+			ConversationID: v2.ConversationID,
+		}
+		if v2.Usage != nil {
+			response.Usage = &models.Usage{
+				PromptTokens:       v2.Usage.InputTokens,
+				CompletionTokens:   v2.Usage.OutputTokens,
+				TotalTokens:        v2.Usage.TotalTokens,
+				CachedPromptTokens: v2.Usage.CachedInputTokens,
+				ReasoningTokens:    v2.Usage.ReasoningTokens,
+				ImageTokens:        v2.Usage.ImageTokens,
+				AudioTokens:        v2.Usage.AudioTokens,
+			}
+		}
+	}
+	response.SchemaVersion = version
+
+	if err := validateIdentity(response.RequestID, response.OrganizationID, response.UserID, response.Model); err != nil {
+		return nil, err
+	}
+	if usage := response.Usage; usage != nil {
+		if usage.PromptTokens < 0 || usage.CompletionTokens < 0 || usage.TotalTokens < 0 ||
+			usage.CachedPromptTokens < 0 || usage.ReasoningTokens < 0 || usage.ImageTokens < 0 || usage.AudioTokens < 0 {
+			return nil, reject(ReasonInvalidUsage, "negative token count")
+		}
+		if usage.CachedPromptTokens > usage.PromptTokens {
+			return nil, reject(ReasonInvalidUsage, "cached prompt tokens exceed prompt tokens")
+		}
+		if usage.ReasoningTokens > usage.CompletionTokens {
+			return nil, reject(ReasonInvalidUsage, "reasoning tokens exceed completion tokens")
+		}
+	}
+	return &response, nil
+}
+
+// resolveVersion picks the header version, falling back to the schemaVersion field
+func resolveVersion(body []byte, headerVersion int) (int, error) {
+	version := headerVersion
+	if version == 0 {
+		var envelope struct {
+			SchemaVersion int `json:"schemaVersion"`
+		}
+		if err := json.Unmarshal(body, &envelope); err != nil {
+			return 0, reject(ReasonMalformed, "%v", err)
+		}
+		version = envelope.SchemaVersion
+	}
+	if version == 0 {
+		version = SchemaV1
+	}
+
+	if version < SchemaV1 || version > LatestSchemaVersion {
+		return 0, reject(ReasonUnsupportedVersion, "version %d", version)
+	}
+	return version, nil
+}
+
+func validateIdentity(requestID, orgID, userID, model string) error {
+	switch {
+	case strings.TrimSpace(requestID) == "":
+		return &ValidationError{Reason: ReasonMissingRequestID}
+	case strings.TrimSpace(orgID) == "":
+		return &ValidationError{Reason: ReasonMissingOrgID}
+	case strings.TrimSpace(userID) == "":
+		return &ValidationError{Reason: ReasonMissingUserID}
+	case strings.TrimSpace(model) == "":
+		return &ValidationError{Reason: ReasonMissingModel}
+	}
+	return nil
+}
+
+// Reason returns the rejection reason for err, or "invalid" for other errors
+func Reason(err error) string {
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		return validationErr.Reason
+	}
+	return "invalid"
+}
@@ -0,0 +1,150 @@
+package events
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestVersionFromHeader(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  int
+	}{
+		{nil, 0},
+		{int32(2), 2},
+		{int64(1), 1},
+		{"2", 2},
+		{" v2 ", 2},
+		{"two", -1},
+		{2.0, -1},
+	}
+	for _, tt := range tests {
+		if got := VersionFromHeader(tt.value); got != tt.want {
+			t.Errorf("VersionFromHeader(%#v) = %d, want %d", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeRequestVersions(t *testing.T) {
+	tests := []struct {
+		name          string
+		body          string
+		headerVersion int
+		wantVersion   int
+		wantReason    string
+	}{
+		{
+			name:        "v1 without version",
+			body:        `{"requestId":"r1","organizationId":"org-1","userId":"u1","model":"gpt-4"}`,
+			wantVersion: SchemaV1,
+		},
+		{
+			name:        "v2 from body field",
+			body:        `{"schemaVersion":2,"requestId":"r1","orgId":"org-1","userId":"u1","model":"gpt-4"}`,
+			wantVersion: SchemaV2,
+		},
+		{
+			name:          "header wins over body field",
+			body:          `{"schemaVersion":1,"requestId":"r1","orgId":"org-1","userId":"u1","model":"gpt-4"}`,
+			headerVersion: SchemaV2,
+			wantVersion:   SchemaV2,
+		},
+		{
+			name:       "v2 layout read as v1 has no organization",
+			body:       `{"requestId":"r1","orgId":"org-1","userId":"u1","model":"gpt-4"}`,
+			wantReason: ReasonMissingOrgID,
+		},
+		{
+			name:       "unsupported version",
+			body:       `{"schemaVersion":3,"requestId":"r1","orgId":"org-1","userId":"u1","model":"gpt-4"}`,
+			wantReason: ReasonUnsupportedVersion,
+		},
+		{
+			name:          "unparseable header",
+			body:          `{"requestId":"r1","organizationId":"org-1","userId":"u1","model":"gpt-4"}`,
+			headerVersion: -1,
+			wantReason:    ReasonUnsupportedVersion,
+		},
+		{
+			name:       "malformed",
+			body:       `{"requestId":`,
+			wantReason: ReasonMalformed,
+		},
+		{
+			name:       "missing request ID",
+			body:       `{"requestId":" ","organizationId":"org-1","userId":"u1","model":"gpt-4"}`,
+			wantReason: ReasonMissingRequestID,
+		},
+		{
+			name:       "missing user",
+			body:       `{"requestId":"r1","organizationId":"org-1","model":"gpt-4"}`,
+			wantReason: ReasonMissingUserID,
+		},
+		{
+			name:       "missing model",
+			body:       `{"requestId":"r1","organizationId":"org-1","userId":"u1"}`,
+			wantReason: ReasonMissingModel,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request, err := DecodeRequest([]byte(tt.body), tt.headerVersion)
+			if tt.wantReason != "" {
+				if Reason(err) != tt.wantReason {
+					t.Fatalf("DecodeRequest error = %v, want reason %s", err, tt.wantReason)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DecodeRequest: %v", err)
+			}
+			if request.SchemaVersion != tt.wantVersion {
+				t.Fatalf("schema version = %d, want %d", request.SchemaVersion, tt.wantVersion)
+			}
+			if request.OrganizationID != "org-1" {
+				t.Fatalf("organization = %q, want org-1", request.OrganizationID)
+			}
+		})
+	}
+}
+
+func TestDecodeResponseMapsV2Usage(t *testing.T) {
+	body := `{"schemaVersion":2,"requestId":"r1","orgId":"org-1","userId":"u1","model":"gpt-4",
+		"usage":{"inputTokens":100,"outputTokens":40,"totalTokens":140,"cachedInputTokens":60,"reasoningTokens":10,"imageTokens":2,"audioTokens":3}}`
+	response, err := DecodeResponse([]byte(body), 0)
+	if err != nil {
+		t.Fatalf("DecodeResponse: %v", err)
+	}
+	usage := response.Usage
+	if usage == nil {
+		t.Fatal("usage not decoded")
+	}
+	if usage.PromptTokens != 100 || usage.CompletionTokens != 40 || usage.TotalTokens != 140 ||
+		usage.CachedPromptTokens != 60 || usage.ReasoningTokens != 10 || usage.ImageTokens != 2 || usage.AudioTokens != 3 {
+		t.Fatalf("usage = %+v, want the v2 counts mapped", *usage)
+	}
+	if response.OrganizationID != "org-1" {
+		t.Fatalf("organization = %q, want org-1", response.OrganizationID)
+	}
+}
+
+func TestDecodeResponseRejectsInvalidUsage(t *testing.T) {
+	tests := []struct {
+		name  string
+		usage string
+	}{
+		{"negative", `{"promptTokens":-1,"completionTokens":5,"totalTokens":4}`},
+		{"cached above prompt", `{"promptTokens":10,"completionTokens":5,"totalTokens":15,"cachedPromptTokens":11}`},
+		{"reasoning above completion", `{"promptTokens":10,"completionTokens":5,"totalTokens":15,"reasoningTokens":6}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"requestId":"r1","organizationId":"org-1","userId":"u1","model":"gpt-4","usage":` + tt.usage + `}`
+			_, err := DecodeResponse([]byte(body), 0)
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) || validationErr.Reason != ReasonInvalidUsage {
+				t.Fatalf("DecodeResponse error = %v, want %s", err, ReasonInvalidUsage)
+			}
+		})
+	}
+}
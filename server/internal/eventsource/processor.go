@@ -0,0 +1,235 @@
+package eventsource
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"freedom-ai/management-server/internal/config"
+	"freedom-ai/management-server/internal/events"
+	"freedom-ai/management-server/internal/metrics"
+	"freedom-ai/management-server/internal/models"
+	"freedom-ai/management-server/internal/services/consumption"
+	"freedom-ai/management-server/internal/services/ingest"
+
+	"go.uber.org/zap"
+)
+
+// Processor validates and pairs events from any EventSource and writes the
+// resulting consumption records in batches
+type Processor struct {
+	ingestService      *ingest.Service
+	consumptionService *consumption.Service
+	batchSize          int
+	batchFlushInterval time.Duration
+	logger             *zap.Logger
+}
+
+// pendingRecord is a consumption record waiting to be written, together with
+// the message to acknowledge once it is stored
+type pendingRecord struct {
+	msg    Message
+	record models.TokenConsumption
+}
+
+func NewProcessor(cfg *config.Config, ingestService *ingest.Service, consumptionService *consumption.Service, logger *zap.Logger) *Processor {
+	p := &Processor{
+		ingestService:      ingestService,
+		consumptionService: consumptionService,
+		batchSize:          cfg.ConsumptionBatchSize,
+		batchFlushInterval: time.Duration(cfg.ConsumptionBatchFlushMs) * time.Millisecond,
+		logger:             logger,
+	}
+	if p.batchSize < 1 {
+		p.batchSize = 1
+	}
+	if p.batchFlushInterval <= 0 {
+		p.batchFlushInterval = 500 * time.Millisecond
+	}
+	return p
+}
+
+// Run consumes source until ctx is cancelled, then flushes the pending batch
+func (p *Processor) Run(ctx context.Context, source EventSource) error {
+	batches := make(chan pendingRecord, p.batchSize)
+	writerDone := make(chan struct{})
+	go func() {
+		defer close(writerDone)
+		p.runBatchWriter(batches)
+	}()
+
+	var acks sync.WaitGroup
+	err := source.Start(ctx, func(msg Message) {
+		if written := p.ingestService.Archive(source.Name(), msg.Kind(), msg.SchemaVersion(), msg.Body()); written != nil {
+			msg = &archivedMessage{Message: msg, ctx: ctx, written: written, pending: &acks}
+		}
+
+		switch msg.Kind() {
+		case KindRequest:
+			p.handleRequest(msg)
+		case KindResponse:
+			if pending, ok := p.handleResponse(msg); ok {
+				batches <- pending
+			}
+		default:
+			p.reject(msg, &events.ValidationError{Reason: "unknown_event_type", Detail: msg.Kind()})
+		}
+	})
+
+	// Flush whatever the handlers queued before returning
+	close(batches)
+	<-writerDone
+	acks.Wait()
+
+	return err
+}
+
+func (p *Processor) handleRequest(msg Message) {
+	requestData, err := events.DecodeRequest(msg.Body(), msg.SchemaVersion())
+	if err != nil {
+		p.reject(msg, err)
+		return
+	}
+
+	p.ingestService.TrackRequest(context.Background(), requestData)
+
+	msg.Ack()
+}
+
+// handleResponse pairs the response with its cached request and builds the
+// consumption record. Rejected messages are dead-lettered, and messages that
+// cannot be priced are retried; both are reported as not ok.
+func (p *Processor) handleResponse(msg Message) (pendingRecord, bool) {
+	responseData, err := events.DecodeResponse(msg.Body(), msg.SchemaVersion())
+	if err != nil {
+		p.reject(msg, err)
+		return pendingRecord{}, false
+	}
+
+	record, err := p.ingestService.Pair(context.Background(), responseData)
+	if err != nil {
+		p.logger.Error("Failed to build consumption record",
+			zap.String("requestId", responseData.RequestID),
+			zap.Error(err))
+		msg.Nack(err)
+		return pendingRecord{}, false
+	}
+
+	return pendingRecord{msg: msg, record: record}, true
+}
+
+// archivedMessage acknowledges its message only once the raw event is in the
+// archive, so a crash before the archive is written redelivers it. If the
+// processor shuts down first, the message is retried instead. Retries and
+// dead-letters keep the body in the broker and need not wait.
+type archivedMessage struct {
+	Message
+	ctx     context.Context
+	written <-chan struct{}
+	pending *sync.WaitGroup
+}
+
+func (m *archivedMessage) Ack() {
+	m.pending.Add(1)
+	go func() {
+		defer m.pending.Done()
+		select {
+		case <-m.written:
+			m.Message.Ack()
+			return
+		case <-m.ctx.Done():
+		}
+
+		select {
+		case <-m.written:
+			m.Message.Ack()
+		default:
+			m.Message.Nack(fmt.Errorf("shut down before the event was archived: %w", m.ctx.Err()))
+		}
+	}()
+}
+
+// reject dead-letters an event that failed schema validation
+func (p *Processor) reject(msg Message, err error) {
+	reason := events.Reason(err)
+	metrics.RejectedEvents.Add(reason, 1)
+	p.logger.Error("Rejected invalid event",
+		zap.String("kind", msg.Kind()),
+		zap.String("reason", reason),
+		zap.Error(err))
+
+	msg.DeadLetter(fmt.Sprintf("rejected: %v", err))
+}
+
+// runBatchWriter collects records until the batch is full or the flush interval
+// elapses, then writes them in one bulk write. It flushes what is left and
+// returns when records is closed.
+func (p *Processor) runBatchWriter(records <-chan pendingRecord) {
+	ticker := time.NewTicker(p.batchFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]pendingRecord, 0, p.batchSize)
+	for {
+		select {
+		case pending, ok := <-records:
+			if !ok {
+				p.flushBatch(batch)
+				return
+			}
+			batch = append(batch, pending)
+			if len(batch) >= p.batchSize {
+				p.flushBatch(batch)
+				batch = make([]pendingRecord, 0, p.batchSize)
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				p.flushBatch(batch)
+				batch = make([]pendingRecord, 0, p.batchSize)
+			}
+		}
+	}
+}
+
+// flushBatch stores the batch and acknowledges its messages. If the write
+// fails, every message in the batch is retried; ingestion is idempotent on
+// requestId, so records that did commit are not counted twice.
+func (p *Processor) flushBatch(batch []pendingRecord) {
+	if len(batch) == 0 {
+		return
+	}
+
+	ctx := context.Background()
+	records := make([]models.TokenConsumption, len(batch))
+	for i, pending := range batch {
+		records[i] = pending.record
+	}
+
+	start := time.Now()
+	err := p.consumptionService.ProcessConsumptionBatch(ctx, records)
+	elapsed := time.Since(start)
+
+	metrics.Ingestion.Add("batches", 1)
+	metrics.Ingestion.Add("batched_records", int64(len(batch)))
+	metrics.Ingestion.Add("batch_flush_ms_total", elapsed.Milliseconds())
+
+	if err != nil {
+		p.logger.Error("Failed to write consumption batch",
+			zap.Int("records", len(batch)),
+			zap.Error(err))
+		for _, pending := range batch {
+			pending.msg.Nack(err)
+		}
+		return
+	}
+
+	for _, pending := range batch {
+		// Only drop the cached request once the record is stored, so retries can still pair it
+		p.ingestService.Complete(ctx, pending.record.RequestID)
+		pending.msg.Ack()
+	}
+
+	p.logger.Debug("Flushed consumption batch",
+		zap.Int("records", len(batch)),
+		zap.Duration("elapsed", elapsed))
+}
@@ -0,0 +1,179 @@
+package eventsource
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"freedom-ai/management-server/internal/config"
+	"freedom-ai/management-server/internal/database/databasetest"
+	"freedom-ai/management-server/internal/models"
+	"freedom-ai/management-server/internal/redis/redistest"
+	"freedom-ai/management-server/internal/services/consumption"
+	"freedom-ai/management-server/internal/services/ingest"
+	"freedom-ai/management-server/internal/services/privacy"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+// testMessage records how it was settled and when
+type testMessage struct {
+	enqueued time.Time
+	acked    chan time.Duration
+	nacked   atomic.Bool
+}
+
+func (m *testMessage) Kind() string             { return KindResponse }
+func (m *testMessage) Body() []byte             { return nil }
+func (m *testMessage) SchemaVersion() int       { return 0 }
+func (m *testMessage) Ack()                     { m.acked <- time.Since(m.enqueued) }
+func (m *testMessage) Nack(error)               { m.nacked.Store(true); m.acked <- 0 }
+func (m *testMessage) DeadLetter(reason string) { m.acked <- 0 }
+
+func newTestProcessor(tb testing.TB, batchSize int) *Processor {
+	tb.Helper()
+	db := databasetest.Connect(tb)
+	rdb, _ := redistest.Start(tb)
+	logger := zap.NewNop()
+
+	cfg := &config.Config{ConsumptionBatchSize: batchSize, ConsumptionBatchFlushMs: 50}
+	consumptionService := consumption.NewService(db, cfg, logger)
+	if err := consumptionService.EnsureIndexes(context.Background()); err != nil {
+		tb.Fatalf("EnsureIndexes: %v", err)
+	}
+	ingestService := ingest.NewService(rdb, consumptionService, privacy.NewService(db, logger), logger)
+	return NewProcessor(cfg, ingestService, consumptionService, logger)
+}
+
+func testRecord(i int) models.TokenConsumption {
+	now := time.Now()
+	return models.TokenConsumption{
+		ID:           primitive.NewObjectID(),
+		RequestID:    fmt.Sprintf("bench-%s-%d", primitive.NewObjectID().Hex(), i),
+		Timestamp:    now,
+		OrgID:        "org-bench",
+		UserID:       "user-bench",
+		Model:        "gpt-4",
+		PromptTokens: 100,
+		TotalTokens:  150,
+		Cost:         0.01,
+		Status:       "complete",
+		Billable:     true,
+		CreatedAt:    now,
+	}
+}
+
+// BenchmarkFlushBatch measures the bulk write of one batch for different batch
+// sizes. Compare records/s across sub-benchmarks.
+func BenchmarkFlushBatch(b *testing.B) {
+	for _, size := range []int{1, 100, 500} {
+		b.Run(fmt.Sprintf("batch=%d", size), func(b *testing.B) {
+			p := newTestProcessor(b, size)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				batch := make([]pendingRecord, size)
+				for j := range batch {
+					msg := &testMessage{acked: make(chan time.Duration, 1)}
+					batch[j] = pendingRecord{msg: msg, record: testRecord(j)}
+				}
+				b.StartTimer()
+
+				p.flushBatch(batch)
+
+				b.StopTimer()
+				for _, pending := range batch {
+					msg := pending.msg.(*testMessage)
+					<-msg.acked
+					if msg.nacked.Load() {
+						b.Fatal("record was not stored")
+					}
+				}
+				b.StartTimer()
+			}
+			b.ReportMetric(float64(b.N*size)/b.Elapsed().Seconds(), "records/s")
+		})
+	}
+}
+
+// BenchmarkBatchWriter streams records through the batch writer and reports
+// throughput and the mean time from queueing a record to acknowledging it
+func BenchmarkBatchWriter(b *testing.B) {
+	p := newTestProcessor(b, 100)
+
+	records := make(chan pendingRecord, p.batchSize)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.runBatchWriter(records)
+	}()
+
+	msgs := make([]*testMessage, b.N)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		msgs[i] = &testMessage{enqueued: time.Now(), acked: make(chan time.Duration, 1)}
+		records <- pendingRecord{msg: msgs[i], record: testRecord(i)}
+	}
+	close(records)
+	<-done
+	b.StopTimer()
+
+	var latency time.Duration
+	for _, msg := range msgs {
+		latency += <-msg.acked
+		if msg.nacked.Load() {
+			b.Fatal("record was not stored")
+		}
+	}
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "records/s")
+	b.ReportMetric(float64(latency.Milliseconds())/float64(b.N), "ms/ack")
+}
+
+func TestArchivedMessageAcksOnceWritten(t *testing.T) {
+	var acks sync.WaitGroup
+	inner := &testMessage{enqueued: time.Now(), acked: make(chan time.Duration, 1)}
+	written := make(chan struct{})
+	msg := &archivedMessage{Message: inner, ctx: context.Background(), written: written, pending: &acks}
+
+	msg.Ack()
+	select {
+	case <-inner.acked:
+		t.Fatal("message acknowledged before the event was archived")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(written)
+	acks.Wait()
+	if inner.nacked.Load() || len(inner.acked) != 1 {
+		t.Fatal("message not acknowledged once the event was archived")
+	}
+}
+
+func TestArchivedMessageRetriesOnShutdown(t *testing.T) {
+	for _, archived := range []bool{false, true} {
+		var acks sync.WaitGroup
+		inner := &testMessage{enqueued: time.Now(), acked: make(chan time.Duration, 1)}
+		written := make(chan struct{})
+		if archived {
+			close(written)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		(&archivedMessage{Message: inner, ctx: ctx, written: written, pending: &acks}).Ack()
+		acks.Wait()
+
+		// An event archived before shutdown is still acknowledged
+		if inner.nacked.Load() == archived {
+			t.Fatalf("archived=%v: nacked=%v on shutdown", archived, inner.nacked.Load())
+		}
+		if len(inner.acked) != 1 {
+			t.Fatalf("archived=%v: message not settled on shutdown", archived)
+		}
+	}
+}
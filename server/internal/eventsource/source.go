@@ -0,0 +1,67 @@
+package eventsource
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	KindRequest  = "request"
+	KindResponse = "response"
+
+	// MaxRetryDelay caps the backoff between redeliveries of a failed message
+	MaxRetryDelay = 5 * time.Minute
+)
+
+// Message is a single collector event delivered by an EventSource. Exactly one
+// of Ack, Nack or DeadLetter must be called once the message is handled.
+type Message interface {
+	// Kind is KindRequest or KindResponse
+	Kind() string
+	Body() []byte
+	// SchemaVersion is the transport's schema version header, or 0 if it has none
+	SchemaVersion() int
+	// Ack marks the message as processed
+	Ack()
+	// Nack schedules the message for redelivery with backoff, or dead-letters it
+	// once the source's retry budget is exhausted
+	Nack(cause error)
+	// DeadLetter moves the message aside without retrying
+	DeadLetter(reason string)
+}
+
+// Handler processes a message. Sources may call it from several goroutines.
+type Handler func(Message)
+
+// EventSource delivers collector request and response events from a broker
+type EventSource interface {
+	// Name identifies the source in logs
+	Name() string
+	// Start delivers messages to handle until ctx is cancelled. It must not
+	// return before every in-flight call to handle has returned.
+	Start(ctx context.Context, handle Handler) error
+	Close() error
+}
+
+// RetryDelay returns the backoff before the next attempt of a message that has
+// already been retried retries times: base, doubled per attempt, capped at MaxRetryDelay
+func RetryDelay(base time.Duration, retries int) time.Duration {
+	delay := base << retries
+	if delay <= 0 || delay > MaxRetryDelay {
+		return MaxRetryDelay
+	}
+	return delay
+}
+
+// RetryDelays returns the distinct backoff delays used across a retry budget of
+// maxRetries attempts, for sources that keep one delay queue per delay
+func RetryDelays(base time.Duration, maxRetries int) []time.Duration {
+	var delays []time.Duration
+	for retries := 0; retries < maxRetries; retries++ {
+		delay := RetryDelay(base, retries)
+		if len(delays) == 0 || delays[len(delays)-1] != delay {
+			delays = append(delays, delay)
+		}
+	}
+	return delays
+}
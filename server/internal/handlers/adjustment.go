@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"freedom-ai/management-server/internal/services/adjustment"
+	"freedom-ai/management-server/internal/services/ledger"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type AdjustmentHandler struct {
+	adjustments *adjustment.Service
+}
+
+func NewAdjustmentHandler(adjustmentService *adjustment.Service) *AdjustmentHandler {
+	return &AdjustmentHandler{adjustments: adjustmentService}
+}
+
+// CreateAdjustment credits or debits a tenant's wallet (developer only)
+func (h *AdjustmentHandler) CreateAdjustment(c *gin.Context) {
+	var req struct {
+		Amount           float64 `json:"amount" binding:"required"` // positive credits, negative debits
+		ReasonCode       string  `json:"reasonCode" binding:"required"`
+		Note             string  `json:"note" binding:"required"`
+		BillingHistoryID string  `json:"billingHistoryId"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	request := adjustment.Request{
+		OrgID:      c.Param("id"),
+		Amount:     req.Amount,
+		ReasonCode: req.ReasonCode,
+		Note:       req.Note,
+		CreatedBy:  c.GetString("userId"),
+	}
+	if err := adjustment.Validate(request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	billingHistoryID, ok := optionalObjectID(c, req.BillingHistoryID, "billingHistoryId")
+	if !ok {
+		return
+	}
+
+	record, err := h.adjustments.AdjustWallet(c.Request.Context(), request, billingHistoryID)
+	if err != nil {
+		adjustmentError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, record)
+}
+
+// CreateCreditNote issues a credit note against a billing history entry or an
+// invoice (developer only)
+func (h *AdjustmentHandler) CreateCreditNote(c *gin.Context) {
+	var req struct {
+		Amount           float64 `json:"amount" binding:"required"`
+		ReasonCode       string  `json:"reasonCode" binding:"required"`
+		Note             string  `json:"note" binding:"required"`
+		BillingHistoryID string  `json:"billingHistoryId"`
+		InvoiceID        string  `json:"invoiceId"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	request := adjustment.Request{
+		OrgID:      c.Param("id"),
+		Amount:     req.Amount,
+		ReasonCode: req.ReasonCode,
+		Note:       req.Note,
+		CreatedBy:  c.GetString("userId"),
+	}
+	if err := adjustment.Validate(request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Amount <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "amount must be positive"})
+		return
+	}
+	if (req.BillingHistoryID == "") == (req.InvoiceID == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "exactly one of billingHistoryId and invoiceId is required"})
+		return
+	}
+	billingHistoryID, ok := optionalObjectID(c, req.BillingHistoryID, "billingHistoryId")
+	if !ok {
+		return
+	}
+	invoiceID, ok := optionalObjectID(c, req.InvoiceID, "invoiceId")
+	if !ok {
+		return
+	}
+
+	note, err := h.adjustments.IssueCreditNote(c.Request.Context(), request, billingHistoryID, invoiceID)
+	if err != nil {
+		adjustmentError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, note)
+}
+
+// ListCreditNotes returns a tenant's credit notes (developer only)
+func (h *AdjustmentHandler) ListCreditNotes(c *gin.Context) {
+	notes, err := h.adjustments.ListCreditNotes(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, notes)
+}
+
+// RefundTopUp refunds a Stripe top-up, in full unless an amount is given (developer only)
+func (h *AdjustmentHandler) RefundTopUp(c *gin.Context) {
+	topUpID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid top-up ID"})
+		return
+	}
+	var req struct {
+		Amount     float64 `json:"amount"`
+		ReasonCode string  `json:"reasonCode" binding:"required"`
+		Note       string  `json:"note" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	request := adjustment.Request{
+		Amount:     req.Amount,
+		ReasonCode: req.ReasonCode,
+		Note:       req.Note,
+		CreatedBy:  c.GetString("userId"),
+	}
+	if err := adjustment.Validate(request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Amount < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "amount must not be negative"})
+		return
+	}
+
+	record, err := h.adjustments.RefundTopUp(c.Request.Context(), topUpID, request)
+	if err != nil {
+		adjustmentError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, record)
+}
+
+func adjustmentError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, adjustment.ErrExceedsCharge),
+		errors.Is(err, adjustment.ErrNotRefundable),
+		errors.Is(err, adjustment.ErrInsufficientBalance):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	case errors.Is(err, ledger.ErrDuplicateReference):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, adjustment.ErrRefundsUnavailable):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// optionalObjectID parses an optional ID from a request body, responding with
+// 400 when it is malformed
+func optionalObjectID(c *gin.Context, hex, field string) (*primitive.ObjectID, bool) {
+	if hex == "" {
+		return nil, true
+	}
+	id, err := primitive.ObjectIDFromHex(hex)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + field})
+		return nil, false
+	}
+	return &id, true
+}
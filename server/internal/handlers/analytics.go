@@ -5,6 +5,9 @@ import (
 	"strconv"
 	"time"
 
+	"freedom-ai/management-server/internal/models"
+	"freedom-ai/management-server/internal/services/consumption"
+
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -166,16 +169,20 @@ func (h *AnalyticsHandler) GetConsumptionTrends(c *gin.Context) {
 		match["organizationId"] = orgID
 	}
 
+	// Days follow the organization's timezone when one is selected
+	dateToString := bson.M{
+		"format": "%Y-%m-%d",
+		"date":   "$timestamp",
+	}
+	if orgID != "" {
+		dateToString["timezone"] = organizationCalendar(c.Request.Context(), h.db, orgID).Location.String()
+	}
+
 	pipeline := []bson.M{
 		{"$match": match},
 		{
 			"$group": bson.M{
-				"_id": bson.M{
-					"$dateToString": bson.M{
-						"format": "%Y-%m-%d",
-						"date":   "$timestamp",
-					},
-				},
+				"_id":    bson.M{"$dateToString": dateToString},
 				"tokens": bson.M{"$sum": "$totalTokens"},
 				"cost":   bson.M{"$sum": "$cost"},
 			},
@@ -272,6 +279,7 @@ func (h *AnalyticsHandler) GetRevenueTrends(c *gin.Context) {
 					"$lte": end,
 				},
 				"status": "completed",
+				"type":   bson.M{"$ne": models.BillingTypeRefund},
 			},
 		},
 		{
@@ -305,3 +313,145 @@ func (h *AnalyticsHandler) GetRevenueTrends(c *gin.Context) {
 	c.JSON(http.StatusOK, results)
 }
 
+// GetConsumptionByStatus returns record counts by ingestion status (complete,
+// request-only, response-only), so failed or aborted LLM calls stay visible
+func (h *AnalyticsHandler) GetConsumptionByStatus(c *gin.Context) {
+	orgID := c.Query("organizationId")
+	startDate := c.Query("startDate")
+	endDate := c.Query("endDate")
+
+	match := bson.M{}
+	if orgID != "" {
+		match["organizationId"] = orgID
+	}
+	if startDate != "" && endDate != "" {
+		start, _ := time.Parse(time.RFC3339, startDate)
+		end, _ := time.Parse(time.RFC3339, endDate)
+		match["timestamp"] = bson.M{
+			"$gte": start,
+			"$lte": end,
+		}
+	}
+
+	pipeline := []bson.M{
+		{"$match": match},
+		{
+			"$group": bson.M{
+				"_id":           "$status",
+				"count":         bson.M{"$sum": 1},
+				"billableCount": bson.M{"$sum": bson.M{"$cond": bson.A{"$billable", 1, 0}}},
+				"tokens":        bson.M{"$sum": "$totalTokens"},
+				"cost":          bson.M{"$sum": "$cost"},
+			},
+		},
+		{"$sort": bson.M{"count": -1}},
+	}
+
+	collection := h.db.Collection("token_consumption")
+	cursor, err := collection.Aggregate(c.Request.Context(), pipeline)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer cursor.Close(c.Request.Context())
+
+	var results []bson.M
+	if err := cursor.All(c.Request.Context(), &results); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, results)
+}
+
+// GetCostByTokenClass returns token counts and cost per token class (prompt,
+// cached prompt, completion, reasoning, image, audio) for each model. Records
+// priced before token classes existed are reported as unclassified cost.
+func (h *AnalyticsHandler) GetCostByTokenClass(c *gin.Context) {
+	orgID := c.Query("organizationId")
+	startDate := c.Query("startDate")
+	endDate := c.Query("endDate")
+
+	match := bson.M{"$or": consumption.BillableConditions()}
+	if orgID != "" {
+		match["organizationId"] = orgID
+	}
+	if startDate != "" && endDate != "" {
+		start, _ := time.Parse(time.RFC3339, startDate)
+		end, _ := time.Parse(time.RFC3339, endDate)
+		match["timestamp"] = bson.M{
+			"$gte": start,
+			"$lte": end,
+		}
+	}
+
+	sumOf := func(field string) bson.M {
+		return bson.M{"$sum": bson.M{"$ifNull": bson.A{field, 0}}}
+	}
+	pipeline := []bson.M{
+		{"$match": match},
+		{
+			"$group": bson.M{
+				"_id":                "$model",
+				"promptTokens":       sumOf("$promptTokens"),
+				"cachedPromptTokens": sumOf("$cachedPromptTokens"),
+				"completionTokens":   sumOf("$completionTokens"),
+				"reasoningTokens":    sumOf("$reasoningTokens"),
+				"imageTokens":        sumOf("$imageTokens"),
+				"audioTokens":        sumOf("$audioTokens"),
+				"promptCost":         sumOf("$costByClass.prompt"),
+				"cachedPromptCost":   sumOf("$costByClass.cachedPrompt"),
+				"completionCost":     sumOf("$costByClass.completion"),
+				"reasoningCost":      sumOf("$costByClass.reasoning"),
+				"imageCost":          sumOf("$costByClass.image"),
+				"audioCost":          sumOf("$costByClass.audio"),
+				"unclassifiedCost": bson.M{"$sum": bson.M{
+					"$cond": bson.A{bson.M{"$eq": bson.A{bson.M{"$type": "$costByClass"}, "object"}}, 0, "$cost"},
+				}},
+				"totalCost": bson.M{"$sum": "$cost"},
+			},
+		},
+		{"$sort": bson.M{"totalCost": -1}},
+	}
+
+	collection := h.db.Collection("token_consumption")
+	cursor, err := collection.Aggregate(c.Request.Context(), pipeline)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer cursor.Close(c.Request.Context())
+
+	var results []bson.M
+	if err := cursor.All(c.Request.Context(), &results); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	byModel := make([]gin.H, 0, len(results))
+	for _, result := range results {
+		byModel = append(byModel, gin.H{
+			"model": result["_id"],
+			"tokens": gin.H{
+				"prompt":       result["promptTokens"],
+				"cachedPrompt": result["cachedPromptTokens"],
+				"completion":   result["completionTokens"],
+				"reasoning":    result["reasoningTokens"],
+				"image":        result["imageTokens"],
+				"audio":        result["audioTokens"],
+			},
+			"cost": gin.H{
+				"prompt":       result["promptCost"],
+				"cachedPrompt": result["cachedPromptCost"],
+				"completion":   result["completionCost"],
+				"reasoning":    result["reasoningCost"],
+				"image":        result["imageCost"],
+				"audio":        result["audioCost"],
+				"unclassified": result["unclassifiedCost"],
+			},
+			"totalCost": result["totalCost"],
+		})
+	}
+
+	c.JSON(http.StatusOK, byModel)
+}
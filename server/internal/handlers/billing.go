@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"freedom-ai/management-server/internal/models"
+	"freedom-ai/management-server/internal/services/limits"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
@@ -13,11 +14,12 @@ import (
 )
 
 type BillingHandler struct {
-	db *mongo.Database
+	db     *mongo.Database
+	limits *limits.Service
 }
 
-func NewBillingHandler(db *mongo.Database) *BillingHandler {
-	return &BillingHandler{db: db}
+func NewBillingHandler(db *mongo.Database, limitsService *limits.Service) *BillingHandler {
+	return &BillingHandler{db: db, limits: limitsService}
 }
 
 // GetWalletBalance returns the wallet balance for an organization
@@ -40,7 +42,22 @@ func (h *BillingHandler) GetWalletBalance(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"balance": org.WalletBalance})
+	credit, err := h.limits.CreditStatus(c.Request.Context(), org)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"balance":     org.WalletBalance,
+		"accountMode": credit.AccountMode,
+		"creditLimit": credit.CreditLimit,
+		"creditFloor": credit.CreditFloor,
+		"unbilled":    credit.Unbilled,
+		"headroom":    credit.Headroom,
+		"restricted":  credit.Restricted,
+		"blocked":     credit.Blocked,
+	})
 }
 
 // GetBillingHistory returns billing history for an organization
@@ -75,7 +92,7 @@ func (h *BillingHandler) GetBillingHistory(c *gin.Context) {
 func (h *BillingHandler) CreateTopUp(c *gin.Context) {
 	var req struct {
 		OrganizationID string  `json:"organizationId"`
-		Amount          float64 `json:"amount"`
+		Amount         float64 `json:"amount"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -100,4 +117,3 @@ func (h *BillingHandler) CreateTopUp(c *gin.Context) {
 
 	c.JSON(http.StatusCreated, topUp)
 }
-
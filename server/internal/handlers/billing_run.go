@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"freedom-ai/management-server/internal/services/billing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type BillingRunHandler struct {
+	billingService *billing.Service
+}
+
+func NewBillingRunHandler(billingService *billing.Service) *BillingRunHandler {
+	return &BillingRunHandler{billingService: billingService}
+}
+
+// ListBillingRuns returns billing runs (developer only)
+func (h *BillingRunHandler) ListBillingRuns(c *gin.Context) {
+	var from, to *time.Time
+	if v := c.Query("from"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from must be YYYY-MM-DD"})
+			return
+		}
+		from = &t
+	}
+	if v := c.Query("to"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "to must be YYYY-MM-DD"})
+			return
+		}
+		to = &t
+	}
+	limit := 100
+	if l, err := strconv.Atoi(c.DefaultQuery("limit", "100")); err == nil && l > 0 {
+		limit = l
+	}
+
+	runs, err := h.billingService.ListRuns(c.Request.Context(), c.Query("organizationId"), c.Query("status"), from, to, int64(limit))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, runs)
+}
+
+// RunBilling bills an organization for a day, or previews it with dryRun. With
+// force an already billed day is reversed and billed again (developer only).
+func (h *BillingRunHandler) RunBilling(c *gin.Context) {
+	var req struct {
+		OrganizationID string `json:"organizationId" binding:"required"`
+		Date           string `json:"date" binding:"required"` // YYYY-MM-DD in the organization's timezone
+		DryRun         bool   `json:"dryRun"`
+		Force          bool   `json:"force"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	day, err := time.Parse("2006-01-02", req.Date)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "date must be YYYY-MM-DD"})
+		return
+	}
+	if req.DryRun {
+		preview, err := h.billingService.PreviewOrganizationDay(c.Request.Context(), req.OrganizationID, day, req.Force)
+		if err != nil {
+			if errors.Is(err, billing.ErrPeriodOpen) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, preview)
+		return
+	}
+
+	run, err := h.billingService.BillOrganizationDay(c.Request.Context(), req.OrganizationID, day, req.Force)
+	if err != nil {
+		switch {
+		case errors.Is(err, billing.ErrAlreadyBilled):
+			c.JSON(http.StatusConflict, gin.H{"error": "period already billed; set force to bill it again"})
+		case errors.Is(err, billing.ErrRunInProgress):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, billing.ErrPeriodOpen):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, billing.ErrNothingToBill):
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, run)
+}
@@ -141,6 +141,56 @@ func (h *ConsumptionHandler) GetConsumptionByAssistant(c *gin.Context) {
 	c.JSON(http.StatusOK, results)
 }
 
+// GetConsumptionByModel returns consumption grouped by canonical model and provider
+func (h *ConsumptionHandler) GetConsumptionByModel(c *gin.Context) {
+	orgID := c.Query("organizationId")
+	startDate := c.Query("startDate")
+	endDate := c.Query("endDate")
+
+	match := bson.M{}
+	if orgID != "" {
+		match["organizationId"] = orgID
+	}
+	if startDate != "" && endDate != "" {
+		start, _ := time.Parse(time.RFC3339, startDate)
+		end, _ := time.Parse(time.RFC3339, endDate)
+		match["timestamp"] = bson.M{
+			"$gte": start,
+			"$lte": end,
+		}
+	}
+
+	pipeline := []bson.M{
+		{"$match": match},
+		{
+			"$group": bson.M{
+				"_id":       "$model",
+				"provider":  bson.M{"$first": "$provider"},
+				"tokens":    bson.M{"$sum": "$totalTokens"},
+				"cost":      bson.M{"$sum": "$cost"},
+				"rawModels": bson.M{"$addToSet": "$rawModel"},
+			},
+		},
+		{"$sort": bson.M{"cost": -1}},
+	}
+
+	collection := h.db.Collection("token_consumption")
+	cursor, err := collection.Aggregate(c.Request.Context(), pipeline)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer cursor.Close(c.Request.Context())
+
+	var results []bson.M
+	if err := cursor.All(c.Request.Context(), &results); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, results)
+}
+
 // GetConsumptionByUser returns consumption grouped by user
 func (h *ConsumptionHandler) GetConsumptionByUser(c *gin.Context) {
 	orgID := c.Query("organizationId")
@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"freedom-ai/management-server/internal/models"
+	"freedom-ai/management-server/internal/services/credits"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type CreditsHandler struct {
+	db      *mongo.Database
+	credits *credits.Service
+}
+
+func NewCreditsHandler(db *mongo.Database, creditsService *credits.Service) *CreditsHandler {
+	return &CreditsHandler{db: db, credits: creditsService}
+}
+
+// GetCredits returns an organization's credit grants and the promotional
+// balance left in its wallet
+func (h *CreditsHandler) GetCredits(c *gin.Context) {
+	orgID := c.Query("organizationId")
+	if orgID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "organizationId is required"})
+		return
+	}
+
+	grants, err := h.credits.ListGrants(c.Request.Context(), orgID, c.Query("status"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var available float64
+	for _, grant := range grants {
+		if grant.Status == models.CreditGrantActive {
+			available += grant.Remaining
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"available": available, "grants": grants})
+}
+
+// CreateCreditGrant grants credit to a tenant (developer only)
+func (h *CreditsHandler) CreateCreditGrant(c *gin.Context) {
+	var req struct {
+		Amount      float64    `json:"amount" binding:"required"`
+		Source      string     `json:"source"` // trial, promo or manual (default)
+		Priority    int        `json:"priority"`
+		Description string     `json:"description"`
+		ExpiresAt   *time.Time `json:"expiresAt"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Source == "" {
+		req.Source = models.CreditSourceManual
+	}
+	grant := models.CreditGrant{
+		OrgID:       c.Param("id"),
+		Amount:      req.Amount,
+		Source:      req.Source,
+		Priority:    req.Priority,
+		Description: req.Description,
+		ExpiresAt:   req.ExpiresAt,
+		CreatedBy:   c.GetString("userId"),
+	}
+	if err := credits.ValidateGrant(grant); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	count, err := h.db.Collection("organizations").CountDocuments(c.Request.Context(), bson.M{"orgId": grant.OrgID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if count == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tenant not found"})
+		return
+	}
+
+	issued, err := h.credits.Grant(c.Request.Context(), grant)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, issued)
+}
+
+// ListPromoCodes returns all promo codes (developer only)
+func (h *CreditsHandler) ListPromoCodes(c *gin.Context) {
+	cursor, err := h.db.Collection("promo_codes").Find(c.Request.Context(), bson.M{},
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer cursor.Close(c.Request.Context())
+
+	codes := []models.PromoCode{}
+	if err := cursor.All(c.Request.Context(), &codes); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, codes)
+}
+
+// CreatePromoCode creates a promo code (developer only)
+func (h *CreditsHandler) CreatePromoCode(c *gin.Context) {
+	var promo models.PromoCode
+	if err := c.ShouldBindJSON(&promo); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	created, err := h.credits.CreatePromoCode(c.Request.Context(), promo)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, created)
+}
+
+// DeactivatePromoCode stops a promo code from being redeemed (developer only)
+func (h *CreditsHandler) DeactivatePromoCode(c *gin.Context) {
+	result, err := h.db.Collection("promo_codes").UpdateOne(c.Request.Context(),
+		bson.M{"code": credits.NormalizeCode(c.Param("code"))},
+		bson.M{"$set": bson.M{"active": false, "updatedAt": time.Now()}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Promo code not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Promo code deactivated"})
+}
+
+// RedeemPromoCode redeems a promo code for an organization
+func (h *CreditsHandler) RedeemPromoCode(c *gin.Context) {
+	var req struct {
+		OrganizationID string `json:"organizationId" binding:"required"`
+		Code           string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	grant, err := h.credits.Redeem(c.Request.Context(), req.OrganizationID, req.Code, c.GetString("userId"))
+	if err != nil {
+		switch {
+		case errors.Is(err, credits.ErrPromoNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, credits.ErrPromoExpired), errors.Is(err, credits.ErrPromoExhausted):
+			c.JSON(http.StatusGone, gin.H{"error": err.Error()})
+		case errors.Is(err, credits.ErrAlreadyRedeemed):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, grant)
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"freedom-ai/management-server/internal/rabbitmq"
+
+	"github.com/gin-gonic/gin"
+)
+
+type DeadLetterHandler struct {
+	consumer *rabbitmq.Consumer
+}
+
+func NewDeadLetterHandler(consumer *rabbitmq.Consumer) *DeadLetterHandler {
+	return &DeadLetterHandler{consumer: consumer}
+}
+
+// ListDeadLetters returns dead-lettered messages for the requests or responses queue (developer only)
+func (h *DeadLetterHandler) ListDeadLetters(c *gin.Context) {
+	limit := 50
+	if l, err := strconv.Atoi(c.DefaultQuery("limit", "50")); err == nil && l > 0 {
+		limit = l
+	}
+
+	letters, err := h.consumer.ListDeadLetters(c.Request.Context(), c.Param("queue"), limit)
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, letters)
+}
+
+// GetDeadLetter returns a single dead-lettered message
+func (h *DeadLetterHandler) GetDeadLetter(c *gin.Context) {
+	letter, err := h.consumer.GetDeadLetter(c.Request.Context(), c.Param("queue"), c.Param("messageId"))
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, letter)
+}
+
+// ReplayDeadLetters republishes dead-lettered messages for processing
+func (h *DeadLetterHandler) ReplayDeadLetters(c *gin.Context) {
+	var req struct {
+		MessageIDs []string `json:"messageIds"`
+		Limit      int      `json:"limit"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.MessageIDs) == 0 && req.Limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "messageIds or limit is required"})
+		return
+	}
+
+	replayed, err := h.consumer.ReplayDeadLetters(c.Request.Context(), c.Param("queue"), req.MessageIDs, req.Limit)
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"replayed": replayed})
+}
+
+// PurgeDeadLetters deletes all dead-lettered messages for a queue
+func (h *DeadLetterHandler) PurgeDeadLetters(c *gin.Context) {
+	purged, err := h.consumer.PurgeDeadLetters(c.Request.Context(), c.Param("queue"))
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"purged": purged})
+}
+
+func (h *DeadLetterHandler) respondError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, rabbitmq.ErrUnknownDeadLetterQueue):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "queue must be one of: requests, responses"})
+	case errors.Is(err, rabbitmq.ErrDeadLetterNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+	case errors.Is(err, rabbitmq.ErrNotConnected):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"freedom-ai/management-server/internal/models"
+	"freedom-ai/management-server/internal/services/dunning"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type DunningHandler struct {
+	db      *mongo.Database
+	dunning *dunning.Service
+}
+
+func NewDunningHandler(db *mongo.Database, dunningService *dunning.Service) *DunningHandler {
+	return &DunningHandler{db: db, dunning: dunningService}
+}
+
+// GetDunning returns a tenant's dunning state, effective policy and events (developer only)
+func (h *DunningHandler) GetDunning(c *gin.Context) {
+	id := c.Param("id")
+	var org models.Organization
+	err := h.db.Collection("organizations").FindOne(c.Request.Context(), bson.M{"orgId": id}).Decode(&org)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Tenant not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	limit := 50
+	if l, err := strconv.Atoi(c.DefaultQuery("limit", "50")); err == nil && l > 0 && l <= 500 {
+		limit = l
+	}
+	events, err := h.dunning.ListEvents(c.Request.Context(), id, int64(limit))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"state":        org.DunningState(),
+		"status":       org.Dunning,
+		"policy":       org.EffectiveDunningPolicy(),
+		"custom":       org.DunningPolicy != nil,
+		"suspendBelow": org.DunningSuspendBelow(),
+		"balance":      org.WalletBalance,
+		"events":       events,
+	})
+}
+
+// UpdateDunningPolicy sets a tenant's dunning policy; an empty body restores
+// the default policy (developer only)
+func (h *DunningHandler) UpdateDunningPolicy(c *gin.Context) {
+	id := c.Param("id")
+	var policy *models.DunningPolicy
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&policy); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if err := models.ValidateDunningPolicy(policy); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	update := bson.M{"$set": bson.M{"dunningPolicy": policy, "updatedAt": time.Now()}}
+	if policy == nil {
+		update = bson.M{"$set": bson.M{"updatedAt": time.Now()}, "$unset": bson.M{"dunningPolicy": ""}}
+	}
+	result, err := h.db.Collection("organizations").UpdateOne(c.Request.Context(), bson.M{"orgId": id}, update)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tenant not found"})
+		return
+	}
+
+	// A new threshold can start or end dunning right away
+	if err := h.dunning.Evaluate(c.Request.Context(), id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Dunning policy updated"})
+}
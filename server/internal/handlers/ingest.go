@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"freedom-ai/management-server/internal/services/ingest"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxIngestBatch caps the number of events accepted in one request
+const maxIngestBatch = 1000
+
+type IngestHandler struct {
+	ingestService *ingest.Service
+}
+
+func NewIngestHandler(ingestService *ingest.Service) *IngestHandler {
+	return &IngestHandler{ingestService: ingestService}
+}
+
+// IngestEvents accepts a single {"type", "event"} object or an array of them and
+// reports per-event results
+func (h *IngestHandler) IngestEvents(c *gin.Context) {
+	var batch []ingest.Event
+	if !bindIngestBody(c, &batch) {
+		return
+	}
+	h.ingest(c, batch)
+}
+
+// IngestRequests accepts a single request event or an array of them, in the
+// JSON shape the broker carries
+func (h *IngestHandler) IngestRequests(c *gin.Context) {
+	h.ingestRaw(c, ingest.EventTypeRequest)
+}
+
+// IngestResponses accepts a single response event or an array of them, in the
+// JSON shape the broker carries
+func (h *IngestHandler) IngestResponses(c *gin.Context) {
+	h.ingestRaw(c, ingest.EventTypeResponse)
+}
+
+func (h *IngestHandler) ingestRaw(c *gin.Context, eventType string) {
+	var raw []json.RawMessage
+	if !bindIngestBody(c, &raw) {
+		return
+	}
+	batch := make([]ingest.Event, len(raw))
+	for i, event := range raw {
+		batch[i] = ingest.Event{Type: eventType, Event: event}
+	}
+	h.ingest(c, batch)
+}
+
+// bindIngestBody decodes a single JSON object or an array of them into batch,
+// responding with 400 when the body is invalid or too large
+func bindIngestBody[T any](c *gin.Context, batch *[]T) bool {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		err = json.Unmarshal(trimmed, batch)
+	} else {
+		var event T
+		err = json.Unmarshal(trimmed, &event)
+		*batch = []T{event}
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return false
+	}
+	if len(*batch) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No events provided"})
+		return false
+	}
+	if len(*batch) > maxIngestBatch {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("At most %d events per request", maxIngestBatch)})
+		return false
+	}
+	return true
+}
+
+func (h *IngestHandler) ingest(c *gin.Context, batch []ingest.Event) {
+	// Applies to every event in the request; events may also carry schemaVersion
+	headerVersion := 0
+	if v := c.GetHeader("X-Schema-Version"); v != "" {
+		var err error
+		headerVersion, err = strconv.Atoi(v)
+		if err != nil || headerVersion < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid X-Schema-Version header"})
+			return
+		}
+	}
+
+	results, err := h.ingestService.Ingest(c.Request.Context(), batch, headerVersion, c.GetString("ingestOrgId"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store consumption records, retry the request: " + err.Error()})
+		return
+	}
+
+	accepted := 0
+	for _, result := range results {
+		if result.Status == "accepted" {
+			accepted++
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"accepted": accepted,
+		"rejected": len(results) - accepted,
+		"results":  results,
+	})
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"freedom-ai/management-server/internal/middleware"
+	"freedom-ai/management-server/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ingestKeyPrefix marks ingestion keys so they are recognisable in configs and logs
+const ingestKeyPrefix = "fai_ingest_"
+
+// IngestKeyHandler manages per-organization HTTP ingestion keys
+type IngestKeyHandler struct {
+	db *mongo.Database
+}
+
+func NewIngestKeyHandler(db *mongo.Database) *IngestKeyHandler {
+	return &IngestKeyHandler{db: db}
+}
+
+// ListIngestKeys returns a tenant's ingestion keys, without the keys themselves (developer only)
+func (h *IngestKeyHandler) ListIngestKeys(c *gin.Context) {
+	cursor, err := h.db.Collection("ingest_api_keys").Find(c.Request.Context(),
+		bson.M{"orgId": c.Param("id")},
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer cursor.Close(c.Request.Context())
+
+	keys := []models.IngestKey{}
+	if err := cursor.All(c.Request.Context(), &keys); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, keys)
+}
+
+// CreateIngestKey issues an ingestion key for a tenant. The key is only
+// returned in this response (developer only).
+func (h *IngestKeyHandler) CreateIngestKey(c *gin.Context) {
+	var req struct {
+		Name string `json:"name" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	orgID := c.Param("id")
+	count, err := h.db.Collection("organizations").CountDocuments(c.Request.Context(), bson.M{"orgId": orgID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if count == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tenant not found"})
+		return
+	}
+
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	plaintext := ingestKeyPrefix + hex.EncodeToString(secret)
+
+	key := models.IngestKey{
+		ID:        primitive.NewObjectID(),
+		OrgID:     orgID,
+		Name:      req.Name,
+		Prefix:    plaintext[:len(ingestKeyPrefix)+6],
+		KeyHash:   middleware.HashAPIKey(plaintext),
+		CreatedBy: c.GetString("userId"),
+		CreatedAt: time.Now(),
+	}
+	if _, err := h.db.Collection("ingest_api_keys").InsertOne(c.Request.Context(), key); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"key": plaintext, "ingestKey": key})
+}
+
+// RevokeIngestKey stops a tenant's ingestion key from authenticating (developer only)
+func (h *IngestKeyHandler) RevokeIngestKey(c *gin.Context) {
+	keyID, err := primitive.ObjectIDFromHex(c.Param("keyId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid key ID"})
+		return
+	}
+
+	result, err := h.db.Collection("ingest_api_keys").UpdateOne(c.Request.Context(),
+		bson.M{"_id": keyID, "orgId": c.Param("id"), "revokedAt": nil},
+		bson.M{"$set": bson.M{"revokedAt": time.Now()}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Ingest key not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Ingest key revoked"})
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newTestContext(method, target, body string) (*gin.Context, *httptest.ResponseRecorder) {
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(method, target, strings.NewReader(body))
+	return c, recorder
+}
+
+func TestBindIngestBody(t *testing.T) {
+	tooMany := "[" + strings.TrimSuffix(strings.Repeat(`{"requestId":"r"},`, maxIngestBatch+1), ",") + "]"
+
+	tests := []struct {
+		name     string
+		body     string
+		wantOK   bool
+		wantSize int
+	}{
+		{name: "single object", body: ` {"requestId":"r1"} `, wantOK: true, wantSize: 1},
+		{name: "array", body: `[{"requestId":"r1"},{"requestId":"r2"}]`, wantOK: true, wantSize: 2},
+		{name: "empty array", body: `[]`},
+		{name: "malformed", body: `{"requestId":`},
+		{name: "too many events", body: tooMany},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, recorder := newTestContext(http.MethodPost, "/api/v1/ingest/requests", tt.body)
+			var batch []json.RawMessage
+			ok := bindIngestBody(c, &batch)
+			if ok != tt.wantOK {
+				t.Fatalf("bindIngestBody = %v, want %v (%s)", ok, tt.wantOK, recorder.Body.String())
+			}
+			if !ok {
+				if recorder.Code != http.StatusBadRequest {
+					t.Fatalf("status = %d, want 400", recorder.Code)
+				}
+				return
+			}
+			if len(batch) != tt.wantSize {
+				t.Fatalf("batch has %d events, want %d", len(batch), tt.wantSize)
+			}
+		})
+	}
+}
+
+func TestIngestRejectsInvalidSchemaHeader(t *testing.T) {
+	for _, header := range []string{"two", "0", "-1"} {
+		c, recorder := newTestContext(http.MethodPost, "/api/v1/ingest/requests", `{"requestId":"r1"}`)
+		c.Request.Header.Set("X-Schema-Version", header)
+
+		// The header is checked before the service is used
+		(&IngestHandler{}).IngestRequests(c)
+		if recorder.Code != http.StatusBadRequest {
+			t.Fatalf("X-Schema-Version %q: status = %d, want 400", header, recorder.Code)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"freedom-ai/management-server/internal/models"
+	"freedom-ai/management-server/internal/services/invoice"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type InvoiceHandler struct {
+	db       *mongo.Database
+	invoices *invoice.Service
+}
+
+func NewInvoiceHandler(db *mongo.Database, invoiceService *invoice.Service) *InvoiceHandler {
+	return &InvoiceHandler{db: db, invoices: invoiceService}
+}
+
+// ListInvoices returns an organization's invoices, newest first; the
+// caller's own organization unless organizationId is given
+func (h *InvoiceHandler) ListInvoices(c *gin.Context) {
+	orgID := c.Query("organizationId")
+	if orgID == "" {
+		orgID = c.GetString("organizationId")
+	}
+	if orgID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "organizationId is required"})
+		return
+	}
+
+	cursor, err := h.db.Collection("invoices").Find(c.Request.Context(), bson.M{"orgId": orgID},
+		options.Find().SetSort(bson.D{{Key: "periodStart", Value: -1}}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer cursor.Close(c.Request.Context())
+
+	invoices := []models.Invoice{}
+	if err := cursor.All(c.Request.Context(), &invoices); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, invoices)
+}
+
+// GetInvoice returns an invoice as JSON
+func (h *InvoiceHandler) GetInvoice(c *gin.Context) {
+	inv, ok := h.findInvoice(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, inv)
+}
+
+// DownloadInvoicePDF returns an invoice as a PDF document
+func (h *InvoiceHandler) DownloadInvoicePDF(c *gin.Context) {
+	inv, ok := h.findInvoice(c)
+	if !ok {
+		return
+	}
+
+	c.Header("Content-Type", "application/pdf")
+	c.Header("Content-Disposition", "attachment; filename="+inv.Number+".pdf")
+	if err := invoice.RenderPDF(inv, c.Writer); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// GenerateInvoice issues an organization's invoice for a closed billing cycle (developer only)
+func (h *InvoiceHandler) GenerateInvoice(c *gin.Context) {
+	var req struct {
+		OrganizationID string `json:"organizationId" binding:"required"`
+		Month          string `json:"month" binding:"required"` // YYYY-MM the billing cycle starts in
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	month, err := time.Parse("2006-01", req.Month)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "month must be YYYY-MM"})
+		return
+	}
+
+	inv, created, err := h.invoices.Generate(c.Request.Context(), req.OrganizationID, month.Year(), month.Month())
+	if err != nil {
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+		case errors.Is(err, invoice.ErrCycleOpen):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, invoice.ErrNoActivity):
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		case errors.Is(err, invoice.ErrBillingIncomplete):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	c.JSON(status, inv)
+}
+
+// findInvoice loads the invoice in the path. Only developers see other
+// organizations' invoices; for anyone else they are not found.
+func (h *InvoiceHandler) findInvoice(c *gin.Context) (*models.Invoice, bool) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invoice ID"})
+		return nil, false
+	}
+
+	filter := bson.M{"_id": id}
+	if c.GetString("userRole") != "developer" {
+		filter["orgId"] = c.GetString("organizationId")
+	}
+	var inv models.Invoice
+	err = h.db.Collection("invoices").FindOne(c.Request.Context(), filter).Decode(&inv)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return &inv, true
+}
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"freedom-ai/management-server/internal/database/databasetest"
+	"freedom-ai/management-server/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestGetInvoiceIsScopedToTheCallersOrganization(t *testing.T) {
+	db := databasetest.Connect(t)
+	inv := models.Invoice{ID: primitive.NewObjectID(), OrgID: "org-1", Number: "INV-000001"}
+	if _, err := db.Collection("invoices").InsertOne(context.Background(), inv); err != nil {
+		t.Fatalf("insert invoice: %v", err)
+	}
+	h := NewInvoiceHandler(db, nil)
+
+	tests := []struct {
+		name       string
+		role       string
+		orgID      string
+		wantStatus int
+	}{
+		{"own organization", "tenant_user", "org-1", http.StatusOK},
+		{"other organization", "tenant_admin", "org-2", http.StatusNotFound},
+		{"no organization", "", "", http.StatusNotFound},
+		{"developer", "developer", "org-dev", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, recorder := newTestContext(http.MethodGet, "/api/v1/invoices/"+inv.ID.Hex(), "")
+			c.Params = gin.Params{{Key: "id", Value: inv.ID.Hex()}}
+			if tt.role != "" {
+				c.Set("userRole", tt.role)
+				c.Set("organizationId", tt.orgID)
+			}
+
+			h.GetInvoice(c)
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", recorder.Code, tt.wantStatus, recorder.Body.String())
+			}
+		})
+	}
+}
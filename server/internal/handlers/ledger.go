@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"freedom-ai/management-server/internal/models"
+	"freedom-ai/management-server/internal/services/ledger"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type LedgerHandler struct {
+	db     *mongo.Database
+	ledger *ledger.Service
+}
+
+func NewLedgerHandler(db *mongo.Database, ledgerService *ledger.Service) *LedgerHandler {
+	return &LedgerHandler{db: db, ledger: ledgerService}
+}
+
+// GetLedger returns an organization's wallet ledger entries, newest first
+func (h *LedgerHandler) GetLedger(c *gin.Context) {
+	orgID := c.Query("organizationId")
+	if orgID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "organizationId is required"})
+		return
+	}
+
+	limit := 50
+	if l, err := strconv.Atoi(c.DefaultQuery("limit", "50")); err == nil && l > 0 && l <= 500 {
+		limit = l
+	}
+	filter := bson.M{"orgId": orgID}
+	if entryType := c.Query("type"); entryType != "" {
+		filter["type"] = entryType
+	}
+	if before := c.Query("before"); before != "" {
+		t, err := time.Parse(time.RFC3339, before)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "before must be RFC3339"})
+			return
+		}
+		filter["createdAt"] = bson.M{"$lt": t}
+	}
+
+	cursor, err := h.db.Collection("wallet_ledger").Find(c.Request.Context(), filter,
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}).SetLimit(int64(limit)))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer cursor.Close(c.Request.Context())
+
+	entries := []models.LedgerEntry{}
+	if err := cursor.All(c.Request.Context(), &entries); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, entries)
+}
+
+// CheckLedgerConsistency compares cached wallet balances with the ledger (developer only)
+func (h *LedgerHandler) CheckLedgerConsistency(c *gin.Context) {
+	drifts, err := h.ledger.CheckConsistency(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"consistent": len(drifts) == 0, "drifts": drifts})
+}
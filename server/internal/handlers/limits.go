@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"freedom-ai/management-server/internal/models"
+	"freedom-ai/management-server/internal/services/limits"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type LimitsHandler struct {
+	db     *mongo.Database
+	limits *limits.Service
+}
+
+func NewLimitsHandler(db *mongo.Database, limitsService *limits.Service) *LimitsHandler {
+	return &LimitsHandler{db: db, limits: limitsService}
+}
+
+// CheckLimits reports whether an organization may consume more tokens, and
+// why not when it is blocked
+func (h *LimitsHandler) CheckLimits(c *gin.Context) {
+	orgID := c.Query("organizationId")
+	if orgID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "organizationId is required"})
+		return
+	}
+	var tokens int64
+	if v := c.Query("tokens"); v != "" {
+		t, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || t < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "tokens must be a non-negative integer"})
+			return
+		}
+		tokens = t
+	}
+
+	var org models.Organization
+	err := h.db.Collection("organizations").FindOne(c.Request.Context(), bson.M{"orgId": orgID}).Decode(&org)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := gin.H{"allowed": true, "blocked": false}
+	if err := h.limits.CheckConsumptionLimits(c.Request.Context(), orgID, c.Query("userId"), tokens); err != nil {
+		if !errors.Is(err, limits.ErrCreditExhausted) && !errors.Is(err, limits.ErrLimitExceeded) && !errors.Is(err, limits.ErrSuspended) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		response["allowed"] = false
+		response["blocked"] = true
+		response["reason"] = err.Error()
+	}
+
+	credit, err := h.limits.CreditStatus(c.Request.Context(), org)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	response["credit"] = credit
+
+	c.JSON(http.StatusOK, response)
+}
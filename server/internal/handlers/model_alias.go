@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"net/http"
+	"regexp"
+	"time"
+
+	"freedom-ai/management-server/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ModelAliasHandler manages the model alias registry. Changes apply to new
+// records within a minute; stored records keep the model they were resolved to.
+type ModelAliasHandler struct {
+	db *mongo.Database
+}
+
+func NewModelAliasHandler(db *mongo.Database) *ModelAliasHandler {
+	return &ModelAliasHandler{db: db}
+}
+
+type modelAliasRequest struct {
+	Pattern        string `json:"pattern" binding:"required"`
+	Regex          bool   `json:"regex"`
+	CanonicalModel string `json:"canonicalModel" binding:"required"`
+	Provider       string `json:"provider"`
+	Priority       int    `json:"priority"`
+}
+
+// ListModelAliases returns the registry (developer only)
+func (h *ModelAliasHandler) ListModelAliases(c *gin.Context) {
+	opts := options.Find().SetSort(bson.D{{Key: "regex", Value: 1}, {Key: "priority", Value: -1}, {Key: "pattern", Value: 1}})
+	cursor, err := h.db.Collection("model_aliases").Find(c.Request.Context(), bson.M{}, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer cursor.Close(c.Request.Context())
+
+	aliases := []models.ModelAlias{}
+	if err := cursor.All(c.Request.Context(), &aliases); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, aliases)
+}
+
+// CreateModelAlias adds an exact or regex alias
+func (h *ModelAliasHandler) CreateModelAlias(c *gin.Context) {
+	var req modelAliasRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Regex {
+		if _, err := regexp.Compile(req.Pattern); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid pattern: " + err.Error()})
+			return
+		}
+	}
+
+	now := time.Now()
+	alias := models.ModelAlias{
+		ID:             primitive.NewObjectID(),
+		Pattern:        req.Pattern,
+		Regex:          req.Regex,
+		CanonicalModel: req.CanonicalModel,
+		Provider:       req.Provider,
+		Priority:       req.Priority,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	if _, err := h.db.Collection("model_aliases").InsertOne(c.Request.Context(), alias); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, alias)
+}
+
+// UpdateModelAlias replaces an alias
+func (h *ModelAliasHandler) UpdateModelAlias(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid alias ID"})
+		return
+	}
+
+	var req modelAliasRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Regex {
+		if _, err := regexp.Compile(req.Pattern); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid pattern: " + err.Error()})
+			return
+		}
+	}
+
+	var alias models.ModelAlias
+	err = h.db.Collection("model_aliases").FindOneAndUpdate(
+		c.Request.Context(),
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{
+			"pattern":        req.Pattern,
+			"regex":          req.Regex,
+			"canonicalModel": req.CanonicalModel,
+			"provider":       req.Provider,
+			"priority":       req.Priority,
+			"updatedAt":      time.Now(),
+		}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&alias)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Alias not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, alias)
+}
+
+// DeleteModelAlias removes an alias
+func (h *ModelAliasHandler) DeleteModelAlias(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid alias ID"})
+		return
+	}
+
+	result, err := h.db.Collection("model_aliases").DeleteOne(c.Request.Context(), bson.M{"_id": id})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if result.DeletedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Alias not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Alias deleted"})
+}
@@ -0,0 +1,285 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"freedom-ai/management-server/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ModelPriceHandler manages the model_prices catalog. The pricing service
+// caches the catalog, so changes apply to new records within a minute.
+type ModelPriceHandler struct {
+	db *mongo.Database
+}
+
+func NewModelPriceHandler(db *mongo.Database) *ModelPriceHandler {
+	return &ModelPriceHandler{db: db}
+}
+
+type modelPriceRequest struct {
+	Model                string             `json:"model" binding:"required"`
+	PromptPricePer1K     float64            `json:"promptPricePer1k" binding:"min=0"`
+	CompletionPricePer1K float64            `json:"completionPricePer1k" binding:"min=0"`
+	EffectiveFrom        time.Time          `json:"effectiveFrom" binding:"required"`
+	EffectiveTo          *time.Time         `json:"effectiveTo"`
+	Tiers                []models.PriceTier `json:"tiers"`
+	models.ClassPrices
+}
+
+// ListModelPrices returns the catalog, optionally filtered by model (developer only)
+func (h *ModelPriceHandler) ListModelPrices(c *gin.Context) {
+	filter := bson.M{}
+	if model := c.Query("model"); model != "" {
+		filter["model"] = model
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "model", Value: 1}, {Key: "effectiveFrom", Value: -1}})
+	cursor, err := h.db.Collection("model_prices").Find(c.Request.Context(), filter, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer cursor.Close(c.Request.Context())
+
+	prices := []models.ModelPrice{}
+	if err := cursor.All(c.Request.Context(), &prices); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, prices)
+}
+
+// CreateModelPrice adds a price for a model's effective window
+func (h *ModelPriceHandler) CreateModelPrice(c *gin.Context) {
+	var req modelPriceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.EffectiveTo != nil && !req.EffectiveTo.After(req.EffectiveFrom) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "effectiveTo must be after effectiveFrom"})
+		return
+	}
+	if err := models.ValidateTiers(req.Tiers); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.ClassPrices.Negative() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Prices cannot be negative"})
+		return
+	}
+
+	overlap, err := h.overlaps(c.Request.Context(), req, primitive.NilObjectID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if overlap {
+		c.JSON(http.StatusConflict, gin.H{"error": "A price for this model already covers part of that period"})
+		return
+	}
+
+	now := time.Now()
+	price := models.ModelPrice{
+		ID:                   primitive.NewObjectID(),
+		Model:                req.Model,
+		PromptPricePer1K:     req.PromptPricePer1K,
+		CompletionPricePer1K: req.CompletionPricePer1K,
+		EffectiveFrom:        req.EffectiveFrom,
+		EffectiveTo:          req.EffectiveTo,
+		ClassPrices:          req.ClassPrices,
+		Tiers:                req.Tiers,
+		CreatedAt:            now,
+		UpdatedAt:            now,
+	}
+	if _, err := h.db.Collection("model_prices").InsertOne(c.Request.Context(), price); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, price)
+}
+
+// UpdateModelPrice changes a price or its effective window
+func (h *ModelPriceHandler) UpdateModelPrice(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid price ID"})
+		return
+	}
+
+	var req modelPriceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.EffectiveTo != nil && !req.EffectiveTo.After(req.EffectiveFrom) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "effectiveTo must be after effectiveFrom"})
+		return
+	}
+	if err := models.ValidateTiers(req.Tiers); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.ClassPrices.Negative() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Prices cannot be negative"})
+		return
+	}
+
+	overlap, err := h.overlaps(c.Request.Context(), req, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if overlap {
+		c.JSON(http.StatusConflict, gin.H{"error": "A price for this model already covers part of that period"})
+		return
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"model":                req.Model,
+			"promptPricePer1k":     req.PromptPricePer1K,
+			"completionPricePer1k": req.CompletionPricePer1K,
+			"effectiveFrom":        req.EffectiveFrom,
+			"updatedAt":            time.Now(),
+		},
+	}
+	unset := bson.M{}
+	if req.EffectiveTo != nil {
+		update["$set"].(bson.M)["effectiveTo"] = *req.EffectiveTo
+	} else {
+		unset["effectiveTo"] = ""
+	}
+	if len(req.Tiers) > 0 {
+		update["$set"].(bson.M)["tiers"] = req.Tiers
+	} else {
+		unset["tiers"] = ""
+	}
+	if req.CachedPromptPricePer1K != nil {
+		update["$set"].(bson.M)["cachedPromptPricePer1k"] = *req.CachedPromptPricePer1K
+	} else {
+		unset["cachedPromptPricePer1k"] = ""
+	}
+	if req.ReasoningPricePer1K != nil {
+		update["$set"].(bson.M)["reasoningPricePer1k"] = *req.ReasoningPricePer1K
+	} else {
+		unset["reasoningPricePer1k"] = ""
+	}
+	update["$set"].(bson.M)["imagePricePer1k"] = req.ImagePricePer1K
+	update["$set"].(bson.M)["audioPricePer1k"] = req.AudioPricePer1K
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	var price models.ModelPrice
+	err = h.db.Collection("model_prices").FindOneAndUpdate(
+		c.Request.Context(),
+		bson.M{"_id": id},
+		update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&price)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Price not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, price)
+}
+
+// DeleteModelPrice removes a price. Records already priced keep their cost.
+func (h *ModelPriceHandler) DeleteModelPrice(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid price ID"})
+		return
+	}
+
+	result, err := h.db.Collection("model_prices").DeleteOne(c.Request.Context(), bson.M{"_id": id})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if result.DeletedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Price not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Price deleted"})
+}
+
+// GetUnpricedModels lists models that were recorded without a catalog price
+func (h *ModelPriceHandler) GetUnpricedModels(c *gin.Context) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"unpriced": true}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":         "$model",
+			"records":     bson.M{"$sum": 1},
+			"totalTokens": bson.M{"$sum": "$totalTokens"},
+			"firstSeen":   bson.M{"$min": "$timestamp"},
+			"lastSeen":    bson.M{"$max": "$timestamp"},
+		}}},
+		{{Key: "$sort", Value: bson.M{"records": -1}}},
+	}
+
+	cursor, err := h.db.Collection("token_consumption").Aggregate(c.Request.Context(), pipeline)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer cursor.Close(c.Request.Context())
+
+	var results []bson.M
+	if err := cursor.All(c.Request.Context(), &results); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	unpriced := make([]gin.H, 0, len(results))
+	for _, result := range results {
+		unpriced = append(unpriced, gin.H{
+			"model":       result["_id"],
+			"records":     result["records"],
+			"totalTokens": result["totalTokens"],
+			"firstSeen":   result["firstSeen"],
+			"lastSeen":    result["lastSeen"],
+		})
+	}
+
+	c.JSON(http.StatusOK, unpriced)
+}
+
+// overlaps reports whether another price for the same model intersects the
+// requested window
+func (h *ModelPriceHandler) overlaps(ctx context.Context, req modelPriceRequest, exclude primitive.ObjectID) (bool, error) {
+	filter := bson.M{
+		"model": req.Model,
+		"_id":   bson.M{"$ne": exclude},
+		"$or": bson.A{
+			bson.M{"effectiveTo": bson.M{"$exists": false}},
+			bson.M{"effectiveTo": bson.M{"$gt": req.EffectiveFrom}},
+		},
+	}
+	if req.EffectiveTo != nil {
+		filter["effectiveFrom"] = bson.M{"$lt": *req.EffectiveTo}
+	}
+
+	count, err := h.db.Collection("model_prices").CountDocuments(ctx, filter)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
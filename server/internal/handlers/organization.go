@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"freedom-ai/management-server/internal/models"
+	"freedom-ai/management-server/internal/services/privacy"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
@@ -81,3 +82,39 @@ func (h *OrganizationHandler) UpdateAutoTopUp(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Auto-top-up settings updated"})
 }
 
+// UpdateContentPolicy sets how much prompt and completion content is retained for debugging
+func (h *OrganizationHandler) UpdateContentPolicy(c *gin.Context) {
+	orgID := c.Param("id")
+	var req struct {
+		ContentPolicy string `json:"contentPolicy" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !privacy.ValidPolicy(req.ContentPolicy) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "contentPolicy must be one of: none, hashed, redacted"})
+		return
+	}
+
+	collection := h.db.Collection("organizations")
+	result, err := collection.UpdateOne(
+		c.Request.Context(),
+		bson.M{"orgId": orgID},
+		bson.M{"$set": bson.M{
+			"contentPolicy": req.ContentPolicy,
+			"updatedAt":     time.Now(),
+		}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Content policy updated"})
+}
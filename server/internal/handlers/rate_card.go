@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"freedom-ai/management-server/internal/models"
+	"freedom-ai/management-server/internal/services/consumption"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RateCardHandler manages organizations' negotiated rate cards. Like the
+// model price catalog, changes apply to new records within a minute.
+type RateCardHandler struct {
+	db *mongo.Database
+}
+
+func NewRateCardHandler(db *mongo.Database) *RateCardHandler {
+	return &RateCardHandler{db: db}
+}
+
+type rateCardRequest struct {
+	Name          string                `json:"name"`
+	Overrides     []models.RateOverride `json:"overrides"`
+	EffectiveFrom time.Time             `json:"effectiveFrom" binding:"required"`
+	EffectiveTo   *time.Time            `json:"effectiveTo"`
+}
+
+// ListRateCards returns every rate card version of an organization, newest first
+func (h *RateCardHandler) ListRateCards(c *gin.Context) {
+	orgID := c.Param("id")
+
+	opts := options.Find().SetSort(bson.D{{Key: "version", Value: -1}})
+	cursor, err := h.db.Collection("rate_cards").Find(c.Request.Context(), bson.M{"orgId": orgID}, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer cursor.Close(c.Request.Context())
+
+	cards := []models.RateCard{}
+	if err := cursor.All(c.Request.Context(), &cards); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, cards)
+}
+
+// CreateRateCard adds a new rate card version for an organization. The
+// previous version ends where the new one starts.
+func (h *RateCardHandler) CreateRateCard(c *gin.Context) {
+	orgID := c.Param("id")
+
+	var req rateCardRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.EffectiveTo != nil && !req.EffectiveTo.After(req.EffectiveFrom) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "effectiveTo must be after effectiveFrom"})
+		return
+	}
+	for _, override := range req.Overrides {
+		if override.Model == "" && override.AssistantType == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Each override needs a model or an assistantType"})
+			return
+		}
+		if len(override.Tiers) == 0 && override.PromptPricePer1K == nil && override.CompletionPricePer1K == nil && override.Multiplier <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Each override needs tiers, prices or a positive multiplier"})
+			return
+		}
+		if override.ClassPrices != nil && override.ClassPrices.Negative() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Prices and multipliers cannot be negative"})
+			return
+		}
+		if err := models.ValidateTiers(override.Tiers); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if (override.PromptPricePer1K != nil && *override.PromptPricePer1K < 0) ||
+			(override.CompletionPricePer1K != nil && *override.CompletionPricePer1K < 0) || override.Multiplier < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Prices and multipliers cannot be negative"})
+			return
+		}
+	}
+
+	count, err := h.db.Collection("organizations").CountDocuments(c.Request.Context(), bson.M{"orgId": orgID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if count == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tenant not found"})
+		return
+	}
+
+	collection := h.db.Collection("rate_cards")
+	version := 1
+	var latest models.RateCard
+	err = collection.FindOne(
+		c.Request.Context(),
+		bson.M{"orgId": orgID},
+		options.FindOne().SetSort(bson.D{{Key: "version", Value: -1}}),
+	).Decode(&latest)
+	switch {
+	case err == mongo.ErrNoDocuments:
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	default:
+		if req.EffectiveFrom.Before(latest.EffectiveFrom) {
+			c.JSON(http.StatusConflict, gin.H{"error": "A new version cannot start before the current version"})
+			return
+		}
+		version = latest.Version + 1
+	}
+
+	card := models.RateCard{
+		ID:            primitive.NewObjectID(),
+		OrgID:         orgID,
+		Version:       version,
+		Name:          req.Name,
+		Overrides:     req.Overrides,
+		EffectiveFrom: req.EffectiveFrom,
+		EffectiveTo:   req.EffectiveTo,
+		CreatedAt:     time.Now(),
+	}
+	if card.Overrides == nil {
+		card.Overrides = []models.RateOverride{}
+	}
+	if _, err := collection.InsertOne(c.Request.Context(), card); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Another version was created concurrently, retry"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Close the previous version's window at the new version's start
+	if version > 1 && (latest.EffectiveTo == nil || latest.EffectiveTo.After(req.EffectiveFrom)) {
+		_, err := collection.UpdateOne(
+			c.Request.Context(),
+			bson.M{"_id": latest.ID},
+			bson.M{"$set": bson.M{"effectiveTo": req.EffectiveFrom}},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	c.JSON(http.StatusCreated, card)
+}
+
+// GetTierUsage returns an organization's tier positions per model for a
+// billing cycle (?month=YYYY-MM, the month the cycle starts in; default the
+// current cycle)
+func (h *RateCardHandler) GetTierUsage(c *gin.Context) {
+	var org models.Organization
+	err := h.db.Collection("organizations").FindOne(c.Request.Context(), bson.M{"orgId": c.Param("id")}).Decode(&org)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	month := c.DefaultQuery("month", consumption.TierMonth(org.Calendar(), time.Now()))
+	if _, err := time.Parse("2006-01", month); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "month must be YYYY-MM"})
+		return
+	}
+
+	cursor, err := h.db.Collection("tier_usage").Find(c.Request.Context(), bson.M{"orgId": c.Param("id"), "month": month})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer cursor.Close(c.Request.Context())
+
+	usage := []models.TierUsage{}
+	if err := cursor.All(c.Request.Context(), &usage); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, usage)
+}
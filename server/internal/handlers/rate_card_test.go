@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"freedom-ai/management-server/internal/database/databasetest"
+	"freedom-ai/management-server/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestCreateRateCardRejectsInvalidOverrides(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"window ends before it starts", `{"effectiveFrom":"2026-02-01T00:00:00Z","effectiveTo":"2026-01-01T00:00:00Z","overrides":[]}`},
+		{"override without target", `{"effectiveFrom":"2026-01-01T00:00:00Z","overrides":[{"multiplier":0.8}]}`},
+		{"override without prices", `{"effectiveFrom":"2026-01-01T00:00:00Z","overrides":[{"model":"gpt-4"}]}`},
+		{"negative multiplier", `{"effectiveFrom":"2026-01-01T00:00:00Z","overrides":[{"model":"gpt-4","multiplier":-1,"promptPricePer1k":0.01}]}`},
+		{"negative price", `{"effectiveFrom":"2026-01-01T00:00:00Z","overrides":[{"model":"gpt-4","promptPricePer1k":-0.01}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, recorder := newTestContext(http.MethodPost, "/api/v1/admin/tenants/org-1/rate-cards", tt.body)
+			c.Params = gin.Params{{Key: "id", Value: "org-1"}}
+
+			// Validation happens before the database is used
+			(&RateCardHandler{}).CreateRateCard(c)
+			if recorder.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400 (%s)", recorder.Code, recorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestCreateRateCardVersions(t *testing.T) {
+	ctx := context.Background()
+	db := databasetest.Connect(t)
+	if _, err := db.Collection("organizations").InsertOne(ctx, bson.M{"orgId": "org-1"}); err != nil {
+		t.Fatalf("insert organization: %v", err)
+	}
+	h := NewRateCardHandler(db)
+
+	create := func(body string) int {
+		t.Helper()
+		c, recorder := newTestContext(http.MethodPost, "/api/v1/admin/tenants/org-1/rate-cards", body)
+		c.Params = gin.Params{{Key: "id", Value: "org-1"}}
+		h.CreateRateCard(c)
+		return recorder.Code
+	}
+
+	if code := create(`{"effectiveFrom":"2026-01-01T00:00:00Z","overrides":[{"model":"gpt-4","multiplier":0.8}]}`); code != http.StatusCreated {
+		t.Fatalf("create version 1: status %d", code)
+	}
+	if code := create(`{"effectiveFrom":"2026-03-01T00:00:00Z","overrides":[{"model":"gpt-4","multiplier":0.7}]}`); code != http.StatusCreated {
+		t.Fatalf("create version 2: status %d", code)
+	}
+	if code := create(`{"effectiveFrom":"2026-02-01T00:00:00Z","overrides":[]}`); code != http.StatusConflict {
+		t.Fatalf("version starting before the current one: status %d, want 409", code)
+	}
+
+	cursor, err := db.Collection("rate_cards").Find(ctx, bson.M{"orgId": "org-1"}, options.Find().SetSort(bson.M{"version": 1}))
+	if err != nil {
+		t.Fatalf("find rate cards: %v", err)
+	}
+	var cards []models.RateCard
+	if err := cursor.All(ctx, &cards); err != nil {
+		t.Fatalf("decode rate cards: %v", err)
+	}
+	if len(cards) != 2 || cards[0].Version != 1 || cards[1].Version != 2 {
+		body, _ := json.Marshal(cards)
+		t.Fatalf("rate cards = %s, want versions 1 and 2", body)
+	}
+	// The new version closes the previous one's window at its start
+	switchover := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	if cards[0].EffectiveTo == nil || !cards[0].EffectiveTo.Equal(switchover) {
+		t.Fatalf("version 1 ends at %v, want %v", cards[0].EffectiveTo, switchover)
+	}
+	if cards[1].EffectiveTo != nil {
+		t.Fatalf("version 2 ends at %v, want open-ended", cards[1].EffectiveTo)
+	}
+}
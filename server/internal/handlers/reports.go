@@ -1,9 +1,12 @@
 package handlers
 
 import (
+	"context"
 	"net/http"
 	"time"
 
+	"freedom-ai/management-server/internal/models"
+
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -34,7 +37,9 @@ func (h *ReportsHandler) GetMonthlyReport(c *gin.Context) {
 		return
 	}
 
-	monthStart := time.Date(monthTime.Year(), monthTime.Month(), 1, 0, 0, 0, 0, time.UTC)
+	// The month is the organization's billing cycle starting in it
+	calendar := organizationCalendar(c.Request.Context(), h.db, orgID)
+	monthStart := calendar.CycleFor(monthTime.Year(), monthTime.Month())
 	nextMonth := monthStart.AddDate(0, 1, 0)
 	prevMonth := monthStart.AddDate(0, -1, 0)
 
@@ -204,3 +209,13 @@ func (h *ReportsHandler) GetMonthlyReport(c *gin.Context) {
 
 	c.JSON(http.StatusOK, report)
 }
+
+// organizationCalendar returns an organization's billing calendar, or UTC
+// calendar months when the organization cannot be loaded
+func organizationCalendar(ctx context.Context, db *mongo.Database, orgID string) models.BillingCalendar {
+	var org models.Organization
+	if err := db.Collection("organizations").FindOne(ctx, bson.M{"orgId": orgID}).Decode(&org); err != nil {
+		return models.UTCCalendar
+	}
+	return org.Calendar()
+}
@@ -4,6 +4,8 @@ import (
 	"net/http"
 	"time"
 
+	"freedom-ai/management-server/internal/models"
+
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -46,8 +48,8 @@ func (h *RevenueHandler) GetTopUpFrequency(c *gin.Context) {
 						"date":   "$createdAt",
 					},
 				},
-				"count":  bson.M{"$sum": 1},
-				"totalAmount": bson.M{"$sum": "$amount"},
+				"count":         bson.M{"$sum": 1},
+				"totalAmount":   bson.M{"$sum": "$amount"},
 				"averageAmount": bson.M{"$avg": "$amount"},
 			},
 		},
@@ -77,7 +79,8 @@ func (h *RevenueHandler) GetBillingDeductionsByDay(c *gin.Context) {
 	startDate := c.Query("startDate")
 	endDate := c.Query("endDate")
 
-	match := bson.M{"status": "completed"}
+	// Refunds repay top-ups and are not revenue
+	match := bson.M{"status": "completed", "type": bson.M{"$ne": models.BillingTypeRefund}}
 	if orgID != "" {
 		match["organizationId"] = orgID
 	}
@@ -130,7 +133,8 @@ func (h *RevenueHandler) GetRevenueByTenant(c *gin.Context) {
 	startDate := c.Query("startDate")
 	endDate := c.Query("endDate")
 
-	match := bson.M{"status": "completed"}
+	// Refunds repay top-ups and are not revenue
+	match := bson.M{"status": "completed", "type": bson.M{"$ne": models.BillingTypeRefund}}
 	if startDate != "" && endDate != "" {
 		start, _ := time.Parse(time.RFC3339, startDate)
 		end, _ := time.Parse(time.RFC3339, endDate)
@@ -144,9 +148,9 @@ func (h *RevenueHandler) GetRevenueByTenant(c *gin.Context) {
 		{"$match": match},
 		{
 			"$group": bson.M{
-				"_id":         "$organizationId",
+				"_id":          "$organizationId",
 				"totalRevenue": bson.M{"$sum": "$totalCost"},
-				"totalTokens": bson.M{"$sum": "$totalTokens"},
+				"totalTokens":  bson.M{"$sum": "$totalTokens"},
 				"billingCount": bson.M{"$sum": 1},
 			},
 		},
@@ -169,4 +173,3 @@ func (h *RevenueHandler) GetRevenueByTenant(c *gin.Context) {
 
 	c.JSON(http.StatusOK, results)
 }
-
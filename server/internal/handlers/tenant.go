@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"freedom-ai/management-server/internal/models"
+	"freedom-ai/management-server/internal/services/ledger"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
@@ -13,11 +14,12 @@ import (
 )
 
 type TenantHandler struct {
-	db *mongo.Database
+	db     *mongo.Database
+	ledger *ledger.Service
 }
 
-func NewTenantHandler(db *mongo.Database) *TenantHandler {
-	return &TenantHandler{db: db}
+func NewTenantHandler(db *mongo.Database, ledgerService *ledger.Service) *TenantHandler {
+	return &TenantHandler{db: db, ledger: ledgerService}
 }
 
 // ListTenants returns all tenants (developer only)
@@ -42,7 +44,7 @@ func (h *TenantHandler) ListTenants(c *gin.Context) {
 		models.Organization
 		UserCount int64 `json:"userCount"`
 	}
-	
+
 	tenantsWithCounts := make([]TenantWithCount, len(tenants))
 	for i, tenant := range tenants {
 		userCount, _ := userCollection.CountDocuments(c.Request.Context(), bson.M{
@@ -85,6 +87,19 @@ func (h *TenantHandler) CreateTenant(c *gin.Context) {
 		return
 	}
 
+	if err := models.ValidateBillingCalendar(tenant.Timezone, tenant.BillingAnchorDay); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := models.ValidateAccountMode(tenant.AccountMode, tenant.CreditLimit); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := models.ValidateDunningPolicy(tenant.DunningPolicy); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	tenant.ID = primitive.NewObjectID()
 	tenant.CreatedAt = time.Now()
 	tenant.UpdatedAt = time.Now()
@@ -94,6 +109,14 @@ func (h *TenantHandler) CreateTenant(c *gin.Context) {
 	if tenant.WalletBalance == 0 {
 		tenant.WalletBalance = 0
 	}
+	// Restriction follows the balance
+	tenant.Restricted = tenant.OverCreditLimit()
+	tenant.RestrictedAt = nil
+	tenant.Dunning = nil
+	if tenant.Restricted {
+		now := time.Now()
+		tenant.RestrictedAt = &now
+	}
 
 	collection := h.db.Collection("organizations")
 	_, err := collection.InsertOne(c.Request.Context(), tenant)
@@ -102,6 +125,12 @@ func (h *TenantHandler) CreateTenant(c *gin.Context) {
 		return
 	}
 
+	// Record the initial balance so the ledger accounts for it
+	if err := h.ledger.RecordOpeningBalance(c.Request.Context(), tenant.OrgID, tenant.WalletBalance); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
 	c.JSON(http.StatusCreated, tenant)
 }
 
@@ -114,12 +143,38 @@ func (h *TenantHandler) UpdateTenant(c *gin.Context) {
 		return
 	}
 
+	if err := models.ValidateBillingCalendar(updates.Timezone, updates.BillingAnchorDay); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := models.ValidateAccountMode(updates.AccountMode, updates.CreditLimit); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := models.ValidateDunningPolicy(updates.DunningPolicy); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	updates.UpdatedAt = time.Now()
+
+	// The wallet balance only changes through ledger entries, and the
+	// restriction follows it
+	set, err := toBSONMap(updates)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	delete(set, "walletBalance")
+	delete(set, "restricted")
+	delete(set, "restrictedAt")
+	delete(set, "dunning")
+
 	collection := h.db.Collection("organizations")
 	result, err := collection.UpdateOne(
 		c.Request.Context(),
 		bson.M{"orgId": id},
-		bson.M{"$set": updates},
+		bson.M{"$set": set},
 	)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -131,6 +186,17 @@ func (h *TenantHandler) UpdateTenant(c *gin.Context) {
 		return
 	}
 
+	// A new account mode or credit limit can move the credit floor
+	var tenant models.Organization
+	if err := collection.FindOne(c.Request.Context(), bson.M{"orgId": id}).Decode(&tenant); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.ledger.UpdateRestriction(c.Request.Context(), tenant); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "Tenant updated"})
 }
 
@@ -160,3 +226,15 @@ func (h *TenantHandler) DeleteTenant(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Tenant deactivated"})
 }
 
+// toBSONMap converts a document to a map so individual fields can be dropped from an update
+func toBSONMap(doc interface{}) (bson.M, error) {
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var m bson.M
+	if err := bson.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
@@ -37,8 +37,7 @@ func (h *TenantHandler) GetTenantDetails(c *gin.Context) {
 
 	// Get consumption summary
 	consumptionCollection := h.db.Collection("token_consumption")
-	now := time.Now().UTC()
-	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	monthStart := tenant.Calendar().CycleStart(time.Now())
 
 	consumptionPipeline := []bson.M{
 		{
@@ -90,4 +89,3 @@ func (h *TenantHandler) GetTenantDetails(c *gin.Context) {
 
 	c.JSON(http.StatusOK, details)
 }
-
@@ -142,7 +142,7 @@ func (h *UsagePatternsHandler) GetUsageByAssistantType(c *gin.Context) {
 		{"$match": match},
 		{
 			"$group": bson.M{
-				"_id":   "$assistantType",
+				"_id":    "$assistantType",
 				"tokens": bson.M{"$sum": "$totalTokens"},
 				"cost":   bson.M{"$sum": "$cost"},
 				"count":  bson.M{"$sum": 1},
@@ -168,6 +168,57 @@ func (h *UsagePatternsHandler) GetUsageByAssistantType(c *gin.Context) {
 	c.JSON(http.StatusOK, results)
 }
 
+// GetUsageByModel returns usage grouped by canonical model
+func (h *UsagePatternsHandler) GetUsageByModel(c *gin.Context) {
+	orgID := c.Query("organizationId")
+	startDate := c.Query("startDate")
+	endDate := c.Query("endDate")
+
+	match := bson.M{"status": "complete"}
+	if orgID != "" {
+		match["organizationId"] = orgID
+	}
+	if startDate != "" && endDate != "" {
+		start, _ := time.Parse(time.RFC3339, startDate)
+		end, _ := time.Parse(time.RFC3339, endDate)
+		match["timestamp"] = bson.M{
+			"$gte": start,
+			"$lte": end,
+		}
+	}
+
+	pipeline := []bson.M{
+		{"$match": match},
+		{
+			"$group": bson.M{
+				"_id":               "$model",
+				"provider":          bson.M{"$first": "$provider"},
+				"tokens":            bson.M{"$sum": "$totalTokens"},
+				"cost":              bson.M{"$sum": "$cost"},
+				"count":             bson.M{"$sum": 1},
+				"avgResponseTimeMs": bson.M{"$avg": "$responseTimeMs"},
+			},
+		},
+		{"$sort": bson.M{"tokens": -1}},
+	}
+
+	collection := h.db.Collection("token_consumption")
+	cursor, err := collection.Aggregate(c.Request.Context(), pipeline)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer cursor.Close(c.Request.Context())
+
+	var results []bson.M
+	if err := cursor.All(c.Request.Context(), &results); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, results)
+}
+
 // GetUserActivityPatterns returns user activity patterns
 func (h *UsagePatternsHandler) GetUserActivityPatterns(c *gin.Context) {
 	orgID := c.Query("organizationId")
@@ -191,10 +242,10 @@ func (h *UsagePatternsHandler) GetUserActivityPatterns(c *gin.Context) {
 		{"$match": match},
 		{
 			"$group": bson.M{
-				"_id":   "$userId",
-				"tokens": bson.M{"$sum": "$totalTokens"},
-				"cost":   bson.M{"$sum": "$cost"},
-				"count":  bson.M{"$sum": 1},
+				"_id":        "$userId",
+				"tokens":     bson.M{"$sum": "$totalTokens"},
+				"cost":       bson.M{"$sum": "$cost"},
+				"count":      bson.M{"$sum": 1},
 				"lastActive": bson.M{"$max": "$timestamp"},
 			},
 		},
@@ -218,4 +269,3 @@ func (h *UsagePatternsHandler) GetUserActivityPatterns(c *gin.Context) {
 
 	c.JSON(http.StatusOK, results)
 }
-
@@ -0,0 +1,72 @@
+package kafka
+
+import (
+	"sync"
+
+	kafkago "github.com/segmentio/kafka-go"
+)
+
+// offsetTracker records which fetched messages have been handled so that only
+// contiguous prefixes of each partition are committed
+type offsetTracker struct {
+	mu         sync.Mutex
+	partitions map[int]*partitionOffsets
+}
+
+type partitionOffsets struct {
+	// inFlight holds fetched messages in offset order
+	inFlight []kafkago.Message
+	handled  map[int64]bool
+	// last is the newest message whose offset, and every one before it, is handled
+	last *kafkago.Message
+}
+
+func newOffsetTracker() *offsetTracker {
+	return &offsetTracker{partitions: make(map[int]*partitionOffsets)}
+}
+
+func (t *offsetTracker) fetched(msg kafkago.Message) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.partitions[msg.Partition]
+	if !ok {
+		p = &partitionOffsets{handled: make(map[int64]bool)}
+		t.partitions[msg.Partition] = p
+	}
+	p.inFlight = append(p.inFlight, msg)
+}
+
+func (t *offsetTracker) done(msg kafkago.Message) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.partitions[msg.Partition]
+	if !ok {
+		return
+	}
+	p.handled[msg.Offset] = true
+
+	for len(p.inFlight) > 0 && p.handled[p.inFlight[0].Offset] {
+		head := p.inFlight[0]
+		delete(p.handled, head.Offset)
+		p.inFlight = p.inFlight[1:]
+		p.last = &head
+	}
+}
+
+// committable returns, per partition, the newest message that can be committed
+// since the previous call
+func (t *offsetTracker) committable() []kafkago.Message {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var msgs []kafkago.Message
+	for _, p := range t.partitions {
+		if p.last != nil {
+			msgs = append(msgs, *p.last)
+			p.last = nil
+		}
+	}
+	return msgs
+}
@@ -0,0 +1,378 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"freedom-ai/management-server/internal/config"
+	"freedom-ai/management-server/internal/events"
+	"freedom-ai/management-server/internal/eventsource"
+	"freedom-ai/management-server/internal/metrics"
+
+	kafkago "github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+)
+
+const (
+	headerRetryCount       = "x-retry-count"
+	headerLastError        = "x-last-error"
+	headerDeadLetterReason = "x-dead-letter-reason"
+	headerDeadLetteredAt   = "x-dead-lettered-at"
+	headerRetryAt          = "x-retry-at"
+
+	commitInterval = time.Second
+	fetchBackoff   = 5 * time.Second
+)
+
+// Source consumes collector events from Kafka as a consumer group. Offsets are
+// committed only once every earlier message in the partition has been handled,
+// so a crash never skips a message that was still waiting in a batch.
+//
+// Kafka has no per-message delay, so failed messages wait in a retry topic per
+// backoff delay (<topic>.retry.<delay>ms) and are forwarded back to their topic
+// once due. Every message in a retry topic waits the same delay, so they fall
+// due in order and a long backoff never holds up a shorter one.
+type Source struct {
+	brokers        []string
+	groupID        string
+	requestTopic   string
+	responseTopic  string
+	maxRetries     int
+	retryBaseDelay time.Duration
+	prefetch       int
+	writer         *kafkago.Writer
+	logger         *zap.Logger
+}
+
+func NewSource(cfg *config.Config, logger *zap.Logger) (*Source, error) {
+	if len(cfg.KafkaBrokers) == 0 {
+		return nil, errors.New("KAFKA_BROKERS is required for the kafka event source")
+	}
+
+	return &Source{
+		brokers:        cfg.KafkaBrokers,
+		groupID:        cfg.KafkaGroupID,
+		requestTopic:   cfg.KafkaRequestTopic,
+		responseTopic:  cfg.KafkaResponseTopic,
+		maxRetries:     cfg.EventMaxRetries,
+		retryBaseDelay: time.Duration(cfg.EventRetryBaseDelayMs) * time.Millisecond,
+		prefetch:       cfg.EventPrefetch,
+		writer: &kafkago.Writer{
+			Addr:                   kafkago.TCP(cfg.KafkaBrokers...),
+			Balancer:               &kafkago.Hash{},
+			RequiredAcks:           kafkago.RequireAll,
+			AllowAutoTopicCreation: true,
+		},
+		logger: logger,
+	}, nil
+}
+
+// Name identifies the source in logs
+func (s *Source) Name() string {
+	return "kafka"
+}
+
+func retryTopic(topic string, delay time.Duration) string {
+	return fmt.Sprintf("%s.retry.%dms", topic, delay.Milliseconds())
+}
+
+// Start consumes both topics, and forwards due retries back to them, until ctx
+// is cancelled
+func (s *Source) Start(ctx context.Context, handle eventsource.Handler) error {
+	delays := eventsource.RetryDelays(s.retryBaseDelay, s.maxRetries)
+	if err := s.createRetryTopics(ctx, delays); err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	for _, t := range []struct{ topic, kind string }{
+		{s.requestTopic, eventsource.KindRequest},
+		{s.responseTopic, eventsource.KindResponse},
+	} {
+		wg.Add(1)
+		go func(topic, kind string) {
+			defer wg.Done()
+			s.consume(ctx, topic, kind, handle)
+		}(t.topic, t.kind)
+
+		for _, delay := range delays {
+			wg.Add(1)
+			go func(from, to string) {
+				defer wg.Done()
+				s.forwardRetries(ctx, from, to)
+			}(retryTopic(t.topic, delay), t.topic)
+		}
+	}
+
+	s.logger.Info("Kafka consumer started",
+		zap.Strings("brokers", s.brokers),
+		zap.String("groupId", s.groupID),
+		zap.String("requestTopic", s.requestTopic),
+		zap.String("responseTopic", s.responseTopic))
+
+	wg.Wait()
+	return nil
+}
+
+func (s *Source) consume(ctx context.Context, topic, kind string, handle eventsource.Handler) {
+	reader := kafkago.NewReader(kafkago.ReaderConfig{
+		Brokers:       s.brokers,
+		GroupID:       s.groupID,
+		Topic:         topic,
+		QueueCapacity: s.prefetch,
+		StartOffset:   kafkago.FirstOffset,
+	})
+	defer reader.Close()
+
+	tracker := newOffsetTracker()
+	committerDone := make(chan struct{})
+	go func() {
+		defer close(committerDone)
+		s.commitLoop(ctx, reader, tracker)
+	}()
+
+	for {
+		msg, err := reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			s.logger.Warn("Failed to fetch Kafka message", zap.String("topic", topic), zap.Error(err))
+			select {
+			case <-ctx.Done():
+			case <-time.After(fetchBackoff):
+			}
+			continue
+		}
+
+		tracker.fetched(msg)
+		handle(&message{source: s, tracker: tracker, msg: msg, kind: kind})
+	}
+
+	<-committerDone
+}
+
+// createRetryTopics creates any missing retry topics, since a consumer group
+// does not pick up a topic created after it joined
+func (s *Source) createRetryTopics(ctx context.Context, delays []time.Duration) error {
+	var topics []kafkago.TopicConfig
+	for _, topic := range []string{s.requestTopic, s.responseTopic} {
+		for _, delay := range delays {
+			// -1 uses the broker's default partitions and replication (Kafka 2.4+)
+			topics = append(topics, kafkago.TopicConfig{Topic: retryTopic(topic, delay), NumPartitions: -1, ReplicationFactor: -1})
+		}
+	}
+	if len(topics) == 0 {
+		return nil
+	}
+
+	client := &kafkago.Client{Addr: kafkago.TCP(s.brokers...), Timeout: 10 * time.Second}
+	resp, err := client.CreateTopics(ctx, &kafkago.CreateTopicsRequest{Topics: topics})
+	if err != nil {
+		return fmt.Errorf("failed to create Kafka retry topics: %w", err)
+	}
+	for topic, err := range resp.Errors {
+		if err != nil && !errors.Is(err, kafkago.TopicAlreadyExists) {
+			return fmt.Errorf("failed to create Kafka retry topic %s: %w", topic, err)
+		}
+	}
+	return nil
+}
+
+// forwardRetries republishes messages from a retry topic to their original
+// topic once their delay has passed. The offset is committed after each
+// message is forwarded; a crash in between forwards it twice, which is
+// harmless because ingestion is idempotent on requestId.
+func (s *Source) forwardRetries(ctx context.Context, from, to string) {
+	reader := kafkago.NewReader(kafkago.ReaderConfig{
+		Brokers:     s.brokers,
+		GroupID:     s.groupID,
+		Topic:       from,
+		StartOffset: kafkago.FirstOffset,
+	})
+	defer reader.Close()
+
+	for {
+		msg, err := reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			s.logger.Warn("Failed to fetch Kafka retry message", zap.String("topic", from), zap.Error(err))
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(fetchBackoff):
+			}
+			continue
+		}
+
+		if v, ok := header(msg, headerRetryAt); ok {
+			if due, err := time.Parse(time.RFC3339Nano, v); err == nil {
+				select {
+				case <-ctx.Done():
+					// Uncommitted, so forwarded after a restart
+					return
+				case <-time.After(time.Until(due)):
+				}
+			}
+		}
+
+		for {
+			err := s.publish(to, msg, nil)
+			if err == nil {
+				break
+			}
+			s.logger.Error("Failed to forward Kafka retry message", zap.String("topic", to), zap.Error(err))
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(fetchBackoff):
+			}
+		}
+
+		if err := reader.CommitMessages(ctx, msg); err != nil && ctx.Err() == nil {
+			s.logger.Warn("Failed to commit Kafka retry offset", zap.String("topic", from), zap.Error(err))
+		}
+	}
+}
+
+// commitLoop periodically commits the highest fully handled offset of each
+// partition, and once more when ctx is cancelled
+func (s *Source) commitLoop(ctx context.Context, reader *kafkago.Reader, tracker *offsetTracker) {
+	ticker := time.NewTicker(commitInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// Give in-flight batches a chance to be committed on shutdown
+			s.commit(context.Background(), reader, tracker)
+			return
+		case <-ticker.C:
+			s.commit(ctx, reader, tracker)
+		}
+	}
+}
+
+func (s *Source) commit(ctx context.Context, reader *kafkago.Reader, tracker *offsetTracker) {
+	committable := tracker.committable()
+	if len(committable) == 0 {
+		return
+	}
+	if err := reader.CommitMessages(ctx, committable...); err != nil {
+		// Partitions may have moved in a rebalance; the new owner redelivers from the last commit
+		s.logger.Warn("Failed to commit Kafka offsets", zap.Error(err))
+	}
+}
+
+// publish writes msg to topic with the given headers
+func (s *Source) publish(topic string, msg kafkago.Message, headers map[string]string) error {
+	out := kafkago.Message{Topic: topic, Key: msg.Key, Value: msg.Value}
+	for _, h := range msg.Headers {
+		if _, replaced := headers[h.Key]; !replaced {
+			out.Headers = append(out.Headers, h)
+		}
+	}
+	for k, v := range headers {
+		out.Headers = append(out.Headers, kafkago.Header{Key: k, Value: []byte(v)})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return s.writer.WriteMessages(ctx, out)
+}
+
+func (s *Source) Close() error {
+	return s.writer.Close()
+}
+
+// message adapts a Kafka message to eventsource.Message
+type message struct {
+	source  *Source
+	tracker *offsetTracker
+	msg     kafkago.Message
+	kind    string
+}
+
+func (m *message) Kind() string { return m.kind }
+
+func (m *message) Body() []byte { return m.msg.Value }
+
+func (m *message) SchemaVersion() int {
+	if v, ok := header(m.msg, events.SchemaVersionHeader); ok {
+		return events.VersionFromHeader(v)
+	}
+	return 0
+}
+
+func (m *message) Ack() { m.tracker.done(m.msg) }
+
+// Nack publishes the message to the retry topic for its backoff delay with an
+// incremented retry count, or dead-letters it once the retry budget is spent
+func (m *message) Nack(cause error) {
+	retries := 0
+	if v, ok := header(m.msg, headerRetryCount); ok {
+		retries, _ = strconv.Atoi(v)
+	}
+	if retries >= m.source.maxRetries {
+		m.DeadLetter(fmt.Sprintf("retries exhausted: %v", cause))
+		return
+	}
+
+	delay := eventsource.RetryDelay(m.source.retryBaseDelay, retries)
+	err := m.source.publish(retryTopic(m.msg.Topic, delay), m.msg, map[string]string{
+		headerRetryCount: strconv.Itoa(retries + 1),
+		headerLastError:  cause.Error(),
+		headerRetryAt:    time.Now().Add(delay).UTC().Format(time.RFC3339Nano),
+	})
+	if err != nil {
+		// Leave the offset uncommitted so the message is redelivered after a restart or rebalance
+		m.source.logger.Error("Failed to schedule Kafka message retry", zap.String("topic", m.msg.Topic), zap.Error(err))
+		return
+	}
+
+	metrics.Ingestion.Add("retries", 1)
+	m.source.logger.Warn("Scheduled message retry",
+		zap.String("topic", m.msg.Topic),
+		zap.Int("attempt", retries+1),
+		zap.Duration("delay", delay),
+		zap.Error(cause))
+	m.tracker.done(m.msg)
+}
+
+// DeadLetter publishes the message to <topic>.dlq without its prompt and
+// completion content
+func (m *message) DeadLetter(reason string) {
+	stripped := m.msg
+	stripped.Value = events.StripContent(m.msg.Value)
+	err := m.source.publish(m.msg.Topic+".dlq", stripped, map[string]string{
+		headerDeadLetterReason: reason,
+		headerDeadLetteredAt:   time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		m.source.logger.Error("Failed to dead-letter Kafka message", zap.String("topic", m.msg.Topic), zap.Error(err))
+		return
+	}
+
+	metrics.Ingestion.Add("dead_lettered", 1)
+	m.source.logger.Warn("Dead-lettered message",
+		zap.String("topic", m.msg.Topic),
+		zap.Int("partition", m.msg.Partition),
+		zap.Int64("offset", m.msg.Offset),
+		zap.String("reason", reason))
+	m.tracker.done(m.msg)
+}
+
+func header(msg kafkago.Message, key string) (string, bool) {
+	for _, h := range msg.Headers {
+		if h.Key == key {
+			return string(h.Value), true
+		}
+	}
+	return "", false
+}
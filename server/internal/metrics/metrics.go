@@ -0,0 +1,18 @@
+package metrics
+
+import (
+	"expvar"
+)
+
+// Ingestion counts consumption ingestion outcomes (processed, duplicates, ...).
+// Values are published on the /metrics endpoint through expvar.
+var Ingestion = expvar.NewMap("ingestion")
+
+// RejectedEvents counts collector events rejected by schema validation, keyed by reason.
+var RejectedEvents = expvar.NewMap("rejected_events")
+
+// Archive counts raw events written to the event archive (events, chunks, flush_errors, dropped).
+var Archive = expvar.NewMap("archive")
+
+// Ledger counts wallet ledger activity (entries, duplicates, drift_orgs, restriction_changes).
+var Ledger = expvar.NewMap("ledger")
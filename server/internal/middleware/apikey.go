@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"freedom-ai/management-server/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EnsureIngestKeyIndexes creates the index RequireIngestKey looks keys up by
+func EnsureIngestKeyIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("ingest_api_keys").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "keyHash", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create ingest_api_keys index: %w", err)
+	}
+	return nil
+}
+
+// HashAPIKey returns the hash under which an API key is stored
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// RequireIngestKey authenticates ingestion clients by the X-API-Key header or a
+// bearer token matching an unrevoked key in ingest_api_keys. The key's
+// organization is set as "ingestOrgId".
+func RequireIngestKey(db *mongo.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		provided := c.GetHeader("X-API-Key")
+		if provided == "" {
+			provided = strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		}
+		if provided == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+			return
+		}
+
+		var key models.IngestKey
+		err := db.Collection("ingest_api_keys").FindOneAndUpdate(c.Request.Context(),
+			bson.M{"keyHash": HashAPIKey(provided), "revokedAt": nil},
+			bson.M{"$set": bson.M{"lastUsedAt": time.Now()}},
+		).Decode(&key)
+		if err == mongo.ErrNoDocuments {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.Set("ingestOrgId", key.OrgID)
+		c.Next()
+	}
+}
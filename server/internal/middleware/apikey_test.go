@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"freedom-ai/management-server/internal/database/databasetest"
+	"freedom-ai/management-server/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestRequireIngestKey(t *testing.T) {
+	ctx := context.Background()
+	db := databasetest.Connect(t)
+	if err := EnsureIngestKeyIndexes(ctx, db); err != nil {
+		t.Fatalf("EnsureIngestKeyIndexes: %v", err)
+	}
+
+	revokedAt := time.Now()
+	for _, key := range []models.IngestKey{
+		{OrgID: "org-1", Name: "collector", KeyHash: HashAPIKey("live-key"), CreatedAt: time.Now()},
+		{OrgID: "org-2", Name: "old", KeyHash: HashAPIKey("revoked-key"), CreatedAt: time.Now(), RevokedAt: &revokedAt},
+	} {
+		if _, err := db.Collection("ingest_api_keys").InsertOne(ctx, key); err != nil {
+			t.Fatalf("insert key: %v", err)
+		}
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/ingest", RequireIngestKey(db), func(c *gin.Context) {
+		c.String(http.StatusOK, c.GetString("ingestOrgId"))
+	})
+
+	tests := []struct {
+		name       string
+		header     string
+		value      string
+		wantStatus int
+		wantOrg    string
+	}{
+		{name: "API key header", header: "X-API-Key", value: "live-key", wantStatus: http.StatusOK, wantOrg: "org-1"},
+		{name: "bearer token", header: "Authorization", value: "Bearer live-key", wantStatus: http.StatusOK, wantOrg: "org-1"},
+		{name: "missing", wantStatus: http.StatusUnauthorized},
+		{name: "unknown", header: "X-API-Key", value: "other-key", wantStatus: http.StatusUnauthorized},
+		{name: "revoked", header: "X-API-Key", value: "revoked-key", wantStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/ingest", nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, req)
+
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", recorder.Code, tt.wantStatus)
+			}
+			if tt.wantOrg != "" && recorder.Body.String() != tt.wantOrg {
+				t.Fatalf("ingestOrgId = %q, want %q", recorder.Body.String(), tt.wantOrg)
+			}
+		})
+	}
+
+	var key models.IngestKey
+	if err := db.Collection("ingest_api_keys").FindOne(ctx, bson.M{"orgId": "org-1"}).Decode(&key); err != nil {
+		t.Fatalf("load key: %v", err)
+	}
+	if key.LastUsedAt == nil {
+		t.Fatal("lastUsedAt not recorded")
+	}
+}
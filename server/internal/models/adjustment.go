@@ -0,0 +1,59 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Reason codes for manual adjustments, credit notes and refunds
+const (
+	ReasonBillingError      = "billing_error"
+	ReasonDuplicateCharge   = "duplicate_charge"
+	ReasonPricingCorrection = "pricing_correction"
+	ReasonServiceIssue      = "service_issue"
+	ReasonGoodwill          = "goodwill"
+	ReasonCustomerRequest   = "customer_request"
+	ReasonFraud             = "fraud"
+	ReasonOther             = "other"
+)
+
+// ReasonCodes lists the valid reason codes
+var ReasonCodes = []string{
+	ReasonBillingError,
+	ReasonDuplicateCharge,
+	ReasonPricingCorrection,
+	ReasonServiceIssue,
+	ReasonGoodwill,
+	ReasonCustomerRequest,
+	ReasonFraud,
+	ReasonOther,
+}
+
+// ValidReasonCode reports whether code is one of ReasonCodes
+func ValidReasonCode(code string) bool {
+	for _, c := range ReasonCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// CreditNote credits an organization's wallet against a billing history entry
+// or an invoice. Credit notes are numbered sequentially (CN-000001) and the
+// total credited against a charge never exceeds it.
+type CreditNote struct {
+	ID               primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	Number           string              `bson:"number" json:"number"`
+	Sequence         int64               `bson:"sequence" json:"sequence"`
+	OrgID            string              `bson:"orgId" json:"orgId"`
+	BillingHistoryID *primitive.ObjectID `bson:"billingHistoryId,omitempty" json:"billingHistoryId,omitempty"`
+	InvoiceID        *primitive.ObjectID `bson:"invoiceId,omitempty" json:"invoiceId,omitempty"`
+	InvoiceNumber    string              `bson:"invoiceNumber,omitempty" json:"invoiceNumber,omitempty"`
+	Amount           float64             `bson:"amount" json:"amount"`
+	ReasonCode       string              `bson:"reasonCode" json:"reasonCode"`
+	Note             string              `bson:"note" json:"note"`
+	IssuedBy         string              `bson:"issuedBy,omitempty" json:"issuedBy,omitempty"`
+	IssuedAt         time.Time           `bson:"issuedAt" json:"issuedAt"`
+}
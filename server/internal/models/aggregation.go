@@ -7,24 +7,27 @@ import (
 )
 
 type DailyConsumption struct {
-	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	OrganizationID string            `bson:"organizationId" json:"organizationId"`
-	Date           time.Time         `bson:"date" json:"date"`
-	TotalTokens    int64             `bson:"totalTokens" json:"totalTokens"`
-	TotalCost      float64           `bson:"totalCost" json:"totalCost"`
+	ID             primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
+	OrganizationID string               `bson:"organizationId" json:"organizationId"`
+	Date           time.Time            `bson:"date" json:"date"`
+	TotalTokens    int64                `bson:"totalTokens" json:"totalTokens"`
+	TotalCost      float64              `bson:"totalCost" json:"totalCost"`
 	Breakdown      ConsumptionBreakdown `bson:"breakdown" json:"breakdown"`
-	CreatedAt      time.Time         `bson:"createdAt" json:"createdAt"`
+	CreatedAt      time.Time            `bson:"createdAt" json:"createdAt"`
 }
 
 type MonthlyConsumption struct {
 	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	OrganizationID string            `bson:"organizationId" json:"organizationId"`
-	Month          string            `bson:"month" json:"month"` // "2024-12"
-	TotalTokens    int64             `bson:"totalTokens" json:"totalTokens"`
-	TotalCost      float64           `bson:"totalCost" json:"totalCost"`
-	Breakdown      ConsumptionBreakdown `bson:"breakdown" json:"breakdown"`
-	CreatedAt      time.Time         `bson:"createdAt" json:"createdAt"`
-	UpdatedAt      time.Time         `bson:"updatedAt" json:"updatedAt"`
+	OrganizationID string             `bson:"organizationId" json:"organizationId"`
+	Month          string             `bson:"month" json:"month"` // "2024-12"
+	// PeriodStart and PeriodEnd bound the organization's billing cycle starting in Month
+	PeriodStart time.Time            `bson:"periodStart" json:"periodStart"`
+	PeriodEnd   time.Time            `bson:"periodEnd" json:"periodEnd"`
+	TotalTokens int64                `bson:"totalTokens" json:"totalTokens"`
+	TotalCost   float64              `bson:"totalCost" json:"totalCost"`
+	Breakdown   ConsumptionBreakdown `bson:"breakdown" json:"breakdown"`
+	CreatedAt   time.Time            `bson:"createdAt" json:"createdAt"`
+	UpdatedAt   time.Time            `bson:"updatedAt" json:"updatedAt"`
 }
 
 type ConsumptionBreakdown struct {
@@ -40,15 +43,15 @@ type ProjectBreakdown struct {
 }
 
 type ProjectConsumptionMonthly struct {
-	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	OrganizationID string            `bson:"organizationId" json:"organizationId"`
-	ProjectID      string            `bson:"projectId" json:"projectId"`
-	Month          string            `bson:"month" json:"month"` // "2024-12"
-	TotalTokens    int64             `bson:"totalTokens" json:"totalTokens"`
-	TotalCost      float64           `bson:"totalCost" json:"totalCost"`
-	RequestCount   int64             `bson:"requestCount" json:"requestCount"`
+	ID             primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
+	OrganizationID string                 `bson:"organizationId" json:"organizationId"`
+	ProjectID      string                 `bson:"projectId" json:"projectId"`
+	Month          string                 `bson:"month" json:"month"` // "2024-12"
+	TotalTokens    int64                  `bson:"totalTokens" json:"totalTokens"`
+	TotalCost      float64                `bson:"totalCost" json:"totalCost"`
+	RequestCount   int64                  `bson:"requestCount" json:"requestCount"`
 	Breakdown      ProjectBreakdownDetail `bson:"breakdown" json:"breakdown"`
-	UpdatedAt      time.Time         `bson:"updatedAt" json:"updatedAt"`
+	UpdatedAt      time.Time              `bson:"updatedAt" json:"updatedAt"`
 }
 
 type ProjectBreakdownDetail struct {
@@ -67,4 +70,3 @@ type UserProjectBreakdown struct {
 	Cost     float64 `bson:"cost" json:"cost"`
 	Requests int64   `bson:"requests" json:"requests"`
 }
-
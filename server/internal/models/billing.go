@@ -7,42 +7,104 @@ import (
 )
 
 type BillingHistory struct {
-	ID                primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	OrganizationID    string            `bson:"organizationId" json:"organizationId"`
-	BillingDate       time.Time         `bson:"billingDate" json:"billingDate"`
-	PeriodStart       time.Time         `bson:"periodStart" json:"periodStart"`
-	PeriodEnd         time.Time         `bson:"periodEnd" json:"periodEnd"`
-	TotalTokens       int64             `bson:"totalTokens" json:"totalTokens"`
-	TotalCost         float64           `bson:"totalCost" json:"totalCost"`
-	Breakdown         BillingBreakdown  `bson:"breakdown" json:"breakdown"`
-	WalletBalanceBefore float64         `bson:"walletBalanceBefore" json:"walletBalanceBefore"`
-	WalletBalanceAfter  float64         `bson:"walletBalanceAfter" json:"walletBalanceAfter"`
-	Status            string            `bson:"status" json:"status"` // completed, failed, pending
-	CreatedAt         time.Time         `bson:"createdAt" json:"createdAt"`
+	ID                  primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	OrganizationID      string             `bson:"organizationId" json:"organizationId"`
+	BillingDate         time.Time          `bson:"billingDate" json:"billingDate"`
+	PeriodStart         time.Time          `bson:"periodStart" json:"periodStart"`
+	PeriodEnd           time.Time          `bson:"periodEnd" json:"periodEnd"`
+	TotalTokens         int64              `bson:"totalTokens" json:"totalTokens"`
+	TotalCost           float64            `bson:"totalCost" json:"totalCost"`
+	Breakdown           BillingBreakdown   `bson:"breakdown" json:"breakdown"`
+	WalletBalanceBefore float64            `bson:"walletBalanceBefore" json:"walletBalanceBefore"`
+	WalletBalanceAfter  float64            `bson:"walletBalanceAfter" json:"walletBalanceAfter"`
+	CreditsUsed         float64            `bson:"creditsUsed,omitempty" json:"creditsUsed,omitempty"` // part of TotalCost drawn from credit grants
+	Status              string             `bson:"status" json:"status"`                               // completed, failed, pending, reversed
+	Type                string             `bson:"type,omitempty" json:"type,omitempty"`               // empty for daily billing, adjustment, credit_note or refund
+	ReratingID          primitive.ObjectID `bson:"reratingId,omitempty" json:"reratingId,omitempty"`
+	// ReasonCode, Note and Reference (credit note number, Stripe refund ID,
+	// or the ledger reference of a daily charge) describe manual adjustments,
+	// credit notes, refunds and daily billing
+	ReasonCode string `bson:"reasonCode,omitempty" json:"reasonCode,omitempty"`
+	Note       string `bson:"note,omitempty" json:"note,omitempty"`
+	Reference  string `bson:"reference,omitempty" json:"reference,omitempty"`
+	// InvoiceID and InvoiceNumber name the invoice a credit note is issued
+	// against. PeriodStart and PeriodEnd of an adjustment or credit note are
+	// those of the charge or invoice it targets; BillingDate is when it was
+	// issued.
+	InvoiceID     *primitive.ObjectID `bson:"invoiceId,omitempty" json:"invoiceId,omitempty"`
+	InvoiceNumber string              `bson:"invoiceNumber,omitempty" json:"invoiceNumber,omitempty"`
+	CreatedBy     string              `bson:"createdBy,omitempty" json:"createdBy,omitempty"`
+	CreatedAt     time.Time           `bson:"createdAt" json:"createdAt"`
 }
 
+// Billing history entry types besides daily billing. TotalCost is what the
+// entry charged the wallet, so credits are negative.
+const (
+	// BillingTypeAdjustment marks re-rating corrections and manual wallet adjustments
+	BillingTypeAdjustment = "adjustment"
+	// BillingTypeCreditNote marks credit notes against a charge or invoice
+	BillingTypeCreditNote = "credit_note"
+	// BillingTypeRefund marks top-ups refunded through Stripe; they are
+	// repayments, not charges
+	BillingTypeRefund = "refund"
+)
+
+// BillingStatusReversed marks daily billing and re-rating entries undone by a
+// forced re-run of their day
+const BillingStatusReversed = "reversed"
+
 type BillingBreakdown struct {
 	ByAssistant map[string]AssistantBreakdown `bson:"byAssistant" json:"byAssistant"`
 	ByUser      map[string]UserBreakdown      `bson:"byUser" json:"byUser"`
 }
 
 type AssistantBreakdown struct {
-	Tokens  int64   `bson:"tokens" json:"tokens"`
-	Cost    float64 `bson:"cost" json:"cost"`
+	Tokens int64   `bson:"tokens" json:"tokens"`
+	Cost   float64 `bson:"cost" json:"cost"`
 }
 
 type UserBreakdown struct {
-	Tokens  int64   `bson:"tokens" json:"tokens"`
-	Cost    float64 `bson:"cost" json:"cost"`
+	Tokens int64   `bson:"tokens" json:"tokens"`
+	Cost   float64 `bson:"cost" json:"cost"`
 }
 
 type TopUpTransaction struct {
-	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	OrganizationID string            `bson:"organizationId" json:"organizationId"`
-	Amount         float64           `bson:"amount" json:"amount"`
-	StripePaymentIntentID string     `bson:"stripePaymentIntentId" json:"stripePaymentIntentId"`
-	Status         string            `bson:"status" json:"status"` // pending, succeeded, failed
-	CreatedAt      time.Time         `bson:"createdAt" json:"createdAt"`
-	CompletedAt    *time.Time        `bson:"completedAt,omitempty" json:"completedAt,omitempty"`
+	ID                    primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	OrganizationID        string             `bson:"organizationId" json:"organizationId"`
+	Amount                float64            `bson:"amount" json:"amount"`
+	StripePaymentIntentID string             `bson:"stripePaymentIntentId" json:"stripePaymentIntentId"`
+	Status                string             `bson:"status" json:"status"` // pending, succeeded, failed
+	CreatedAt             time.Time          `bson:"createdAt" json:"createdAt"`
+	CompletedAt           *time.Time         `bson:"completedAt,omitempty" json:"completedAt,omitempty"`
+	RefundedAmount        float64            `bson:"refundedAmount,omitempty" json:"refundedAmount,omitempty"`
 }
 
+// Billing run statuses
+const (
+	BillingRunRunning   = "running"
+	BillingRunCompleted = "completed"
+	BillingRunFailed    = "failed"
+)
+
+// BillingRun records the billing of one organization for one period in
+// billing_runs. There is at most one run per organization and period start, so
+// a period is never charged twice.
+type BillingRun struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	OrgID       string             `bson:"orgId" json:"orgId"`
+	PeriodStart time.Time          `bson:"periodStart" json:"periodStart"`
+	PeriodEnd   time.Time          `bson:"periodEnd" json:"periodEnd"`
+	Status      string             `bson:"status" json:"status"`   // running, completed, failed
+	Trigger     string             `bson:"trigger" json:"trigger"` // schedule, admin
+	BillingID   primitive.ObjectID `bson:"billingId,omitempty" json:"billingId,omitempty"`
+	TotalTokens int64              `bson:"totalTokens" json:"totalTokens"`
+	TotalCost   float64            `bson:"totalCost" json:"totalCost"`
+	Attempts    int                `bson:"attempts" json:"attempts"`
+	// Revision counts forced re-runs; each reverses the previous charge and
+	// charges the period again
+	Revision    int        `bson:"revision,omitempty" json:"revision,omitempty"`
+	Error       string     `bson:"error,omitempty" json:"error,omitempty"`
+	StartedAt   time.Time  `bson:"startedAt" json:"startedAt"`
+	CompletedAt *time.Time `bson:"completedAt,omitempty" json:"completedAt,omitempty"`
+	UpdatedAt   time.Time  `bson:"updatedAt" json:"updatedAt"`
+}
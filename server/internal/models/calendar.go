@@ -0,0 +1,86 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// maxAnchorDay keeps the billing anchor on a day every month has
+const maxAnchorDay = 28
+
+// BillingCalendar places an organization's billing days and monthly cycles.
+// Days start at local midnight; cycles start at local midnight on AnchorDay.
+type BillingCalendar struct {
+	Location  *time.Location
+	AnchorDay int
+}
+
+// UTCCalendar is the calendar of organizations without a timezone or anchor
+var UTCCalendar = BillingCalendar{Location: time.UTC, AnchorDay: 1}
+
+// Calendar returns the organization's billing calendar. An unknown timezone
+// falls back to UTC.
+func (o Organization) Calendar() BillingCalendar {
+	calendar := UTCCalendar
+	if o.Timezone != "" {
+		if loc, err := time.LoadLocation(o.Timezone); err == nil {
+			calendar.Location = loc
+		}
+	}
+	if o.BillingAnchorDay >= 1 && o.BillingAnchorDay <= maxAnchorDay {
+		calendar.AnchorDay = o.BillingAnchorDay
+	}
+	return calendar
+}
+
+// ValidateBillingCalendar checks a timezone and billing anchor day. An empty
+// timezone and anchor day 0 are unset fields (UTC and day 1), so they pass.
+func ValidateBillingCalendar(timezone string, anchorDay int) error {
+	if timezone != "" {
+		if _, err := time.LoadLocation(timezone); err != nil {
+			return fmt.Errorf("unknown timezone %q", timezone)
+		}
+	}
+	if anchorDay < 0 || anchorDay > maxAnchorDay {
+		return fmt.Errorf("billingAnchorDay must be between 1 and %d, or omitted for the default of 1", maxAnchorDay)
+	}
+	return nil
+}
+
+// Day returns the start of the local calendar day year-month-day
+func (c BillingCalendar) Day(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, c.Location)
+}
+
+// DayStart returns the start of the local day containing t
+func (c BillingCalendar) DayStart(t time.Time) time.Time {
+	local := t.In(c.Location)
+	return c.Day(local.Year(), local.Month(), local.Day())
+}
+
+// NextDayStart returns the start of the local day after the one containing t
+func (c BillingCalendar) NextDayStart(t time.Time) time.Time {
+	local := t.In(c.Location)
+	return c.Day(local.Year(), local.Month(), local.Day()+1)
+}
+
+// CycleStart returns the start of the billing cycle containing t
+func (c BillingCalendar) CycleStart(t time.Time) time.Time {
+	local := t.In(c.Location)
+	start := c.Day(local.Year(), local.Month(), c.AnchorDay)
+	if local.Before(start) {
+		start = c.Day(local.Year(), local.Month()-1, c.AnchorDay)
+	}
+	return start
+}
+
+// CycleFor returns the start of the billing cycle that begins in the given month
+func (c BillingCalendar) CycleFor(year int, month time.Month) time.Time {
+	return c.Day(year, month, c.AnchorDay)
+}
+
+// NextCycleStart returns the start of the cycle after the one containing t
+func (c BillingCalendar) NextCycleStart(t time.Time) time.Time {
+	start := c.CycleStart(t)
+	return c.Day(start.Year(), start.Month()+1, c.AnchorDay)
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidateBillingCalendar(t *testing.T) {
+	tests := []struct {
+		timezone  string
+		anchorDay int
+		valid     bool
+	}{
+		{"", 0, true}, // unset: UTC, day 1
+		{"Europe/Berlin", 1, true},
+		{"America/New_York", 28, true},
+		{"", 29, false},
+		{"", -1, false},
+		{"Mars/Olympus_Mons", 1, false},
+	}
+	for _, tt := range tests {
+		err := ValidateBillingCalendar(tt.timezone, tt.anchorDay)
+		if (err == nil) != tt.valid {
+			t.Errorf("ValidateBillingCalendar(%q, %d) = %v, want valid %v", tt.timezone, tt.anchorDay, err, tt.valid)
+		}
+	}
+}
+
+func TestCalendarDefaults(t *testing.T) {
+	calendar := Organization{}.Calendar()
+	if calendar.Location != time.UTC || calendar.AnchorDay != 1 {
+		t.Fatalf("default calendar = %v day %d, want UTC day 1", calendar.Location, calendar.AnchorDay)
+	}
+
+	calendar = Organization{Timezone: "Asia/Tokyo", BillingAnchorDay: 15}.Calendar()
+	start := calendar.CycleStart(time.Date(2024, 5, 10, 0, 0, 0, 0, time.UTC))
+	if want := time.Date(2024, 4, 15, 0, 0, 0, 0, calendar.Location); !start.Equal(want) {
+		t.Fatalf("cycle start = %v, want %v", start, want)
+	}
+}
@@ -0,0 +1,55 @@
+package models
+
+import "fmt"
+
+// Account modes
+const (
+	// AccountPrepaid organizations can spend down to a zero balance
+	AccountPrepaid = "prepaid"
+	// AccountPostpaid organizations can spend down to -CreditLimit
+	AccountPostpaid = "postpaid"
+)
+
+// Mode returns the organization's account mode. Without one set, an
+// organization with a credit limit is postpaid and any other is prepaid, as
+// before account modes existed.
+func (o Organization) Mode() string {
+	switch o.AccountMode {
+	case AccountPostpaid, AccountPrepaid:
+		return o.AccountMode
+	}
+	if o.CreditLimit > 0 {
+		return AccountPostpaid
+	}
+	return AccountPrepaid
+}
+
+// CreditFloor is the lowest wallet balance the organization may spend down to
+func (o Organization) CreditFloor() float64 {
+	if o.Mode() == AccountPostpaid {
+		return -o.CreditLimit
+	}
+	return 0
+}
+
+// Headroom is what the organization can still spend after pending (not yet
+// billed) charges
+func (o Organization) Headroom(pending float64) float64 {
+	return o.WalletBalance - pending - o.CreditFloor()
+}
+
+// OverCreditLimit reports whether the wallet balance is below the credit floor
+func (o Organization) OverCreditLimit() bool {
+	return o.WalletBalance < o.CreditFloor()
+}
+
+// ValidateAccountMode checks an account mode and credit limit
+func ValidateAccountMode(mode string, creditLimit float64) error {
+	if mode != "" && mode != AccountPrepaid && mode != AccountPostpaid {
+		return fmt.Errorf("accountMode must be one of: %s, %s", AccountPrepaid, AccountPostpaid)
+	}
+	if creditLimit < 0 {
+		return fmt.Errorf("creditLimit must not be negative")
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Credit grant sources
+const (
+	CreditSourceTrial  = "trial"
+	CreditSourcePromo  = "promo"
+	CreditSourceManual = "manual"
+)
+
+// Credit grant statuses
+const (
+	CreditGrantActive    = "active"
+	CreditGrantExhausted = "exhausted"
+	CreditGrantExpired   = "expired"
+)
+
+// CreditGrant is promotional credit in an organization's wallet. Granting
+// credits the wallet through a promo ledger entry; daily billing draws active
+// grants down before paid balance, highest Priority first, and whatever is
+// left at ExpiresAt is debited again by the expiry sweep.
+type CreditGrant struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	OrgID       string             `bson:"orgId" json:"orgId"`
+	Amount      float64            `bson:"amount" json:"amount"`
+	Remaining   float64            `bson:"remaining" json:"remaining"`
+	Source      string             `bson:"source" json:"source"` // trial, promo, manual
+	PromoCode   string             `bson:"promoCode,omitempty" json:"promoCode,omitempty"`
+	Priority    int                `bson:"priority" json:"priority"`
+	Description string             `bson:"description,omitempty" json:"description,omitempty"`
+	ExpiresAt   *time.Time         `bson:"expiresAt,omitempty" json:"expiresAt,omitempty"`
+	Status      string             `bson:"status" json:"status"` // active, exhausted, expired
+	Draws       []CreditDraw       `bson:"draws,omitempty" json:"draws,omitempty"`
+	ExpiredAt   *time.Time         `bson:"expiredAt,omitempty" json:"expiredAt,omitempty"`
+	CreatedBy   string             `bson:"createdBy,omitempty" json:"createdBy,omitempty"`
+	CreatedAt   time.Time          `bson:"createdAt" json:"createdAt"`
+	UpdatedAt   time.Time          `bson:"updatedAt" json:"updatedAt"`
+}
+
+// CreditDraw is an amount of a grant used by a billing run
+type CreditDraw struct {
+	Reference string    `bson:"reference" json:"reference"` // ledger reference of the billing charge
+	Amount    float64   `bson:"amount" json:"amount"`
+	DrawnAt   time.Time `bson:"drawnAt" json:"drawnAt"`
+}
+
+// PromoCode grants credit to organizations that redeem it
+type PromoCode struct {
+	ID     primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Code   string             `bson:"code" json:"code"` // stored upper case
+	Amount float64            `bson:"amount" json:"amount"`
+	// Priority and ExpiresAfterDays (0 for no expiry) apply to the grants it creates
+	Priority         int        `bson:"priority" json:"priority"`
+	ExpiresAfterDays int        `bson:"expiresAfterDays" json:"expiresAfterDays"`
+	ValidUntil       *time.Time `bson:"validUntil,omitempty" json:"validUntil,omitempty"`
+	// MaxRedemptions caps redemptions across organizations (0 for no cap); each
+	// organization can redeem a code once
+	MaxRedemptions int       `bson:"maxRedemptions" json:"maxRedemptions"`
+	Redemptions    int       `bson:"redemptions" json:"redemptions"`
+	Active         bool      `bson:"active" json:"active"`
+	Description    string    `bson:"description,omitempty" json:"description,omitempty"`
+	CreatedAt      time.Time `bson:"createdAt" json:"createdAt"`
+	UpdatedAt      time.Time `bson:"updatedAt" json:"updatedAt"`
+}
+
+// PromoRedemption records an organization redeeming a promo code
+type PromoRedemption struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Code       string             `bson:"code" json:"code"`
+	OrgID      string             `bson:"orgId" json:"orgId"`
+	GrantID    primitive.ObjectID `bson:"grantId" json:"grantId"`
+	RedeemedBy string             `bson:"redeemedBy,omitempty" json:"redeemedBy,omitempty"`
+	RedeemedAt time.Time          `bson:"redeemedAt" json:"redeemedAt"`
+}
@@ -0,0 +1,76 @@
+package models
+
+import "testing"
+
+func TestAccountMode(t *testing.T) {
+	tests := []struct {
+		name      string
+		org       Organization
+		wantMode  string
+		wantFloor float64
+	}{
+		{"no mode, no credit limit", Organization{}, AccountPrepaid, 0},
+		{"no mode, credit limit", Organization{CreditLimit: 50}, AccountPostpaid, -50},
+		{"prepaid with credit limit", Organization{AccountMode: AccountPrepaid, CreditLimit: 50}, AccountPrepaid, 0},
+		{"postpaid", Organization{AccountMode: AccountPostpaid, CreditLimit: 20}, AccountPostpaid, -20},
+		{"postpaid without credit limit", Organization{AccountMode: AccountPostpaid}, AccountPostpaid, 0},
+		{"unknown mode", Organization{AccountMode: "barter", CreditLimit: 10}, AccountPostpaid, -10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if mode := tt.org.Mode(); mode != tt.wantMode {
+				t.Fatalf("Mode() = %q, want %q", mode, tt.wantMode)
+			}
+			if floor := tt.org.CreditFloor(); floor != tt.wantFloor {
+				t.Fatalf("CreditFloor() = %v, want %v", floor, tt.wantFloor)
+			}
+		})
+	}
+}
+
+func TestHeadroom(t *testing.T) {
+	tests := []struct {
+		name         string
+		org          Organization
+		pending      float64
+		wantHeadroom float64
+		wantOver     bool
+	}{
+		{"prepaid with balance", Organization{WalletBalance: 10}, 4, 6, false},
+		{"prepaid spent", Organization{WalletBalance: 3}, 5, -2, false},
+		{"prepaid overdrawn", Organization{WalletBalance: -1}, 0, -1, true},
+		{"postpaid below zero within limit", Organization{WalletBalance: -30, CreditLimit: 50}, 5, 15, false},
+		{"postpaid past limit", Organization{WalletBalance: -60, CreditLimit: 50}, 0, -10, true},
+		{"prepaid ignores credit limit", Organization{AccountMode: AccountPrepaid, WalletBalance: -30, CreditLimit: 50}, 0, -30, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if headroom := tt.org.Headroom(tt.pending); headroom != tt.wantHeadroom {
+				t.Fatalf("Headroom(%v) = %v, want %v", tt.pending, headroom, tt.wantHeadroom)
+			}
+			if over := tt.org.OverCreditLimit(); over != tt.wantOver {
+				t.Fatalf("OverCreditLimit() = %v, want %v", over, tt.wantOver)
+			}
+		})
+	}
+}
+
+func TestValidateAccountMode(t *testing.T) {
+	tests := []struct {
+		mode        string
+		creditLimit float64
+		valid       bool
+	}{
+		{"", 0, true},
+		{AccountPrepaid, 0, true},
+		{AccountPostpaid, 100, true},
+		{"barter", 0, false},
+		{AccountPostpaid, -1, false},
+	}
+	for _, tt := range tests {
+		err := ValidateAccountMode(tt.mode, tt.creditLimit)
+		if (err == nil) != tt.valid {
+			t.Errorf("ValidateAccountMode(%q, %v) = %v, want valid %v", tt.mode, tt.creditLimit, err, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Dunning states. An organization without a dunning status is current.
+const (
+	DunningCurrent   = "current"
+	DunningGrace     = "grace"
+	DunningPastDue   = "past_due"
+	DunningSuspended = "suspended"
+)
+
+// Dunning event kinds
+const (
+	DunningEventTransition = "transition"
+	DunningEventReminder   = "reminder"
+)
+
+// DunningPolicy configures an organization's dunning. Dunning starts when the
+// wallet balance drops below Threshold; after GraceDays the account is past
+// due, reminders go out ReminderDays after dunning started, and after
+// SuspendAfterDays the organization is suspended if its balance is also below
+// DunningSuspendBelow.
+type DunningPolicy struct {
+	// Threshold defaults to 0, so dunning starts once the wallet goes negative
+	Threshold        *float64 `bson:"threshold,omitempty" json:"threshold,omitempty"`
+	GraceDays        int      `bson:"graceDays" json:"graceDays"`
+	ReminderDays     []int    `bson:"reminderDays" json:"reminderDays"`
+	SuspendAfterDays int      `bson:"suspendAfterDays" json:"suspendAfterDays"`
+	// LowBalanceThreshold triggers the low balance alert after billing; 0 disables it
+	LowBalanceThreshold float64 `bson:"lowBalanceThreshold" json:"lowBalanceThreshold"`
+}
+
+// DefaultDunningPolicy applies to organizations without their own policy
+var DefaultDunningPolicy = DunningPolicy{
+	GraceDays:           3,
+	ReminderDays:        []int{3, 7, 10},
+	SuspendAfterDays:    14,
+	LowBalanceThreshold: 10,
+}
+
+// DunningStatus is where an organization is in the dunning workflow
+type DunningStatus struct {
+	State          string     `bson:"state" json:"state"`
+	StartedAt      time.Time  `bson:"startedAt" json:"startedAt"`
+	PastDueAt      *time.Time `bson:"pastDueAt,omitempty" json:"pastDueAt,omitempty"`
+	SuspendedAt    *time.Time `bson:"suspendedAt,omitempty" json:"suspendedAt,omitempty"`
+	RemindersSent  int        `bson:"remindersSent" json:"remindersSent"`
+	LastReminderAt *time.Time `bson:"lastReminderAt,omitempty" json:"lastReminderAt,omitempty"`
+}
+
+// DunningEvent records a dunning transition or reminder
+type DunningEvent struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	OrgID     string             `bson:"orgId" json:"orgId"`
+	Kind      string             `bson:"kind" json:"kind"` // transition, reminder
+	From      string             `bson:"from" json:"from"`
+	To        string             `bson:"to" json:"to"`
+	Balance   float64            `bson:"balance" json:"balance"`
+	Threshold float64            `bson:"threshold" json:"threshold"`
+	Reason    string             `bson:"reason" json:"reason"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// EffectiveDunningPolicy returns the organization's dunning policy, or the
+// default one, with the threshold resolved
+func (o Organization) EffectiveDunningPolicy() DunningPolicy {
+	policy := DefaultDunningPolicy
+	if o.DunningPolicy != nil {
+		policy = *o.DunningPolicy
+	}
+	if policy.Threshold == nil {
+		threshold := 0.0
+		policy.Threshold = &threshold
+	}
+	return policy
+}
+
+// DunningSuspendBelow is the balance below which dunning suspends the
+// organization: its credit floor, or the dunning threshold if that is lower.
+// A postpaid organization in dunning but within its credit limit goes past due
+// without being suspended.
+func (o Organization) DunningSuspendBelow() float64 {
+	return math.Min(*o.EffectiveDunningPolicy().Threshold, o.CreditFloor())
+}
+
+// DunningState returns the organization's dunning state
+func (o Organization) DunningState() string {
+	if o.Dunning == nil || o.Dunning.State == "" {
+		return DunningCurrent
+	}
+	return o.Dunning.State
+}
+
+// ValidateDunningPolicy checks a dunning policy
+func ValidateDunningPolicy(policy *DunningPolicy) error {
+	if policy == nil {
+		return nil
+	}
+	if policy.GraceDays < 0 {
+		return fmt.Errorf("graceDays must not be negative")
+	}
+	if policy.SuspendAfterDays < 1 || policy.SuspendAfterDays < policy.GraceDays {
+		return fmt.Errorf("suspendAfterDays must be at least 1 and not less than graceDays")
+	}
+	for _, day := range policy.ReminderDays {
+		if day < 1 || day >= policy.SuspendAfterDays {
+			return fmt.Errorf("reminderDays must be between 1 and suspendAfterDays - 1")
+		}
+	}
+	if policy.LowBalanceThreshold < 0 {
+		return fmt.Errorf("lowBalanceThreshold must not be negative")
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package models
+
+import "testing"
+
+func TestEffectiveDunningPolicy(t *testing.T) {
+	threshold, low := 5.0, -20.0
+	tests := []struct {
+		name             string
+		org              Organization
+		wantThreshold    float64
+		wantSuspendBelow float64
+	}{
+		{"prepaid", Organization{}, 0, 0},
+		{"postpaid", Organization{AccountMode: AccountPostpaid, CreditLimit: 50}, 0, -50},
+		{"explicit threshold", Organization{DunningPolicy: &DunningPolicy{Threshold: &threshold, SuspendAfterDays: 7}}, 5, 0},
+		{"explicit threshold below the floor", Organization{CreditLimit: 10, DunningPolicy: &DunningPolicy{Threshold: &low}}, -20, -20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := tt.org.EffectiveDunningPolicy()
+			if policy.Threshold == nil || *policy.Threshold != tt.wantThreshold {
+				t.Fatalf("Threshold = %v, want %v", policy.Threshold, tt.wantThreshold)
+			}
+			if below := tt.org.DunningSuspendBelow(); below != tt.wantSuspendBelow {
+				t.Fatalf("DunningSuspendBelow() = %v, want %v", below, tt.wantSuspendBelow)
+			}
+		})
+	}
+
+	// Resolving the threshold leaves the shared default untouched
+	if DefaultDunningPolicy.Threshold != nil {
+		t.Fatalf("DefaultDunningPolicy.Threshold = %v, want nil", *DefaultDunningPolicy.Threshold)
+	}
+}
@@ -0,0 +1,65 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Invoice line kinds
+const (
+	InvoiceLineUsage          = "usage"
+	InvoiceLineAdjustment     = "adjustment"
+	InvoiceLineCreditNote     = "credit_note"
+	InvoiceLineReconciliation = "reconciliation"
+)
+
+// Invoice is an organization's monthly invoice for one billing cycle. Invoices
+// are numbered sequentially and never modified once issued; Checksum covers
+// the invoiced content so later changes can be detected.
+type Invoice struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Number       string             `bson:"number" json:"number"` // INV-000001
+	Sequence     int64              `bson:"sequence" json:"sequence"`
+	OrgID        string             `bson:"orgId" json:"orgId"`
+	OrgName      string             `bson:"orgName" json:"orgName"`
+	BillingEmail string             `bson:"billingEmail,omitempty" json:"billingEmail,omitempty"`
+	Month        string             `bson:"month" json:"month"` // YYYY-MM the cycle starts in
+	PeriodStart  time.Time          `bson:"periodStart" json:"periodStart"`
+	PeriodEnd    time.Time          `bson:"periodEnd" json:"periodEnd"`
+	Timezone     string             `bson:"timezone" json:"timezone"`
+	Currency     string             `bson:"currency" json:"currency"`
+	Lines        []InvoiceLine      `bson:"lines" json:"lines"`
+	Payments     []InvoicePayment   `bson:"payments" json:"payments"`
+	// Total is what was charged to the wallet for the cycle: daily usage plus
+	// adjustments, less credit notes
+	Total          float64   `bson:"total" json:"total"`
+	TotalTokens    int64     `bson:"totalTokens" json:"totalTokens"`
+	PaymentsTotal  float64   `bson:"paymentsTotal" json:"paymentsTotal"`
+	OpeningBalance float64   `bson:"openingBalance" json:"openingBalance"`
+	ClosingBalance float64   `bson:"closingBalance" json:"closingBalance"`
+	Status         string    `bson:"status" json:"status"` // issued
+	Checksum       string    `bson:"checksum" json:"checksum"`
+	IssuedAt       time.Time `bson:"issuedAt" json:"issuedAt"`
+}
+
+// InvoiceLine is a charge on an invoice. Usage lines are per model and
+// assistant type.
+type InvoiceLine struct {
+	Kind          string  `bson:"kind" json:"kind"` // usage, adjustment, credit_note, reconciliation
+	Model         string  `bson:"model,omitempty" json:"model,omitempty"`
+	AssistantType string  `bson:"assistantType,omitempty" json:"assistantType,omitempty"`
+	Description   string  `bson:"description" json:"description"`
+	Tokens        int64   `bson:"tokens" json:"tokens"`
+	Requests      int64   `bson:"requests" json:"requests"`
+	Amount        float64 `bson:"amount" json:"amount"`
+}
+
+// InvoicePayment is a wallet top-up received during the cycle, or a refund of
+// one (negative)
+type InvoicePayment struct {
+	Reference   string    `bson:"reference" json:"reference"`
+	Description string    `bson:"description" json:"description"`
+	Amount      float64   `bson:"amount" json:"amount"`
+	ReceivedAt  time.Time `bson:"receivedAt" json:"receivedAt"`
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Ledger entry types
+const (
+	LedgerUsageDebit  = "usage_debit"
+	LedgerTopUpCredit = "top_up_credit"
+	LedgerAdjustment  = "adjustment"
+	LedgerRefund      = "refund"
+	LedgerPromo       = "promo"
+)
+
+// LedgerEntry is an append-only wallet_ledger entry. Each entry moves Amount
+// between the organization's wallet and a contra account (revenue, payments,
+// promotions or adjustments): positive amounts credit the wallet, negative
+// amounts debit it. The wallet balance is the sum of an organization's entries;
+// Organization.WalletBalance caches it.
+type LedgerEntry struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	OrgID         string             `bson:"orgId" json:"orgId"`
+	Type          string             `bson:"type" json:"type"`
+	Amount        float64            `bson:"amount" json:"amount"`
+	ContraAccount string             `bson:"contraAccount" json:"contraAccount"`
+	BalanceAfter  float64            `bson:"balanceAfter" json:"balanceAfter"`
+	// Reference identifies the cause (e.g. "billing:<id>", "stripe:<payment intent>");
+	// an entry is posted at most once per reference
+	Reference   string    `bson:"reference,omitempty" json:"reference,omitempty"`
+	Description string    `bson:"description,omitempty" json:"description,omitempty"`
+	CreatedAt   time.Time `bson:"createdAt" json:"createdAt"`
+}
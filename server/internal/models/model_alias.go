@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ModelAlias maps raw model names reported by collectors to a canonical model
+// ID and provider. Exact aliases match case-insensitively; regex aliases may
+// reference capture groups in CanonicalModel (e.g. "$1").
+type ModelAlias struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Pattern        string             `bson:"pattern" json:"pattern"`
+	Regex          bool               `bson:"regex" json:"regex"`
+	CanonicalModel string             `bson:"canonicalModel" json:"canonicalModel"`
+	Provider       string             `bson:"provider,omitempty" json:"provider,omitempty"`
+	Priority       int                `bson:"priority" json:"priority"` // regex aliases are tried highest first
+	CreatedAt      time.Time          `bson:"createdAt" json:"createdAt"`
+	UpdatedAt      time.Time          `bson:"updatedAt" json:"updatedAt"`
+}
@@ -7,25 +7,40 @@ import (
 )
 
 type Organization struct {
-	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	OrgID        string             `bson:"orgId" json:"orgId"` // SuperTokens tenant ID
-	Name         string             `bson:"name" json:"name"`
-	ContactEmail string             `bson:"contactEmail" json:"contactEmail"`
-	BillingEmail string             `bson:"billingEmail" json:"billingEmail"`
-	WalletBalance float64           `bson:"walletBalance" json:"walletBalance"`
-	CreditLimit   float64           `bson:"creditLimit" json:"creditLimit"`
-	AutoTopUp     AutoTopUpConfig   `bson:"autoTopUp" json:"autoTopUp"`
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	OrgID         string             `bson:"orgId" json:"orgId"` // SuperTokens tenant ID
+	Name          string             `bson:"name" json:"name"`
+	ContactEmail  string             `bson:"contactEmail" json:"contactEmail"`
+	BillingEmail  string             `bson:"billingEmail" json:"billingEmail"`
+	WalletBalance float64            `bson:"walletBalance" json:"walletBalance"`
+	CreditLimit   float64            `bson:"creditLimit" json:"creditLimit"`
+	// AccountMode is prepaid (blocked at a zero balance) or postpaid (blocked
+	// at -CreditLimit). When unset it is postpaid if CreditLimit > 0.
+	// Restricted is set while the balance is below that floor.
+	AccountMode       string            `bson:"accountMode,omitempty" json:"accountMode,omitempty"`
+	Restricted        bool              `bson:"restricted" json:"restricted"`
+	RestrictedAt      *time.Time        `bson:"restrictedAt,omitempty" json:"restrictedAt,omitempty"`
+	AutoTopUp         AutoTopUpConfig   `bson:"autoTopUp" json:"autoTopUp"`
 	ConsumptionLimits ConsumptionLimits `bson:"consumptionLimits" json:"consumptionLimits"`
-	Status         string            `bson:"status" json:"status"` // active, inactive, suspended
-	CreatedAt      time.Time         `bson:"createdAt" json:"createdAt"`
-	UpdatedAt      time.Time         `bson:"updatedAt" json:"updatedAt"`
+	ContentPolicy     string            `bson:"contentPolicy,omitempty" json:"contentPolicy,omitempty"` // none (default), hashed or redacted
+	// Timezone (IANA name, default UTC) and BillingAnchorDay (1-28, default 1)
+	// set the days and monthly cycles used for billing, aggregation and limits
+	Timezone         string `bson:"timezone,omitempty" json:"timezone,omitempty"`
+	BillingAnchorDay int    `bson:"billingAnchorDay,omitempty" json:"billingAnchorDay,omitempty"`
+	// DunningPolicy overrides DefaultDunningPolicy; Dunning is set while the
+	// organization is in the dunning workflow
+	DunningPolicy *DunningPolicy `bson:"dunningPolicy,omitempty" json:"dunningPolicy,omitempty"`
+	Dunning       *DunningStatus `bson:"dunning,omitempty" json:"dunning,omitempty"`
+	Status        string         `bson:"status" json:"status"` // active, inactive, suspended
+	CreatedAt     time.Time      `bson:"createdAt" json:"createdAt"`
+	UpdatedAt     time.Time      `bson:"updatedAt" json:"updatedAt"`
 }
 
 type AutoTopUpConfig struct {
-	Enabled        bool   `bson:"enabled" json:"enabled"`
-	Threshold      float64 `bson:"threshold" json:"threshold"`
-	Amount         float64 `bson:"amount" json:"amount"`
-	PaymentMethodID string `bson:"paymentMethodId" json:"paymentMethodId"`
+	Enabled         bool    `bson:"enabled" json:"enabled"`
+	Threshold       float64 `bson:"threshold" json:"threshold"`
+	Amount          float64 `bson:"amount" json:"amount"`
+	PaymentMethodID string  `bson:"paymentMethodId" json:"paymentMethodId"`
 }
 
 type ConsumptionLimits struct {
@@ -33,4 +48,3 @@ type ConsumptionLimits struct {
 	DailyLimit   int64 `bson:"dailyLimit" json:"dailyLimit"`
 	PerUserLimit int64 `bson:"perUserLimit" json:"perUserLimit"`
 }
-
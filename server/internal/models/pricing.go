@@ -0,0 +1,134 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ModelPrice is a model_prices catalog entry. Prices are per 1k tokens and apply
+// to records timestamped in [EffectiveFrom, EffectiveTo).
+type ModelPrice struct {
+	ID                   primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Model                string             `bson:"model" json:"model"`
+	PromptPricePer1K     float64            `bson:"promptPricePer1k" json:"promptPricePer1k"`
+	CompletionPricePer1K float64            `bson:"completionPricePer1k" json:"completionPricePer1k"`
+	ClassPrices          `bson:",inline"`
+	EffectiveFrom        time.Time  `bson:"effectiveFrom" json:"effectiveFrom"`
+	EffectiveTo          *time.Time `bson:"effectiveTo,omitempty" json:"effectiveTo,omitempty"` // nil = open-ended
+	// Tiers, when set, replace the flat prices with volume pricing
+	Tiers     []PriceTier `bson:"tiers,omitempty" json:"tiers,omitempty"`
+	CreatedAt time.Time   `bson:"createdAt" json:"createdAt"`
+	UpdatedAt time.Time   `bson:"updatedAt" json:"updatedAt"`
+}
+
+// ValidAt reports whether the price applies at t
+func (p ModelPrice) ValidAt(t time.Time) bool {
+	return !t.Before(p.EffectiveFrom) && (p.EffectiveTo == nil || t.Before(*p.EffectiveTo))
+}
+
+// PriceTier prices tokens up to UpToTokens of an organization's monthly usage
+// of a model. The last tier has UpToTokens 0 and is unbounded.
+type PriceTier struct {
+	UpToTokens           int64   `bson:"upToTokens" json:"upToTokens"`
+	PromptPricePer1K     float64 `bson:"promptPricePer1k" json:"promptPricePer1k"`
+	CompletionPricePer1K float64 `bson:"completionPricePer1k" json:"completionPricePer1k"`
+	ClassPrices          `bson:",inline"`
+}
+
+// ClassPrices prices token classes other than plain prompt and completion
+// tokens, per 1k. Cached prompt and reasoning tokens fall back to the prompt
+// and completion prices when unset; image and audio units are free unless priced.
+type ClassPrices struct {
+	CachedPromptPricePer1K *float64 `bson:"cachedPromptPricePer1k,omitempty" json:"cachedPromptPricePer1k,omitempty"`
+	ReasoningPricePer1K    *float64 `bson:"reasoningPricePer1k,omitempty" json:"reasoningPricePer1k,omitempty"`
+	ImagePricePer1K        float64  `bson:"imagePricePer1k,omitempty" json:"imagePricePer1k,omitempty"`
+	AudioPricePer1K        float64  `bson:"audioPricePer1k,omitempty" json:"audioPricePer1k,omitempty"`
+}
+
+// Negative reports whether any class price is negative
+func (c ClassPrices) Negative() bool {
+	return (c.CachedPromptPricePer1K != nil && *c.CachedPromptPricePer1K < 0) ||
+		(c.ReasoningPricePer1K != nil && *c.ReasoningPricePer1K < 0) ||
+		c.ImagePricePer1K < 0 || c.AudioPricePer1K < 0
+}
+
+// Scale multiplies every class price by factor
+func (c ClassPrices) Scale(factor float64) ClassPrices {
+	scaled := ClassPrices{ImagePricePer1K: c.ImagePricePer1K * factor, AudioPricePer1K: c.AudioPricePer1K * factor}
+	if c.CachedPromptPricePer1K != nil {
+		price := *c.CachedPromptPricePer1K * factor
+		scaled.CachedPromptPricePer1K = &price
+	}
+	if c.ReasoningPricePer1K != nil {
+		price := *c.ReasoningPricePer1K * factor
+		scaled.ReasoningPricePer1K = &price
+	}
+	return scaled
+}
+
+// CostByClass splits a record's cost across token classes
+type CostByClass struct {
+	Prompt       float64 `bson:"prompt" json:"prompt"` // uncached prompt tokens
+	CachedPrompt float64 `bson:"cachedPrompt" json:"cachedPrompt"`
+	Completion   float64 `bson:"completion" json:"completion"` // completion tokens other than reasoning
+	Reasoning    float64 `bson:"reasoning" json:"reasoning"`
+	Image        float64 `bson:"image" json:"image"`
+	Audio        float64 `bson:"audio" json:"audio"`
+}
+
+// Total is the sum of all classes
+func (c CostByClass) Total() float64 {
+	return c.Prompt + c.CachedPrompt + c.Completion + c.Reasoning + c.Image + c.Audio
+}
+
+// Add returns the class-wise sum of c and other scaled by factor
+func (c CostByClass) Add(other CostByClass, factor float64) CostByClass {
+	return CostByClass{
+		Prompt:       c.Prompt + other.Prompt*factor,
+		CachedPrompt: c.CachedPrompt + other.CachedPrompt*factor,
+		Completion:   c.Completion + other.Completion*factor,
+		Reasoning:    c.Reasoning + other.Reasoning*factor,
+		Image:        c.Image + other.Image*factor,
+		Audio:        c.Audio + other.Audio*factor,
+	}
+}
+
+// TierCharge is the part of a record's tokens and cost that fell into a tier
+type TierCharge struct {
+	Tier   int     `bson:"tier" json:"tier"` // index into the price's tiers
+	Tokens int64   `bson:"tokens" json:"tokens"`
+	Cost   float64 `bson:"cost" json:"cost"`
+}
+
+// TierUsage is an organization's monthly token position for a tiered model
+type TierUsage struct {
+	OrgID     string    `bson:"orgId" json:"orgId"`
+	Model     string    `bson:"model" json:"model"`
+	Month     string    `bson:"month" json:"month"` // YYYY-MM the billing cycle starts in
+	Tokens    int64     `bson:"tokens" json:"tokens"`
+	UpdatedAt time.Time `bson:"updatedAt" json:"updatedAt"`
+}
+
+// ValidateTiers checks that tier bounds ascend and only the last tier is unbounded
+func ValidateTiers(tiers []PriceTier) error {
+	var previous int64
+	for i, tier := range tiers {
+		if tier.PromptPricePer1K < 0 || tier.CompletionPricePer1K < 0 || tier.ClassPrices.Negative() {
+			return fmt.Errorf("tier %d has a negative price", i)
+		}
+		last := i == len(tiers)-1
+		if tier.UpToTokens == 0 {
+			if !last {
+				return fmt.Errorf("only the last tier can be unbounded")
+			}
+			continue
+		}
+		if tier.UpToTokens <= previous {
+			return fmt.Errorf("tier %d must end above %d tokens", i, previous)
+		}
+		previous = tier.UpToTokens
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RateCard is one version of an organization's negotiated pricing. Versions
+// are immutable once created; a new version closes the window of the previous one.
+type RateCard struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	OrgID         string             `bson:"orgId" json:"orgId"`
+	Version       int                `bson:"version" json:"version"`
+	Name          string             `bson:"name,omitempty" json:"name,omitempty"`
+	Overrides     []RateOverride     `bson:"overrides" json:"overrides"`
+	EffectiveFrom time.Time          `bson:"effectiveFrom" json:"effectiveFrom"`
+	EffectiveTo   *time.Time         `bson:"effectiveTo,omitempty" json:"effectiveTo,omitempty"` // nil = open-ended
+	CreatedAt     time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// RateOverride replaces or scales catalog prices for a model, an assistant
+// type, or both. Tiers take precedence over fixed prices, and fixed prices
+// over Multiplier.
+type RateOverride struct {
+	Model                string      `bson:"model,omitempty" json:"model,omitempty"`
+	AssistantType        string      `bson:"assistantType,omitempty" json:"assistantType,omitempty"`
+	PromptPricePer1K     *float64    `bson:"promptPricePer1k,omitempty" json:"promptPricePer1k,omitempty"`
+	CompletionPricePer1K *float64    `bson:"completionPricePer1k,omitempty" json:"completionPricePer1k,omitempty"`
+	Multiplier           float64     `bson:"multiplier,omitempty" json:"multiplier,omitempty"` // e.g. 0.8 for a 20% discount, 1.1 for a markup
+	Tiers                []PriceTier `bson:"tiers,omitempty" json:"tiers,omitempty"`
+	// ClassPrices, when set, replace the catalog's class prices; otherwise the
+	// catalog's are kept (scaled by Multiplier)
+	ClassPrices *ClassPrices `bson:"classPrices,omitempty" json:"classPrices,omitempty"`
+}
+
+// ValidAt reports whether the rate card applies at t
+func (r RateCard) ValidAt(t time.Time) bool {
+	return !t.Before(r.EffectiveFrom) && (r.EffectiveTo == nil || t.Before(*r.EffectiveTo))
+}
+
+// Override returns the most specific override for model and assistant type:
+// model and assistant type, then model, then assistant type
+func (r RateCard) Override(model, assistantType string) (RateOverride, bool) {
+	best, bestScore := RateOverride{}, 0
+	for _, o := range r.Overrides {
+		if (o.Model != "" && o.Model != model) || (o.AssistantType != "" && o.AssistantType != assistantType) {
+			continue
+		}
+		score := 0
+		if o.Model != "" {
+			score += 2
+		}
+		if o.AssistantType != "" {
+			score++
+		}
+		if score > bestScore {
+			best, bestScore = o, score
+		}
+	}
+	return best, bestScore > 0
+}
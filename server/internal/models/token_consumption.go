@@ -7,99 +7,137 @@ import (
 )
 
 type TokenConsumption struct {
-	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	RequestID  string            `bson:"requestId" json:"requestId"` // Unique UUID
-	Timestamp  time.Time         `bson:"timestamp" json:"timestamp"` // Response timestamp (or request if no response)
-	UserID     string            `bson:"userId" json:"userId"`
-	OrgID      string            `bson:"organizationId" json:"organizationId"`
-	
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	RequestID string             `bson:"requestId" json:"requestId"` // Unique UUID
+	Timestamp time.Time          `bson:"timestamp" json:"timestamp"` // Response timestamp (or request if no response)
+	UserID    string             `bson:"userId" json:"userId"`
+	OrgID     string             `bson:"organizationId" json:"organizationId"`
+
 	// Enhanced fields (after collector updates)
-	AssistantType string `bson:"assistantType" json:"assistantType"` // word, excel, powerpoint, etc.
-	DocumentID    string `bson:"documentId" json:"documentId"`
+	AssistantType  string `bson:"assistantType" json:"assistantType"` // word, excel, powerpoint, etc.
+	DocumentID     string `bson:"documentId" json:"documentId"`
 	ConversationID string `bson:"conversationId" json:"conversationId"`
-	ProjectID     string `bson:"projectId" json:"projectId"` // Derived from documentId or conversationId
-	
-	Model string `bson:"model" json:"model"`
-	
+	ProjectID      string `bson:"projectId" json:"projectId"` // Derived from documentId or conversationId
+
+	Model    string `bson:"model" json:"model"`                           // Canonical model ID from the alias registry
+	RawModel string `bson:"rawModel,omitempty" json:"rawModel,omitempty"` // Model name as reported by the collector
+	Provider string `bson:"provider,omitempty" json:"provider,omitempty"`
+
 	// Request data
 	RequestTimestamp      time.Time `bson:"requestTimestamp" json:"requestTimestamp"`
 	RequestCharacterCount int       `bson:"requestCharacterCount" json:"requestCharacterCount"`
 	RequestTokenCount     int       `bson:"requestTokenCount" json:"requestTokenCount"` // Estimated
-	RequestType           string    `bson:"requestType" json:"requestType"`           // stream or non-stream
+	RequestType           string    `bson:"requestType" json:"requestType"`             // stream or non-stream
 	ToolCount             int       `bson:"toolCount" json:"toolCount"`
 	MessageCount          int       `bson:"messageCount" json:"messageCount"`
-	
+
 	// Response data
 	ResponseTimestamp      time.Time `bson:"responseTimestamp" json:"responseTimestamp"`
 	ResponseCharacterCount int       `bson:"responseCharacterCount" json:"responseCharacterCount"`
 	ResponseTokenCount     int       `bson:"responseTokenCount" json:"responseTokenCount"` // Estimated
-	
+
 	// Accurate token counts (from usage field)
-	PromptTokens     int `bson:"promptTokens" json:"promptTokens"`         // From usage.promptTokens
-	CompletionTokens int `bson:"completionTokens" json:"completionTokens"` // From usage.completionTokens
-	TotalTokens      int `bson:"totalTokens" json:"totalTokens"`           // From usage.totalTokens (use this for billing)
-	
+	PromptTokens       int  `bson:"promptTokens" json:"promptTokens"`                                 // From usage.promptTokens
+	CompletionTokens   int  `bson:"completionTokens" json:"completionTokens"`                         // From usage.completionTokens
+	TotalTokens        int  `bson:"totalTokens" json:"totalTokens"`                                   // From usage.totalTokens (use this for billing)
+	TokensEstimated    bool `bson:"tokensEstimated" json:"tokensEstimated"`                           // No usage field; counts are collector estimates
+	CachedPromptTokens int  `bson:"cachedPromptTokens,omitempty" json:"cachedPromptTokens,omitempty"` // Part of promptTokens
+	ReasoningTokens    int  `bson:"reasoningTokens,omitempty" json:"reasoningTokens,omitempty"`       // Part of completionTokens
+	ImageTokens        int  `bson:"imageTokens,omitempty" json:"imageTokens,omitempty"`
+	AudioTokens        int  `bson:"audioTokens,omitempty" json:"audioTokens,omitempty"`
+
 	// Metadata
 	FinishReason   string `bson:"finishReason" json:"finishReason"`
 	ResponseTimeMs int64  `bson:"responseTimeMs" json:"responseTimeMs"`
 	HasToolCalls   bool   `bson:"hasToolCalls" json:"hasToolCalls"`
 	ToolCallCount  int    `bson:"toolCallCount" json:"toolCallCount"`
-	
+
 	// Billing
-	Cost float64 `bson:"cost" json:"cost"` // Calculated from totalTokens and model pricing
-	
+	Cost            float64            `bson:"cost" json:"cost"`                                 // Calculated from totalTokens and model pricing
+	Billable        bool               `bson:"billable" json:"billable"`                         // request-only records follow the REQUEST_ONLY_BILLABLE policy
+	PriceID         primitive.ObjectID `bson:"priceId,omitempty" json:"priceId,omitempty"`       // model_prices entry used for Cost
+	Unpriced        bool               `bson:"unpriced,omitempty" json:"unpriced,omitempty"`     // no catalog price for the model; Cost is 0
+	RateCardID      primitive.ObjectID `bson:"rateCardId,omitempty" json:"rateCardId,omitempty"` // organization rate card that adjusted Cost
+	RateCardVersion int                `bson:"rateCardVersion,omitempty" json:"rateCardVersion,omitempty"`
+	TierCharges     []TierCharge       `bson:"tierCharges,omitempty" json:"tierCharges,omitempty"` // split of Cost across volume tiers
+	CostByClass     *CostByClass       `bson:"costByClass,omitempty" json:"costByClass,omitempty"` // split of Cost across token classes
+	ReratingID      primitive.ObjectID `bson:"reratingId,omitempty" json:"reratingId,omitempty"`   // rerating_runs entry that last changed Cost
+	ReratedAt       *time.Time         `bson:"reratedAt,omitempty" json:"reratedAt,omitempty"`
+
+	// Debug content kept under the organization's content policy (never raw text)
+	RequestContent  *RetainedContent `bson:"requestContent,omitempty" json:"requestContent,omitempty"`
+	ResponseContent *RetainedContent `bson:"responseContent,omitempty" json:"responseContent,omitempty"`
+
 	// Status
 	Status string `bson:"status" json:"status"` // complete, request-only, response-only, error
-	
+
 	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
 }
 
 // LLMRequestData matches the collector's request message format
 type LLMRequestData struct {
-	RequestID        string    `json:"requestId"`
-	Timestamp        time.Time `json:"timestamp"`
-	UserID           string    `json:"userId"`
-	OrganizationID   string    `json:"organizationId"`
-	Model            string    `json:"model"`
-	Messages         []interface{} `json:"messages"`
-	SystemPrompt     string    `json:"systemPrompt"`
-	Tools            []interface{} `json:"tools"`
-	CharacterCount   int       `json:"characterCount"`
-	TokenCount       int       `json:"tokenCount"` // Estimated
-	RequestType      string    `json:"requestType"`
-	ToolCount        int       `json:"toolCount"`
-	MessageCount     int       `json:"messageCount"`
-	Temperature      float64   `json:"temperature"`
-	MaxTokens        int       `json:"maxTokens"`
-	TopP             float64   `json:"topP"`
-	AssistantType    string    `json:"assistantType,omitempty"` // After enhancement
-	DocumentID       string    `json:"documentId,omitempty"`    // After enhancement
-	ConversationID   string    `json:"conversationId,omitempty"` // After enhancement
+	SchemaVersion   int              `json:"schemaVersion,omitempty"`
+	RequestID       string           `json:"requestId"`
+	Timestamp       time.Time        `json:"timestamp"`
+	UserID          string           `json:"userId"`
+	OrganizationID  string           `json:"organizationId"`
+	Model           string           `json:"model"`
+	Messages        []interface{}    `json:"messages"`
+	SystemPrompt    string           `json:"systemPrompt"`
+	Tools           []interface{}    `json:"tools"`
+	CharacterCount  int              `json:"characterCount"`
+	TokenCount      int              `json:"tokenCount"` // Estimated
+	RequestType     string           `json:"requestType"`
+	ToolCount       int              `json:"toolCount"`
+	MessageCount    int              `json:"messageCount"`
+	Temperature     float64          `json:"temperature"`
+	MaxTokens       int              `json:"maxTokens"`
+	TopP            float64          `json:"topP"`
+	AssistantType   string           `json:"assistantType,omitempty"`   // After enhancement
+	DocumentID      string           `json:"documentId,omitempty"`      // After enhancement
+	ConversationID  string           `json:"conversationId,omitempty"`  // After enhancement
+	RetainedContent *RetainedContent `json:"retainedContent,omitempty"` // Set when content is minimised
 }
 
 // LLMResponseData matches the collector's response message format
 type LLMResponseData struct {
-	RequestID      string    `json:"requestId"`
-	Timestamp      time.Time `json:"timestamp"`
-	UserID         string    `json:"userId"`
-	OrganizationID string    `json:"organizationId"`
-	Model          string    `json:"model"`
-	Response       string    `json:"response"`
-	CharacterCount int       `json:"characterCount"`
-	TokenCount     int       `json:"tokenCount"` // Estimated
-	FinishReason   string    `json:"finishReason"`
-	Usage          *Usage    `json:"usage"` // Accurate token counts
-	ResponseTimeMs int64     `json:"responseTimeMs"`
-	HasToolCalls   bool      `json:"hasToolCalls"`
-	ToolCallCount  int       `json:"toolCallCount"`
-	AssistantType  string    `json:"assistantType,omitempty"` // After enhancement
-	DocumentID     string    `json:"documentId,omitempty"`    // After enhancement
-	ConversationID string    `json:"conversationId,omitempty"` // After enhancement
+	SchemaVersion   int              `json:"schemaVersion,omitempty"`
+	RequestID       string           `json:"requestId"`
+	Timestamp       time.Time        `json:"timestamp"`
+	UserID          string           `json:"userId"`
+	OrganizationID  string           `json:"organizationId"`
+	Model           string           `json:"model"`
+	Response        string           `json:"response"`
+	CharacterCount  int              `json:"characterCount"`
+	TokenCount      int              `json:"tokenCount"` // Estimated
+	FinishReason    string           `json:"finishReason"`
+	Usage           *Usage           `json:"usage"` // Accurate token counts
+	ResponseTimeMs  int64            `json:"responseTimeMs"`
+	HasToolCalls    bool             `json:"hasToolCalls"`
+	ToolCallCount   int              `json:"toolCallCount"`
+	AssistantType   string           `json:"assistantType,omitempty"`   // After enhancement
+	DocumentID      string           `json:"documentId,omitempty"`      // After enhancement
+	ConversationID  string           `json:"conversationId,omitempty"`  // After enhancement
+	RetainedContent *RetainedContent `json:"retainedContent,omitempty"` // Set when content is minimised
+}
+
+// RetainedContent is what an organization's content policy keeps of prompt or
+// completion text for debugging
+type RetainedContent struct {
+	Policy   string `bson:"policy" json:"policy"`                         // hashed or redacted
+	Hash     string `bson:"hash,omitempty" json:"hash,omitempty"`         // SHA-256 of the content
+	Redacted string `bson:"redacted,omitempty" json:"redacted,omitempty"` // structure with text replaced by its length
 }
 
 type Usage struct {
 	PromptTokens     int `json:"promptTokens"`
 	CompletionTokens int `json:"completionTokens"`
 	TotalTokens      int `json:"totalTokens"`
+	// Token classes priced separately. Cached prompt tokens are part of
+	// PromptTokens and reasoning tokens part of CompletionTokens; image and
+	// audio units are reported on their own.
+	CachedPromptTokens int `json:"cachedPromptTokens,omitempty"`
+	ReasoningTokens    int `json:"reasoningTokens,omitempty"`
+	ImageTokens        int `json:"imageTokens,omitempty"`
+	AudioTokens        int `json:"audioTokens,omitempty"`
 }
-
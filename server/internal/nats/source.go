@@ -0,0 +1,241 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"freedom-ai/management-server/internal/config"
+	"freedom-ai/management-server/internal/events"
+	"freedom-ai/management-server/internal/eventsource"
+	"freedom-ai/management-server/internal/metrics"
+
+	natsgo "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"go.uber.org/zap"
+)
+
+const (
+	headerDeadLetterReason = "x-dead-letter-reason"
+	headerDeadLetteredAt   = "x-dead-lettered-at"
+)
+
+// Source consumes collector events from a JetStream stream through durable pull
+// consumers. Failed messages are redelivered by the server with backoff.
+type Source struct {
+	conn            *natsgo.Conn
+	js              jetstream.JetStream
+	stream          jetstream.Stream
+	consumerName    string
+	requestSubject  string
+	responseSubject string
+	maxRetries      int
+	retryBaseDelay  time.Duration
+	prefetch        int
+	logger          *zap.Logger
+}
+
+func NewSource(cfg *config.Config, logger *zap.Logger) (*Source, error) {
+	conn, err := natsgo.Connect(cfg.NATSURL,
+		natsgo.MaxReconnects(-1),
+		natsgo.DisconnectErrHandler(func(_ *natsgo.Conn, err error) {
+			logger.Warn("NATS connection lost, reconnecting", zap.Error(err))
+		}),
+		natsgo.ReconnectHandler(func(_ *natsgo.Conn) {
+			logger.Info("Reconnected to NATS")
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+
+	js, err := jetstream.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to create JetStream context: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	stream, err := js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name: cfg.NATSStream,
+		Subjects: []string{
+			cfg.NATSRequestSubject,
+			cfg.NATSResponseSubject,
+			deadLetterSubject(cfg.NATSRequestSubject),
+			deadLetterSubject(cfg.NATSResponseSubject),
+		},
+		Storage: jetstream.FileStorage,
+	})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to declare stream %s: %w", cfg.NATSStream, err)
+	}
+
+	return &Source{
+		conn:            conn,
+		js:              js,
+		stream:          stream,
+		consumerName:    cfg.NATSConsumerName,
+		requestSubject:  cfg.NATSRequestSubject,
+		responseSubject: cfg.NATSResponseSubject,
+		maxRetries:      cfg.EventMaxRetries,
+		retryBaseDelay:  time.Duration(cfg.EventRetryBaseDelayMs) * time.Millisecond,
+		prefetch:        cfg.EventPrefetch,
+		logger:          logger,
+	}, nil
+}
+
+func deadLetterSubject(subject string) string {
+	return subject + ".dlq"
+}
+
+// Name identifies the source in logs
+func (s *Source) Name() string {
+	return "nats"
+}
+
+// Start consumes the request and response subjects until ctx is cancelled
+func (s *Source) Start(ctx context.Context, handle eventsource.Handler) error {
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	for _, c := range []struct{ name, subject, kind string }{
+		{s.consumerName + "-requests", s.requestSubject, eventsource.KindRequest},
+		{s.consumerName + "-responses", s.responseSubject, eventsource.KindResponse},
+	} {
+		consumer, err := s.stream.CreateOrUpdateConsumer(ctx, jetstream.ConsumerConfig{
+			Durable:       c.name,
+			FilterSubject: c.subject,
+			AckPolicy:     jetstream.AckExplicitPolicy,
+			MaxAckPending: s.prefetch,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create consumer %s: %w", c.name, err)
+		}
+
+		wg.Add(1)
+		go func(kind string) {
+			defer wg.Done()
+			if err := s.consume(ctx, consumer, kind, handle); err != nil {
+				errs <- err
+			}
+		}(c.kind)
+	}
+
+	s.logger.Info("NATS consumer started",
+		zap.String("requestSubject", s.requestSubject),
+		zap.String("responseSubject", s.responseSubject))
+
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+func (s *Source) consume(ctx context.Context, consumer jetstream.Consumer, kind string, handle eventsource.Handler) error {
+	it, err := consumer.Messages(jetstream.PullMaxMessages(s.prefetch))
+	if err != nil {
+		return fmt.Errorf("failed to start %s consumer: %w", kind, err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		it.Stop()
+	}()
+
+	for {
+		msg, err := it.Next()
+		if err != nil {
+			if errors.Is(err, jetstream.ErrMsgIteratorClosed) {
+				return nil
+			}
+			s.logger.Warn("Failed to fetch NATS message", zap.String("kind", kind), zap.Error(err))
+			continue
+		}
+		handle(&message{source: s, msg: msg, kind: kind})
+	}
+}
+
+func (s *Source) Close() error {
+	s.conn.Close()
+	return nil
+}
+
+// message adapts a JetStream message to eventsource.Message
+type message struct {
+	source *Source
+	msg    jetstream.Msg
+	kind   string
+}
+
+func (m *message) Kind() string { return m.kind }
+
+func (m *message) Body() []byte { return m.msg.Data() }
+
+func (m *message) SchemaVersion() int {
+	if v := m.msg.Headers().Get(events.SchemaVersionHeader); v != "" {
+		return events.VersionFromHeader(v)
+	}
+	return 0
+}
+
+func (m *message) Ack() {
+	if err := m.msg.Ack(); err != nil {
+		m.source.logger.Warn("Failed to acknowledge NATS message", zap.Error(err))
+	}
+}
+
+// Nack asks the server to redeliver the message after an exponential backoff
+func (m *message) Nack(cause error) {
+	retries := 0
+	if md, err := m.msg.Metadata(); err == nil && md.NumDelivered > 0 {
+		retries = int(md.NumDelivered - 1)
+	}
+	if retries >= m.source.maxRetries {
+		m.DeadLetter(fmt.Sprintf("retries exhausted: %v", cause))
+		return
+	}
+
+	delay := eventsource.RetryDelay(m.source.retryBaseDelay, retries)
+	if err := m.msg.NakWithDelay(delay); err != nil {
+		m.source.logger.Warn("Failed to schedule NATS message retry", zap.Error(err))
+		return
+	}
+
+	metrics.Ingestion.Add("retries", 1)
+	m.source.logger.Warn("Scheduled message retry",
+		zap.String("subject", m.msg.Subject()),
+		zap.Int("attempt", retries+1),
+		zap.Duration("delay", delay),
+		zap.Error(cause))
+}
+
+// DeadLetter publishes the message to <subject>.dlq without its prompt and
+// completion content and terminates redelivery
+func (m *message) DeadLetter(reason string) {
+	out := natsgo.NewMsg(deadLetterSubject(m.msg.Subject()))
+	out.Data = events.StripContent(m.msg.Data())
+	for k, v := range m.msg.Headers() {
+		out.Header[k] = v
+	}
+	out.Header.Set(headerDeadLetterReason, reason)
+	out.Header.Set(headerDeadLetteredAt, time.Now().UTC().Format(time.RFC3339))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := m.source.js.PublishMsg(ctx, out); err != nil {
+		// Without an ack the server redelivers the message after the ack wait
+		m.source.logger.Error("Failed to dead-letter NATS message", zap.String("subject", m.msg.Subject()), zap.Error(err))
+		return
+	}
+
+	if err := m.msg.Term(); err != nil {
+		m.source.logger.Warn("Failed to terminate dead-lettered NATS message", zap.Error(err))
+	}
+
+	metrics.Ingestion.Add("dead_lettered", 1)
+	m.source.logger.Warn("Dead-lettered message",
+		zap.String("subject", m.msg.Subject()),
+		zap.String("reason", reason))
+}
@@ -0,0 +1,152 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.uber.org/zap"
+)
+
+const (
+	StatusConnected    = "connected"
+	StatusReconnecting = "reconnecting"
+	StatusClosed       = "closed"
+
+	initialReconnectDelay = time.Second
+	maxReconnectDelay     = time.Minute
+)
+
+var ErrNotConnected = errors.New("RabbitMQ consumer is not connected")
+
+// ConnectionState describes the broker connection as reported by the health endpoint
+type ConnectionState struct {
+	Status     string    `json:"status"`
+	Since      time.Time `json:"since"`
+	LastError  string    `json:"lastError,omitempty"`
+	Reconnects int       `json:"reconnects"`
+}
+
+// State returns a snapshot of the broker connection state
+func (c *Consumer) State() ConnectionState {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.state
+}
+
+// connect dials the broker, opens a channel and (re)declares the topology
+func (c *Consumer) connect() error {
+	conn, err := amqp.Dial(c.url)
+	if err != nil {
+		return fmt.Errorf("failed to connect to RabbitMQ: %w", err)
+	}
+
+	channel, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to open channel: %w", err)
+	}
+
+	if err := channel.Qos(c.prefetch, 0, false); err != nil {
+		channel.Close()
+		conn.Close()
+		return fmt.Errorf("failed to set channel prefetch: %w", err)
+	}
+
+	if err := c.declareTopology(channel); err != nil {
+		channel.Close()
+		conn.Close()
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state.Status == StatusClosed {
+		channel.Close()
+		conn.Close()
+		return ErrNotConnected
+	}
+	c.conn = conn
+	c.channel = channel
+	c.state.Status = StatusConnected
+	c.state.Since = time.Now()
+	c.state.LastError = ""
+
+	return nil
+}
+
+// reconnect retries connect with exponential backoff until it succeeds, the
+// context is cancelled or the consumer is closed
+func (c *Consumer) reconnect(ctx context.Context, cause error) error {
+	c.mu.Lock()
+	c.state.Status = StatusReconnecting
+	c.state.Since = time.Now()
+	if cause != nil {
+		c.state.LastError = cause.Error()
+	}
+	c.mu.Unlock()
+
+	delay := initialReconnectDelay
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+
+		if c.isClosed() {
+			return ErrNotConnected
+		}
+
+		err := c.connect()
+		if err == nil {
+			c.mu.Lock()
+			c.state.Reconnects++
+			c.mu.Unlock()
+			c.logger.Info("Reconnected to RabbitMQ")
+			return nil
+		}
+
+		c.mu.Lock()
+		c.state.LastError = err.Error()
+		c.mu.Unlock()
+		c.logger.Warn("Failed to reconnect to RabbitMQ", zap.Duration("retryIn", delay*2), zap.Error(err))
+
+		delay *= 2
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+// connection returns the current connection and channel, or ErrNotConnected
+// while the supervisor is reconnecting
+func (c *Consumer) connection() (*amqp.Connection, *amqp.Channel, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.state.Status != StatusConnected || c.conn == nil || c.conn.IsClosed() {
+		return nil, nil, ErrNotConnected
+	}
+	return c.conn, c.channel, nil
+}
+
+// openChannel opens a short-lived channel for admin operations
+func (c *Consumer) openChannel() (*amqp.Channel, error) {
+	conn, _, err := c.connection()
+	if err != nil {
+		return nil, err
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open channel: %w", err)
+	}
+	return ch, nil
+}
+
+func (c *Consumer) isClosed() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.state.Status == StatusClosed
+}
@@ -2,51 +2,73 @@ package rabbitmq
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"sync"
+	"time"
 
 	"freedom-ai/management-server/internal/config"
-	"freedom-ai/management-server/internal/models"
-	"freedom-ai/management-server/internal/services/consumption"
+	"freedom-ai/management-server/internal/events"
+	"freedom-ai/management-server/internal/eventsource"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.uber.org/zap"
 )
 
-type Consumer struct {
-	conn          *amqp.Connection
-	channel       *amqp.Channel
-	requestQueue  string
-	responseQueue string
-	exchange      string
-	db            *mongo.Database
-	redis         RedisClient
-	consumptionService *consumption.Service
-	logger        *zap.Logger
-}
+const (
+	requestRoutingKey  = "llm.request"
+	responseRoutingKey = "llm.response"
+)
 
-type RedisClient interface {
-	SetWithTTL(ctx context.Context, key string, value interface{}, ttl int) error
-	Get(ctx context.Context, key string) (string, error)
-	Delete(ctx context.Context, key string) error
+type Consumer struct {
+	url                string
+	mu                 sync.RWMutex
+	conn               *amqp.Connection
+	channel            *amqp.Channel
+	state              ConnectionState
+	requestQueue       string
+	responseQueue      string
+	exchange           string
+	deadLetterExchange string
+	maxRetries         int
+	retryBaseDelay     time.Duration
+	prefetch           int
+	workers            int
+	db                 *mongo.Database
+	logger             *zap.Logger
 }
 
-func NewConsumer(cfg *config.Config, db *mongo.Database, redis RedisClient, consumptionService *consumption.Service, logger *zap.Logger) (*Consumer, error) {
-	conn, err := amqp.Dial(cfg.RabbitMQURL)
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to RabbitMQ: %w", err)
+func NewConsumer(cfg *config.Config, db *mongo.Database, logger *zap.Logger) (*Consumer, error) {
+	c := &Consumer{
+		url:                cfg.RabbitMQURL,
+		requestQueue:       "llm-consumption-requests",
+		responseQueue:      "llm-consumption-responses",
+		exchange:           cfg.RabbitMQExchange,
+		deadLetterExchange: cfg.RabbitMQDeadLetterExchange,
+		maxRetries:         cfg.EventMaxRetries,
+		retryBaseDelay:     time.Duration(cfg.EventRetryBaseDelayMs) * time.Millisecond,
+		prefetch:           cfg.EventPrefetch,
+		workers:            cfg.RabbitMQWorkers,
+		db:                 db,
+		logger:             logger,
+	}
+	if c.workers < 1 {
+		c.workers = 1
 	}
 
-	channel, err := conn.Channel()
-	if err != nil {
-		conn.Close()
-		return nil, fmt.Errorf("failed to open channel: %w", err)
+	if err := c.connect(); err != nil {
+		return nil, err
 	}
 
+	return c, nil
+}
+
+// declareTopology declares the exchanges, queues and bindings used by the consumer,
+// including the per-queue retry and dead-letter queues
+func (c *Consumer) declareTopology(channel *amqp.Channel) error {
 	// Declare exchange
-	err = channel.ExchangeDeclare(
-		cfg.RabbitMQExchange,
+	err := channel.ExchangeDeclare(
+		c.exchange,
 		"topic",
 		true,
 		false,
@@ -55,74 +77,142 @@ func NewConsumer(cfg *config.Config, db *mongo.Database, redis RedisClient, cons
 		nil,
 	)
 	if err != nil {
-		channel.Close()
-		conn.Close()
-		return nil, fmt.Errorf("failed to declare exchange: %w", err)
+		return fmt.Errorf("failed to declare exchange: %w", err)
 	}
 
-	// Declare queues
-	requestQueue := "llm-consumption-requests"
-	responseQueue := "llm-consumption-responses"
+	// Declare dead-letter exchange
+	err = channel.ExchangeDeclare(c.deadLetterExchange, "direct", true, false, false, false, nil)
+	if err != nil {
+		return fmt.Errorf("failed to declare dead-letter exchange: %w", err)
+	}
 
-	_, err = channel.QueueDeclare(requestQueue, true, false, false, false, nil)
+	// Declare queues
+	_, err = channel.QueueDeclare(c.requestQueue, true, false, false, false, nil)
 	if err != nil {
-		channel.Close()
-		conn.Close()
-		return nil, fmt.Errorf("failed to declare request queue: %w", err)
+		return fmt.Errorf("failed to declare request queue: %w", err)
 	}
 
-	_, err = channel.QueueDeclare(responseQueue, true, false, false, false, nil)
+	_, err = channel.QueueDeclare(c.responseQueue, true, false, false, false, nil)
 	if err != nil {
-		channel.Close()
-		conn.Close()
-		return nil, fmt.Errorf("failed to declare response queue: %w", err)
+		return fmt.Errorf("failed to declare response queue: %w", err)
 	}
 
 	// Bind queues
-	err = channel.QueueBind(requestQueue, "llm.request", cfg.RabbitMQExchange, false, nil)
+	err = channel.QueueBind(c.requestQueue, requestRoutingKey, c.exchange, false, nil)
 	if err != nil {
-		channel.Close()
-		conn.Close()
-		return nil, fmt.Errorf("failed to bind request queue: %w", err)
+		return fmt.Errorf("failed to bind request queue: %w", err)
 	}
 
-	err = channel.QueueBind(responseQueue, "llm.response", cfg.RabbitMQExchange, false, nil)
+	err = channel.QueueBind(c.responseQueue, responseRoutingKey, c.exchange, false, nil)
 	if err != nil {
-		channel.Close()
-		conn.Close()
-		return nil, fmt.Errorf("failed to bind response queue: %w", err)
-	}
-
-	return &Consumer{
-		conn:              conn,
-		channel:           channel,
-		requestQueue:      requestQueue,
-		responseQueue:     responseQueue,
-		exchange:          cfg.RabbitMQExchange,
-		db:                db,
-		redis:             redis,
-		consumptionService: consumptionService,
-		logger:            logger,
-	}, nil
-}
+		return fmt.Errorf("failed to bind response queue: %w", err)
+	}
 
-func (c *Consumer) Start(ctx context.Context) error {
-	// Start consuming request messages
-	go c.consumeRequests(ctx)
+	// Retry and dead-letter queues
+	for _, q := range []struct{ queue, routingKey string }{
+		{c.requestQueue, requestRoutingKey},
+		{c.responseQueue, responseRoutingKey},
+	} {
+		// Messages wait in the retry queue for their delay until the queue TTL
+		// expires them, then RabbitMQ dead-letters them straight back onto the work queue
+		for _, delay := range eventsource.RetryDelays(c.retryBaseDelay, c.maxRetries) {
+			_, err = channel.QueueDeclare(retryQueueName(q.queue, delay), true, false, false, false, amqp.Table{
+				"x-message-ttl":             delay.Milliseconds(),
+				"x-dead-letter-exchange":    "",
+				"x-dead-letter-routing-key": q.queue,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to declare retry queue for %s: %w", q.queue, err)
+			}
+		}
 
-	// Start consuming response messages
-	go c.consumeResponses(ctx)
+		_, err = channel.QueueDeclare(deadLetterQueueName(q.queue), true, false, false, false, nil)
+		if err != nil {
+			return fmt.Errorf("failed to declare dead-letter queue for %s: %w", q.queue, err)
+		}
 
-	c.logger.Info("RabbitMQ consumer started",
-		zap.String("requestQueue", c.requestQueue),
-		zap.String("responseQueue", c.responseQueue))
+		err = channel.QueueBind(deadLetterQueueName(q.queue), q.routingKey, c.deadLetterExchange, false, nil)
+		if err != nil {
+			return fmt.Errorf("failed to bind dead-letter queue for %s: %w", q.queue, err)
+		}
+	}
 
-	<-ctx.Done()
 	return nil
 }
 
-func (c *Consumer) consumeRequests(ctx context.Context) {
-	msgs, err := c.channel.Consume(
+// Start consumes both queues until ctx is cancelled. When the connection or
+// channel drops, it reconnects with exponential backoff and resumes consuming.
+func (c *Consumer) Start(ctx context.Context, handle eventsource.Handler) error {
+	for {
+		conn, channel, err := c.connection()
+		if err != nil {
+			return err
+		}
+
+		connClosed := conn.NotifyClose(make(chan *amqp.Error, 1))
+		channelClosed := channel.NotifyClose(make(chan *amqp.Error, 1))
+
+		sessionCtx, cancel := context.WithCancel(ctx)
+		consumerErrs := make(chan error, 2)
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			consumerErrs <- c.consumeRequests(sessionCtx, channel, handle)
+		}()
+		go func() {
+			defer wg.Done()
+			consumerErrs <- c.consumeResponses(sessionCtx, channel, handle)
+		}()
+
+		c.logger.Info("RabbitMQ consumer started",
+			zap.String("requestQueue", c.requestQueue),
+			zap.String("responseQueue", c.responseQueue))
+
+		var cause error
+		select {
+		case <-ctx.Done():
+			cancel()
+			wg.Wait()
+			return nil
+		case amqpErr := <-connClosed:
+			if amqpErr != nil {
+				cause = amqpErr
+			}
+		case amqpErr := <-channelClosed:
+			if amqpErr != nil {
+				cause = amqpErr
+			}
+		case cause = <-consumerErrs:
+		}
+
+		cancel()
+		wg.Wait()
+
+		if c.isClosed() {
+			return nil
+		}
+		if cause == nil {
+			cause = ErrNotConnected
+		}
+
+		c.logger.Warn("RabbitMQ connection lost, reconnecting", zap.Error(cause))
+		channel.Close()
+		conn.Close()
+
+		if err := c.reconnect(ctx, cause); err != nil {
+			if ctx.Err() != nil || c.isClosed() {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// consumeRequests returns nil when ctx is cancelled and an error when the
+// delivery stream ends, so the supervisor can reconnect
+func (c *Consumer) consumeRequests(ctx context.Context, channel *amqp.Channel, handle eventsource.Handler) error {
+	msgs, err := channel.Consume(
 		c.requestQueue,
 		"",
 		false, // manual ack
@@ -132,25 +222,19 @@ func (c *Consumer) consumeRequests(ctx context.Context) {
 		nil,
 	)
 	if err != nil {
-		c.logger.Error("Failed to register request consumer", zap.Error(err))
-		return
+		return fmt.Errorf("failed to register request consumer: %w", err)
 	}
 
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case msg, ok := <-msgs:
-			if !ok {
-				return
-			}
-			c.handleRequest(msg)
-		}
+	if !c.runWorkers(ctx, msgs, func(msg amqp.Delivery) {
+		handle(&delivery{consumer: c, msg: msg, kind: eventsource.KindRequest, queue: c.requestQueue, routingKey: requestRoutingKey})
+	}) {
+		return fmt.Errorf("request delivery channel closed")
 	}
+	return nil
 }
 
-func (c *Consumer) consumeResponses(ctx context.Context) {
-	msgs, err := c.channel.Consume(
+func (c *Consumer) consumeResponses(ctx context.Context, channel *amqp.Channel, handle eventsource.Handler) error {
+	msgs, err := channel.Consume(
 		c.responseQueue,
 		"",
 		false, // manual ack
@@ -160,83 +244,90 @@ func (c *Consumer) consumeResponses(ctx context.Context) {
 		nil,
 	)
 	if err != nil {
-		c.logger.Error("Failed to register response consumer", zap.Error(err))
-		return
+		return fmt.Errorf("failed to register response consumer: %w", err)
 	}
 
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case msg, ok := <-msgs:
-			if !ok {
-				return
-			}
-			c.handleResponse(msg)
-		}
+	if !c.runWorkers(ctx, msgs, func(msg amqp.Delivery) {
+		handle(&delivery{consumer: c, msg: msg, kind: eventsource.KindResponse, queue: c.responseQueue, routingKey: responseRoutingKey})
+	}) {
+		return fmt.Errorf("response delivery channel closed")
 	}
+	return nil
 }
 
-func (c *Consumer) handleRequest(msg amqp.Delivery) {
-	var requestData models.LLMRequestData
-	if err := json.Unmarshal(msg.Body, &requestData); err != nil {
-		c.logger.Error("Failed to unmarshal request message", zap.Error(err))
-		msg.Nack(false, false)
-		return
+// runWorkers handles deliveries with c.workers goroutines until ctx is cancelled
+// or the delivery channel closes. It reports whether the channel was still open.
+func (c *Consumer) runWorkers(ctx context.Context, msgs <-chan amqp.Delivery, handle func(amqp.Delivery)) bool {
+	var wg sync.WaitGroup
+	closed := make(chan struct{}, c.workers)
+	for i := 0; i < c.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case msg, ok := <-msgs:
+					if !ok {
+						closed <- struct{}{}
+						return
+					}
+					handle(msg)
+				}
+			}
+		}()
 	}
+	wg.Wait()
 
-	// Store request in Redis cache (TTL: 1 hour)
-	ctx := context.Background()
-	key := fmt.Sprintf("request:%s", requestData.RequestID)
-	requestJSON, _ := json.Marshal(requestData)
-	if err := c.redis.SetWithTTL(ctx, key, string(requestJSON), 3600); err != nil {
-		c.logger.Warn("Failed to cache request", zap.String("requestId", requestData.RequestID), zap.Error(err))
-	}
+	return len(closed) == 0
+}
 
-	msg.Ack(false)
+// delivery adapts an AMQP delivery to eventsource.Message
+type delivery struct {
+	consumer   *Consumer
+	msg        amqp.Delivery
+	kind       string
+	queue      string
+	routingKey string
 }
 
-func (c *Consumer) handleResponse(msg amqp.Delivery) {
-	var responseData models.LLMResponseData
-	if err := json.Unmarshal(msg.Body, &responseData); err != nil {
-		c.logger.Error("Failed to unmarshal response message", zap.Error(err))
-		msg.Nack(false, false)
-		return
-	}
-
-	ctx := context.Background()
-	key := fmt.Sprintf("request:%s", responseData.RequestID)
-
-	// Try to get matching request
-	requestJSON, err := c.redis.Get(ctx, key)
-	var requestData *models.LLMRequestData
-	if err == nil && requestJSON != "" {
-		requestData = &models.LLMRequestData{}
-		if err := json.Unmarshal([]byte(requestJSON), requestData); err == nil {
-			// Delete from cache
-			_ = c.redis.Delete(ctx, key)
-		}
-	}
+func (d *delivery) Kind() string { return d.kind }
 
-	// Process consumption record
-	if err := c.consumptionService.ProcessConsumption(ctx, requestData, &responseData); err != nil {
-		c.logger.Error("Failed to process consumption",
-			zap.String("requestId", responseData.RequestID),
-			zap.Error(err))
-		msg.Nack(false, true) // Requeue on error
-		return
-	}
+func (d *delivery) Body() []byte { return d.msg.Body }
 
-	msg.Ack(false)
+func (d *delivery) SchemaVersion() int {
+	return events.VersionFromHeader(d.msg.Headers[events.SchemaVersionHeader])
+}
+
+func (d *delivery) Ack() { d.msg.Ack(false) }
+
+func (d *delivery) Nack(cause error) {
+	d.consumer.retryOrDeadLetter(d.msg, d.queue, d.routingKey, cause)
+}
+
+func (d *delivery) DeadLetter(reason string) {
+	d.consumer.deadLetter(d.msg, d.routingKey, reason)
+}
+
+// Name identifies the consumer as an event source
+func (c *Consumer) Name() string {
+	return "rabbitmq"
 }
 
 func (c *Consumer) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Mark closed first so the supervisor does not treat this as a dropped connection
+	c.state.Status = StatusClosed
+	c.state.Since = time.Now()
+
 	if c.channel != nil {
 		c.channel.Close()
 	}
-	if c.conn != nil {
+	if c.conn != nil && !c.conn.IsClosed() {
 		return c.conn.Close()
 	}
 	return nil
 }
-
@@ -0,0 +1,65 @@
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.uber.org/zap"
+)
+
+// handlerLatency stands in for the per-message work of validating, pairing and
+// queueing an event, which is dominated by the Redis round trip
+const handlerLatency = 200 * time.Microsecond
+
+// BenchmarkRunWorkers measures delivery throughput of the worker pool for
+// different pool sizes. Compare msgs/s across sub-benchmarks.
+func BenchmarkRunWorkers(b *testing.B) {
+	for _, workers := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			c := &Consumer{workers: workers, logger: zap.NewNop()}
+
+			msgs := make(chan amqp.Delivery, 256)
+			var handled atomic.Int64
+			done := make(chan bool)
+			go func() {
+				done <- c.runWorkers(context.Background(), msgs, func(amqp.Delivery) {
+					time.Sleep(handlerLatency)
+					handled.Add(1)
+				})
+			}()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				msgs <- amqp.Delivery{Body: []byte(`{}`)}
+			}
+			close(msgs)
+			<-done
+			b.StopTimer()
+
+			if handled.Load() != int64(b.N) {
+				b.Fatalf("handled %d of %d deliveries", handled.Load(), b.N)
+			}
+			b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "msgs/s")
+		})
+	}
+}
+
+func TestRunWorkersReportsClosedChannel(t *testing.T) {
+	c := &Consumer{workers: 4, logger: zap.NewNop()}
+
+	msgs := make(chan amqp.Delivery)
+	close(msgs)
+	if c.runWorkers(context.Background(), msgs, func(amqp.Delivery) {}) {
+		t.Fatal("runWorkers reported an open channel after it was closed")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if !c.runWorkers(ctx, make(chan amqp.Delivery), func(amqp.Delivery) {}) {
+		t.Fatal("runWorkers reported a closed channel after cancellation")
+	}
+}
@@ -0,0 +1,338 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"freedom-ai/management-server/internal/events"
+	"freedom-ai/management-server/internal/eventsource"
+	"freedom-ai/management-server/internal/metrics"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+const (
+	headerRetryCount       = "x-retry-count"
+	headerLastError        = "x-last-error"
+	headerDeadLetterReason = "x-dead-letter-reason"
+	headerDeadLetteredAt   = "x-dead-lettered-at"
+
+	// maxDeadLetterScan bounds how many messages a single admin lookup walks through
+	maxDeadLetterScan = 10000
+)
+
+var (
+	ErrUnknownDeadLetterQueue = errors.New("unknown dead-letter queue")
+	ErrDeadLetterNotFound     = errors.New("dead-lettered message not found")
+)
+
+// DeadLetter is a dead-lettered message as exposed by the admin API
+type DeadLetter struct {
+	MessageID      string    `json:"messageId"`
+	Queue          string    `json:"queue"`
+	RoutingKey     string    `json:"routingKey"`
+	Reason         string    `json:"reason"`
+	LastError      string    `json:"lastError,omitempty"`
+	RetryCount     int       `json:"retryCount"`
+	DeadLetteredAt time.Time `json:"deadLetteredAt"`
+	Body           string    `json:"body"`
+}
+
+// retryQueueName names the retry queue holding messages for one backoff delay.
+// Each delay gets its own queue with a queue-level TTL, because RabbitMQ only
+// expires messages at the head of a queue and a long delay would otherwise hold
+// up shorter ones queued behind it.
+func retryQueueName(queue string, delay time.Duration) string {
+	return fmt.Sprintf("%s.retry.%dms", queue, delay.Milliseconds())
+}
+
+func deadLetterQueueName(queue string) string {
+	return queue + ".dlq"
+}
+
+// retryOrDeadLetter schedules the message for another attempt after an exponential
+// backoff, or dead-letters it once EventMaxRetries attempts have failed
+func (c *Consumer) retryOrDeadLetter(msg amqp.Delivery, queue, routingKey string, cause error) {
+	retries := retryCount(msg.Headers)
+	if retries >= c.maxRetries {
+		c.deadLetter(msg, routingKey, fmt.Sprintf("retries exhausted: %v", cause))
+		return
+	}
+
+	delay := eventsource.RetryDelay(c.retryBaseDelay, retries)
+	headers := copyHeaders(msg.Headers)
+	headers[headerRetryCount] = int32(retries + 1)
+	headers[headerLastError] = cause.Error()
+
+	err := c.publish("", retryQueueName(queue, delay), amqp.Publishing{
+		Headers:      headers,
+		ContentType:  msg.ContentType,
+		DeliveryMode: amqp.Persistent,
+		MessageId:    msg.MessageId,
+		Timestamp:    msg.Timestamp,
+		Body:         msg.Body,
+	})
+	if err != nil {
+		c.logger.Error("Failed to schedule message retry, requeueing", zap.String("queue", queue), zap.Error(err))
+		msg.Nack(false, true)
+		return
+	}
+
+	metrics.Ingestion.Add("retries", 1)
+	c.logger.Warn("Scheduled message retry",
+		zap.String("queue", queue),
+		zap.Int("attempt", retries+1),
+		zap.Duration("delay", delay),
+		zap.Error(cause))
+	msg.Ack(false)
+}
+
+// deadLetter moves the message to the dead-letter exchange with the given reason.
+// Prompt and completion content is removed first, since dead-lettered messages
+// are kept indefinitely and readable through the admin API.
+func (c *Consumer) deadLetter(msg amqp.Delivery, routingKey, reason string) {
+	headers := copyHeaders(msg.Headers)
+	headers[headerDeadLetterReason] = reason
+	headers[headerDeadLetteredAt] = time.Now().UTC().Format(time.RFC3339)
+
+	messageID := msg.MessageId
+	if messageID == "" {
+		messageID = primitive.NewObjectID().Hex()
+	}
+
+	err := c.publish(c.deadLetterExchange, routingKey, amqp.Publishing{
+		Headers:      headers,
+		ContentType:  msg.ContentType,
+		DeliveryMode: amqp.Persistent,
+		MessageId:    messageID,
+		Timestamp:    msg.Timestamp,
+		Body:         events.StripContent(msg.Body),
+	})
+	if err != nil {
+		c.logger.Error("Failed to dead-letter message, requeueing", zap.String("routingKey", routingKey), zap.Error(err))
+		msg.Nack(false, true)
+		return
+	}
+
+	metrics.Ingestion.Add("dead_lettered", 1)
+	c.logger.Warn("Dead-lettered message",
+		zap.String("messageId", messageID),
+		zap.String("routingKey", routingKey),
+		zap.String("reason", reason))
+	msg.Ack(false)
+}
+
+func (c *Consumer) publish(exchange, routingKey string, msg amqp.Publishing) error {
+	_, channel, err := c.connection()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return channel.PublishWithContext(ctx, exchange, routingKey, false, false, msg)
+}
+
+// resolveDeadLetterQueue maps the admin API queue name ("requests" or "responses")
+// to the dead-letter queue and the routing key used to replay its messages
+func (c *Consumer) resolveDeadLetterQueue(name string) (string, string, error) {
+	switch name {
+	case "requests":
+		return deadLetterQueueName(c.requestQueue), requestRoutingKey, nil
+	case "responses":
+		return deadLetterQueueName(c.responseQueue), responseRoutingKey, nil
+	}
+	return "", "", ErrUnknownDeadLetterQueue
+}
+
+// ListDeadLetters returns up to limit dead-lettered messages without removing them
+func (c *Consumer) ListDeadLetters(ctx context.Context, name string, limit int) ([]DeadLetter, error) {
+	queue, _, err := c.resolveDeadLetterQueue(name)
+	if err != nil {
+		return nil, err
+	}
+
+	ch, err := c.openChannel()
+	if err != nil {
+		return nil, err
+	}
+	// Unacknowledged messages are requeued when the channel closes
+	defer ch.Close()
+
+	letters := []DeadLetter{}
+	for len(letters) < limit {
+		msg, ok, err := ch.Get(queue, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read dead-letter queue: %w", err)
+		}
+		if !ok {
+			break
+		}
+		letters = append(letters, toDeadLetter(queue, msg))
+	}
+
+	return letters, nil
+}
+
+// GetDeadLetter returns a single dead-lettered message without removing it
+func (c *Consumer) GetDeadLetter(ctx context.Context, name, messageID string) (*DeadLetter, error) {
+	queue, _, err := c.resolveDeadLetterQueue(name)
+	if err != nil {
+		return nil, err
+	}
+
+	ch, err := c.openChannel()
+	if err != nil {
+		return nil, err
+	}
+	defer ch.Close()
+
+	for i := 0; i < maxDeadLetterScan; i++ {
+		msg, ok, err := ch.Get(queue, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read dead-letter queue: %w", err)
+		}
+		if !ok {
+			break
+		}
+		if msg.MessageId == messageID {
+			letter := toDeadLetter(queue, msg)
+			return &letter, nil
+		}
+	}
+
+	return nil, ErrDeadLetterNotFound
+}
+
+// ReplayDeadLetters republishes dead-lettered messages to the main exchange with a
+// fresh retry budget. With no messageIDs, up to limit messages are replayed.
+func (c *Consumer) ReplayDeadLetters(ctx context.Context, name string, messageIDs []string, limit int) (int, error) {
+	queue, routingKey, err := c.resolveDeadLetterQueue(name)
+	if err != nil {
+		return 0, err
+	}
+
+	wanted := make(map[string]bool, len(messageIDs))
+	for _, id := range messageIDs {
+		wanted[id] = true
+	}
+	if len(wanted) > 0 {
+		limit = len(wanted)
+	}
+
+	ch, err := c.openChannel()
+	if err != nil {
+		return 0, err
+	}
+	defer ch.Close()
+
+	replayed := 0
+	for i := 0; i < maxDeadLetterScan && replayed < limit; i++ {
+		msg, ok, err := ch.Get(queue, false)
+		if err != nil {
+			return replayed, fmt.Errorf("failed to read dead-letter queue: %w", err)
+		}
+		if !ok {
+			break
+		}
+		if len(wanted) > 0 && !wanted[msg.MessageId] {
+			continue
+		}
+
+		headers := copyHeaders(msg.Headers)
+		delete(headers, headerRetryCount)
+		delete(headers, headerLastError)
+		delete(headers, headerDeadLetterReason)
+		delete(headers, headerDeadLetteredAt)
+
+		err = ch.PublishWithContext(ctx, c.exchange, routingKey, false, false, amqp.Publishing{
+			Headers:      headers,
+			ContentType:  msg.ContentType,
+			DeliveryMode: amqp.Persistent,
+			MessageId:    msg.MessageId,
+			Timestamp:    msg.Timestamp,
+			Body:         msg.Body,
+		})
+		if err != nil {
+			return replayed, fmt.Errorf("failed to replay message %s: %w", msg.MessageId, err)
+		}
+		if err := msg.Ack(false); err != nil {
+			return replayed, fmt.Errorf("failed to acknowledge replayed message %s: %w", msg.MessageId, err)
+		}
+		replayed++
+	}
+
+	if len(wanted) > 0 && replayed == 0 {
+		return 0, ErrDeadLetterNotFound
+	}
+
+	c.logger.Info("Replayed dead-lettered messages", zap.String("queue", queue), zap.Int("count", replayed))
+	return replayed, nil
+}
+
+// PurgeDeadLetters deletes every message in the dead-letter queue
+func (c *Consumer) PurgeDeadLetters(ctx context.Context, name string) (int, error) {
+	queue, _, err := c.resolveDeadLetterQueue(name)
+	if err != nil {
+		return 0, err
+	}
+
+	ch, err := c.openChannel()
+	if err != nil {
+		return 0, err
+	}
+	defer ch.Close()
+
+	purged, err := ch.QueuePurge(queue, false)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge dead-letter queue: %w", err)
+	}
+
+	c.logger.Info("Purged dead-letter queue", zap.String("queue", queue), zap.Int("count", purged))
+	return purged, nil
+}
+
+func toDeadLetter(queue string, msg amqp.Delivery) DeadLetter {
+	letter := DeadLetter{
+		MessageID:  msg.MessageId,
+		Queue:      queue,
+		RoutingKey: msg.RoutingKey,
+		RetryCount: retryCount(msg.Headers),
+		// Messages dead-lettered by older versions may still carry content
+		Body: string(events.StripContent(msg.Body)),
+	}
+	if reason, ok := msg.Headers[headerDeadLetterReason].(string); ok {
+		letter.Reason = reason
+	}
+	if lastError, ok := msg.Headers[headerLastError].(string); ok {
+		letter.LastError = lastError
+	}
+	if at, ok := msg.Headers[headerDeadLetteredAt].(string); ok {
+		letter.DeadLetteredAt, _ = time.Parse(time.RFC3339, at)
+	}
+	return letter
+}
+
+func retryCount(headers amqp.Table) int {
+	switch v := headers[headerRetryCount].(type) {
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case int:
+		return v
+	}
+	return 0
+}
+
+func copyHeaders(headers amqp.Table) amqp.Table {
+	copied := amqp.Table{}
+	for k, v := range headers {
+		copied[k] = v
+	}
+	return copied
+}
@@ -0,0 +1,27 @@
+package rabbitmq
+
+import (
+	"strings"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestToDeadLetterStripsContent(t *testing.T) {
+	msg := amqp.Delivery{
+		MessageId: "m1",
+		Headers:   amqp.Table{headerDeadLetterReason: "malformed"},
+		Body:      []byte(`{"requestId":"r1","organizationId":"org-1","systemPrompt":"secret","messages":[{"role":"user","content":"secret"}],"response":"secret"}`),
+	}
+
+	letter := toDeadLetter("llm.requests.dlq", msg)
+	if strings.Contains(letter.Body, "secret") {
+		t.Fatalf("dead letter body contains content: %s", letter.Body)
+	}
+	if !strings.Contains(letter.Body, `"requestId":"r1"`) {
+		t.Fatalf("dead letter body lost its identifiers: %s", letter.Body)
+	}
+	if letter.Reason != "malformed" {
+		t.Fatalf("reason = %q, want malformed", letter.Reason)
+	}
+}
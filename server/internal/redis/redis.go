@@ -76,3 +76,23 @@ func (r *RedisClient) GetInt(ctx context.Context, key string) (int64, error) {
 	return strconv.ParseInt(val, 10, 64)
 }
 
+func (r *RedisClient) ZAdd(ctx context.Context, key string, score float64, member string) error {
+	return r.client.ZAdd(ctx, key, redis.Z{Score: score, Member: member}).Err()
+}
+
+// ZRangeByScore returns up to limit members with a score at or below max
+func (r *RedisClient) ZRangeByScore(ctx context.Context, key string, max float64, limit int64) ([]string, error) {
+	return r.client.ZRangeByScore(ctx, key, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatFloat(max, 'f', -1, 64),
+		Count: limit,
+	}).Result()
+}
+
+func (r *RedisClient) ZRem(ctx context.Context, key string, members ...string) error {
+	args := make([]interface{}, len(members))
+	for i, m := range members {
+		args[i] = m
+	}
+	return r.client.ZRem(ctx, key, args...).Err()
+}
@@ -0,0 +1,30 @@
+// Package redistest runs tests against an in-memory Redis server.
+package redistest
+
+import (
+	"net"
+	"testing"
+
+	"freedom-ai/management-server/internal/redis"
+
+	"github.com/alicebob/miniredis/v2"
+	"go.uber.org/zap"
+)
+
+// Start returns a client for an in-memory Redis server, stopped when the test
+// ends. The server is returned to inspect what was stored.
+func Start(tb testing.TB) (*redis.RedisClient, *miniredis.Miniredis) {
+	tb.Helper()
+	server := miniredis.RunT(tb)
+
+	host, port, err := net.SplitHostPort(server.Addr())
+	if err != nil {
+		tb.Fatalf("parse redis address: %v", err)
+	}
+	client, err := redis.NewRedisClient(host, port, "", 0, zap.NewNop())
+	if err != nil {
+		tb.Fatalf("connect to redis: %v", err)
+	}
+	tb.Cleanup(func() { client.Close() })
+	return client, server
+}
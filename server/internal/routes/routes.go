@@ -1,11 +1,22 @@
 package routes
 
 import (
+	"expvar"
+
 	"freedom-ai/management-server/internal/config"
 	"freedom-ai/management-server/internal/handlers"
 	"freedom-ai/management-server/internal/lib/supertokens"
 	"freedom-ai/management-server/internal/middleware"
+	"freedom-ai/management-server/internal/rabbitmq"
+	"freedom-ai/management-server/internal/services/adjustment"
+	"freedom-ai/management-server/internal/services/billing"
 	"freedom-ai/management-server/internal/services/consumption"
+	"freedom-ai/management-server/internal/services/credits"
+	"freedom-ai/management-server/internal/services/dunning"
+	"freedom-ai/management-server/internal/services/ingest"
+	"freedom-ai/management-server/internal/services/invoice"
+	"freedom-ai/management-server/internal/services/ledger"
+	"freedom-ai/management-server/internal/services/limits"
 	"freedom-ai/management-server/internal/services/stripe"
 
 	"github.com/gin-gonic/gin"
@@ -13,29 +24,44 @@ import (
 	"go.uber.org/zap"
 )
 
-func SetupRoutes(router *gin.Engine, db *mongo.Database, cfg *config.Config, realtimeService *consumption.RealtimeService, logger *zap.Logger) {
+func SetupRoutes(router *gin.Engine, db *mongo.Database, cfg *config.Config, realtimeService *consumption.RealtimeService, ingestService *ingest.Service, billingService *billing.Service, invoiceService *invoice.Service, dunningService *dunning.Service, creditsService *credits.Service, adjustmentService *adjustment.Service, stripeService *stripe.Service, consumer *rabbitmq.Consumer, logger *zap.Logger) {
 	// Health check
 	router.GET("/health", func(c *gin.Context) {
-		c.JSON(200, gin.H{"status": "ok"})
+		health := gin.H{"status": "ok"}
+		if consumer != nil {
+			state := consumer.State()
+			health["rabbitmq"] = state
+			if state.Status != rabbitmq.StatusConnected {
+				health["status"] = "degraded"
+			}
+		}
+		c.JSON(200, health)
 	})
 
+	// Ingestion and billing counters (expvar)
+	router.GET("/metrics", gin.WrapH(expvar.Handler()))
+
 	// Initialize handlers
-	tenantHandler := handlers.NewTenantHandler(db)
+	ledgerService := ledger.NewService(db, logger)
+	tenantHandler := handlers.NewTenantHandler(db, ledgerService)
 	consumptionHandler := handlers.NewConsumptionHandler(db, realtimeService, logger)
-	billingHandler := handlers.NewBillingHandler(db)
+	limitsService := limits.NewService(db, logger)
+	billingHandler := handlers.NewBillingHandler(db, limitsService)
+	limitsHandler := handlers.NewLimitsHandler(db, limitsService)
+	ledgerHandler := handlers.NewLedgerHandler(db, ledgerService)
+	invoiceHandler := handlers.NewInvoiceHandler(db, invoiceService)
+	dunningHandler := handlers.NewDunningHandler(db, dunningService)
+	creditsHandler := handlers.NewCreditsHandler(db, creditsService)
+	adjustmentHandler := handlers.NewAdjustmentHandler(adjustmentService)
 	userHandler := handlers.NewUserHandler(db)
 	analyticsHandler := handlers.NewAnalyticsHandler(db)
 	projectHandler := handlers.NewProjectHandler(db)
 	exportHandler := handlers.NewExportHandler(db)
 	authHandler := handlers.NewAuthHandler(cfg, db)
 
-	// Initialize Stripe service and handler
+	// Stripe handler (if Stripe is configured)
 	var stripeHandler *handlers.StripeHandler
-	if cfg.StripeSecretKey != "" {
-		// Create a simple logger for stripe service
-		logger, _ := zap.NewProduction()
-		defer logger.Sync()
-		stripeService := stripe.NewService(cfg, db, logger)
+	if stripeService != nil {
 		stripeHandler = handlers.NewStripeHandler(stripeService, cfg.StripeWebhookSecret)
 	}
 
@@ -52,7 +78,7 @@ func SetupRoutes(router *gin.Engine, db *mongo.Database, cfg *config.Config, rea
 			auth.POST("/signout", authHandler.SignOut)
 			// GetCurrentUser requires authentication but is public endpoint (returns 401 if not authenticated)
 			auth.GET("/user", supertokens.VerifySession(), authHandler.GetCurrentUser)
-			
+
 			// Third-party OAuth routes (handled by SuperTokens)
 			// These routes are automatically handled by SuperTokens middleware
 		}
@@ -66,6 +92,16 @@ func SetupRoutes(router *gin.Engine, db *mongo.Database, cfg *config.Config, rea
 			}
 		}
 
+		// HTTP ingestion for deployments without a broker (per-organization API keys)
+		ingestHandler := handlers.NewIngestHandler(ingestService)
+		ingestRoutes := v1.Group("/ingest")
+		ingestRoutes.Use(middleware.RequireIngestKey(db))
+		{
+			ingestRoutes.POST("/events", ingestHandler.IngestEvents)
+			ingestRoutes.POST("/requests", ingestHandler.IngestRequests)
+			ingestRoutes.POST("/responses", ingestHandler.IngestResponses)
+		}
+
 		// Protected routes (require authentication)
 		protected := v1.Group("")
 		protected.Use(supertokens.VerifySession())
@@ -80,6 +116,59 @@ func SetupRoutes(router *gin.Engine, db *mongo.Database, cfg *config.Config, rea
 				developerOnly.POST("/admin/tenants", tenantHandler.CreateTenant)
 				developerOnly.PUT("/admin/tenants/:id", tenantHandler.UpdateTenant)
 				developerOnly.DELETE("/admin/tenants/:id", tenantHandler.DeleteTenant)
+				developerOnly.GET("/admin/tenants/:id/dunning", dunningHandler.GetDunning)
+				developerOnly.PUT("/admin/tenants/:id/dunning-policy", dunningHandler.UpdateDunningPolicy)
+				developerOnly.POST("/admin/tenants/:id/credit-grants", creditsHandler.CreateCreditGrant)
+				developerOnly.POST("/admin/tenants/:id/adjustments", adjustmentHandler.CreateAdjustment)
+				developerOnly.GET("/admin/tenants/:id/credit-notes", adjustmentHandler.ListCreditNotes)
+				developerOnly.POST("/admin/tenants/:id/credit-notes", adjustmentHandler.CreateCreditNote)
+				developerOnly.POST("/admin/top-ups/:id/refund", adjustmentHandler.RefundTopUp)
+				developerOnly.GET("/admin/promo-codes", creditsHandler.ListPromoCodes)
+				developerOnly.POST("/admin/promo-codes", creditsHandler.CreatePromoCode)
+				developerOnly.DELETE("/admin/promo-codes/:code", creditsHandler.DeactivatePromoCode)
+				developerOnly.GET("/admin/ledger/consistency", ledgerHandler.CheckLedgerConsistency)
+
+				// HTTP ingestion keys
+				ingestKeyHandler := handlers.NewIngestKeyHandler(db)
+				developerOnly.GET("/admin/tenants/:id/ingest-keys", ingestKeyHandler.ListIngestKeys)
+				developerOnly.POST("/admin/tenants/:id/ingest-keys", ingestKeyHandler.CreateIngestKey)
+				developerOnly.DELETE("/admin/tenants/:id/ingest-keys/:keyId", ingestKeyHandler.RevokeIngestKey)
+
+				// Billing runs
+				billingRunHandler := handlers.NewBillingRunHandler(billingService)
+				developerOnly.GET("/admin/billing/runs", billingRunHandler.ListBillingRuns)
+				developerOnly.POST("/admin/billing/runs", billingRunHandler.RunBilling)
+				developerOnly.POST("/admin/invoices/generate", invoiceHandler.GenerateInvoice)
+
+				// Negotiated rate cards
+				rateCardHandler := handlers.NewRateCardHandler(db)
+				developerOnly.GET("/admin/tenants/:id/rate-cards", rateCardHandler.ListRateCards)
+				developerOnly.POST("/admin/tenants/:id/rate-cards", rateCardHandler.CreateRateCard)
+				developerOnly.GET("/admin/tenants/:id/tier-usage", rateCardHandler.GetTierUsage)
+
+				// Model alias registry
+				modelAliasHandler := handlers.NewModelAliasHandler(db)
+				developerOnly.GET("/admin/model-aliases", modelAliasHandler.ListModelAliases)
+				developerOnly.POST("/admin/model-aliases", modelAliasHandler.CreateModelAlias)
+				developerOnly.PUT("/admin/model-aliases/:id", modelAliasHandler.UpdateModelAlias)
+				developerOnly.DELETE("/admin/model-aliases/:id", modelAliasHandler.DeleteModelAlias)
+
+				// Model price catalog
+				modelPriceHandler := handlers.NewModelPriceHandler(db)
+				developerOnly.GET("/admin/model-prices", modelPriceHandler.ListModelPrices)
+				developerOnly.GET("/admin/model-prices/unpriced", modelPriceHandler.GetUnpricedModels)
+				developerOnly.POST("/admin/model-prices", modelPriceHandler.CreateModelPrice)
+				developerOnly.PUT("/admin/model-prices/:id", modelPriceHandler.UpdateModelPrice)
+				developerOnly.DELETE("/admin/model-prices/:id", modelPriceHandler.DeleteModelPrice)
+
+				// Dead-letter administration (only when the RabbitMQ consumer is running)
+				if consumer != nil {
+					deadLetterHandler := handlers.NewDeadLetterHandler(consumer)
+					developerOnly.GET("/admin/dead-letters/:queue", deadLetterHandler.ListDeadLetters)
+					developerOnly.GET("/admin/dead-letters/:queue/:messageId", deadLetterHandler.GetDeadLetter)
+					developerOnly.POST("/admin/dead-letters/:queue/replay", deadLetterHandler.ReplayDeadLetters)
+					developerOnly.DELETE("/admin/dead-letters/:queue", deadLetterHandler.PurgeDeadLetters)
+				}
 			}
 
 			// Tenant admin and developer routes
@@ -89,6 +178,8 @@ func SetupRoutes(router *gin.Engine, db *mongo.Database, cfg *config.Config, rea
 				orgHandler := handlers.NewOrganizationHandler(db)
 				adminRoutes.PUT("/organization/:id/consumption-limits", orgHandler.UpdateConsumptionLimits)
 				adminRoutes.PUT("/organization/:id/auto-top-up", orgHandler.UpdateAutoTopUp)
+				adminRoutes.PUT("/organization/:id/content-policy", orgHandler.UpdateContentPolicy)
+				adminRoutes.POST("/billing/promo-codes/redeem", creditsHandler.RedeemPromoCode)
 				adminRoutes.GET("/organization/users", userHandler.ListUsers)
 				adminRoutes.GET("/organization/users/:id", userHandler.GetUser)
 				adminRoutes.POST("/organization/users", userHandler.CreateUser)
@@ -101,25 +192,41 @@ func SetupRoutes(router *gin.Engine, db *mongo.Database, cfg *config.Config, rea
 			protected.GET("/consumption/history", consumptionHandler.GetConsumptionHistory)
 			protected.GET("/consumption/by-assistant", consumptionHandler.GetConsumptionByAssistant)
 			protected.GET("/consumption/by-user", consumptionHandler.GetConsumptionByUser)
+			protected.GET("/consumption/by-model", consumptionHandler.GetConsumptionByModel)
 			protected.GET("/consumption/real-time", consumptionHandler.GetRealTimeConsumption)
 			protected.GET("/billing/wallet", billingHandler.GetWalletBalance)
 			protected.GET("/billing/history", billingHandler.GetBillingHistory)
+			protected.GET("/billing/ledger", ledgerHandler.GetLedger)
+			protected.GET("/billing/credits", creditsHandler.GetCredits)
+			protected.GET("/limits/check", limitsHandler.CheckLimits)
 			protected.POST("/billing/top-up", billingHandler.CreateTopUp)
 			protected.GET("/analytics/overview", analyticsHandler.GetSystemOverview)
 			protected.GET("/analytics/consumption-trends", analyticsHandler.GetConsumptionTrends)
 			protected.GET("/analytics/top-tenants", analyticsHandler.GetTopTenants)
 			protected.GET("/analytics/revenue-trends", analyticsHandler.GetRevenueTrends)
+			protected.GET("/analytics/consumption-status", analyticsHandler.GetConsumptionByStatus)
+			protected.GET("/analytics/cost-by-token-class", analyticsHandler.GetCostByTokenClass)
 			protected.GET("/organization/projects", projectHandler.ListProjects)
 			protected.GET("/organization/projects/:id/consumption", projectHandler.GetProjectConsumption)
 			protected.GET("/organization/projects/consumption/monthly", projectHandler.GetProjectConsumptionByMonth)
 			protected.GET("/export/consumption/csv", exportHandler.ExportConsumptionCSV)
 			protected.GET("/export/consumption/json", exportHandler.ExportConsumptionJSON)
 
+			// Invoices, scoped to the caller's organization
+			invoiceRoutes := protected.Group("/invoices")
+			invoiceRoutes.Use(middleware.RequireRole("tenant_user"), middleware.RequireOrganizationScope())
+			{
+				invoiceRoutes.GET("", invoiceHandler.ListInvoices)
+				invoiceRoutes.GET("/:id", invoiceHandler.GetInvoice)
+				invoiceRoutes.GET("/:id/pdf", invoiceHandler.DownloadInvoicePDF)
+			}
+
 			// Usage patterns (all authenticated)
 			usagePatternsHandler := handlers.NewUsagePatternsHandler(db)
 			protected.GET("/analytics/usage-patterns/peak-times", usagePatternsHandler.GetPeakUsageTimes)
 			protected.GET("/analytics/usage-patterns/day-of-week", usagePatternsHandler.GetUsageByDayOfWeek)
 			protected.GET("/analytics/usage-patterns/assistant-type", usagePatternsHandler.GetUsageByAssistantType)
+			protected.GET("/analytics/usage-patterns/by-model", usagePatternsHandler.GetUsageByModel)
 			protected.GET("/analytics/usage-patterns/user-activity", usagePatternsHandler.GetUserActivityPatterns)
 
 			// Revenue endpoints (admin only)
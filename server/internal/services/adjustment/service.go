@@ -0,0 +1,502 @@
+package adjustment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"freedom-ai/management-server/internal/database"
+	"freedom-ai/management-server/internal/models"
+	"freedom-ai/management-server/internal/services/dunning"
+	"freedom-ai/management-server/internal/services/email"
+	"freedom-ai/management-server/internal/services/ledger"
+	"freedom-ai/management-server/internal/services/stripe"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+// centTolerance absorbs floating point error when comparing amounts
+const centTolerance = 0.005
+
+var (
+	// ErrExceedsCharge is returned when a credit note would credit more than was charged
+	ErrExceedsCharge = errors.New("credit exceeds the remaining charge")
+	// ErrNotRefundable is returned for top-ups that cannot be refunded
+	ErrNotRefundable = errors.New("top-up is not refundable")
+	// ErrInsufficientBalance is returned when a refund exceeds the wallet
+	// balance not made up of granted credit
+	ErrInsufficientBalance = errors.New("refund exceeds the wallet balance")
+	// ErrRefundsUnavailable is returned when Stripe is not configured
+	ErrRefundsUnavailable = errors.New("refunds require Stripe to be configured")
+)
+
+// Request is a wallet change made by a developer. ReasonCode and Note are
+// mandatory.
+type Request struct {
+	OrgID      string
+	Amount     float64
+	ReasonCode string
+	Note       string
+	CreatedBy  string
+}
+
+type Service struct {
+	db           *mongo.Database
+	logger       *zap.Logger
+	ledger       *ledger.Service
+	stripe       *stripe.Service
+	emailService *email.Service
+	dunning      *dunning.Service
+}
+
+func NewService(db *mongo.Database, logger *zap.Logger) *Service {
+	return &Service{
+		db:     db,
+		logger: logger,
+		ledger: ledger.NewService(db, logger),
+	}
+}
+
+// SetStripeService enables refunds of Stripe top-ups
+func (s *Service) SetStripeService(stripeService *stripe.Service) {
+	s.stripe = stripeService
+}
+
+// SetEmailService sets the email service for adjustment notices
+func (s *Service) SetEmailService(emailService *email.Service) {
+	s.emailService = emailService
+}
+
+// SetDunningService sets the dunning service that reactivates organizations after credits
+func (s *Service) SetDunningService(dunningService *dunning.Service) {
+	s.dunning = dunningService
+}
+
+// EnsureIndexes creates the credit_notes indexes
+func (s *Service) EnsureIndexes(ctx context.Context) error {
+	_, err := s.db.Collection("credit_notes").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "orgId", Value: 1}, {Key: "issuedAt", Value: -1}}},
+		{
+			Keys:    bson.D{{Key: "number", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create credit_notes indexes: %w", err)
+	}
+	return nil
+}
+
+// Validate checks a request's reason code and note
+func Validate(req Request) error {
+	if !models.ValidReasonCode(req.ReasonCode) {
+		return fmt.Errorf("reasonCode must be one of: %s", strings.Join(models.ReasonCodes, ", "))
+	}
+	if strings.TrimSpace(req.Note) == "" {
+		return fmt.Errorf("note is required")
+	}
+	return nil
+}
+
+// AdjustWallet credits (positive amount) or debits (negative amount) an
+// organization's wallet, optionally against a billing history entry
+func (s *Service) AdjustWallet(ctx context.Context, req Request, billingHistoryID *primitive.ObjectID) (*models.BillingHistory, error) {
+	if err := Validate(req); err != nil {
+		return nil, err
+	}
+	if req.Amount == 0 {
+		return nil, fmt.Errorf("amount must not be zero")
+	}
+	org, err := s.organization(ctx, req.OrgID)
+	if err != nil {
+		return nil, err
+	}
+	record := models.BillingHistory{
+		Type:      models.BillingTypeAdjustment,
+		TotalCost: -req.Amount,
+	}
+	if billingHistoryID != nil {
+		target, err := s.billingRecord(ctx, req.OrgID, *billingHistoryID)
+		if err != nil {
+			return nil, err
+		}
+		record.Reference = billingHistoryID.Hex()
+		record.PeriodStart = target.PeriodStart
+		record.PeriodEnd = target.PeriodEnd
+	}
+
+	err = database.Transact(ctx, s.db, s.logger, func(ctx context.Context) error {
+		return s.post(ctx, req, models.LedgerAdjustment, "", &record)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.finish(ctx, org, record, req)
+	return &record, nil
+}
+
+// IssueCreditNote credits an organization's wallet against a billing history
+// entry or an invoice, up to what is left of that charge
+func (s *Service) IssueCreditNote(ctx context.Context, req Request, billingHistoryID, invoiceID *primitive.ObjectID) (*models.CreditNote, error) {
+	if err := Validate(req); err != nil {
+		return nil, err
+	}
+	if req.Amount <= 0 {
+		return nil, fmt.Errorf("amount must be positive")
+	}
+	if (billingHistoryID == nil) == (invoiceID == nil) {
+		return nil, fmt.Errorf("exactly one of billingHistoryId and invoiceId is required")
+	}
+	org, err := s.organization(ctx, req.OrgID)
+	if err != nil {
+		return nil, err
+	}
+
+	note := models.CreditNote{
+		OrgID:            req.OrgID,
+		BillingHistoryID: billingHistoryID,
+		InvoiceID:        invoiceID,
+		Amount:           req.Amount,
+		ReasonCode:       req.ReasonCode,
+		Note:             req.Note,
+		IssuedBy:         req.CreatedBy,
+	}
+	var charge float64
+	target := bson.M{"billingHistoryId": billingHistoryID}
+	record := models.BillingHistory{
+		Type:      models.BillingTypeCreditNote,
+		TotalCost: -req.Amount,
+	}
+	if billingHistoryID != nil {
+		charged, err := s.billingRecord(ctx, req.OrgID, *billingHistoryID)
+		if err != nil {
+			return nil, err
+		}
+		charge = charged.TotalCost
+		record.PeriodStart = charged.PeriodStart
+		record.PeriodEnd = charged.PeriodEnd
+	} else {
+		var invoice models.Invoice
+		err := s.db.Collection("invoices").FindOne(ctx, bson.M{"_id": *invoiceID, "orgId": req.OrgID}).Decode(&invoice)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find invoice: %w", err)
+		}
+		charge = invoice.Total
+		note.InvoiceNumber = invoice.Number
+		target = bson.M{"invoiceId": invoiceID}
+		record.InvoiceID = invoiceID
+		record.InvoiceNumber = invoice.Number
+		record.PeriodStart = invoice.PeriodStart
+		record.PeriodEnd = invoice.PeriodEnd
+	}
+
+	err = database.Transact(ctx, s.db, s.logger, func(ctx context.Context) error {
+		credited, err := s.credited(ctx, target)
+		if err != nil {
+			return err
+		}
+		if credited+req.Amount > charge+centTolerance {
+			return fmt.Errorf("%w: %.2f charged, %.2f already credited", ErrExceedsCharge, charge, credited)
+		}
+
+		// Numbering and the credit commit together so numbers have no gaps
+		sequence, err := database.NextSequence(ctx, s.db, "credit_note")
+		if err != nil {
+			return err
+		}
+		note.ID = primitive.NewObjectID()
+		note.Sequence = sequence
+		note.Number = fmt.Sprintf("CN-%06d", sequence)
+		note.IssuedAt = time.Now()
+		if _, err := s.db.Collection("credit_notes").InsertOne(ctx, note); err != nil {
+			return fmt.Errorf("failed to store credit note: %w", err)
+		}
+
+		record.Reference = note.Number
+		return s.post(ctx, req, models.LedgerAdjustment, "credit-note:"+note.Number, &record)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.finish(ctx, org, record, req)
+	return &note, nil
+}
+
+// RefundTopUp refunds a Stripe top-up, in full when req.Amount is zero, and
+// debits the refund from the wallet
+func (s *Service) RefundTopUp(ctx context.Context, topUpID primitive.ObjectID, req Request) (*models.BillingHistory, error) {
+	if s.stripe == nil {
+		return nil, ErrRefundsUnavailable
+	}
+	if err := Validate(req); err != nil {
+		return nil, err
+	}
+
+	var topUp models.TopUpTransaction
+	if err := s.db.Collection("top_up_transactions").FindOne(ctx, bson.M{"_id": topUpID}).Decode(&topUp); err != nil {
+		return nil, fmt.Errorf("failed to find top-up: %w", err)
+	}
+	if topUp.Status != "succeeded" || topUp.StripePaymentIntentID == "" {
+		return nil, fmt.Errorf("%w: only succeeded Stripe top-ups can be refunded", ErrNotRefundable)
+	}
+	refundable := topUp.Amount - topUp.RefundedAmount
+	if req.Amount == 0 {
+		req.Amount = refundable
+	}
+	if req.Amount <= 0 || req.Amount > refundable+centTolerance {
+		return nil, fmt.Errorf("%w: %.2f left to refund", ErrNotRefundable, math.Max(refundable, 0))
+	}
+	req.OrgID = topUp.OrganizationID
+	org, err := s.organization(ctx, req.OrgID)
+	if err != nil {
+		return nil, err
+	}
+	// Granted credit is in the wallet balance but was never paid for
+	granted, err := s.grantedCredit(ctx, req.OrgID)
+	if err != nil {
+		return nil, err
+	}
+	if req.Amount > org.WalletBalance-granted+centTolerance {
+		return nil, fmt.Errorf("%w: balance is %.2f, of which %.2f is granted credit",
+			ErrInsufficientBalance, org.WalletBalance, granted)
+	}
+
+	// Reserve the amount on the top-up so concurrent refunds cannot together
+	// exceed it
+	refundedBefore, err := s.reserveRefund(ctx, topUpID, req.Amount)
+	if err != nil {
+		return nil, err
+	}
+
+	// A retry of the same refund gets the same Stripe refund back
+	idempotencyKey := fmt.Sprintf("topup-refund:%s:%.2f:%.2f", topUpID.Hex(), refundedBefore, req.Amount)
+	refundID, err := s.stripe.RefundPayment(ctx, topUp.StripePaymentIntentID, req.Amount, idempotencyKey, map[string]string{
+		"organizationId": req.OrgID,
+		"topUpId":        topUpID.Hex(),
+		"reasonCode":     req.ReasonCode,
+	})
+	if err != nil {
+		s.releaseRefund(ctx, topUpID, req.Amount)
+		return nil, err
+	}
+
+	record := models.BillingHistory{
+		Type:      models.BillingTypeRefund,
+		TotalCost: req.Amount,
+		Reference: refundID,
+	}
+	err = database.Transact(ctx, s.db, s.logger, func(ctx context.Context) error {
+		refund := req
+		refund.Amount = -req.Amount
+		return s.post(ctx, refund, models.LedgerRefund, "refund:"+refundID, &record)
+	})
+	if err != nil {
+		// The money has left Stripe. Releasing the reservation restores the
+		// idempotency key, so retrying reuses the same refund.
+		s.releaseRefund(ctx, topUpID, req.Amount)
+		s.logger.Error("Stripe refund issued but not recorded",
+			zap.String("orgId", req.OrgID),
+			zap.String("refundId", refundID),
+			zap.Error(err))
+		return nil, err
+	}
+	s.finish(ctx, org, record, req)
+	return &record, nil
+}
+
+// reserveRefund adds amount to the top-up's refunded amount if that keeps it
+// within the top-up, and returns the refunded amount before
+func (s *Service) reserveRefund(ctx context.Context, topUpID primitive.ObjectID, amount float64) (float64, error) {
+	var before models.TopUpTransaction
+	err := s.db.Collection("top_up_transactions").FindOneAndUpdate(ctx,
+		bson.M{
+			"_id":    topUpID,
+			"status": "succeeded",
+			"$expr": bson.M{"$lte": bson.A{
+				bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$refundedAmount", 0}}, amount}},
+				bson.M{"$add": bson.A{"$amount", centTolerance}},
+			}},
+		},
+		bson.M{"$inc": bson.M{"refundedAmount": amount}},
+		options.FindOneAndUpdate().SetReturnDocument(options.Before),
+	).Decode(&before)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, fmt.Errorf("%w: the amount left to refund changed", ErrNotRefundable)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to reserve refund on top-up: %w", err)
+	}
+	return before.RefundedAmount, nil
+}
+
+// releaseRefund undoes reserveRefund for a refund that was not made
+func (s *Service) releaseRefund(ctx context.Context, topUpID primitive.ObjectID, amount float64) {
+	_, err := s.db.Collection("top_up_transactions").UpdateOne(ctx,
+		bson.M{"_id": topUpID},
+		bson.M{"$inc": bson.M{"refundedAmount": -amount}},
+	)
+	if err != nil {
+		s.logger.Error("Failed to release refund reservation",
+			zap.String("topUpId", topUpID.Hex()),
+			zap.Float64("amount", amount),
+			zap.Error(err))
+	}
+}
+
+// grantedCredit sums what is left of an organization's active credit grants
+func (s *Service) grantedCredit(ctx context.Context, orgID string) (float64, error) {
+	cursor, err := s.db.Collection("credit_grants").Aggregate(ctx, []bson.M{
+		{"$match": bson.M{"orgId": orgID, "status": models.CreditGrantActive}},
+		{"$group": bson.M{"_id": nil, "remaining": bson.M{"$sum": "$remaining"}}},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to sum credit grants: %w", err)
+	}
+	var results []struct {
+		Remaining float64 `bson:"remaining"`
+	}
+	if err := cursor.All(ctx, &results); err != nil {
+		return 0, fmt.Errorf("failed to decode credit grants: %w", err)
+	}
+	if len(results) == 0 {
+		return 0, nil
+	}
+	return results[0].Remaining, nil
+}
+
+// ListCreditNotes returns an organization's credit notes, newest first
+func (s *Service) ListCreditNotes(ctx context.Context, orgID string) ([]models.CreditNote, error) {
+	cursor, err := s.db.Collection("credit_notes").Find(ctx, bson.M{"orgId": orgID},
+		options.Find().SetSort(bson.D{{Key: "issuedAt", Value: -1}}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find credit notes: %w", err)
+	}
+	notes := []models.CreditNote{}
+	if err := cursor.All(ctx, &notes); err != nil {
+		return nil, fmt.Errorf("failed to decode credit notes: %w", err)
+	}
+	return notes, nil
+}
+
+// post applies req.Amount to the wallet through the ledger and records it in
+// billing history. An empty reference uses the billing history entry's ID, and
+// a record without the period of a target charge or invoice is dated now.
+func (s *Service) post(ctx context.Context, req Request, entryType, reference string, record *models.BillingHistory) error {
+	now := time.Now()
+	record.ID = primitive.NewObjectID()
+	if reference == "" {
+		reference = "adjustment:" + record.ID.Hex()
+	}
+	description := fmt.Sprintf("%s (%s)", strings.ReplaceAll(record.Type, "_", " "), req.ReasonCode)
+
+	entry, applied, err := s.ledger.Post(ctx, models.LedgerEntry{
+		OrgID:       req.OrgID,
+		Type:        entryType,
+		Amount:      req.Amount,
+		Reference:   reference,
+		Description: description,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update wallet balance: %w", err)
+	}
+	if !applied {
+		return fmt.Errorf("%w: %s", ledger.ErrDuplicateReference, reference)
+	}
+
+	record.OrganizationID = req.OrgID
+	record.BillingDate = now
+	if record.PeriodStart.IsZero() {
+		record.PeriodStart = now
+		record.PeriodEnd = now
+	}
+	record.WalletBalanceAfter = entry.BalanceAfter
+	record.WalletBalanceBefore = entry.BalanceAfter - req.Amount
+	record.Status = "completed"
+	record.ReasonCode = req.ReasonCode
+	record.Note = req.Note
+	record.CreatedBy = req.CreatedBy
+	record.CreatedAt = now
+	if _, err := s.db.Collection("billing_history").InsertOne(ctx, record); err != nil {
+		return fmt.Errorf("failed to insert billing record: %w", err)
+	}
+	return nil
+}
+
+// finish logs and emails a committed wallet change and lets dunning react to it
+func (s *Service) finish(ctx context.Context, org models.Organization, record models.BillingHistory, req Request) {
+	s.logger.Info("Wallet adjusted",
+		zap.String("orgId", org.OrgID),
+		zap.String("type", record.Type),
+		zap.Float64("amount", -record.TotalCost),
+		zap.String("reasonCode", req.ReasonCode),
+		zap.String("createdBy", req.CreatedBy))
+
+	if s.emailService != nil && org.BillingEmail != "" {
+		amount := -record.TotalCost
+		if record.Type == models.BillingTypeRefund {
+			amount = record.TotalCost
+		}
+		err := s.emailService.SendWalletAdjustment(org.BillingEmail, org.Name, email.WalletAdjustment{
+			Kind:       record.Type,
+			Reference:  record.Reference,
+			Amount:     amount,
+			ReasonCode: req.ReasonCode,
+			Note:       req.Note,
+			Balance:    record.WalletBalanceAfter,
+		})
+		if err != nil {
+			s.logger.Warn("Failed to send wallet adjustment email", zap.String("orgId", org.OrgID), zap.Error(err))
+		}
+	}
+
+	if s.dunning != nil {
+		if err := s.dunning.Evaluate(ctx, org.OrgID); err != nil {
+			s.logger.Warn("Failed to update dunning after adjustment", zap.String("orgId", org.OrgID), zap.Error(err))
+		}
+	}
+}
+
+// credited sums the credit notes issued against a charge
+func (s *Service) credited(ctx context.Context, target bson.M) (float64, error) {
+	cursor, err := s.db.Collection("credit_notes").Aggregate(ctx, []bson.M{
+		{"$match": target},
+		{"$group": bson.M{"_id": nil, "amount": bson.M{"$sum": "$amount"}}},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to sum credit notes: %w", err)
+	}
+	var results []struct {
+		Amount float64 `bson:"amount"`
+	}
+	if err := cursor.All(ctx, &results); err != nil {
+		return 0, fmt.Errorf("failed to decode credit notes: %w", err)
+	}
+	if len(results) == 0 {
+		return 0, nil
+	}
+	return results[0].Amount, nil
+}
+
+func (s *Service) organization(ctx context.Context, orgID string) (models.Organization, error) {
+	var org models.Organization
+	if err := s.db.Collection("organizations").FindOne(ctx, bson.M{"orgId": orgID}).Decode(&org); err != nil {
+		return org, fmt.Errorf("failed to find organization: %w", err)
+	}
+	return org, nil
+}
+
+func (s *Service) billingRecord(ctx context.Context, orgID string, id primitive.ObjectID) (models.BillingHistory, error) {
+	var record models.BillingHistory
+	err := s.db.Collection("billing_history").FindOne(ctx, bson.M{"_id": id, "organizationId": orgID}).Decode(&record)
+	if err != nil {
+		return record, fmt.Errorf("failed to find billing history entry: %w", err)
+	}
+	return record, nil
+}
@@ -0,0 +1,218 @@
+package adjustment
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"freedom-ai/management-server/internal/database/databasetest"
+	"freedom-ai/management-server/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+func newTestService(t *testing.T, db *mongo.Database) *Service {
+	t.Helper()
+	ctx := context.Background()
+	s := NewService(db, zap.NewNop())
+	if err := s.EnsureIndexes(ctx); err != nil {
+		t.Fatalf("EnsureIndexes: %v", err)
+	}
+	if err := s.ledger.EnsureIndexes(ctx); err != nil {
+		t.Fatalf("ledger EnsureIndexes: %v", err)
+	}
+	if _, err := db.Collection("organizations").InsertOne(ctx, bson.M{"orgId": "org-1", "walletBalance": 0.0}); err != nil {
+		t.Fatalf("insert organization: %v", err)
+	}
+	return s
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     Request
+		wantErr bool
+	}{
+		{"valid", Request{ReasonCode: models.ReasonGoodwill, Note: "Outage on May 3"}, false},
+		{"missing reason code", Request{Note: "Outage on May 3"}, true},
+		{"unknown reason code", Request{ReasonCode: "because", Note: "Outage on May 3"}, true},
+		{"reason code in the wrong case", Request{ReasonCode: "Goodwill", Note: "Outage on May 3"}, true},
+		{"missing note", Request{ReasonCode: models.ReasonGoodwill}, true},
+		{"blank note", Request{ReasonCode: models.ReasonGoodwill, Note: "  \n"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Validate(tt.req); (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+	for _, code := range models.ReasonCodes {
+		if err := Validate(Request{ReasonCode: code, Note: "x"}); err != nil {
+			t.Fatalf("Validate(%s) = %v", code, err)
+		}
+	}
+}
+
+func TestIssueCreditNoteIsCappedByTheCharge(t *testing.T) {
+	ctx := context.Background()
+	db := databasetest.Connect(t)
+	s := newTestService(t, db)
+
+	day := time.Date(2024, 5, 3, 0, 0, 0, 0, time.UTC)
+	charge := models.BillingHistory{
+		ID:             primitive.NewObjectID(),
+		OrganizationID: "org-1",
+		PeriodStart:    day,
+		PeriodEnd:      day.AddDate(0, 0, 1),
+		TotalCost:      10,
+		Status:         "completed",
+	}
+	if _, err := db.Collection("billing_history").InsertOne(ctx, charge); err != nil {
+		t.Fatalf("insert billing history: %v", err)
+	}
+	req := func(amount float64) Request {
+		return Request{OrgID: "org-1", Amount: amount, ReasonCode: models.ReasonServiceIssue, Note: "Outage", CreatedBy: "dev-1"}
+	}
+
+	first, err := s.IssueCreditNote(ctx, req(6), &charge.ID, nil)
+	if err != nil {
+		t.Fatalf("IssueCreditNote: %v", err)
+	}
+	if _, err := s.IssueCreditNote(ctx, req(4.5), &charge.ID, nil); !errors.Is(err, ErrExceedsCharge) {
+		t.Fatalf("IssueCreditNote beyond the charge = %v, want ErrExceedsCharge", err)
+	}
+	second, err := s.IssueCreditNote(ctx, req(4), &charge.ID, nil)
+	if err != nil {
+		t.Fatalf("IssueCreditNote up to the charge: %v", err)
+	}
+	if second.Sequence != first.Sequence+1 {
+		t.Fatalf("credit note numbers %s, %s, want consecutive numbers", first.Number, second.Number)
+	}
+
+	var record models.BillingHistory
+	if err := db.Collection("billing_history").FindOne(ctx, bson.M{"reference": first.Number}).Decode(&record); err != nil {
+		t.Fatalf("load credit note record: %v", err)
+	}
+	if record.Type != models.BillingTypeCreditNote || record.TotalCost != -6 ||
+		!record.PeriodStart.Equal(charge.PeriodStart) || !record.PeriodEnd.Equal(charge.PeriodEnd) {
+		t.Fatalf("record = %+v, want a credit note of 6 for the charged day", record)
+	}
+
+	// Against an invoice the record carries the invoice and its cycle
+	invoice := models.Invoice{
+		ID:          primitive.NewObjectID(),
+		Number:      "INV-000007",
+		OrgID:       "org-1",
+		PeriodStart: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+		PeriodEnd:   time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC),
+		Total:       3,
+	}
+	if _, err := db.Collection("invoices").InsertOne(ctx, invoice); err != nil {
+		t.Fatalf("insert invoice: %v", err)
+	}
+	note, err := s.IssueCreditNote(ctx, req(3), nil, &invoice.ID)
+	if err != nil {
+		t.Fatalf("IssueCreditNote against an invoice: %v", err)
+	}
+	if _, err := s.IssueCreditNote(ctx, req(0.01), nil, &invoice.ID); !errors.Is(err, ErrExceedsCharge) {
+		t.Fatalf("IssueCreditNote beyond the invoice = %v, want ErrExceedsCharge", err)
+	}
+	if err := db.Collection("billing_history").FindOne(ctx, bson.M{"reference": note.Number}).Decode(&record); err != nil {
+		t.Fatalf("load credit note record: %v", err)
+	}
+	if record.InvoiceID == nil || *record.InvoiceID != invoice.ID || record.InvoiceNumber != invoice.Number ||
+		!record.PeriodStart.Equal(invoice.PeriodStart) || !record.PeriodEnd.Equal(invoice.PeriodEnd) {
+		t.Fatalf("record = %+v, want it against %s and its cycle", record, invoice.Number)
+	}
+
+	var org models.Organization
+	if err := db.Collection("organizations").FindOne(ctx, bson.M{"orgId": "org-1"}).Decode(&org); err != nil {
+		t.Fatalf("load organization: %v", err)
+	}
+	if org.WalletBalance != 13 {
+		t.Fatalf("walletBalance = %v, want 13", org.WalletBalance)
+	}
+
+	if _, err := s.IssueCreditNote(ctx, req(1), &charge.ID, &invoice.ID); err == nil {
+		t.Fatal("IssueCreditNote against a charge and an invoice succeeded, want an error")
+	}
+}
+
+func TestReserveRefundUnderConcurrentRefunds(t *testing.T) {
+	ctx := context.Background()
+	db := databasetest.Connect(t)
+	s := newTestService(t, db)
+
+	topUp := models.TopUpTransaction{
+		ID:                    primitive.NewObjectID(),
+		OrganizationID:        "org-1",
+		Amount:                10,
+		StripePaymentIntentID: "pi_1",
+		Status:                "succeeded",
+	}
+	if _, err := db.Collection("top_up_transactions").InsertOne(ctx, topUp); err != nil {
+		t.Fatalf("insert top-up: %v", err)
+	}
+	refunded := func() float64 {
+		t.Helper()
+		var stored models.TopUpTransaction
+		if err := db.Collection("top_up_transactions").FindOne(ctx, bson.M{"_id": topUp.ID}).Decode(&stored); err != nil {
+			t.Fatalf("load top-up: %v", err)
+		}
+		return stored.RefundedAmount
+	}
+
+	// Only three refunds of 3 fit into the top-up of 10
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	reserved, rejected := 0, 0
+	befores := make(map[float64]bool)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			before, err := s.reserveRefund(ctx, topUp.ID, 3)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				reserved++
+				befores[before] = true
+			case errors.Is(err, ErrNotRefundable):
+				rejected++
+			default:
+				t.Errorf("reserveRefund: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if reserved != 3 || rejected != 5 {
+		t.Fatalf("reserved %d, rejected %d refunds of 3, want 3 and 5", reserved, rejected)
+	}
+	// Each reservation saw a different refunded amount, so each gets its own
+	// idempotency key
+	if !befores[0] || !befores[3] || !befores[6] {
+		t.Fatalf("refunded amounts before the reservations = %v, want 0, 3 and 6", befores)
+	}
+	if amount := refunded(); amount != 9 {
+		t.Fatalf("refundedAmount = %v, want 9", amount)
+	}
+
+	// A refund that failed at Stripe frees its reservation
+	s.releaseRefund(ctx, topUp.ID, 3)
+	if amount := refunded(); amount != 6 {
+		t.Fatalf("refundedAmount after release = %v, want 6", amount)
+	}
+	if _, err := s.reserveRefund(ctx, topUp.ID, 4); err != nil {
+		t.Fatalf("reserveRefund of the rest: %v", err)
+	}
+	if _, err := s.reserveRefund(ctx, topUp.ID, 0.01); !errors.Is(err, ErrNotRefundable) {
+		t.Fatalf("reserveRefund of a fully refunded top-up = %v, want ErrNotRefundable", err)
+	}
+}
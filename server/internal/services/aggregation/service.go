@@ -8,7 +8,6 @@ import (
 	"freedom-ai/management-server/internal/models"
 
 	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.uber.org/zap"
@@ -26,24 +25,70 @@ func NewService(db *mongo.Database, logger *zap.Logger) *Service {
 	}
 }
 
-// AggregateDailyConsumption aggregates consumption for yesterday
+// AggregateDailyConsumption aggregates each organization's previous local day
+// once it has closed. It runs hourly so every timezone is covered soon after
+// its midnight.
 func (s *Service) AggregateDailyConsumption(ctx context.Context) error {
-	now := time.Now().UTC()
-	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
-	yesterday := today.AddDate(0, 0, -1)
+	orgs, err := s.organizations(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, org := range orgs {
+		calendar := org.Calendar()
+		yesterday := calendar.DayStart(calendar.DayStart(now).Add(-time.Nanosecond))
+		count, err := s.db.Collection("daily_consumption").CountDocuments(ctx,
+			bson.M{"organizationId": org.OrgID, "date": yesterday}, options.Count().SetLimit(1))
+		if err != nil {
+			return fmt.Errorf("failed to check daily consumption: %w", err)
+		}
+		if count > 0 {
+			continue
+		}
+		if err := s.AggregateDay(ctx, yesterday, org.OrgID); err != nil {
+			s.logger.Warn("Failed to aggregate daily consumption", zap.String("orgId", org.OrgID), zap.Error(err))
+		}
+	}
+	return nil
+}
+
+// organizations returns every organization with its billing calendar fields
+func (s *Service) organizations(ctx context.Context) ([]models.Organization, error) {
+	cursor, err := s.db.Collection("organizations").Find(ctx, bson.M{},
+		options.Find().SetProjection(bson.M{"orgId": 1, "timezone": 1, "billingAnchorDay": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find organizations: %w", err)
+	}
+	var orgs []models.Organization
+	if err := cursor.All(ctx, &orgs); err != nil {
+		return nil, fmt.Errorf("failed to decode organizations: %w", err)
+	}
+	return orgs, nil
+}
+
+// AggregateDay (re)builds the daily aggregates for the day starting at
+// yesterday, for one organization or, with an empty orgID, all of them. The
+// day ends at the next midnight in yesterday's location.
+func (s *Service) AggregateDay(ctx context.Context, yesterday time.Time, orgID string) error {
+	today := yesterday.AddDate(0, 0, 1)
 
 	s.logger.Info("Aggregating daily consumption", zap.Time("date", yesterday))
 
 	// Get all organizations with consumption yesterday
+	match := bson.M{
+		"timestamp": bson.M{
+			"$gte": yesterday,
+			"$lt":  today,
+		},
+		"status": "complete",
+	}
+	if orgID != "" {
+		match["organizationId"] = orgID
+	}
 	pipeline := []bson.M{
 		{
-			"$match": bson.M{
-				"timestamp": bson.M{
-					"$gte": yesterday,
-					"$lt":  today,
-				},
-				"status": "complete",
-			},
+			"$match": match,
 		},
 		{
 			"$group": bson.M{
@@ -125,8 +170,8 @@ func (s *Service) AggregateDailyConsumption(ctx context.Context) error {
 			breakdown.ByUser[item.User] = existing
 		}
 
+		// No ID: $set on an existing aggregate must not change its _id
 		dailyRecord := models.DailyConsumption{
-			ID:             primitive.NewObjectID(),
 			OrganizationID: result.OrgID,
 			Date:           yesterday,
 			TotalTokens:    result.TotalTokens,
@@ -154,26 +199,57 @@ func (s *Service) AggregateDailyConsumption(ctx context.Context) error {
 	return nil
 }
 
-// AggregateMonthlyConsumption aggregates consumption for previous month
+// AggregateMonthlyConsumption aggregates each organization's previous billing
+// cycle once it has closed
 func (s *Service) AggregateMonthlyConsumption(ctx context.Context) error {
-	now := time.Now().UTC()
-	firstOfThisMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
-	firstOfLastMonth := firstOfThisMonth.AddDate(0, -1, 0)
-	lastMonthEnd := firstOfThisMonth.AddDate(0, 0, -1).Add(23*time.Hour + 59*time.Minute + 59*time.Second)
+	orgs, err := s.organizations(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, org := range orgs {
+		calendar := org.Calendar()
+		lastCycle := calendar.CycleStart(calendar.CycleStart(now).Add(-time.Nanosecond))
+		count, err := s.db.Collection("monthly_consumption").CountDocuments(ctx,
+			bson.M{"organizationId": org.OrgID, "month": lastCycle.Format("2006-01")}, options.Count().SetLimit(1))
+		if err != nil {
+			return fmt.Errorf("failed to check monthly consumption: %w", err)
+		}
+		if count > 0 {
+			continue
+		}
+		if err := s.AggregateMonth(ctx, lastCycle, org.OrgID); err != nil {
+			s.logger.Warn("Failed to aggregate monthly consumption", zap.String("orgId", org.OrgID), zap.Error(err))
+		}
+	}
+	return nil
+}
+
+// AggregateMonth (re)builds the monthly aggregates for the billing cycle
+// starting at firstOfLastMonth and ending a month later, for one organization
+// or, with an empty orgID, all of them. The aggregate is labelled with the
+// month the cycle starts in.
+func (s *Service) AggregateMonth(ctx context.Context, firstOfLastMonth time.Time, orgID string) error {
+	firstOfThisMonth := firstOfLastMonth.AddDate(0, 1, 0)
 
 	monthStr := firstOfLastMonth.Format("2006-01")
 	s.logger.Info("Aggregating monthly consumption", zap.String("month", monthStr))
 
 	// Get all organizations with consumption in previous month
+	match := bson.M{
+		"timestamp": bson.M{
+			"$gte": firstOfLastMonth,
+			"$lt":  firstOfThisMonth,
+		},
+		"status": "complete",
+	}
+	if orgID != "" {
+		match["organizationId"] = orgID
+	}
 	pipeline := []bson.M{
 		{
-			"$match": bson.M{
-				"timestamp": bson.M{
-					"$gte": firstOfLastMonth,
-					"$lte": lastMonthEnd,
-				},
-				"status": "complete",
-			},
+			"$match": match,
 		},
 		{
 			"$group": bson.M{
@@ -281,9 +357,10 @@ func (s *Service) AggregateMonthlyConsumption(ctx context.Context) error {
 		}
 
 		monthlyRecord := models.MonthlyConsumption{
-			ID:             primitive.NewObjectID(),
 			OrganizationID: result.OrgID,
 			Month:          monthStr,
+			PeriodStart:    firstOfLastMonth,
+			PeriodEnd:      firstOfThisMonth,
 			TotalTokens:    result.TotalTokens,
 			TotalCost:      result.TotalCost,
 			Breakdown:      breakdown,
@@ -309,4 +386,3 @@ func (s *Service) AggregateMonthlyConsumption(ctx context.Context) error {
 	s.logger.Info("Monthly aggregation completed", zap.Int("organizations", len(results)))
 	return nil
 }
-
@@ -7,7 +7,9 @@ import (
 
 	"freedom-ai/management-server/internal/config"
 	"freedom-ai/management-server/internal/models"
+	"freedom-ai/management-server/internal/services/dunning"
 	"freedom-ai/management-server/internal/services/email"
+	"freedom-ai/management-server/internal/services/ledger"
 
 	"github.com/stripe/stripe-go/v78"
 	"github.com/stripe/stripe-go/v78/paymentintent"
@@ -22,6 +24,8 @@ type Service struct {
 	db           *mongo.Database
 	logger       *zap.Logger
 	emailService *email.Service
+	ledger       *ledger.Service
+	dunning      *dunning.Service
 }
 
 func NewService(cfg *config.Config, db *mongo.Database, logger *zap.Logger) *Service {
@@ -29,6 +33,7 @@ func NewService(cfg *config.Config, db *mongo.Database, logger *zap.Logger) *Ser
 		config: cfg,
 		db:     db,
 		logger: logger,
+		ledger: ledger.NewService(db, logger),
 	}
 }
 
@@ -36,6 +41,11 @@ func (s *Service) SetEmailService(emailService *email.Service) {
 	s.emailService = emailService
 }
 
+// SetDunningService sets the dunning service that reactivates organizations after top-ups
+func (s *Service) SetDunningService(dunningService *dunning.Service) {
+	s.dunning = dunningService
+}
+
 // CheckAndProcessAutoTopUp checks organizations with auto-top-up enabled and processes if needed
 func (s *Service) CheckAndProcessAutoTopUp(ctx context.Context) error {
 	collection := s.db.Collection("organizations")
@@ -73,10 +83,10 @@ func (s *Service) processAutoTopUp(ctx context.Context, org models.Organization)
 
 	// Create payment intent
 	params := &stripe.PaymentIntentParams{
-		Amount:   stripe.Int64(int64(org.AutoTopUp.Amount * 100)),
-		Currency: stripe.String(string(stripe.CurrencyUSD)),
+		Amount:        stripe.Int64(int64(org.AutoTopUp.Amount * 100)),
+		Currency:      stripe.String(string(stripe.CurrencyUSD)),
 		PaymentMethod: stripe.String(org.AutoTopUp.PaymentMethodID),
-		Confirm:  stripe.Bool(true),
+		Confirm:       stripe.Bool(true),
 		Metadata: map[string]string{
 			"organizationId": org.OrgID,
 			"type":           "auto_topup",
@@ -89,16 +99,15 @@ func (s *Service) processAutoTopUp(ctx context.Context, org models.Organization)
 	}
 
 	if pi.Status == stripe.PaymentIntentStatusSucceeded {
-		// Update wallet balance
-		collection := s.db.Collection("organizations")
-		_, err = collection.UpdateOne(
-			ctx,
-			bson.M{"orgId": org.OrgID},
-			bson.M{
-				"$inc": bson.M{"walletBalance": org.AutoTopUp.Amount},
-				"$set": bson.M{"updatedAt": time.Now()},
-			},
-		)
+		// Credit the wallet; the webhook for the same payment intent posts with
+		// the same reference and is not applied twice
+		_, _, err = s.ledger.Post(ctx, models.LedgerEntry{
+			OrgID:       org.OrgID,
+			Type:        models.LedgerTopUpCredit,
+			Amount:      org.AutoTopUp.Amount,
+			Reference:   "stripe:" + pi.ID,
+			Description: "Auto top-up",
+		})
 		if err != nil {
 			return fmt.Errorf("failed to update wallet: %w", err)
 		}
@@ -106,12 +115,12 @@ func (s *Service) processAutoTopUp(ctx context.Context, org models.Organization)
 		// Create top-up transaction record
 		topUpCollection := s.db.Collection("top_up_transactions")
 		topUp := models.TopUpTransaction{
-			ID:                  primitive.NewObjectID(),
-			OrganizationID:      org.OrgID,
-			Amount:              org.AutoTopUp.Amount,
+			ID:                    primitive.NewObjectID(),
+			OrganizationID:        org.OrgID,
+			Amount:                org.AutoTopUp.Amount,
 			StripePaymentIntentID: pi.ID,
-			Status:              "succeeded",
-			CreatedAt:           time.Now(),
+			Status:                "succeeded",
+			CreatedAt:             time.Now(),
 		}
 		completedAt := time.Now()
 		topUp.CompletedAt = &completedAt
@@ -125,6 +134,12 @@ func (s *Service) processAutoTopUp(ctx context.Context, org models.Organization)
 			zap.String("orgId", org.OrgID),
 			zap.Float64("amount", org.AutoTopUp.Amount))
 
+		if s.dunning != nil {
+			if err := s.dunning.Evaluate(ctx, org.OrgID); err != nil {
+				s.logger.Warn("Failed to update dunning after auto-top-up", zap.String("orgId", org.OrgID), zap.Error(err))
+			}
+		}
+
 		// Send notification email
 		if s.emailService != nil && org.BillingEmail != "" {
 			if err := s.emailService.SendAutoTopUpNotification(org.BillingEmail, org.Name, org.AutoTopUp.Amount); err != nil {
@@ -137,4 +152,3 @@ func (s *Service) processAutoTopUp(ctx context.Context, org models.Organization)
 
 	return nil
 }
-
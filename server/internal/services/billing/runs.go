@@ -0,0 +1,525 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"freedom-ai/management-server/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+const (
+	// maxCatchUpDays bounds how far back daily billing catches up after downtime
+	maxCatchUpDays = 31
+	// staleRunAfter is how long a run may stay running before another attempt takes it over
+	staleRunAfter = time.Hour
+
+	billingTriggerSchedule = "schedule"
+	billingTriggerAdmin    = "admin"
+)
+
+var (
+	// ErrAlreadyBilled is returned when the organization was already billed for the period
+	ErrAlreadyBilled = errors.New("period already billed")
+	// ErrRunInProgress is returned when another billing run for the period is in progress
+	ErrRunInProgress = errors.New("billing run in progress")
+	// ErrPeriodOpen is returned for a day that has not closed in the organization's timezone
+	ErrPeriodOpen = errors.New("period has not closed yet")
+	// ErrNothingToBill is returned when the organization has no billable consumption in the period
+	ErrNothingToBill = errors.New("no billable consumption in period")
+)
+
+// EnsureIndexes creates the billing_runs indexes
+func (s *Service) EnsureIndexes(ctx context.Context) error {
+	_, err := s.db.Collection("billing_runs").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "orgId", Value: 1}, {Key: "periodStart", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "periodStart", Value: -1}}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create billing_runs indexes: %w", err)
+	}
+	return nil
+}
+
+// lastPeriodEnds returns the end of each organization's latest completed
+// billing run, falling back to billing history written before billing runs
+// existed. Failed runs are retried because billing resumes from here.
+func (s *Service) lastPeriodEnds(ctx context.Context) (map[string]time.Time, error) {
+	lastEnds := make(map[string]time.Time)
+	sources := []struct {
+		collection string
+		orgField   string
+		match      bson.M
+	}{
+		{"billing_history", "$organizationId", bson.M{"type": bson.M{"$exists": false}}},
+		{"billing_runs", "$orgId", bson.M{"status": models.BillingRunCompleted}},
+	}
+	for _, source := range sources {
+		cursor, err := s.db.Collection(source.collection).Aggregate(ctx, []bson.M{
+			{"$match": source.match},
+			{"$group": bson.M{"_id": source.orgField, "periodEnd": bson.M{"$max": "$periodEnd"}}},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to find last billed periods: %w", err)
+		}
+		var results []struct {
+			OrgID     string    `bson:"_id"`
+			PeriodEnd time.Time `bson:"periodEnd"`
+		}
+		if err := cursor.All(ctx, &results); err != nil {
+			return nil, fmt.Errorf("failed to decode last billed periods: %w", err)
+		}
+		for _, result := range results {
+			if result.PeriodEnd.After(lastEnds[result.OrgID]) {
+				lastEnds[result.OrgID] = result.PeriodEnd
+			}
+		}
+	}
+	return lastEnds, nil
+}
+
+// billOrganization claims the organization's run for the period and charges it
+func (s *Service) billOrganization(ctx context.Context, result BillingAggregateResult, periodStart, periodEnd time.Time, trigger string) (*models.BillingRun, error) {
+	run, err := s.claimRun(ctx, result.OrgID, periodStart, periodEnd, trigger)
+	if err != nil {
+		return nil, err
+	}
+	return s.charge(ctx, result, run)
+}
+
+// charge bills a claimed run and returns it completed
+func (s *Service) charge(ctx context.Context, result BillingAggregateResult, run *models.BillingRun) (*models.BillingRun, error) {
+	if err := s.processOrganizationBilling(ctx, result, run); err != nil {
+		s.failRun(ctx, run.ID, err)
+		return nil, err
+	}
+	return s.findRun(ctx, run.OrgID, run.PeriodStart)
+}
+
+// chargeReference is the ledger reference of a run's charge. Each revision
+// charges under its own reference, so a retry never charges a revision twice.
+func chargeReference(runID primitive.ObjectID, revision int) string {
+	if revision == 0 {
+		return "billing:" + runID.Hex()
+	}
+	return fmt.Sprintf("billing:%s:%d", runID.Hex(), revision)
+}
+
+// claimRun starts a run for the organization and period. A failed run, or one
+// left running longer than staleRunAfter, is taken over.
+func (s *Service) claimRun(ctx context.Context, orgID string, periodStart, periodEnd time.Time, trigger string) (*models.BillingRun, error) {
+	collection := s.db.Collection("billing_runs")
+	now := time.Now()
+
+	billed, err := s.legacyBilled(ctx, orgID, periodStart, periodEnd, trigger)
+	if err != nil {
+		return nil, err
+	}
+	if billed {
+		return nil, ErrAlreadyBilled
+	}
+
+	run := models.BillingRun{
+		ID:          primitive.NewObjectID(),
+		OrgID:       orgID,
+		PeriodStart: periodStart,
+		PeriodEnd:   periodEnd,
+		Status:      models.BillingRunRunning,
+		Trigger:     trigger,
+		Attempts:    1,
+		StartedAt:   now,
+		UpdatedAt:   now,
+	}
+	_, err = collection.InsertOne(ctx, run)
+	if err == nil {
+		return &run, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return nil, fmt.Errorf("failed to start billing run: %w", err)
+	}
+
+	var existing models.BillingRun
+	err = collection.FindOneAndUpdate(ctx,
+		bson.M{
+			"orgId":       orgID,
+			"periodStart": periodStart,
+			"$or": []bson.M{
+				{"status": models.BillingRunFailed},
+				{"status": models.BillingRunRunning, "updatedAt": bson.M{"$lt": now.Add(-staleRunAfter)}},
+			},
+		},
+		bson.M{
+			"$set": bson.M{
+				"status":    models.BillingRunRunning,
+				"trigger":   trigger,
+				"startedAt": now,
+				"updatedAt": now,
+			},
+			"$unset": bson.M{"error": ""},
+			"$inc":   bson.M{"attempts": 1},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&existing)
+	if err == nil {
+		return &existing, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("failed to resume billing run: %w", err)
+	}
+
+	current, err := s.findRun(ctx, orgID, periodStart)
+	if err != nil {
+		return nil, err
+	}
+	if current.Status == models.BillingRunCompleted {
+		return nil, ErrAlreadyBilled
+	}
+	return nil, ErrRunInProgress
+}
+
+// reopenRun claims a completed run for a forced re-run and advances its
+// revision. A period without a completed run is claimed as usual.
+func (s *Service) reopenRun(ctx context.Context, orgID string, periodStart, periodEnd time.Time, trigger string) (*models.BillingRun, error) {
+	// Gives days billed before billing runs existed a completed run to reopen
+	if _, err := s.legacyBilled(ctx, orgID, periodStart, periodEnd, trigger); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var run models.BillingRun
+	err := s.db.Collection("billing_runs").FindOneAndUpdate(ctx,
+		bson.M{"orgId": orgID, "periodStart": periodStart, "status": models.BillingRunCompleted},
+		bson.M{
+			"$set": bson.M{
+				"status":    models.BillingRunRunning,
+				"trigger":   trigger,
+				"startedAt": now,
+				"updatedAt": now,
+			},
+			"$unset": bson.M{"error": "", "completedAt": ""},
+			"$inc":   bson.M{"attempts": 1, "revision": 1},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&run)
+	if err == nil {
+		return &run, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("failed to reopen billing run: %w", err)
+	}
+	return s.claimRun(ctx, orgID, periodStart, periodEnd, trigger)
+}
+
+// chargedRecords returns the completed daily billing and re-rating records of
+// an organization's day, except the one charged under exclude
+func (s *Service) chargedRecords(ctx context.Context, orgID string, periodStart time.Time, exclude string) ([]models.BillingHistory, error) {
+	filter := bson.M{
+		"organizationId": orgID,
+		"periodStart":    periodStart,
+		"status":         "completed",
+		"$or": bson.A{
+			bson.M{"type": bson.M{"$exists": false}},
+			bson.M{"type": models.BillingTypeAdjustment, "reratingId": bson.M{"$exists": true}},
+		},
+	}
+	if exclude != "" {
+		filter["reference"] = bson.M{"$ne": exclude}
+	}
+	cursor, err := s.db.Collection("billing_history").Find(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load billing history: %w", err)
+	}
+	var records []models.BillingHistory
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, fmt.Errorf("failed to decode billing history: %w", err)
+	}
+	return records, nil
+}
+
+// reverseCharges credits back what earlier revisions of a run charged for its
+// day, including re-rating adjustments since, marks those records reversed and
+// restores the credit grants the earlier charges drew on. Repeating it for the
+// same revision changes nothing.
+func (s *Service) reverseCharges(ctx context.Context, run *models.BillingRun) error {
+	records, err := s.chargedRecords(ctx, run.OrgID, run.PeriodStart, chargeReference(run.ID, run.Revision))
+	if err != nil {
+		return err
+	}
+	charged := 0.0
+	ids := make([]primitive.ObjectID, 0, len(records))
+	for _, record := range records {
+		charged += record.TotalCost
+		ids = append(ids, record.ID)
+	}
+
+	if charged != 0 {
+		_, _, err := s.ledger.Post(ctx, models.LedgerEntry{
+			OrgID:       run.OrgID,
+			Type:        models.LedgerAdjustment,
+			Amount:      charged,
+			Reference:   fmt.Sprintf("billing-reversal:%s:%d", run.ID.Hex(), run.Revision),
+			Description: fmt.Sprintf("Reversal of usage %s", run.PeriodStart.Format("2006-01-02")),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to reverse previous charge: %w", err)
+		}
+	}
+	if len(ids) > 0 {
+		if _, err := s.db.Collection("billing_history").UpdateMany(ctx,
+			bson.M{"_id": bson.M{"$in": ids}},
+			bson.M{"$set": bson.M{"status": models.BillingStatusReversed}},
+		); err != nil {
+			return fmt.Errorf("failed to mark billing history reversed: %w", err)
+		}
+	}
+
+	for revision := 0; revision < run.Revision; revision++ {
+		if _, err := s.credits.Restore(ctx, run.OrgID, chargeReference(run.ID, revision)); err != nil {
+			return fmt.Errorf("failed to restore credit grants: %w", err)
+		}
+	}
+	return nil
+}
+
+// legacyBilled reports whether billing history written before billing runs
+// existed already covers the period, and records a completed run for it
+func (s *Service) legacyBilled(ctx context.Context, orgID string, periodStart, periodEnd time.Time, trigger string) (bool, error) {
+	var record models.BillingHistory
+	err := s.db.Collection("billing_history").FindOne(ctx, bson.M{
+		"organizationId": orgID,
+		"periodStart":    periodStart,
+		"status":         "completed",
+		"type":           bson.M{"$exists": false},
+	}).Decode(&record)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to check billing history: %w", err)
+	}
+
+	completedAt := record.CreatedAt
+	_, err = s.db.Collection("billing_runs").UpdateOne(ctx,
+		bson.M{"orgId": orgID, "periodStart": periodStart},
+		bson.M{"$setOnInsert": models.BillingRun{
+			ID:          primitive.NewObjectID(),
+			OrgID:       orgID,
+			PeriodStart: periodStart,
+			PeriodEnd:   periodEnd,
+			Status:      models.BillingRunCompleted,
+			Trigger:     trigger,
+			BillingID:   record.ID,
+			TotalTokens: record.TotalTokens,
+			TotalCost:   record.TotalCost,
+			Attempts:    1,
+			StartedAt:   record.CreatedAt,
+			CompletedAt: &completedAt,
+			UpdatedAt:   time.Now(),
+		}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		return false, fmt.Errorf("failed to record billing run: %w", err)
+	}
+	return true, nil
+}
+
+// completeRun marks a run completed with the billing record it produced
+func (s *Service) completeRun(ctx context.Context, runID primitive.ObjectID, record models.BillingHistory) error {
+	now := time.Now()
+	result, err := s.db.Collection("billing_runs").UpdateOne(ctx,
+		bson.M{"_id": runID, "status": models.BillingRunRunning},
+		bson.M{"$set": bson.M{
+			"status":      models.BillingRunCompleted,
+			"billingId":   record.ID,
+			"totalTokens": record.TotalTokens,
+			"totalCost":   record.TotalCost,
+			"completedAt": now,
+			"updatedAt":   now,
+		}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to complete billing run: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("billing run %s is no longer running", runID.Hex())
+	}
+	return nil
+}
+
+// failRun marks a run failed so a later attempt can retry it
+func (s *Service) failRun(ctx context.Context, runID primitive.ObjectID, cause error) {
+	_, err := s.db.Collection("billing_runs").UpdateOne(ctx,
+		bson.M{"_id": runID},
+		bson.M{"$set": bson.M{
+			"status":    models.BillingRunFailed,
+			"error":     cause.Error(),
+			"updatedAt": time.Now(),
+		}},
+	)
+	if err != nil {
+		s.logger.Error("Failed to mark billing run failed", zap.String("runId", runID.Hex()), zap.Error(err))
+	}
+}
+
+func (s *Service) findRun(ctx context.Context, orgID string, periodStart time.Time) (*models.BillingRun, error) {
+	var run models.BillingRun
+	err := s.db.Collection("billing_runs").FindOne(ctx, bson.M{"orgId": orgID, "periodStart": periodStart}).Decode(&run)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find billing run: %w", err)
+	}
+	return &run, nil
+}
+
+// BillingPreview is what billing an organization for a period would charge
+type BillingPreview struct {
+	OrgID              string                  `json:"orgId"`
+	PeriodStart        time.Time               `json:"periodStart"`
+	PeriodEnd          time.Time               `json:"periodEnd"`
+	TotalTokens        int64                   `json:"totalTokens"`
+	TotalCost          float64                 `json:"totalCost"`
+	Breakdown          models.BillingBreakdown `json:"breakdown"`
+	WalletBalance      float64                 `json:"walletBalance"`
+	WalletBalanceAfter float64                 `json:"walletBalanceAfter"`
+	Run                *models.BillingRun      `json:"run,omitempty"` // existing run for the period
+	// Reversal is what a forced re-run would credit back for the day's earlier charges
+	Reversal  float64 `json:"reversal,omitempty"`
+	WouldBill bool    `json:"wouldBill"`
+}
+
+// organizationDay returns an organization and the bounds of one of its local
+// days, which must have closed
+func (s *Service) organizationDay(ctx context.Context, orgID string, day time.Time) (models.Organization, time.Time, time.Time, error) {
+	var org models.Organization
+	if err := s.db.Collection("organizations").FindOne(ctx, bson.M{"orgId": orgID}).Decode(&org); err != nil {
+		return org, time.Time{}, time.Time{}, fmt.Errorf("failed to find organization: %w", err)
+	}
+	calendar := org.Calendar()
+	periodStart := calendar.Day(day.Year(), day.Month(), day.Day())
+	periodEnd := calendar.NextDayStart(periodStart)
+	if periodEnd.After(time.Now()) {
+		return org, time.Time{}, time.Time{}, ErrPeriodOpen
+	}
+	return org, periodStart, periodEnd, nil
+}
+
+// PreviewOrganizationDay reports what billing an organization for one of its
+// local days, with force a forced re-run, would charge without changing anything
+func (s *Service) PreviewOrganizationDay(ctx context.Context, orgID string, day time.Time, force bool) (*BillingPreview, error) {
+	org, periodStart, periodEnd, err := s.organizationDay(ctx, orgID, day)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := s.aggregateConsumption(ctx, periodStart, periodEnd, orgID)
+	if err != nil {
+		return nil, err
+	}
+	run, err := s.findRun(ctx, orgID, periodStart)
+	if err != nil {
+		return nil, err
+	}
+
+	preview := &BillingPreview{
+		OrgID:              orgID,
+		PeriodStart:        periodStart,
+		PeriodEnd:          periodEnd,
+		WalletBalance:      org.WalletBalance,
+		WalletBalanceAfter: org.WalletBalance,
+		Run:                run,
+	}
+	if len(results) > 0 {
+		preview.TotalTokens = results[0].TotalTokens
+		preview.TotalCost = results[0].TotalCost
+		preview.Breakdown = buildBreakdown(results[0])
+		preview.WalletBalanceAfter = org.WalletBalance - results[0].TotalCost
+	}
+	preview.WouldBill = len(results) > 0 && (run == nil || run.Status == models.BillingRunFailed)
+	if force && len(results) > 0 && (run == nil || run.Status != models.BillingRunRunning) {
+		records, err := s.chargedRecords(ctx, orgID, periodStart, "")
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range records {
+			preview.Reversal += record.TotalCost
+		}
+		preview.WalletBalanceAfter += preview.Reversal
+		preview.WouldBill = true
+	}
+	return preview, nil
+}
+
+// BillOrganizationDay bills one organization for one of its local days,
+// retrying a failed run. A day already billed returns ErrAlreadyBilled unless
+// force is set; a forced re-run reverses the day's earlier charges and bills
+// it again from the current consumption records.
+func (s *Service) BillOrganizationDay(ctx context.Context, orgID string, day time.Time, force bool) (*models.BillingRun, error) {
+	_, periodStart, periodEnd, err := s.organizationDay(ctx, orgID, day)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := s.aggregateConsumption(ctx, periodStart, periodEnd, orgID)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, ErrNothingToBill
+	}
+
+	if !force {
+		return s.billOrganization(ctx, results[0], periodStart, periodEnd, billingTriggerAdmin)
+	}
+	run, err := s.reopenRun(ctx, orgID, periodStart, periodEnd, billingTriggerAdmin)
+	if err != nil {
+		return nil, err
+	}
+	return s.charge(ctx, results[0], run)
+}
+
+// ListRuns returns billing runs, newest period first
+func (s *Service) ListRuns(ctx context.Context, orgID, status string, from, to *time.Time, limit int64) ([]models.BillingRun, error) {
+	filter := bson.M{}
+	if orgID != "" {
+		filter["orgId"] = orgID
+	}
+	if status != "" {
+		filter["status"] = status
+	}
+	if from != nil || to != nil {
+		period := bson.M{}
+		if from != nil {
+			period["$gte"] = *from
+		}
+		if to != nil {
+			period["$lt"] = *to
+		}
+		filter["periodStart"] = period
+	}
+
+	cursor, err := s.db.Collection("billing_runs").Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "periodStart", Value: -1}, {Key: "orgId", Value: 1}}).SetLimit(limit))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list billing runs: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	runs := []models.BillingRun{}
+	if err := cursor.All(ctx, &runs); err != nil {
+		return nil, fmt.Errorf("failed to decode billing runs: %w", err)
+	}
+	return runs, nil
+}
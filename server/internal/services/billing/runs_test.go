@@ -0,0 +1,458 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"freedom-ai/management-server/internal/database/databasetest"
+	"freedom-ai/management-server/internal/models"
+	"freedom-ai/management-server/internal/services/credits"
+	"freedom-ai/management-server/internal/services/ledger"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+// newTestService returns a billing service with its and the ledger's indexes
+// and the given organizations
+func newTestService(t *testing.T, db *mongo.Database, orgs ...bson.M) *Service {
+	t.Helper()
+	ctx := context.Background()
+	s := NewService(db, zap.NewNop())
+	if err := s.ledger.EnsureIndexes(ctx); err != nil {
+		t.Fatalf("ledger EnsureIndexes: %v", err)
+	}
+	if err := s.EnsureIndexes(ctx); err != nil {
+		t.Fatalf("billing EnsureIndexes: %v", err)
+	}
+	for _, org := range orgs {
+		if _, err := db.Collection("organizations").InsertOne(ctx, org); err != nil {
+			t.Fatalf("insert organization: %v", err)
+		}
+	}
+	return s
+}
+
+func insertConsumption(t *testing.T, db *mongo.Database, orgID string, at time.Time, cost float64) {
+	t.Helper()
+	_, err := db.Collection("token_consumption").InsertOne(context.Background(), models.TokenConsumption{
+		RequestID:   fmt.Sprintf("%s-%d", orgID, at.UnixNano()),
+		Timestamp:   at,
+		OrgID:       orgID,
+		TotalTokens: 100,
+		Status:      "complete",
+		Cost:        cost,
+	})
+	if err != nil {
+		t.Fatalf("insert consumption: %v", err)
+	}
+}
+
+func walletBalance(t *testing.T, db *mongo.Database, orgID string) float64 {
+	t.Helper()
+	var org models.Organization
+	if err := db.Collection("organizations").FindOne(context.Background(), bson.M{"orgId": orgID}).Decode(&org); err != nil {
+		t.Fatalf("load organization: %v", err)
+	}
+	return org.WalletBalance
+}
+
+// TestConcurrentTopUpsAndBilling races wallet top-ups against daily billing,
+// including duplicate runs for the same day, and checks that the cached
+// balance, the ledger and the expected total agree
+func TestConcurrentTopUpsAndBilling(t *testing.T) {
+	const (
+		orgID   = "org-race"
+		opening = 100.0
+		topUps  = 20
+		topUp   = 5.0
+		days    = 10
+		charge  = 3.0
+	)
+
+	ctx := context.Background()
+	db := databasetest.ConnectReplicaSet(t)
+	logger := zap.NewNop()
+	ledgerService := ledger.NewService(db, logger)
+	s := NewService(db, logger)
+	if err := ledgerService.EnsureIndexes(ctx); err != nil {
+		t.Fatalf("ledger EnsureIndexes: %v", err)
+	}
+	if err := s.EnsureIndexes(ctx); err != nil {
+		t.Fatalf("billing EnsureIndexes: %v", err)
+	}
+	if _, err := db.Collection("organizations").InsertOne(ctx, bson.M{"orgId": orgID, "walletBalance": opening}); err != nil {
+		t.Fatalf("insert organization: %v", err)
+	}
+	if err := ledgerService.RecordOpeningBalance(ctx, orgID, opening); err != nil {
+		t.Fatalf("RecordOpeningBalance: %v", err)
+	}
+
+	first := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	var wg sync.WaitGroup
+	errs := make(chan error, topUps+2*days)
+	for i := 0; i < topUps; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, _, err := ledgerService.Post(ctx, models.LedgerEntry{
+				OrgID:     orgID,
+				Type:      models.LedgerTopUpCredit,
+				Amount:    topUp,
+				Reference: fmt.Sprintf("stripe:pi_%d", i),
+			})
+			if err != nil {
+				errs <- fmt.Errorf("top-up %d: %w", i, err)
+			}
+		}(i)
+	}
+	// Each day is billed twice concurrently; only one run may charge it
+	for d := 0; d < days; d++ {
+		for attempt := 0; attempt < 2; attempt++ {
+			wg.Add(1)
+			go func(d int) {
+				defer wg.Done()
+				start := first.AddDate(0, 0, d)
+				_, err := s.billOrganization(ctx, BillingAggregateResult{OrgID: orgID, TotalCost: charge}, start, start.AddDate(0, 0, 1), billingTriggerSchedule)
+				if err != nil && !errors.Is(err, ErrRunInProgress) && !errors.Is(err, ErrAlreadyBilled) {
+					errs <- fmt.Errorf("billing day %d: %w", d, err)
+				}
+			}(d)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	var org models.Organization
+	if err := db.Collection("organizations").FindOne(ctx, bson.M{"orgId": orgID}).Decode(&org); err != nil {
+		t.Fatalf("load organization: %v", err)
+	}
+	cursor, err := db.Collection("wallet_ledger").Aggregate(ctx, []bson.M{
+		{"$match": bson.M{"orgId": orgID}},
+		{"$group": bson.M{"_id": nil, "sum": bson.M{"$sum": "$amount"}}},
+	})
+	if err != nil {
+		t.Fatalf("sum ledger: %v", err)
+	}
+	var sums []struct {
+		Sum float64 `bson:"sum"`
+	}
+	if err := cursor.All(ctx, &sums); err != nil || len(sums) != 1 {
+		t.Fatalf("decode ledger sum: %v (%d results)", err, len(sums))
+	}
+
+	want := opening + topUps*topUp - days*charge
+	if math.Abs(org.WalletBalance-want) > 1e-9 || math.Abs(sums[0].Sum-want) > 1e-9 {
+		t.Fatalf("walletBalance = %v, ledger sum = %v, want %v", org.WalletBalance, sums[0].Sum, want)
+	}
+
+	drifts, err := ledgerService.CheckConsistency(ctx)
+	if err != nil {
+		t.Fatalf("CheckConsistency: %v", err)
+	}
+	if len(drifts) != 0 {
+		t.Fatalf("CheckConsistency reported drift: %+v", drifts)
+	}
+}
+
+func TestClaimRun(t *testing.T) {
+	ctx := context.Background()
+	db := databasetest.Connect(t)
+	s := newTestService(t, db)
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 0, 1)
+	now := time.Now()
+
+	runs := []interface{}{
+		models.BillingRun{OrgID: "org-stale", PeriodStart: start, PeriodEnd: end, Status: models.BillingRunRunning, Attempts: 1, UpdatedAt: now.Add(-2 * staleRunAfter)},
+		models.BillingRun{OrgID: "org-running", PeriodStart: start, PeriodEnd: end, Status: models.BillingRunRunning, Attempts: 1, UpdatedAt: now},
+		models.BillingRun{OrgID: "org-failed", PeriodStart: start, PeriodEnd: end, Status: models.BillingRunFailed, Attempts: 1, Error: "boom", UpdatedAt: now},
+		models.BillingRun{OrgID: "org-completed", PeriodStart: start, PeriodEnd: end, Status: models.BillingRunCompleted, Attempts: 1, UpdatedAt: now},
+	}
+	if _, err := db.Collection("billing_runs").InsertMany(ctx, runs); err != nil {
+		t.Fatalf("insert runs: %v", err)
+	}
+
+	tests := []struct {
+		orgID   string
+		wantErr error
+	}{
+		{"org-new", nil},
+		{"org-stale", nil},
+		{"org-running", ErrRunInProgress},
+		{"org-failed", nil},
+		{"org-completed", ErrAlreadyBilled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.orgID, func(t *testing.T) {
+			run, err := s.claimRun(ctx, tt.orgID, start, end, billingTriggerSchedule)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("claimRun = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if run.Status != models.BillingRunRunning || run.Error != "" || run.UpdatedAt.Before(now) {
+				t.Fatalf("claimed run = %+v, want it running again", run)
+			}
+			wantAttempts := 2
+			if tt.orgID == "org-new" {
+				wantAttempts = 1
+			}
+			if run.Attempts != wantAttempts {
+				t.Fatalf("attempts = %d, want %d", run.Attempts, wantAttempts)
+			}
+
+			// Claimed just now, so a second attempt finds it in progress
+			if _, err := s.claimRun(ctx, tt.orgID, start, end, billingTriggerSchedule); !errors.Is(err, ErrRunInProgress) {
+				t.Fatalf("second claimRun = %v, want ErrRunInProgress", err)
+			}
+		})
+	}
+}
+
+func TestLegacyBilledDaysAreNotBilledAgain(t *testing.T) {
+	ctx := context.Background()
+	db := databasetest.Connect(t)
+	s := newTestService(t, db)
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 0, 1)
+
+	record := models.BillingHistory{
+		ID:             primitive.NewObjectID(),
+		OrganizationID: "org-1",
+		PeriodStart:    start,
+		PeriodEnd:      end,
+		TotalTokens:    500,
+		TotalCost:      4,
+		Status:         "completed",
+		CreatedAt:      end.Add(time.Hour),
+	}
+	if _, err := db.Collection("billing_history").InsertOne(ctx, record); err != nil {
+		t.Fatalf("insert billing history: %v", err)
+	}
+
+	if _, err := s.claimRun(ctx, "org-1", start, end, billingTriggerSchedule); !errors.Is(err, ErrAlreadyBilled) {
+		t.Fatalf("claimRun = %v, want ErrAlreadyBilled", err)
+	}
+	run, err := s.findRun(ctx, "org-1", start)
+	if err != nil || run == nil {
+		t.Fatalf("findRun = %v, %v", run, err)
+	}
+	if run.Status != models.BillingRunCompleted || run.BillingID != record.ID || run.TotalCost != 4 {
+		t.Fatalf("recorded run = %+v, want it completed with the legacy record", run)
+	}
+
+	// Adjustments are not daily billing
+	if _, err := db.Collection("billing_history").InsertOne(ctx, models.BillingHistory{
+		OrganizationID: "org-1",
+		PeriodStart:    end,
+		PeriodEnd:      end.AddDate(0, 0, 1),
+		Status:         "completed",
+		Type:           models.BillingTypeAdjustment,
+	}); err != nil {
+		t.Fatalf("insert adjustment: %v", err)
+	}
+	billed, err := s.legacyBilled(ctx, "org-1", end, end.AddDate(0, 0, 1), billingTriggerSchedule)
+	if err != nil || billed {
+		t.Fatalf("legacyBilled for a day with only an adjustment = %v, %v, want false", billed, err)
+	}
+
+	lastEnds, err := s.lastPeriodEnds(ctx)
+	if err != nil {
+		t.Fatalf("lastPeriodEnds: %v", err)
+	}
+	if !lastEnds["org-1"].Equal(end) {
+		t.Fatalf("last period end = %v, want %v", lastEnds["org-1"], end)
+	}
+}
+
+func TestBillClosedDaysCatchesUp(t *testing.T) {
+	ctx := context.Background()
+	db := databasetest.ConnectReplicaSet(t)
+	s := newTestService(t, db,
+		bson.M{"orgId": "org-old", "walletBalance": 100.0},
+		bson.M{"orgId": "org-new", "walletBalance": 100.0},
+	)
+
+	now := time.Now().UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	for d := 1; d <= maxCatchUpDays+9; d++ {
+		insertConsumption(t, db, "org-old", today.AddDate(0, 0, -d).Add(time.Hour), 1)
+	}
+	for d := 1; d <= 3; d++ {
+		insertConsumption(t, db, "org-new", today.AddDate(0, 0, -d).Add(time.Hour), 1)
+	}
+
+	tests := []struct {
+		orgID     string
+		wantDays  int
+		wantFirst time.Time
+	}{
+		// Consumption older than the catch-up window is left alone
+		{"org-old", maxCatchUpDays, today.AddDate(0, 0, -maxCatchUpDays)},
+		// Never billed: from the first consumption, not just yesterday
+		{"org-new", 3, today.AddDate(0, 0, -3)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.orgID, func(t *testing.T) {
+			billed, err := s.billClosedDays(ctx, models.Organization{OrgID: tt.orgID}, time.Time{}, now)
+			if err != nil {
+				t.Fatalf("billClosedDays: %v", err)
+			}
+			if billed != tt.wantDays {
+				t.Fatalf("billed %d days, want %d", billed, tt.wantDays)
+			}
+			var first models.BillingRun
+			err = db.Collection("billing_runs").FindOne(ctx, bson.M{"orgId": tt.orgID},
+				options.FindOne().SetSort(bson.M{"periodStart": 1})).Decode(&first)
+			if err != nil {
+				t.Fatalf("find first run: %v", err)
+			}
+			if !first.PeriodStart.Equal(tt.wantFirst) {
+				t.Fatalf("first billed day = %v, want %v", first.PeriodStart, tt.wantFirst)
+			}
+
+			// Caught up: nothing left to bill
+			lastEnds, err := s.lastPeriodEnds(ctx)
+			if err != nil {
+				t.Fatalf("lastPeriodEnds: %v", err)
+			}
+			if billed, err := s.billClosedDays(ctx, models.Organization{OrgID: tt.orgID}, lastEnds[tt.orgID], now); err != nil || billed != 0 {
+				t.Fatalf("second billClosedDays = %d, %v, want nothing billed", billed, err)
+			}
+		})
+	}
+}
+
+func TestForcedRerunReversesPreviousCharge(t *testing.T) {
+	ctx := context.Background()
+	db := databasetest.ConnectReplicaSet(t)
+	s := newTestService(t, db, bson.M{"orgId": "org-1", "walletBalance": 0.0})
+	if _, err := credits.NewService(db, zap.NewNop()).Grant(ctx, models.CreditGrant{OrgID: "org-1", Amount: 1, Source: models.CreditSourceManual}); err != nil {
+		t.Fatalf("Grant: %v", err)
+	}
+	if _, _, err := s.ledger.Post(ctx, models.LedgerEntry{OrgID: "org-1", Type: models.LedgerTopUpCredit, Amount: 100}); err != nil {
+		t.Fatalf("top up: %v", err)
+	}
+
+	day := time.Now().UTC().AddDate(0, 0, -3)
+	noon := time.Date(day.Year(), day.Month(), day.Day(), 12, 0, 0, 0, time.UTC)
+	insertConsumption(t, db, "org-1", noon, 2)
+	run, err := s.BillOrganizationDay(ctx, "org-1", day, false)
+	if err != nil {
+		t.Fatalf("BillOrganizationDay: %v", err)
+	}
+	if balance := walletBalance(t, db, "org-1"); math.Abs(balance-99) > 1e-9 {
+		t.Fatalf("walletBalance after billing = %v, want 99", balance)
+	}
+
+	// Consumption that arrived after the day was billed
+	insertConsumption(t, db, "org-1", noon.Add(time.Minute), 1.5)
+	if _, err := s.BillOrganizationDay(ctx, "org-1", day, false); !errors.Is(err, ErrAlreadyBilled) {
+		t.Fatalf("BillOrganizationDay without force = %v, want ErrAlreadyBilled", err)
+	}
+	preview, err := s.PreviewOrganizationDay(ctx, "org-1", day, true)
+	if err != nil {
+		t.Fatalf("PreviewOrganizationDay: %v", err)
+	}
+	if !preview.WouldBill || preview.Reversal != 2 || preview.TotalCost != 3.5 || math.Abs(preview.WalletBalanceAfter-97.5) > 1e-9 {
+		t.Fatalf("preview = %+v, want a reversal of 2 and a charge of 3.5", preview)
+	}
+
+	rerun, err := s.BillOrganizationDay(ctx, "org-1", day, true)
+	if err != nil {
+		t.Fatalf("forced BillOrganizationDay: %v", err)
+	}
+	if rerun.ID != run.ID || rerun.Revision != 1 || rerun.Status != models.BillingRunCompleted || rerun.TotalCost != 3.5 {
+		t.Fatalf("re-run = %+v, want revision 1 of run %s charging 3.5", rerun, run.ID.Hex())
+	}
+	if balance := walletBalance(t, db, "org-1"); math.Abs(balance-97.5) > 1e-9 {
+		t.Fatalf("walletBalance after re-run = %v, want 97.5", balance)
+	}
+
+	cursor, err := db.Collection("billing_history").Find(ctx, bson.M{"organizationId": "org-1"}, options.Find().SetSort(bson.M{"createdAt": 1}))
+	if err != nil {
+		t.Fatalf("find billing history: %v", err)
+	}
+	var history []models.BillingHistory
+	if err := cursor.All(ctx, &history); err != nil {
+		t.Fatalf("decode billing history: %v", err)
+	}
+	if len(history) != 2 || history[0].Status != models.BillingStatusReversed || history[0].TotalCost != 2 ||
+		history[1].Status != "completed" || history[1].TotalCost != 3.5 || history[1].CreditsUsed != 1 {
+		t.Fatalf("billing history = %+v, want the first charge reversed and the re-run completed", history)
+	}
+
+	// The grant drawn by the first charge went back and was drawn again
+	var grant models.CreditGrant
+	if err := db.Collection("credit_grants").FindOne(ctx, bson.M{"orgId": "org-1"}).Decode(&grant); err != nil {
+		t.Fatalf("load grant: %v", err)
+	}
+	if grant.Remaining != 0 || len(grant.Draws) != 1 || grant.Draws[0].Reference != chargeReference(run.ID, 1) {
+		t.Fatalf("grant = %+v, want it drawn once by the re-run", grant)
+	}
+
+	drifts, err := s.ledger.CheckConsistency(ctx)
+	if err != nil {
+		t.Fatalf("CheckConsistency: %v", err)
+	}
+	if len(drifts) != 0 {
+		t.Fatalf("CheckConsistency reported drift: %+v", drifts)
+	}
+}
+
+// TestRetryAfterDebitWithoutTransaction retries a run whose earlier attempt
+// posted the debit on a standalone server and stopped before completing
+func TestRetryAfterDebitWithoutTransaction(t *testing.T) {
+	ctx := context.Background()
+	db := databasetest.ConnectStandalone(t)
+	s := newTestService(t, db, bson.M{"orgId": "org-1", "walletBalance": 10.0})
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 0, 1)
+	result := BillingAggregateResult{OrgID: "org-1", TotalTokens: 100, TotalCost: 2}
+
+	run, err := s.claimRun(ctx, "org-1", start, end, billingTriggerSchedule)
+	if err != nil {
+		t.Fatalf("claimRun: %v", err)
+	}
+	if _, _, err := s.ledger.Post(ctx, models.LedgerEntry{OrgID: "org-1", Type: models.LedgerUsageDebit, Amount: -2, Reference: chargeReference(run.ID, 0)}); err != nil {
+		t.Fatalf("Post: %v", err)
+	}
+
+	// Stopped before the billing record, then again before completing the run
+	for attempt := 0; attempt < 2; attempt++ {
+		if _, err := db.Collection("billing_runs").UpdateOne(ctx, bson.M{"_id": run.ID}, bson.M{"$set": bson.M{"status": models.BillingRunRunning}}); err != nil {
+			t.Fatalf("reset run: %v", err)
+		}
+		if err := s.processOrganizationBilling(ctx, result, run); err != nil {
+			t.Fatalf("attempt %d: %v", attempt, err)
+		}
+
+		count, err := db.Collection("billing_history").CountDocuments(ctx, bson.M{"organizationId": "org-1"})
+		if err != nil {
+			t.Fatalf("count billing history: %v", err)
+		}
+		if count != 1 {
+			t.Fatalf("attempt %d: %d billing records, want 1", attempt, count)
+		}
+		if balance := walletBalance(t, db, "org-1"); balance != 8 {
+			t.Fatalf("attempt %d: walletBalance = %v, want 8", attempt, balance)
+		}
+		current, err := s.findRun(ctx, "org-1", start)
+		if err != nil || current.Status != models.BillingRunCompleted {
+			t.Fatalf("attempt %d: run = %+v, %v, want completed", attempt, current, err)
+		}
+	}
+}
@@ -2,15 +2,20 @@ package billing
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
 	"freedom-ai/management-server/internal/models"
+	"freedom-ai/management-server/internal/services/consumption"
+	"freedom-ai/management-server/internal/services/credits"
 	"freedom-ai/management-server/internal/services/email"
+	"freedom-ai/management-server/internal/services/ledger"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.uber.org/zap"
 )
 
@@ -18,6 +23,8 @@ type Service struct {
 	db           *mongo.Database
 	logger       *zap.Logger
 	emailService *email.Service
+	ledger       *ledger.Service
+	credits      *credits.Service
 }
 
 // BillingAggregateResult is the result of billing aggregation query
@@ -39,8 +46,10 @@ type BillingAggregateResult struct {
 
 func NewService(db *mongo.Database, logger *zap.Logger) *Service {
 	return &Service{
-		db:     db,
-		logger: logger,
+		db:      db,
+		logger:  logger,
+		ledger:  ledger.NewService(db, logger),
+		credits: credits.NewService(db, logger),
 	}
 }
 
@@ -48,89 +57,156 @@ func (s *Service) SetEmailService(emailService *email.Service) {
 	s.emailService = emailService
 }
 
+// ProcessDailyBilling bills every organization for each local day that has
+// closed since its last billing run, catching up at most maxCatchUpDays after
+// downtime. It runs hourly so each organization is billed soon after its
+// local midnight.
 func (s *Service) ProcessDailyBilling(ctx context.Context) error {
-	// Get yesterday's date range
-	now := time.Now().UTC()
-	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
-	yesterday := today.AddDate(0, 0, -1)
+	cursor, err := s.db.Collection("organizations").Find(ctx, bson.M{})
+	if err != nil {
+		return fmt.Errorf("failed to find organizations: %w", err)
+	}
+	var orgs []models.Organization
+	if err := cursor.All(ctx, &orgs); err != nil {
+		return fmt.Errorf("failed to decode organizations: %w", err)
+	}
 
-	s.logger.Info("Processing daily billing", zap.Time("periodStart", yesterday), zap.Time("periodEnd", today))
+	lastEnds, err := s.lastPeriodEnds(ctx)
+	if err != nil {
+		return err
+	}
 
-	// Get all organizations with consumption in the last 24 hours
-	consumptionCollection := s.db.Collection("token_consumption")
-	orgCollection := s.db.Collection("organizations")
-	billingCollection := s.db.Collection("billing_history")
+	now := time.Now()
+	billed := 0
+	for _, org := range orgs {
+		n, err := s.billClosedDays(ctx, org, lastEnds[org.OrgID], now)
+		billed += n
+		if err != nil {
+			s.logger.Error("Failed to process billing for organization",
+				zap.String("orgId", org.OrgID),
+				zap.Error(err))
+		}
+	}
+
+	if billed > 0 {
+		s.logger.Info("Daily billing completed", zap.Int("organizations", len(orgs)), zap.Int("billed", billed))
+	}
+	return nil
+}
+
+// billClosedDays bills an organization for its local days that closed after
+// lastEnd (the end of its latest billed period), or since its first billable
+// consumption if it was never billed, and returns how many were billed. It
+// goes back at most maxCatchUpDays.
+func (s *Service) billClosedDays(ctx context.Context, org models.Organization, lastEnd time.Time, now time.Time) (int, error) {
+	calendar := org.Calendar()
+	todayStart := calendar.DayStart(now)
+	from := todayStart.AddDate(0, 0, -maxCatchUpDays)
+	if lastEnd.After(from) {
+		// Continue where the last period ended, even when the timezone changed since
+		from = lastEnd.In(calendar.Location)
+	}
+	if !from.Before(todayStart) {
+		return 0, nil
+	}
+
+	// Start at the first day with billable consumption, skipping organizations without any
+	var first models.TokenConsumption
+	err := s.db.Collection("token_consumption").FindOne(ctx, bson.M{
+		"organizationId": org.OrgID,
+		"timestamp":      bson.M{"$gte": from, "$lt": todayStart},
+		"$or":            consumption.BillableConditions(),
+		"totalTokens":    bson.M{"$gt": 0},
+	}, options.FindOne().SetSort(bson.D{{Key: "timestamp", Value: 1}}).SetProjection(bson.M{"timestamp": 1})).Decode(&first)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to find consumption: %w", err)
+	}
+	if firstDay := calendar.DayStart(first.Timestamp); firstDay.After(from) {
+		from = firstDay
+	}
+
+	billed := 0
+	for periodStart := from; periodStart.Before(todayStart); {
+		periodEnd := calendar.NextDayStart(periodStart)
+		results, err := s.aggregateConsumption(ctx, periodStart, periodEnd, org.OrgID)
+		if err != nil {
+			return billed, err
+		}
+		if len(results) > 0 {
+			s.logger.Info("Processing daily billing", zap.String("orgId", org.OrgID), zap.Time("periodStart", periodStart), zap.Time("periodEnd", periodEnd))
+			_, err := s.billOrganization(ctx, results[0], periodStart, periodEnd, billingTriggerSchedule)
+			switch {
+			case err == nil:
+				billed++
+			case errors.Is(err, ErrAlreadyBilled), errors.Is(err, ErrRunInProgress):
+			default:
+				return billed, err
+			}
+		}
+		periodStart = periodEnd
+	}
+	return billed, nil
+}
+
+// aggregateConsumption sums billable consumption in [periodStart, periodEnd)
+// per organization; orgID restricts it to one organization
+func (s *Service) aggregateConsumption(ctx context.Context, periodStart, periodEnd time.Time, orgID string) ([]BillingAggregateResult, error) {
+	match := bson.M{
+		"timestamp": bson.M{
+			"$gte": periodStart,
+			"$lt":  periodEnd,
+		},
+		"$or":         consumption.BillableConditions(),
+		"totalTokens": bson.M{"$gt": 0},
+	}
+	if orgID != "" {
+		match["organizationId"] = orgID
+	}
 
 	// Aggregate consumption by organization
 	pipeline := []bson.M{
-		{
-			"$match": bson.M{
-				"timestamp": bson.M{
-					"$gte": yesterday,
-					"$lt":  today,
-				},
-				"status": "complete",
-				"totalTokens": bson.M{"$gt": 0},
-			},
-		},
+		{"$match": match},
 		{
 			"$group": bson.M{
-				"_id": "$organizationId",
+				"_id":         "$organizationId",
 				"totalTokens": bson.M{"$sum": "$totalTokens"},
-				"totalCost": bson.M{"$sum": "$cost"},
+				"totalCost":   bson.M{"$sum": "$cost"},
 				"byAssistant": bson.M{
 					"$push": bson.M{
 						"assistant": "$assistantType",
-						"tokens": "$totalTokens",
-						"cost": "$cost",
+						"tokens":    "$totalTokens",
+						"cost":      "$cost",
 					},
 				},
 				"byUser": bson.M{
 					"$push": bson.M{
-						"user": "$userId",
+						"user":   "$userId",
 						"tokens": "$totalTokens",
-						"cost": "$cost",
+						"cost":   "$cost",
 					},
 				},
 			},
 		},
 	}
 
-	cursor, err := consumptionCollection.Aggregate(ctx, pipeline)
+	cursor, err := s.db.Collection("token_consumption").Aggregate(ctx, pipeline)
 	if err != nil {
-		return fmt.Errorf("failed to aggregate consumption: %w", err)
+		return nil, fmt.Errorf("failed to aggregate consumption: %w", err)
 	}
 	defer cursor.Close(ctx)
 
 	var results []BillingAggregateResult
-
 	if err := cursor.All(ctx, &results); err != nil {
-		return fmt.Errorf("failed to decode aggregation results: %w", err)
-	}
-
-	// Process each organization
-	for _, result := range results {
-		if err := s.processOrganizationBilling(ctx, result, yesterday, today, orgCollection, billingCollection); err != nil {
-			s.logger.Error("Failed to process billing for organization",
-				zap.String("orgId", result.OrgID),
-				zap.Error(err))
-			continue
-		}
+		return nil, fmt.Errorf("failed to decode aggregation results: %w", err)
 	}
-
-	s.logger.Info("Daily billing completed", zap.Int("organizations", len(results)))
-	return nil
+	return results, nil
 }
 
-func (s *Service) processOrganizationBilling(ctx context.Context, result BillingAggregateResult, periodStart, periodEnd time.Time, orgCollection, billingCollection *mongo.Collection) error {
-	// Get organization
-	var org models.Organization
-	err := orgCollection.FindOne(ctx, bson.M{"orgId": result.OrgID}).Decode(&org)
-	if err != nil {
-		return fmt.Errorf("failed to find organization: %w", err)
-	}
-
-	// Build breakdown
+// buildBreakdown splits an organization's billed consumption by assistant and user
+func buildBreakdown(result BillingAggregateResult) models.BillingBreakdown {
 	breakdown := models.BillingBreakdown{
 		ByAssistant: make(map[string]models.AssistantBreakdown),
 		ByUser:      make(map[string]models.UserBreakdown),
@@ -157,45 +233,136 @@ func (s *Service) processOrganizationBilling(ctx context.Context, result Billing
 		existing.Cost += item.Cost
 		breakdown.ByUser[item.User] = existing
 	}
+	return breakdown
+}
 
-	// Get wallet balance before
-	walletBalanceBefore := org.WalletBalance
+// findCharge returns the billing record of the charge posted under reference, or nil
+func (s *Service) findCharge(ctx context.Context, orgID, reference string) (*models.BillingHistory, error) {
+	var record models.BillingHistory
+	err := s.db.Collection("billing_history").FindOne(ctx, bson.M{"organizationId": orgID, "reference": reference}).Decode(&record)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find billing record: %w", err)
+	}
+	return &record, nil
+}
 
-	// Deduct from wallet
-	walletBalanceAfter := walletBalanceBefore - result.TotalCost
+// completeCharged completes a run whose charge an earlier attempt committed
+func (s *Service) completeCharged(ctx context.Context, run *models.BillingRun, reference string) error {
+	record, err := s.findCharge(ctx, run.OrgID, reference)
+	if err != nil {
+		return err
+	}
+	if record == nil {
+		return fmt.Errorf("%w without a billing record", ledger.ErrDuplicateReference)
+	}
+	current, err := s.findRun(ctx, run.OrgID, run.PeriodStart)
+	if err != nil {
+		return err
+	}
+	if current != nil && current.Status == models.BillingRunCompleted {
+		return nil
+	}
+	return s.completeRun(ctx, run.ID, *record)
+}
+
+// processOrganizationBilling charges an organization for a run's period and
+// completes the run. A re-run first reverses the period's earlier charges.
+func (s *Service) processOrganizationBilling(ctx context.Context, result BillingAggregateResult, run *models.BillingRun) error {
+	periodStart, periodEnd := run.PeriodStart, run.PeriodEnd
+	orgCollection := s.db.Collection("organizations")
+	billingCollection := s.db.Collection("billing_history")
 
-	// Update organization wallet
-	_, err = orgCollection.UpdateOne(
-		ctx,
-		bson.M{"orgId": result.OrgID},
-		bson.M{"$set": bson.M{
-			"walletBalance": walletBalanceAfter,
-			"updatedAt":     time.Now(),
-		}},
-	)
+	// Get organization
+	var org models.Organization
+	err := orgCollection.FindOne(ctx, bson.M{"orgId": result.OrgID}).Decode(&org)
 	if err != nil {
-		return fmt.Errorf("failed to update wallet balance: %w", err)
-	}
-
-	// Create billing record
-	billingRecord := models.BillingHistory{
-		ID:                  primitive.NewObjectID(),
-		OrganizationID:      result.OrgID,
-		BillingDate:        time.Now(),
-		PeriodStart:        periodStart,
-		PeriodEnd:          periodEnd,
-		TotalTokens:        result.TotalTokens,
-		TotalCost:          result.TotalCost,
-		Breakdown:          breakdown,
-		WalletBalanceBefore: walletBalanceBefore,
-		WalletBalanceAfter:  walletBalanceAfter,
-		Status:             "completed",
-		CreatedAt:          time.Now(),
-	}
-
-	_, err = billingCollection.InsertOne(ctx, billingRecord)
+		return fmt.Errorf("failed to find organization: %w", err)
+	}
+
+	breakdown := buildBreakdown(result)
+
+	billingID := primitive.NewObjectID()
+	reference := chargeReference(run.ID, run.Revision) // one debit per run revision, even when retried
+	var walletBalanceBefore, walletBalanceAfter float64
+	applied := false
+
+	// Debit the wallet through the ledger and record the billing in one
+	// transaction; the debit is an atomic $inc, so concurrent top-ups are kept
+	err = s.ledger.Transact(ctx, func(ctx context.Context) error {
+		if run.Revision > 0 {
+			if err := s.reverseCharges(ctx, run); err != nil {
+				return err
+			}
+		}
+
+		entry, posted, err := s.ledger.Post(ctx, models.LedgerEntry{
+			OrgID:       result.OrgID,
+			Type:        models.LedgerUsageDebit,
+			Amount:      -result.TotalCost,
+			Reference:   reference,
+			Description: fmt.Sprintf("Usage %s", periodStart.Format("2006-01-02")),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to update wallet balance: %w", err)
+		}
+		walletBalanceAfter = entry.BalanceAfter
+		walletBalanceBefore = walletBalanceAfter - entry.Amount
+		if !posted {
+			// Without transactions an earlier attempt posted the debit and may
+			// have recorded it before stopping
+			record, err := s.findCharge(ctx, result.OrgID, reference)
+			if err != nil {
+				return err
+			}
+			if record != nil {
+				return s.completeRun(ctx, run.ID, *record)
+			}
+		}
+		applied = posted
+
+		// Promotional credits are used before paid balance
+		creditsUsed, err := s.credits.Consume(ctx, result.OrgID, result.TotalCost, entry.Reference)
+		if err != nil {
+			return fmt.Errorf("failed to draw credit grants: %w", err)
+		}
+
+		billingRecord := models.BillingHistory{
+			ID:                  billingID,
+			OrganizationID:      result.OrgID,
+			BillingDate:         time.Now(),
+			PeriodStart:         periodStart,
+			PeriodEnd:           periodEnd,
+			TotalTokens:         result.TotalTokens,
+			TotalCost:           result.TotalCost,
+			Breakdown:           breakdown,
+			WalletBalanceBefore: walletBalanceBefore,
+			WalletBalanceAfter:  walletBalanceAfter,
+			CreditsUsed:         creditsUsed,
+			Status:              "completed",
+			Reference:           reference,
+			CreatedAt:           time.Now(),
+		}
+		if _, err := billingCollection.InsertOne(ctx, billingRecord); err != nil {
+			return fmt.Errorf("failed to insert billing record: %w", err)
+		}
+		return s.completeRun(ctx, run.ID, billingRecord)
+	})
+	if errors.Is(err, ledger.ErrDuplicateReference) {
+		// An earlier attempt committed the debit together with its billing record
+		err = s.completeCharged(ctx, run, reference)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to insert billing record: %w", err)
+		return err
+	}
+	if !applied {
+		// The earlier attempt charged the period; don't notify again
+		s.logger.Info("Billing already charged by an earlier attempt",
+			zap.String("orgId", result.OrgID),
+			zap.String("reference", reference))
+		return nil
 	}
 
 	s.logger.Info("Processed billing for organization",
@@ -245,8 +412,8 @@ func (s *Service) processOrganizationBilling(ctx context.Context, result Billing
 
 	// Check for low balance alert
 	if s.emailService != nil && org.BillingEmail != "" {
-		threshold := 10.0 // Default threshold
-		if walletBalanceAfter < threshold && walletBalanceBefore >= threshold {
+		threshold := org.EffectiveDunningPolicy().LowBalanceThreshold
+		if threshold > 0 && walletBalanceAfter < threshold && walletBalanceBefore >= threshold {
 			if err := s.emailService.SendLowBalanceAlert(org.BillingEmail, org.Name, walletBalanceAfter, threshold); err != nil {
 				s.logger.Warn("Failed to send low balance alert",
 					zap.String("orgId", result.OrgID),
@@ -257,4 +424,3 @@ func (s *Service) processOrganizationBilling(ctx context.Context, result Billing
 
 	return nil
 }
-
@@ -1,41 +1,445 @@
 package consumption
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
 	"freedom-ai/management-server/internal/config"
+	"freedom-ai/management-server/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
 )
 
+// priceCacheTTL is how long the catalog is cached; catalog edits take effect within it
+const priceCacheTTL = time.Minute
+
+// ErrCatalogNotLoaded is returned while the catalog has never been loaded, so
+// records are retried instead of being stored as unpriced
+var ErrCatalogNotLoaded = errors.New("price catalog not loaded")
+
+// PriceInput identifies the usage to price
+type PriceInput struct {
+	RequestID        string
+	OrgID            string
+	Model            string
+	AssistantType    string
+	PromptTokens     int
+	CompletionTokens int
+	// Token classes, as in models.Usage
+	CachedPromptTokens int
+	ReasoningTokens    int
+	ImageTokens        int
+	AudioTokens        int
+	Timestamp          time.Time
+	// Price and RateCard, when set, are used instead of the catalog entry and
+	// rate card valid at Timestamp (re-rating under a chosen version)
+	Price    *models.ModelPrice
+	RateCard *models.RateCard
+}
+
+// Quote is the cost of a record under the catalog and the organization's rate card
+type Quote struct {
+	Cost    float64
+	PriceID primitive.ObjectID
+	// RateCardID and RateCardVersion identify the rate card override applied, if any
+	RateCardID      primitive.ObjectID
+	RateCardVersion int
+	// TierCharges splits Cost across volume tiers when the price is tiered
+	TierCharges []models.TierCharge
+	// CostByClass splits Cost across token classes
+	CostByClass models.CostByClass
+	// Unpriced is set when no catalog price covers the model at the record's time;
+	// the record is then stored with zero cost instead of a guessed rate
+	Unpriced bool
+}
+
+// costByClass returns the class split to store on a record, or nil when unpriced
+func (q Quote) costByClass() *models.CostByClass {
+	if q.Unpriced {
+		return nil
+	}
+	cost := q.CostByClass
+	return &cost
+}
+
 type PricingService struct {
 	config *config.Config
+	db     *mongo.Database
+	logger *zap.Logger
+
+	mu        sync.RWMutex
+	prices    map[string][]models.ModelPrice
+	rateCards map[string][]models.RateCard
+	calendars map[string]models.BillingCalendar
+	loadedAt  time.Time
+}
+
+func NewPricingService(cfg *config.Config, db *mongo.Database, logger *zap.Logger) *PricingService {
+	return &PricingService{config: cfg, db: db, logger: logger}
+}
+
+// CalculateCost prices usage by the catalog entry valid at its timestamp,
+// adjusted by the organization's rate card in force at that time. Tiered
+// prices are charged from the organization's monthly position for the model;
+// with advance the usage is added to that position.
+func (p *PricingService) CalculateCost(ctx context.Context, in PriceInput, advance bool) (Quote, error) {
+	if err := p.refresh(); err != nil && !p.loaded() {
+		return Quote{}, fmt.Errorf("%w: %v", ErrCatalogNotLoaded, err)
+	}
+
+	var quote Quote
+	tiers, priced := p.schedule(in, &quote)
+	if !priced {
+		return Quote{Unpriced: true}, nil
+	}
+
+	tokens := int64(in.PromptTokens + in.CompletionTokens)
+	var start int64
+	if len(tiers) > 1 {
+		var err error
+		start, err = p.position(ctx, in, tokens, advance)
+		if err != nil {
+			return Quote{}, err
+		}
+	}
+
+	quote.CostByClass, quote.TierCharges = chargeTiers(tiers, start, in)
+	quote.Cost = quote.CostByClass.Total()
+	if len(tiers) == 1 {
+		quote.TierCharges = nil
+	}
+	return quote, nil
+}
+
+// schedule resolves the tiers that price the usage, recording the catalog
+// entry and rate card used on quote. A flat price is a single unbounded tier.
+func (p *PricingService) schedule(in PriceInput, quote *Quote) ([]models.PriceTier, bool) {
+	var tiers []models.PriceTier
+	price, priced := models.ModelPrice{}, false
+	if in.Price != nil {
+		price, priced = *in.Price, true
+	} else {
+		price, priced = p.PriceAt(in.Model, in.Timestamp)
+	}
+	if priced {
+		quote.PriceID = price.ID
+		tiers = price.Tiers
+		if len(tiers) == 0 {
+			tiers = []models.PriceTier{{
+				PromptPricePer1K:     price.PromptPricePer1K,
+				CompletionPricePer1K: price.CompletionPricePer1K,
+				ClassPrices:          price.ClassPrices,
+			}}
+		}
+	}
+
+	card, ok := models.RateCard{}, false
+	if in.RateCard != nil {
+		card, ok = *in.RateCard, true
+	} else {
+		card, ok = p.RateCardAt(in.OrgID, in.Timestamp)
+	}
+	if !ok {
+		return tiers, priced
+	}
+	override, ok := card.Override(in.Model, in.AssistantType)
+	if !ok {
+		return tiers, priced
+	}
+
+	switch {
+	case len(override.Tiers) > 0:
+		tiers, priced = override.Tiers, true
+	case override.PromptPricePer1K != nil && override.CompletionPricePer1K != nil:
+		fixed := models.PriceTier{PromptPricePer1K: *override.PromptPricePer1K, CompletionPricePer1K: *override.CompletionPricePer1K}
+		if override.ClassPrices != nil {
+			fixed.ClassPrices = *override.ClassPrices
+		} else if priced {
+			fixed.ClassPrices = tiers[0].ClassPrices
+		}
+		tiers = []models.PriceTier{fixed}
+		priced = true
+	case priced:
+		adjusted := make([]models.PriceTier, len(tiers))
+		for i, tier := range tiers {
+			if override.PromptPricePer1K != nil {
+				tier.PromptPricePer1K = *override.PromptPricePer1K
+			} else if override.Multiplier > 0 {
+				tier.PromptPricePer1K *= override.Multiplier
+			}
+			if override.CompletionPricePer1K != nil {
+				tier.CompletionPricePer1K = *override.CompletionPricePer1K
+			} else if override.Multiplier > 0 {
+				tier.CompletionPricePer1K *= override.Multiplier
+			}
+			if override.ClassPrices != nil {
+				tier.ClassPrices = *override.ClassPrices
+			} else if override.Multiplier > 0 {
+				tier.ClassPrices = tier.ClassPrices.Scale(override.Multiplier)
+			}
+			adjusted[i] = tier
+		}
+		tiers = adjusted
+	}
+
+	if priced {
+		quote.RateCardID = card.ID
+		quote.RateCardVersion = card.Version
+	}
+	return tiers, priced
+}
+
+// chargeTiers prices the tokens occupying [start, start+prompt+completion) of
+// the monthly position. Each tier's share is split between token classes in
+// the record's proportions; tokens past a bounded last tier are charged at its
+// price. Image and audio units do not move the position.
+func chargeTiers(tiers []models.PriceTier, start int64, in PriceInput) (models.CostByClass, []models.TierCharge) {
+	tokens := int64(in.PromptTokens + in.CompletionTokens)
+
+	tierIndex := func(position int64) int {
+		for i, tier := range tiers {
+			if tier.UpToTokens == 0 || position < tier.UpToTokens {
+				return i
+			}
+		}
+		return len(tiers) - 1
+	}
+
+	if tokens == 0 {
+		i := tierIndex(start)
+		cost := classCost(tiers[i], in)
+		return cost, []models.TierCharge{{Tier: i, Cost: cost.Total()}}
+	}
+
+	var total models.CostByClass
+	var charges []models.TierCharge
+	position, remaining := start, tokens
+	for remaining > 0 {
+		i := tierIndex(position)
+		inTier := remaining
+		if bound := tiers[i].UpToTokens; bound > 0 && i < len(tiers)-1 && position+inTier > bound {
+			inTier = bound - position
+		}
+		share := float64(inTier) / float64(tokens)
+		cost := classCost(tiers[i], in)
+		charges = append(charges, models.TierCharge{Tier: i, Tokens: inTier, Cost: cost.Total() * share})
+		total = total.Add(cost, share)
+		position += inTier
+		remaining -= inTier
+	}
+	return total, charges
+}
+
+// classCost is the full usage's cost at a tier's prices (prices are per 1k tokens)
+func classCost(tier models.PriceTier, in PriceInput) models.CostByClass {
+	cachedPrice, reasoningPrice := tier.PromptPricePer1K, tier.CompletionPricePer1K
+	if tier.CachedPromptPricePer1K != nil {
+		cachedPrice = *tier.CachedPromptPricePer1K
+	}
+	if tier.ReasoningPricePer1K != nil {
+		reasoningPrice = *tier.ReasoningPricePer1K
+	}
+
+	per1K := func(tokens int, price float64) float64 {
+		return (float64(tokens) / 1000.0) * price
+	}
+	return models.CostByClass{
+		Prompt:       per1K(in.PromptTokens-in.CachedPromptTokens, tier.PromptPricePer1K),
+		CachedPrompt: per1K(in.CachedPromptTokens, cachedPrice),
+		Completion:   per1K(in.CompletionTokens-in.ReasoningTokens, tier.CompletionPricePer1K),
+		Reasoning:    per1K(in.ReasoningTokens, reasoningPrice),
+		Image:        per1K(in.ImageTokens, tier.ImagePricePer1K),
+		Audio:        per1K(in.AudioTokens, tier.AudioPricePer1K),
+	}
 }
 
-func NewPricingService(cfg *config.Config) *PricingService {
-	return &PricingService{config: cfg}
+// PriceAt returns the catalog price for model valid at t
+func (p *PricingService) PriceAt(model string, at time.Time) (models.ModelPrice, bool) {
+	p.refresh()
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, price := range p.prices[model] {
+		if price.ValidAt(at) {
+			return price, true
+		}
+	}
+	return models.ModelPrice{}, false
 }
 
-func (p *PricingService) CalculateCost(model string, promptTokens, completionTokens int) float64 {
-	var requestPrice, responsePrice float64
+// RateCardAt returns the organization's rate card version in force at t
+func (p *PricingService) RateCardAt(orgID string, at time.Time) (models.RateCard, bool) {
+	p.refresh()
 
-	// Determine pricing based on model
-	switch model {
-	case "gpt-4":
-		requestPrice = p.config.PricingGPT4Request
-		responsePrice = p.config.PricingGPT4Response
-	case "gpt-4-turbo", "gpt-4-turbo-preview":
-		requestPrice = p.config.PricingGPT4TurboRequest
-		responsePrice = p.config.PricingGPT4TurboResponse
-	case "gpt-3.5-turbo", "gpt-3.5-turbo-16k":
-		requestPrice = p.config.PricingGPT35TurboRequest
-		responsePrice = p.config.PricingGPT35TurboResponse
-	default:
-		// Default to GPT-3.5 pricing for unknown models
-		requestPrice = p.config.PricingGPT35TurboRequest
-		responsePrice = p.config.PricingGPT35TurboResponse
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, card := range p.rateCards[orgID] {
+		if card.ValidAt(at) {
+			return card, true
+		}
 	}
+	return models.RateCard{}, false
+}
+
+// loaded reports whether the catalog has been loaded at least once
+func (p *PricingService) loaded() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.prices != nil
+}
 
-	// Calculate cost (prices are per 1k tokens)
-	promptCost := (float64(promptTokens) / 1000.0) * requestPrice
-	completionCost := (float64(completionTokens) / 1000.0) * responsePrice
+// CalendarOf returns the organization's billing calendar, which keys its
+// monthly tier positions
+func (p *PricingService) CalendarOf(orgID string) models.BillingCalendar {
+	p.refresh()
 
-	return promptCost + completionCost
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if calendar, ok := p.calendars[orgID]; ok {
+		return calendar
+	}
+	return models.UTCCalendar
 }
 
+// refresh reloads the catalog, rate cards and billing calendars once the cache has expired. On failure the
+// previous catalog stays in use.
+func (p *PricingService) refresh() error {
+	p.mu.RLock()
+	fresh := p.prices != nil && time.Since(p.loadedAt) < priceCacheTTL
+	p.mu.RUnlock()
+	if fresh || p.db == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := p.db.Collection("model_prices").Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "effectiveFrom", Value: -1}}))
+	if err != nil {
+		p.logger.Warn("Failed to load model prices", zap.Error(err))
+		return err
+	}
+	var catalog []models.ModelPrice
+	if err := cursor.All(ctx, &catalog); err != nil {
+		p.logger.Warn("Failed to decode model prices", zap.Error(err))
+		return err
+	}
+
+	prices := make(map[string][]models.ModelPrice)
+	for _, price := range catalog {
+		prices[price.Model] = append(prices[price.Model], price)
+	}
+
+	cursor, err = p.db.Collection("rate_cards").Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "version", Value: -1}}))
+	if err != nil {
+		p.logger.Warn("Failed to load rate cards", zap.Error(err))
+		return err
+	}
+	var cards []models.RateCard
+	if err := cursor.All(ctx, &cards); err != nil {
+		p.logger.Warn("Failed to decode rate cards", zap.Error(err))
+		return err
+	}
+
+	rateCards := make(map[string][]models.RateCard)
+	for _, card := range cards {
+		rateCards[card.OrgID] = append(rateCards[card.OrgID], card)
+	}
+
+	cursor, err = p.db.Collection("organizations").Find(ctx, bson.M{},
+		options.Find().SetProjection(bson.M{"orgId": 1, "timezone": 1, "billingAnchorDay": 1}))
+	if err != nil {
+		p.logger.Warn("Failed to load billing calendars", zap.Error(err))
+		return err
+	}
+	var orgs []models.Organization
+	if err := cursor.All(ctx, &orgs); err != nil {
+		p.logger.Warn("Failed to decode billing calendars", zap.Error(err))
+		return err
+	}
+
+	calendars := make(map[string]models.BillingCalendar, len(orgs))
+	for _, org := range orgs {
+		calendars[org.OrgID] = org.Calendar()
+	}
+
+	p.mu.Lock()
+	p.prices = prices
+	p.rateCards = rateCards
+	p.calendars = calendars
+	p.loadedAt = time.Now()
+	p.mu.Unlock()
+	return nil
+}
+
+// SeedCatalog creates the catalog, rate card and tier usage indexes and, when
+// the catalog is empty, seeds it from the PRICING_* settings
+func (p *PricingService) SeedCatalog(ctx context.Context) error {
+	_, err := p.db.Collection("tier_usage").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "orgId", Value: 1}, {Key: "model", Value: 1}, {Key: "month", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create tier_usage index: %w", err)
+	}
+
+	_, err = p.db.Collection("rate_cards").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "orgId", Value: 1}, {Key: "version", Value: -1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create rate_cards index: %w", err)
+	}
+
+	collection := p.db.Collection("model_prices")
+	_, err = collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "model", Value: 1}, {Key: "effectiveFrom", Value: -1}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create model_prices index: %w", err)
+	}
+
+	count, err := collection.CountDocuments(ctx, bson.M{})
+	if err != nil {
+		return fmt.Errorf("failed to count model prices: %w", err)
+	}
+	if count > 0 {
+		return nil
+	}
+
+	now := time.Now()
+	seed := []struct {
+		models             []string
+		prompt, completion float64
+	}{
+		{[]string{"gpt-4"}, p.config.PricingGPT4Request, p.config.PricingGPT4Response},
+		{[]string{"gpt-4-turbo", "gpt-4-turbo-preview"}, p.config.PricingGPT4TurboRequest, p.config.PricingGPT4TurboResponse},
+		{[]string{"gpt-3.5-turbo", "gpt-3.5-turbo-16k"}, p.config.PricingGPT35TurboRequest, p.config.PricingGPT35TurboResponse},
+	}
+	var docs []interface{}
+	for _, entry := range seed {
+		for _, model := range entry.models {
+			docs = append(docs, models.ModelPrice{
+				Model:                model,
+				PromptPricePer1K:     entry.prompt,
+				CompletionPricePer1K: entry.completion,
+				EffectiveFrom:        time.Unix(0, 0).UTC(),
+				CreatedAt:            now,
+				UpdatedAt:            now,
+			})
+		}
+	}
+	if _, err := collection.InsertMany(ctx, docs); err != nil {
+		return fmt.Errorf("failed to seed model prices: %w", err)
+	}
+
+	p.logger.Info("Seeded model price catalog from configuration", zap.Int("prices", len(docs)))
+	return nil
+}
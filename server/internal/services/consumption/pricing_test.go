@@ -0,0 +1,208 @@
+package consumption
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"freedom-ai/management-server/internal/config"
+	"freedom-ai/management-server/internal/models"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+// unreachableDatabase returns a database whose every operation fails quickly
+func unreachableDatabase(t *testing.T) *mongo.Database {
+	t.Helper()
+	client, err := mongo.Connect(context.Background(), options.Client().
+		ApplyURI("mongodb://127.0.0.1:1").
+		SetServerSelectionTimeout(100*time.Millisecond))
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(func() { _ = client.Disconnect(context.Background()) })
+	return client.Database("unreachable")
+}
+
+func TestCalculateCostFailsUntilCatalogLoaded(t *testing.T) {
+	p := NewPricingService(&config.Config{}, unreachableDatabase(t), zap.NewNop())
+	in := PriceInput{Model: "gpt-4", PromptTokens: 1000, CompletionTokens: 1000, Timestamp: time.Now()}
+
+	if _, err := p.CalculateCost(context.Background(), in, false); !errors.Is(err, ErrCatalogNotLoaded) {
+		t.Fatalf("CalculateCost error = %v, want ErrCatalogNotLoaded", err)
+	}
+
+	// Once loaded, a failed reload keeps pricing from the previous catalog
+	p.prices = map[string][]models.ModelPrice{
+		"gpt-4": {{Model: "gpt-4", PromptPricePer1K: 0.03, CompletionPricePer1K: 0.06}},
+	}
+	p.loadedAt = time.Now().Add(-2 * priceCacheTTL)
+
+	quote, err := p.CalculateCost(context.Background(), in, false)
+	if err != nil {
+		t.Fatalf("CalculateCost: %v", err)
+	}
+	if quote.Unpriced || quote.Cost < 0.0899 || quote.Cost > 0.0901 {
+		t.Fatalf("quote = %+v, want cost 0.09 from the previous catalog", quote)
+	}
+}
+
+// newCatalogPricing returns a pricing service over fixed prices and rate cards
+func newCatalogPricing(prices []models.ModelPrice, cards []models.RateCard) *PricingService {
+	p := NewPricingService(&config.Config{}, nil, zap.NewNop())
+	p.prices = make(map[string][]models.ModelPrice)
+	for _, price := range prices {
+		p.prices[price.Model] = append(p.prices[price.Model], price)
+	}
+	p.rateCards = make(map[string][]models.RateCard)
+	for _, card := range cards {
+		p.rateCards[card.OrgID] = append(p.rateCards[card.OrgID], card)
+	}
+	p.loadedAt = time.Now()
+	return p
+}
+
+func TestRateCardOverridesCatalogPrice(t *testing.T) {
+	price := func(v float64) *float64 { return &v }
+	switchover := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	catalog := []models.ModelPrice{
+		{Model: "gpt-4", PromptPricePer1K: 0.03, CompletionPricePer1K: 0.06},
+		{Model: "claude-3", PromptPricePer1K: 0.01, CompletionPricePer1K: 0.02},
+	}
+	// Newest version first, as refresh loads them
+	cards := []models.RateCard{
+		{
+			OrgID: "org-1", Version: 2, EffectiveFrom: switchover,
+			Overrides: []models.RateOverride{{Model: "gpt-4", Multiplier: 0.5}},
+		},
+		{
+			OrgID: "org-1", Version: 1, EffectiveFrom: switchover.AddDate(0, -2, 0), EffectiveTo: &switchover,
+			Overrides: []models.RateOverride{
+				{Model: "gpt-4", Multiplier: 0.8},
+				{Model: "gpt-4", AssistantType: "excel", PromptPricePer1K: price(0.01), CompletionPricePer1K: price(0.01)},
+				{AssistantType: "word", Multiplier: 2},
+				// A fixed price prices a model the catalog does not
+				{Model: "in-house", PromptPricePer1K: price(0.001), CompletionPricePer1K: price(0.002)},
+			},
+		},
+	}
+	p := newCatalogPricing(catalog, cards)
+
+	before := switchover.Add(-time.Hour)
+	tests := []struct {
+		name        string
+		in          PriceInput
+		wantCost    float64
+		wantVersion int
+		wantPriced  bool
+	}{
+		{"catalog price without a card", PriceInput{OrgID: "org-2", Model: "gpt-4", Timestamp: before}, 0.09, 0, true},
+		{"before the first version", PriceInput{OrgID: "org-1", Model: "gpt-4", Timestamp: switchover.AddDate(0, -3, 0)}, 0.09, 0, true},
+		{"model multiplier", PriceInput{OrgID: "org-1", Model: "gpt-4", Timestamp: before}, 0.072, 1, true},
+		{"model and assistant fixed price", PriceInput{OrgID: "org-1", Model: "gpt-4", AssistantType: "excel", Timestamp: before}, 0.02, 1, true},
+		{"model wins over assistant", PriceInput{OrgID: "org-1", Model: "gpt-4", AssistantType: "word", Timestamp: before}, 0.072, 1, true},
+		{"assistant multiplier", PriceInput{OrgID: "org-1", Model: "claude-3", AssistantType: "word", Timestamp: before}, 0.06, 1, true},
+		{"no matching override", PriceInput{OrgID: "org-1", Model: "claude-3", Timestamp: before}, 0.03, 0, true},
+		{"fixed price for an uncatalogued model", PriceInput{OrgID: "org-1", Model: "in-house", Timestamp: before}, 0.003, 1, true},
+		{"uncatalogued model in the next version", PriceInput{OrgID: "org-1", Model: "in-house", Timestamp: switchover}, 0, 0, false},
+		{"next version from its effective time", PriceInput{OrgID: "org-1", Model: "gpt-4", Timestamp: switchover}, 0.045, 2, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.in.PromptTokens, tt.in.CompletionTokens = 1000, 1000
+			quote, err := p.CalculateCost(context.Background(), tt.in, false)
+			if err != nil {
+				t.Fatalf("CalculateCost: %v", err)
+			}
+			if quote.Unpriced == tt.wantPriced {
+				t.Fatalf("unpriced = %v, want %v", quote.Unpriced, !tt.wantPriced)
+			}
+			if diff := quote.Cost - tt.wantCost; diff > 1e-9 || diff < -1e-9 {
+				t.Fatalf("cost = %v, want %v", quote.Cost, tt.wantCost)
+			}
+			if quote.RateCardVersion != tt.wantVersion {
+				t.Fatalf("rate card version = %d, want %d", quote.RateCardVersion, tt.wantVersion)
+			}
+		})
+	}
+}
+
+func TestClassCostPricesTokenClassesSeparately(t *testing.T) {
+	price := func(v float64) *float64 { return &v }
+	usage := PriceInput{
+		PromptTokens: 1000, CachedPromptTokens: 400,
+		CompletionTokens: 500, ReasoningTokens: 200,
+		ImageTokens: 10, AudioTokens: 20,
+	}
+
+	tests := []struct {
+		name string
+		tier models.PriceTier
+		want models.CostByClass
+	}{
+		{
+			name: "class prices",
+			tier: models.PriceTier{PromptPricePer1K: 0.03, CompletionPricePer1K: 0.06, ClassPrices: models.ClassPrices{
+				CachedPromptPricePer1K: price(0.015), ReasoningPricePer1K: price(0.12), ImagePricePer1K: 0.5, AudioPricePer1K: 1,
+			}},
+			want: models.CostByClass{Prompt: 0.018, CachedPrompt: 0.006, Completion: 0.018, Reasoning: 0.024, Image: 0.005, Audio: 0.02},
+		},
+		{
+			// Cached and reasoning tokens fall back to the prompt and completion
+			// prices; image and audio units are free
+			name: "no class prices",
+			tier: models.PriceTier{PromptPricePer1K: 0.03, CompletionPricePer1K: 0.06},
+			want: models.CostByClass{Prompt: 0.018, CachedPrompt: 0.012, Completion: 0.018, Reasoning: 0.012},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := classCost(tt.tier, usage)
+			if !closeCosts(got, tt.want) {
+				t.Fatalf("classCost = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildRecordStoresCostByClass(t *testing.T) {
+	price := func(v float64) *float64 { return &v }
+	p := newCatalogPricing([]models.ModelPrice{{
+		Model: "o1", PromptPricePer1K: 0.03, CompletionPricePer1K: 0.06,
+		ClassPrices: models.ClassPrices{CachedPromptPricePer1K: price(0.015), AudioPricePer1K: 1},
+	}}, []models.RateCard{{
+		OrgID: "org-1", Version: 1,
+		Overrides: []models.RateOverride{{Model: "o1", Multiplier: 0.5}},
+	}})
+	s := &Service{config: &config.Config{}, logger: zap.NewNop(), pricingService: p, modelRegistry: newTestRegistry(t, nil)}
+
+	response := &models.LLMResponseData{
+		RequestID: "req-1", OrganizationID: "org-1", UserID: "user-1", Model: "o1", Timestamp: time.Now(),
+		Usage: &models.Usage{PromptTokens: 1000, CompletionTokens: 500, TotalTokens: 1500, CachedPromptTokens: 400, ReasoningTokens: 200, AudioTokens: 20},
+	}
+	record, err := s.PreviewRecord(context.Background(), nil, response)
+	if err != nil {
+		t.Fatalf("PreviewRecord: %v", err)
+	}
+
+	if record.CachedPromptTokens != 400 || record.ReasoningTokens != 200 || record.AudioTokens != 20 {
+		t.Fatalf("record classes = %d/%d/%d, want 400/200/20", record.CachedPromptTokens, record.ReasoningTokens, record.AudioTokens)
+	}
+	// The rate card multiplier scales class prices too
+	want := models.CostByClass{Prompt: 0.009, CachedPrompt: 0.003, Completion: 0.009, Reasoning: 0.006, Audio: 0.01}
+	if record.CostByClass == nil || !closeCosts(*record.CostByClass, want) {
+		t.Fatalf("cost by class = %+v, want %+v", record.CostByClass, want)
+	}
+	if diff := record.Cost - want.Total(); diff > 1e-9 || diff < -1e-9 {
+		t.Fatalf("cost = %v, want the class total %v", record.Cost, want.Total())
+	}
+}
+
+func closeCosts(a, b models.CostByClass) bool {
+	near := func(x, y float64) bool { return x-y < 1e-9 && y-x < 1e-9 }
+	return near(a.Prompt, b.Prompt) && near(a.CachedPrompt, b.CachedPrompt) && near(a.Completion, b.Completion) &&
+		near(a.Reasoning, b.Reasoning) && near(a.Image, b.Image) && near(a.Audio, b.Audio)
+}
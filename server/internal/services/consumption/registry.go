@@ -0,0 +1,168 @@
+package consumption
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"freedom-ai/management-server/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+// defaultAliases seed an empty model_aliases collection
+var defaultAliases = []models.ModelAlias{
+	{Pattern: `^azure/(.+)$`, Regex: true, CanonicalModel: "$1", Provider: "azure", Priority: 100},
+	{Pattern: `^openai/(.+)$`, Regex: true, CanonicalModel: "$1", Provider: "openai", Priority: 100},
+	{Pattern: `^(gpt-4)-\d{4}$`, Regex: true, CanonicalModel: "$1", Provider: "openai", Priority: 10},
+	{Pattern: `^(gpt-4-turbo)-\d{4}-\d{2}-\d{2}$`, Regex: true, CanonicalModel: "$1", Provider: "openai", Priority: 10},
+	{Pattern: `^(gpt-3\.5-turbo(?:-16k)?)-\d{4}$`, Regex: true, CanonicalModel: "$1", Provider: "openai", Priority: 10},
+	{Pattern: `^gpt-.+$`, Regex: true, CanonicalModel: "$0", Provider: "openai", Priority: 0},
+}
+
+type compiledAlias struct {
+	alias models.ModelAlias
+	re    *regexp.Regexp
+}
+
+// ModelRegistry resolves raw model names to canonical models and providers
+// from the model_aliases collection. Like the price catalog, it is cached for
+// priceCacheTTL.
+type ModelRegistry struct {
+	db     *mongo.Database
+	logger *zap.Logger
+
+	mu       sync.RWMutex
+	exact    map[string]models.ModelAlias
+	patterns []compiledAlias
+	loadedAt time.Time
+}
+
+func NewModelRegistry(db *mongo.Database, logger *zap.Logger) *ModelRegistry {
+	return &ModelRegistry{db: db, logger: logger}
+}
+
+// maxAliasDepth bounds alias chains, so aliases that map to each other cannot loop
+const maxAliasDepth = 8
+
+// Resolve returns the canonical model and provider for a raw model name.
+// Aliases are applied until the name stops changing, so azure/gpt-4-0613
+// resolves through gpt-4-0613 to gpt-4; the provider is the first one an
+// alias in the chain names. Unknown names are returned unchanged with an
+// empty provider.
+func (r *ModelRegistry) Resolve(raw string) (string, string) {
+	r.refresh()
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	model, provider := raw, ""
+	for i := 0; i < maxAliasDepth; i++ {
+		canonical, aliasProvider, ok := r.resolveOnce(model)
+		if !ok {
+			break
+		}
+		if provider == "" {
+			provider = aliasProvider
+		}
+		if canonical == model {
+			break
+		}
+		model = canonical
+	}
+	return model, provider
+}
+
+// resolveOnce applies the first alias matching name. Callers hold r.mu.
+func (r *ModelRegistry) resolveOnce(name string) (string, string, bool) {
+	if alias, ok := r.exact[strings.ToLower(name)]; ok {
+		return alias.CanonicalModel, alias.Provider, true
+	}
+	for _, p := range r.patterns {
+		if match := p.re.FindStringSubmatchIndex(name); match != nil {
+			canonical := string(p.re.ExpandString(nil, p.alias.CanonicalModel, name, match))
+			return canonical, p.alias.Provider, true
+		}
+	}
+	return "", "", false
+}
+
+// refresh reloads the aliases once the cache has expired. On failure the
+// previous aliases stay in use.
+func (r *ModelRegistry) refresh() {
+	r.mu.RLock()
+	fresh := r.exact != nil && time.Since(r.loadedAt) < priceCacheTTL
+	r.mu.RUnlock()
+	if fresh || r.db == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := r.db.Collection("model_aliases").Find(ctx, bson.M{})
+	if err != nil {
+		r.logger.Warn("Failed to load model aliases", zap.Error(err))
+		return
+	}
+	var aliases []models.ModelAlias
+	if err := cursor.All(ctx, &aliases); err != nil {
+		r.logger.Warn("Failed to decode model aliases", zap.Error(err))
+		return
+	}
+
+	exact := make(map[string]models.ModelAlias)
+	var patterns []compiledAlias
+	for _, alias := range aliases {
+		if !alias.Regex {
+			exact[strings.ToLower(alias.Pattern)] = alias
+			continue
+		}
+		re, err := regexp.Compile(alias.Pattern)
+		if err != nil {
+			r.logger.Warn("Skipping invalid model alias pattern", zap.String("pattern", alias.Pattern), zap.Error(err))
+			continue
+		}
+		patterns = append(patterns, compiledAlias{alias: alias, re: re})
+	}
+	sort.SliceStable(patterns, func(i, j int) bool {
+		return patterns[i].alias.Priority > patterns[j].alias.Priority
+	})
+
+	r.mu.Lock()
+	r.exact = exact
+	r.patterns = patterns
+	r.loadedAt = time.Now()
+	r.mu.Unlock()
+}
+
+// Seed fills an empty model_aliases collection with defaultAliases
+func (r *ModelRegistry) Seed(ctx context.Context) error {
+	collection := r.db.Collection("model_aliases")
+	count, err := collection.CountDocuments(ctx, bson.M{})
+	if err != nil {
+		return fmt.Errorf("failed to count model aliases: %w", err)
+	}
+	if count > 0 {
+		return nil
+	}
+
+	now := time.Now()
+	docs := make([]interface{}, 0, len(defaultAliases))
+	for _, alias := range defaultAliases {
+		alias.CreatedAt = now
+		alias.UpdatedAt = now
+		docs = append(docs, alias)
+	}
+	if _, err := collection.InsertMany(ctx, docs); err != nil {
+		return fmt.Errorf("failed to seed model aliases: %w", err)
+	}
+
+	r.logger.Info("Seeded model aliases", zap.Int("aliases", len(docs)))
+	return nil
+}
@@ -0,0 +1,54 @@
+package consumption
+
+import (
+	"regexp"
+	"testing"
+
+	"freedom-ai/management-server/internal/models"
+
+	"go.uber.org/zap"
+)
+
+func newTestRegistry(t *testing.T, aliases []models.ModelAlias) *ModelRegistry {
+	t.Helper()
+	r := NewModelRegistry(nil, zap.NewNop())
+	r.exact = make(map[string]models.ModelAlias)
+	for _, alias := range aliases {
+		if !alias.Regex {
+			r.exact[alias.Pattern] = alias
+			continue
+		}
+		r.patterns = append(r.patterns, compiledAlias{alias: alias, re: regexp.MustCompile(alias.Pattern)})
+	}
+	return r
+}
+
+func TestResolveFollowsAliasChains(t *testing.T) {
+	r := newTestRegistry(t, append([]models.ModelAlias{
+		{Pattern: "my-deployment", CanonicalModel: "azure/gpt-4-0613", Provider: "internal"},
+		{Pattern: "loop-a", CanonicalModel: "loop-b"},
+		{Pattern: "loop-b", CanonicalModel: "loop-a"},
+	}, defaultAliases...))
+
+	tests := []struct {
+		raw, model, provider string
+	}{
+		{"azure/gpt-4-0613", "gpt-4", "azure"},
+		{"openai/gpt-3.5-turbo-16k-0613", "gpt-3.5-turbo-16k", "openai"},
+		{"gpt-4-0613", "gpt-4", "openai"},
+		{"gpt-4", "gpt-4", "openai"},
+		{"my-deployment", "gpt-4", "internal"},
+		{"claude-3-opus", "claude-3-opus", ""},
+	}
+	for _, tt := range tests {
+		model, provider := r.Resolve(tt.raw)
+		if model != tt.model || provider != tt.provider {
+			t.Errorf("Resolve(%q) = %q, %q; want %q, %q", tt.raw, model, provider, tt.model, tt.provider)
+		}
+	}
+
+	// Cyclic aliases stop after maxAliasDepth instead of looping
+	if model, _ := r.Resolve("loop-a"); model != "loop-a" && model != "loop-b" {
+		t.Errorf("Resolve(loop-a) = %q", model)
+	}
+}
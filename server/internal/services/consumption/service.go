@@ -2,22 +2,49 @@ package consumption
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
 	"freedom-ai/management-server/internal/config"
+	"freedom-ai/management-server/internal/metrics"
 	"freedom-ai/management-server/internal/models"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.uber.org/zap"
 )
 
+// PendingRequestsKey is a Redis sorted set of requestIds awaiting a response, scored by arrival time
+const PendingRequestsKey = "requests:pending"
+
+// RequestCacheKey is the Redis key holding a request until its response arrives
+func RequestCacheKey(requestID string) string {
+	return fmt.Sprintf("request:%s", requestID)
+}
+
+// BillableConditions are the $or conditions matching consumption records that
+// are charged to the organization's wallet
+func BillableConditions() []bson.M {
+	return []bson.M{
+		{"status": "complete"},
+		{"status": "request-only", "billable": true},
+	}
+}
+
+// IsBillable reports whether a record matches BillableConditions
+func IsBillable(record models.TokenConsumption) bool {
+	return record.Status == "complete" || (record.Status == "request-only" && record.Billable)
+}
+
 type Service struct {
-	db             *mongo.Database
-	config         *config.Config
-	logger         *zap.Logger
-	pricingService *PricingService
+	db              *mongo.Database
+	config          *config.Config
+	logger          *zap.Logger
+	pricingService  *PricingService
+	modelRegistry   *ModelRegistry
 	realtimeService *RealtimeService
 }
 
@@ -26,13 +53,87 @@ func NewService(db *mongo.Database, cfg *config.Config, logger *zap.Logger) *Ser
 		db:             db,
 		config:         cfg,
 		logger:         logger,
-		pricingService: NewPricingService(cfg),
+		pricingService: NewPricingService(cfg, db, logger),
+		modelRegistry:  NewModelRegistry(db, logger),
+	}
+}
+
+// SeedModelAliases fills an empty model alias registry with defaults
+func (s *Service) SeedModelAliases(ctx context.Context) error {
+	return s.modelRegistry.Seed(ctx)
+}
+
+// ResolveModel returns the canonical model and provider for a raw model name
+func (s *Service) ResolveModel(raw string) (string, string) {
+	return s.modelRegistry.Resolve(raw)
+}
+
+// SeedPriceCatalog prepares the model_prices and rate_cards collections, seeding the catalog from configuration when empty
+func (s *Service) SeedPriceCatalog(ctx context.Context) error {
+	return s.pricingService.SeedCatalog(ctx)
+}
+
+// EnsureIndexes creates the indexes ProcessConsumption relies on
+func (s *Service) EnsureIndexes(ctx context.Context) error {
+	collection := s.db.Collection("token_consumption")
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "requestId", Value: 1}},
+		Options: options.Index().
+			SetName("requestId_unique").
+			SetUnique(true).
+			// Records without a requestId cannot be deduplicated and must not collide
+			SetPartialFilterExpression(bson.M{"requestId": bson.M{"$gt": ""}}),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create requestId index: %w", err)
 	}
+	return nil
 }
 
 func (s *Service) ProcessConsumption(ctx context.Context, requestData *models.LLMRequestData, responseData *models.LLMResponseData) error {
+	record, err := s.BuildRecord(ctx, requestData, responseData)
+	if err != nil {
+		return err
+	}
+	return s.ProcessConsumptionBatch(ctx, []models.TokenConsumption{record})
+}
+
+// ProcessConsumptionBatch stores records built by BuildRecord with a single
+// unordered BulkWrite. Records whose requestId is already stored are skipped.
+func (s *Service) ProcessConsumptionBatch(ctx context.Context, records []models.TokenConsumption) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	// Store in MongoDB (at most once per requestId)
+	inserted, err := s.storeRecords(ctx, records)
+	if err != nil {
+		return err
+	}
+
+	for i, record := range records {
+		s.recordProcessed(ctx, record, inserted[i])
+	}
+
+	return nil
+}
+
+// BuildRecord pairs a request and response (either may be nil) into a priced
+// consumption record. Billable usage of tiered models advances the
+// organization's monthly tier position.
+func (s *Service) BuildRecord(ctx context.Context, requestData *models.LLMRequestData, responseData *models.LLMResponseData) (models.TokenConsumption, error) {
+	return s.buildRecord(ctx, requestData, responseData, true)
+}
+
+// PreviewRecord builds a record like BuildRecord without advancing tier positions
+func (s *Service) PreviewRecord(ctx context.Context, requestData *models.LLMRequestData, responseData *models.LLMResponseData) (models.TokenConsumption, error) {
+	return s.buildRecord(ctx, requestData, responseData, false)
+}
+
+func (s *Service) buildRecord(ctx context.Context, requestData *models.LLMRequestData, responseData *models.LLMResponseData, advance bool) (models.TokenConsumption, error) {
 	// Determine token counts (priority: usage.totalTokens > usage sum > estimated)
 	var totalTokens, promptTokens, completionTokens int
+	tokensEstimated := false
 
 	if responseData != nil && responseData.Usage != nil {
 		if responseData.Usage.TotalTokens > 0 {
@@ -46,17 +147,26 @@ func (s *Service) ProcessConsumption(ctx context.Context, requestData *models.LL
 		}
 	} else if requestData != nil && responseData != nil {
 		// Fallback to estimated counts
+		tokensEstimated = true
 		totalTokens = requestData.TokenCount + responseData.TokenCount
 		promptTokens = requestData.TokenCount
 		completionTokens = responseData.TokenCount
 	} else if responseData != nil {
+		tokensEstimated = true
 		totalTokens = responseData.TokenCount
 		completionTokens = responseData.TokenCount
 	} else if requestData != nil {
+		tokensEstimated = true
 		totalTokens = requestData.TokenCount
 		promptTokens = requestData.TokenCount
 	}
 
+	// Token classes are only known from the provider's usage report
+	var classes models.Usage
+	if responseData != nil && responseData.Usage != nil {
+		classes = *responseData.Usage
+	}
+
 	// Determine status
 	status := "complete"
 	if requestData == nil && responseData != nil {
@@ -65,6 +175,9 @@ func (s *Service) ProcessConsumption(ctx context.Context, requestData *models.LL
 		status = "request-only"
 	}
 
+	// Request-only records (no response ever arrived) are billed by policy
+	billable := status != "request-only" || s.config.RequestOnlyBillable
+
 	// Get organization ID and user ID
 	var orgID, userID string
 	if responseData != nil {
@@ -93,60 +206,136 @@ func (s *Service) ProcessConsumption(ctx context.Context, requestData *models.LL
 		projectID = conversationID
 	}
 
-	// Get model
-	model := ""
+	// Get model, normalised through the alias registry
+	rawModel := ""
 	if responseData != nil {
-		model = responseData.Model
+		rawModel = responseData.Model
 	} else if requestData != nil {
-		model = requestData.Model
+		rawModel = requestData.Model
+	}
+	model, provider := s.modelRegistry.Resolve(rawModel)
+
+	// Calculate cost from the prices valid when the request was made
+	timestamp := getTimestamp(responseData, requestData)
+	if model != rawModel && rawModel != "" {
+		// Keep pricing models the catalog only knows by their reported name
+		if _, ok := s.pricingService.PriceAt(model, timestamp); !ok {
+			if _, ok := s.pricingService.PriceAt(rawModel, timestamp); ok {
+				model = rawModel
+			}
+		}
+	}
+	requestID := getRequestID(requestData, responseData)
+	quote, err := s.pricingService.CalculateCost(ctx, PriceInput{
+		RequestID:          requestID,
+		OrgID:              orgID,
+		Model:              model,
+		AssistantType:      assistantType,
+		PromptTokens:       promptTokens,
+		CompletionTokens:   completionTokens,
+		CachedPromptTokens: classes.CachedPromptTokens,
+		ReasoningTokens:    classes.ReasoningTokens,
+		ImageTokens:        classes.ImageTokens,
+		AudioTokens:        classes.AudioTokens,
+		Timestamp:          timestamp,
+	}, advance && billable)
+	if err != nil {
+		return models.TokenConsumption{}, fmt.Errorf("failed to price record %s: %w", requestID, err)
+	}
+	if quote.Unpriced {
+		metrics.Ingestion.Add("unpriced_records", 1)
+		s.logger.Warn("No catalog price for model, recording zero cost",
+			zap.String("model", model),
+			zap.Time("timestamp", timestamp))
 	}
-
-	// Calculate cost
-	cost := s.pricingService.CalculateCost(model, promptTokens, completionTokens)
 
 	// Create consumption record
 	record := models.TokenConsumption{
-		ID:         primitive.NewObjectID(),
-		RequestID:  getRequestID(requestData, responseData),
-		Timestamp:  getTimestamp(responseData, requestData),
-		UserID:     userID,
-		OrgID:      orgID,
-		AssistantType: assistantType,
-		DocumentID:    documentID,
-		ConversationID: conversationID,
-		ProjectID:     projectID,
-		Model:         model,
-		RequestTimestamp:      getRequestTimestamp(requestData),
-		RequestCharacterCount: getRequestCharacterCount(requestData),
-		RequestTokenCount:     getRequestTokenCount(requestData),
-		RequestType:           getRequestType(requestData),
-		ToolCount:             getToolCount(requestData),
-		MessageCount:          getMessageCount(requestData),
+		ID:                     primitive.NewObjectID(),
+		RequestID:              requestID,
+		Timestamp:              timestamp,
+		UserID:                 userID,
+		OrgID:                  orgID,
+		AssistantType:          assistantType,
+		DocumentID:             documentID,
+		ConversationID:         conversationID,
+		ProjectID:              projectID,
+		Model:                  model,
+		RawModel:               rawModel,
+		Provider:               provider,
+		RequestTimestamp:       getRequestTimestamp(requestData),
+		RequestCharacterCount:  getRequestCharacterCount(requestData),
+		RequestTokenCount:      getRequestTokenCount(requestData),
+		RequestType:            getRequestType(requestData),
+		ToolCount:              getToolCount(requestData),
+		MessageCount:           getMessageCount(requestData),
 		ResponseTimestamp:      getResponseTimestamp(responseData),
 		ResponseCharacterCount: getResponseCharacterCount(responseData),
 		ResponseTokenCount:     getResponseTokenCount(responseData),
-		PromptTokens:          promptTokens,
-		CompletionTokens:      completionTokens,
-		TotalTokens:           totalTokens,
-		FinishReason:          getFinishReason(responseData),
-		ResponseTimeMs:        getResponseTimeMs(responseData),
-		HasToolCalls:          getHasToolCalls(responseData),
-		ToolCallCount:         getToolCallCount(responseData),
-		Cost:                  cost,
-		Status:                status,
-		CreatedAt:             time.Now(),
-	}
-
-	// Store in MongoDB
-	collection := s.db.Collection("token_consumption")
-	_, err := collection.InsertOne(ctx, record)
-	if err != nil {
-		return fmt.Errorf("failed to insert consumption record: %w", err)
+		PromptTokens:           promptTokens,
+		CompletionTokens:       completionTokens,
+		TotalTokens:            totalTokens,
+		TokensEstimated:        tokensEstimated,
+		CachedPromptTokens:     classes.CachedPromptTokens,
+		ReasoningTokens:        classes.ReasoningTokens,
+		ImageTokens:            classes.ImageTokens,
+		AudioTokens:            classes.AudioTokens,
+		FinishReason:           getFinishReason(responseData),
+		ResponseTimeMs:         getResponseTimeMs(responseData),
+		HasToolCalls:           getHasToolCalls(responseData),
+		ToolCallCount:          getToolCallCount(responseData),
+		Cost:                   quote.Cost,
+		PriceID:                quote.PriceID,
+		RateCardID:             quote.RateCardID,
+		RateCardVersion:        quote.RateCardVersion,
+		TierCharges:            quote.TierCharges,
+		CostByClass:            quote.costByClass(),
+		Unpriced:               quote.Unpriced,
+		Billable:               billable,
+		RequestContent:         getRequestContent(requestData),
+		ResponseContent:        getResponseContent(responseData),
+		Status:                 status,
+		CreatedAt:              time.Now(),
+	}
+
+	return record, nil
+}
+
+// Reprice prices a stored record again, under price and card when given and
+// otherwise under the catalog and rate card valid at its timestamp. Tier
+// positions are reused, never advanced.
+func (s *Service) Reprice(ctx context.Context, record models.TokenConsumption, price *models.ModelPrice, card *models.RateCard) (Quote, error) {
+	return s.pricingService.CalculateCost(ctx, PriceInput{
+		RequestID:          record.RequestID,
+		OrgID:              record.OrgID,
+		Model:              record.Model,
+		AssistantType:      record.AssistantType,
+		PromptTokens:       record.PromptTokens,
+		CompletionTokens:   record.CompletionTokens,
+		CachedPromptTokens: record.CachedPromptTokens,
+		ReasoningTokens:    record.ReasoningTokens,
+		ImageTokens:        record.ImageTokens,
+		AudioTokens:        record.AudioTokens,
+		Timestamp:          record.Timestamp,
+		Price:              price,
+		RateCard:           card,
+	}, false)
+}
+
+// recordProcessed updates metrics and real-time counters for a stored record
+func (s *Service) recordProcessed(ctx context.Context, record models.TokenConsumption, inserted bool) {
+	if !inserted {
+		metrics.Ingestion.Add("duplicates", 1)
+		s.logger.Info("Skipped duplicate consumption record",
+			zap.String("requestId", record.RequestID),
+			zap.String("orgId", record.OrgID))
+		return
 	}
+	metrics.Ingestion.Add("processed", 1)
 
 	// Update real-time counters if service is available and record is complete
-	if s.realtimeService != nil && status == "complete" && orgID != "" && userID != "" && totalTokens > 0 {
-		if err := s.realtimeService.UpdateRealTimeCounters(ctx, orgID, userID, int64(totalTokens)); err != nil {
+	if s.realtimeService != nil && record.Status == "complete" && record.OrgID != "" && record.UserID != "" && record.TotalTokens > 0 {
+		if err := s.realtimeService.UpdateRealTimeCounters(ctx, record.OrgID, record.UserID, int64(record.TotalTokens)); err != nil {
 			s.logger.Warn("Failed to update real-time counters",
 				zap.String("requestId", record.RequestID),
 				zap.Error(err))
@@ -155,11 +344,104 @@ func (s *Service) ProcessConsumption(ctx context.Context, requestData *models.LL
 
 	s.logger.Info("Processed consumption record",
 		zap.String("requestId", record.RequestID),
-		zap.String("orgId", orgID),
-		zap.Int("totalTokens", totalTokens),
-		zap.Float64("cost", cost))
+		zap.String("orgId", record.OrgID),
+		zap.Int("totalTokens", record.TotalTokens),
+		zap.Float64("cost", record.Cost))
+}
 
-	return nil
+// storeRecords upserts the records keyed on requestId. For each record it reports
+// false when a record with the same requestId already exists, e.g. after a
+// redelivery, unless the existing record is a request-only placeholder that
+// this record completes.
+func (s *Service) storeRecords(ctx context.Context, records []models.TokenConsumption) ([]bool, error) {
+	collection := s.db.Collection("token_consumption")
+
+	writes := make([]mongo.WriteModel, len(records))
+	for i, record := range records {
+		if record.RequestID == "" {
+			writes[i] = mongo.NewInsertOneModel().SetDocument(record)
+			continue
+		}
+		writes[i] = mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"requestId": record.RequestID}).
+			SetUpdate(bson.M{"$setOnInsert": record}).
+			SetUpsert(true)
+	}
+
+	result, err := collection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+	failed := make(map[int]bool)
+	if err != nil {
+		// Concurrent upserts of the same requestId lose on the unique index; those are duplicates
+		var bulkErr mongo.BulkWriteException
+		if !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil {
+			return nil, fmt.Errorf("failed to write consumption records: %w", err)
+		}
+		for _, writeErr := range bulkErr.WriteErrors {
+			if !mongo.IsDuplicateKeyError(writeErr) {
+				return nil, fmt.Errorf("failed to write consumption records: %w", err)
+			}
+			failed[writeErr.Index] = true
+		}
+	}
+
+	inserted := make([]bool, len(records))
+	for i, record := range records {
+		if record.RequestID == "" {
+			inserted[i] = !failed[i]
+		}
+	}
+	if result != nil {
+		for index := range result.UpsertedIDs {
+			inserted[index] = true
+		}
+	}
+
+	// A late response supersedes the request-only record written by the orphan sweeper
+	for i, record := range records {
+		if inserted[i] || record.RequestID == "" || record.Status == "request-only" {
+			continue
+		}
+		record.ID = primitive.NilObjectID
+		replaced, err := collection.ReplaceOne(
+			ctx,
+			bson.M{"requestId": record.RequestID, "status": "request-only"},
+			record,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to replace request-only record: %w", err)
+		}
+		if replaced.ModifiedCount > 0 {
+			metrics.Ingestion.Add("request_only_superseded", 1)
+			inserted[i] = true
+		}
+	}
+
+	return inserted, nil
+}
+
+// ReplaceRecords upserts records by requestId, overwriting any stored record.
+// It is used by archive replay and does not update real-time counters.
+func (s *Service) ReplaceRecords(ctx context.Context, records []models.TokenConsumption) (inserted, replaced int64, err error) {
+	var writes []mongo.WriteModel
+	for _, record := range records {
+		if record.RequestID == "" {
+			continue
+		}
+		record.ID = primitive.NilObjectID
+		writes = append(writes, mongo.NewReplaceOneModel().
+			SetFilter(bson.M{"requestId": record.RequestID}).
+			SetReplacement(record).
+			SetUpsert(true))
+	}
+	if len(writes) == 0 {
+		return 0, 0, nil
+	}
+
+	result, err := s.db.Collection("token_consumption").BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to replace consumption records: %w", err)
+	}
+	return result.UpsertedCount, result.ModifiedCount, nil
 }
 
 // SetRealtimeService sets the realtime service for updating counters
@@ -279,3 +561,16 @@ func getToolCallCount(resp *models.LLMResponseData) int {
 	return 0
 }
 
+func getRequestContent(req *models.LLMRequestData) *models.RetainedContent {
+	if req != nil {
+		return req.RetainedContent
+	}
+	return nil
+}
+
+func getResponseContent(resp *models.LLMResponseData) *models.RetainedContent {
+	if resp != nil {
+		return resp.RetainedContent
+	}
+	return nil
+}
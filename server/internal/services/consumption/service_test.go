@@ -0,0 +1,130 @@
+package consumption
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"freedom-ai/management-server/internal/config"
+	"freedom-ai/management-server/internal/database/databasetest"
+	"freedom-ai/management-server/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.uber.org/zap"
+)
+
+func newTestService(t *testing.T) *Service {
+	t.Helper()
+	ctx := context.Background()
+	db := databasetest.Connect(t)
+	s := NewService(db, &config.Config{}, zap.NewNop())
+	if err := s.EnsureIndexes(ctx); err != nil {
+		t.Fatalf("EnsureIndexes: %v", err)
+	}
+	if err := s.SeedPriceCatalog(ctx); err != nil {
+		t.Fatalf("SeedPriceCatalog: %v", err)
+	}
+	return s
+}
+
+func testPair(requestID string) (*models.LLMRequestData, *models.LLMResponseData) {
+	at := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	request := &models.LLMRequestData{
+		RequestID:      requestID,
+		Timestamp:      at,
+		UserID:         "user-1",
+		OrganizationID: "org-1",
+		Model:          "gpt-4",
+	}
+	response := &models.LLMResponseData{
+		RequestID:      requestID,
+		Timestamp:      at.Add(time.Second),
+		UserID:         "user-1",
+		OrganizationID: "org-1",
+		Model:          "gpt-4",
+		Usage:          &models.Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15},
+	}
+	return request, response
+}
+
+func TestProcessConsumptionStoresEachRequestOnce(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+	request, response := testPair("req-1")
+
+	// A redelivery after the record was stored
+	for i := 0; i < 2; i++ {
+		if err := s.ProcessConsumption(ctx, request, response); err != nil {
+			t.Fatalf("ProcessConsumption: %v", err)
+		}
+	}
+
+	// Concurrent redeliveries race on the unique index
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.ProcessConsumption(ctx, request, response); err != nil {
+				t.Errorf("ProcessConsumption: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	count, err := s.db.Collection("token_consumption").CountDocuments(ctx, bson.M{"requestId": "req-1"})
+	if err != nil {
+		t.Fatalf("count records: %v", err)
+	}
+	if count != 1 {
+		t.Fatalf("stored %d records for one request, want 1", count)
+	}
+}
+
+func TestStoreRecordsReportsDuplicatesInBatch(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+
+	first, _ := testPair("req-1")
+	second, _ := testPair("req-2")
+	records := make([]models.TokenConsumption, 0, 4)
+	for _, request := range []*models.LLMRequestData{first, second, first} {
+		record, err := s.PreviewRecord(ctx, request, nil)
+		if err != nil {
+			t.Fatalf("PreviewRecord: %v", err)
+		}
+		records = append(records, record)
+	}
+	// Records without a requestId cannot be deduplicated and are all kept
+	anonymous, err := s.PreviewRecord(ctx, &models.LLMRequestData{OrganizationID: "org-1", Model: "gpt-4"}, nil)
+	if err != nil {
+		t.Fatalf("PreviewRecord: %v", err)
+	}
+	records = append(records, anonymous, anonymous)
+
+	inserted, err := s.storeRecords(ctx, records)
+	if err != nil {
+		t.Fatalf("storeRecords: %v", err)
+	}
+	var stored int
+	for _, ok := range inserted {
+		if ok {
+			stored++
+		}
+	}
+	if stored != 4 {
+		t.Fatalf("inserted = %v, want 4 records stored", inserted)
+	}
+	if inserted[0] == inserted[2] {
+		t.Fatalf("inserted = %v, want one of the two req-1 copies stored", inserted)
+	}
+
+	count, err := s.db.Collection("token_consumption").CountDocuments(ctx, bson.M{})
+	if err != nil {
+		t.Fatalf("count records: %v", err)
+	}
+	if count != 4 {
+		t.Fatalf("stored %d records, want 4", count)
+	}
+}
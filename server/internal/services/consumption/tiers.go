@@ -0,0 +1,164 @@
+package consumption
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"freedom-ai/management-server/internal/database"
+	"freedom-ai/management-server/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// tierAllocation records where a request's tokens were placed in a monthly
+// tier position, so redeliveries and rebuilt records keep their tier
+type tierAllocation struct {
+	RequestID string    `bson:"_id"`
+	OrgID     string    `bson:"orgId"`
+	Model     string    `bson:"model"`
+	Month     string    `bson:"month"`
+	Start     int64     `bson:"start"`
+	Tokens    int64     `bson:"tokens"`
+	CreatedAt time.Time `bson:"createdAt"`
+}
+
+// TierMonth is the tier_usage month key for t: the billing cycle containing t
+// in the organization's calendar, named after the local month it starts in
+func TierMonth(calendar models.BillingCalendar, t time.Time) string {
+	return calendar.CycleStart(t).Format("2006-01")
+}
+
+// errAllocated reports that a concurrent delivery of the same request
+// allocated its tier position first
+var errAllocated = errors.New("tier position already allocated")
+
+// position returns where the usage starts in the organization's monthly
+// position for the model. With advance the usage is added to the position;
+// without it nothing is written. The position advances at most once per
+// request: the counter and the request's allocation are written in one
+// transaction, so a crash or redelivery never counts the same tokens twice.
+func (p *PricingService) position(ctx context.Context, in PriceInput, tokens int64, advance bool) (int64, error) {
+	month := TierMonth(p.CalendarOf(in.OrgID), in.Timestamp)
+	counterFilter := bson.M{"orgId": in.OrgID, "model": in.Model, "month": month}
+	counters := p.db.Collection("tier_usage")
+
+	if !advance {
+		allocation, err := p.allocation(ctx, in.RequestID)
+		if err != nil {
+			return 0, err
+		}
+		if allocation != nil {
+			return allocation.Start, nil
+		}
+		var usage models.TierUsage
+		err = counters.FindOne(ctx, counterFilter).Decode(&usage)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return 0, fmt.Errorf("failed to load tier usage: %w", err)
+		}
+		return usage.Tokens, nil
+	}
+
+	var start int64
+	err := database.Transact(ctx, p.db, p.logger, func(ctx context.Context) error {
+		allocation, err := p.allocation(ctx, in.RequestID)
+		if err != nil {
+			return err
+		}
+		if allocation != nil {
+			start = allocation.Start
+			if allocation.Tokens == tokens {
+				return nil
+			}
+			// The record was rebuilt with a different token count (e.g. a
+			// request-only placeholder completed by its response)
+			if err := p.advance(ctx, bson.M{"orgId": allocation.OrgID, "model": allocation.Model, "month": allocation.Month}, tokens-allocation.Tokens); err != nil {
+				return err
+			}
+			if _, err := p.db.Collection("tier_allocations").UpdateOne(ctx, bson.M{"_id": in.RequestID}, bson.M{"$set": bson.M{"tokens": tokens}}); err != nil {
+				return fmt.Errorf("failed to update tier allocation: %w", err)
+			}
+			return nil
+		}
+
+		var usage models.TierUsage
+		err = counters.FindOneAndUpdate(ctx, counterFilter,
+			bson.M{"$inc": bson.M{"tokens": tokens}, "$set": bson.M{"updatedAt": time.Now()}},
+			options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+		).Decode(&usage)
+		if err != nil {
+			return fmt.Errorf("failed to advance tier usage: %w", err)
+		}
+		start = usage.Tokens - tokens
+
+		if in.RequestID == "" {
+			return nil
+		}
+		_, err = p.db.Collection("tier_allocations").InsertOne(ctx, tierAllocation{
+			RequestID: in.RequestID,
+			OrgID:     in.OrgID,
+			Model:     in.Model,
+			Month:     month,
+			Start:     start,
+			Tokens:    tokens,
+			CreatedAt: time.Now(),
+		})
+		if mongo.IsDuplicateKeyError(err) {
+			// Without a transaction our increment has to be undone by hand
+			if !database.InTransaction(ctx) {
+				if err := p.advance(ctx, counterFilter, -tokens); err != nil {
+					return err
+				}
+			}
+			return errAllocated
+		}
+		if err != nil {
+			return fmt.Errorf("failed to store tier allocation: %w", err)
+		}
+		return nil
+	})
+	if errors.Is(err, errAllocated) {
+		allocation, err := p.allocation(ctx, in.RequestID)
+		if err != nil {
+			return 0, err
+		}
+		if allocation == nil {
+			return 0, errors.New("tier allocation disappeared after a concurrent insert")
+		}
+		return allocation.Start, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return start, nil
+}
+
+// allocation returns the request's tier allocation, or nil if it has none
+func (p *PricingService) allocation(ctx context.Context, requestID string) (*tierAllocation, error) {
+	if requestID == "" {
+		return nil, nil
+	}
+	var allocation tierAllocation
+	err := p.db.Collection("tier_allocations").FindOne(ctx, bson.M{"_id": requestID}).Decode(&allocation)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tier allocation: %w", err)
+	}
+	return &allocation, nil
+}
+
+func (p *PricingService) advance(ctx context.Context, filter bson.M, tokens int64) error {
+	_, err := p.db.Collection("tier_usage").UpdateOne(ctx, filter,
+		bson.M{"$inc": bson.M{"tokens": tokens}, "$set": bson.M{"updatedAt": time.Now()}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to adjust tier usage: %w", err)
+	}
+	return nil
+}
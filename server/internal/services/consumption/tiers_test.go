@@ -0,0 +1,94 @@
+package consumption
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"freedom-ai/management-server/internal/config"
+	"freedom-ai/management-server/internal/database/databasetest"
+	"freedom-ai/management-server/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.uber.org/zap"
+)
+
+func TestPositionAdvancesOncePerRequest(t *testing.T) {
+	ctx := context.Background()
+	db := databasetest.Connect(t)
+	p := NewPricingService(&config.Config{}, db, zap.NewNop())
+	if err := p.SeedCatalog(ctx); err != nil {
+		t.Fatalf("SeedCatalog: %v", err)
+	}
+
+	in := PriceInput{RequestID: "req-1", OrgID: "org-1", Model: "gpt-4", Timestamp: time.Now()}
+	const tokens = 1000
+
+	// Concurrent redeliveries of the same request share one allocation
+	starts := make([]int64, 8)
+	var wg sync.WaitGroup
+	for i := range starts {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			start, err := p.position(ctx, in, tokens, true)
+			if err != nil {
+				t.Errorf("position: %v", err)
+			}
+			starts[i] = start
+		}(i)
+	}
+	wg.Wait()
+	for _, start := range starts {
+		if start != 0 {
+			t.Fatalf("redelivery started at %d, want 0", start)
+		}
+	}
+
+	var usage models.TierUsage
+	filter := bson.M{"orgId": in.OrgID, "model": in.Model, "month": TierMonth(models.UTCCalendar, in.Timestamp)}
+	if err := db.Collection("tier_usage").FindOne(ctx, filter).Decode(&usage); err != nil {
+		t.Fatalf("load tier usage: %v", err)
+	}
+	if usage.Tokens != tokens {
+		t.Fatalf("tier usage = %d, want %d", usage.Tokens, tokens)
+	}
+
+	next := in
+	next.RequestID = "req-2"
+	start, err := p.position(ctx, next, tokens, true)
+	if err != nil {
+		t.Fatalf("position: %v", err)
+	}
+	if start != tokens {
+		t.Fatalf("next request started at %d, want %d", start, tokens)
+	}
+}
+
+func TestTierMonthFollowsBillingCycle(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("timezone data unavailable: %v", err)
+	}
+	calendar := models.BillingCalendar{Location: berlin, AnchorDay: 15}
+
+	tests := []struct {
+		at   time.Time
+		want string
+	}{
+		// 23:30 UTC on May 14 is already May 15 in Berlin, so the May cycle
+		{time.Date(2024, 5, 14, 23, 30, 0, 0, time.UTC), "2024-05"},
+		{time.Date(2024, 5, 14, 21, 0, 0, 0, time.UTC), "2024-04"},
+		{time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC), "2024-05"},
+		{time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC), "2023-12"},
+	}
+	for _, tt := range tests {
+		if got := TierMonth(calendar, tt.at); got != tt.want {
+			t.Errorf("TierMonth(%s) = %s, want %s", tt.at, got, tt.want)
+		}
+	}
+	if got := TierMonth(models.UTCCalendar, time.Date(2024, 5, 31, 23, 59, 0, 0, time.UTC)); got != "2024-05" {
+		t.Errorf("UTC TierMonth = %s, want 2024-05", got)
+	}
+}
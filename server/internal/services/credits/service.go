@@ -0,0 +1,457 @@
+package credits
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"freedom-ai/management-server/internal/database"
+	"freedom-ai/management-server/internal/models"
+	"freedom-ai/management-server/internal/services/ledger"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+// exhaustedBelow treats floating point leftovers as an exhausted grant
+const exhaustedBelow = 1e-9
+
+var (
+	// ErrPromoNotFound is returned for unknown or deactivated promo codes
+	ErrPromoNotFound = errors.New("promo code not found")
+	// ErrPromoExpired is returned for promo codes past their validity
+	ErrPromoExpired = errors.New("promo code has expired")
+	// ErrPromoExhausted is returned once a promo code reached its redemption limit
+	ErrPromoExhausted = errors.New("promo code redemption limit reached")
+	// ErrAlreadyRedeemed is returned when the organization already redeemed the code
+	ErrAlreadyRedeemed = errors.New("promo code already redeemed by this organization")
+)
+
+type Service struct {
+	db     *mongo.Database
+	logger *zap.Logger
+	ledger *ledger.Service
+}
+
+func NewService(db *mongo.Database, logger *zap.Logger) *Service {
+	return &Service{
+		db:     db,
+		logger: logger,
+		ledger: ledger.NewService(db, logger),
+	}
+}
+
+// EnsureIndexes creates the credit grant and promo code indexes
+func (s *Service) EnsureIndexes(ctx context.Context) error {
+	if _, err := s.db.Collection("credit_grants").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "orgId", Value: 1}, {Key: "status", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "expiresAt", Value: 1}}},
+	}); err != nil {
+		return fmt.Errorf("failed to create credit_grants indexes: %w", err)
+	}
+	if _, err := s.db.Collection("promo_codes").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "code", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}); err != nil {
+		return fmt.Errorf("failed to create promo_codes indexes: %w", err)
+	}
+	if _, err := s.db.Collection("promo_redemptions").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "code", Value: 1}, {Key: "orgId", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}); err != nil {
+		return fmt.Errorf("failed to create promo_redemptions indexes: %w", err)
+	}
+	return nil
+}
+
+// ValidateGrant checks a credit grant before it is issued
+func ValidateGrant(grant models.CreditGrant) error {
+	if grant.Amount <= 0 {
+		return fmt.Errorf("amount must be positive")
+	}
+	switch grant.Source {
+	case models.CreditSourceTrial, models.CreditSourcePromo, models.CreditSourceManual:
+	default:
+		return fmt.Errorf("source must be one of: %s, %s, %s", models.CreditSourceTrial, models.CreditSourcePromo, models.CreditSourceManual)
+	}
+	if grant.ExpiresAt != nil && !grant.ExpiresAt.After(time.Now()) {
+		return fmt.Errorf("expiresAt must be in the future")
+	}
+	return nil
+}
+
+// Grant issues a credit grant and credits its amount to the wallet
+func (s *Service) Grant(ctx context.Context, grant models.CreditGrant) (*models.CreditGrant, error) {
+	if err := ValidateGrant(grant); err != nil {
+		return nil, err
+	}
+	if grant.ID.IsZero() {
+		grant.ID = primitive.NewObjectID()
+	}
+	grant.Remaining = grant.Amount
+	grant.Status = models.CreditGrantActive
+	grant.Draws = nil
+	grant.CreatedAt = time.Now()
+	grant.UpdatedAt = grant.CreatedAt
+
+	description := grant.Description
+	if description == "" {
+		description = "Credit grant (" + grant.Source + ")"
+	}
+	err := database.Transact(ctx, s.db, s.logger, func(ctx context.Context) error {
+		if _, err := s.db.Collection("credit_grants").InsertOne(ctx, grant); err != nil {
+			return fmt.Errorf("failed to store credit grant: %w", err)
+		}
+		_, _, err := s.ledger.Post(ctx, models.LedgerEntry{
+			OrgID:       grant.OrgID,
+			Type:        models.LedgerPromo,
+			Amount:      grant.Amount,
+			Reference:   "grant:" + grant.ID.Hex(),
+			Description: description,
+		})
+		if err != nil {
+			if !database.InTransaction(ctx) {
+				s.db.Collection("credit_grants").DeleteOne(ctx, bson.M{"_id": grant.ID})
+			}
+			return fmt.Errorf("failed to credit grant: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("Issued credit grant",
+		zap.String("orgId", grant.OrgID),
+		zap.String("source", grant.Source),
+		zap.Float64("amount", grant.Amount))
+	return &grant, nil
+}
+
+// Consume draws up to amount from an organization's active grants, highest
+// priority first, then soonest expiring, then oldest. The wallet itself is
+// debited by the caller; grants only track how much of the balance is
+// promotional. Draws are recorded under reference, so consuming again for
+// the same reference returns what was drawn before.
+func (s *Service) Consume(ctx context.Context, orgID string, amount float64, reference string) (float64, error) {
+	if amount <= 0 {
+		return 0, nil
+	}
+	collection := s.db.Collection("credit_grants")
+
+	previous, err := s.drawn(ctx, orgID, reference)
+	if err != nil || previous > 0 {
+		return previous, err
+	}
+
+	now := time.Now()
+	cursor, err := collection.Find(ctx, bson.M{
+		"orgId":     orgID,
+		"status":    models.CreditGrantActive,
+		"remaining": bson.M{"$gt": 0},
+		"$or":       bson.A{bson.M{"expiresAt": nil}, bson.M{"expiresAt": bson.M{"$gt": now}}},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to find credit grants: %w", err)
+	}
+	var grants []models.CreditGrant
+	if err := cursor.All(ctx, &grants); err != nil {
+		return 0, fmt.Errorf("failed to decode credit grants: %w", err)
+	}
+	sort.SliceStable(grants, func(i, j int) bool {
+		a, b := grants[i], grants[j]
+		if a.Priority != b.Priority {
+			return a.Priority > b.Priority
+		}
+		if (a.ExpiresAt == nil) != (b.ExpiresAt == nil) {
+			return a.ExpiresAt != nil
+		}
+		if a.ExpiresAt != nil && !a.ExpiresAt.Equal(*b.ExpiresAt) {
+			return a.ExpiresAt.Before(*b.ExpiresAt)
+		}
+		return a.CreatedAt.Before(b.CreatedAt)
+	})
+
+	left := amount
+	for _, grant := range grants {
+		if left <= 0 {
+			break
+		}
+		draw := grant.Remaining
+		if left < draw {
+			draw = left
+		}
+		remaining := grant.Remaining - draw
+		status := models.CreditGrantActive
+		if remaining < exhaustedBelow {
+			remaining = 0
+			status = models.CreditGrantExhausted
+		}
+		// Skip grants changed concurrently (e.g. by the expiry sweep)
+		result, err := collection.UpdateOne(ctx,
+			bson.M{"_id": grant.ID, "status": models.CreditGrantActive, "remaining": grant.Remaining},
+			bson.M{
+				"$set":  bson.M{"remaining": remaining, "status": status, "updatedAt": now},
+				"$push": bson.M{"draws": models.CreditDraw{Reference: reference, Amount: draw, DrawnAt: now}},
+			},
+		)
+		if err != nil {
+			return amount - left, fmt.Errorf("failed to draw credit grant: %w", err)
+		}
+		if result.ModifiedCount > 0 {
+			left -= draw
+		}
+	}
+	return amount - left, nil
+}
+
+// drawn sums what was drawn from an organization's grants under reference
+func (s *Service) drawn(ctx context.Context, orgID, reference string) (float64, error) {
+	cursor, err := s.db.Collection("credit_grants").Aggregate(ctx, []bson.M{
+		{"$match": bson.M{"orgId": orgID, "draws.reference": reference}},
+		{"$unwind": "$draws"},
+		{"$match": bson.M{"draws.reference": reference}},
+		{"$group": bson.M{"_id": nil, "amount": bson.M{"$sum": "$draws.amount"}}},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to sum credit draws: %w", err)
+	}
+	var results []struct {
+		Amount float64 `bson:"amount"`
+	}
+	if err := cursor.All(ctx, &results); err != nil {
+		return 0, fmt.Errorf("failed to decode credit draws: %w", err)
+	}
+	if len(results) == 0 {
+		return 0, nil
+	}
+	return results[0].Amount, nil
+}
+
+// Restore gives back what was drawn from an organization's grants under
+// reference, reactivating grants it exhausted, and returns the amount
+// restored. Draws from expired grants stay drawn. Restoring again is a no-op.
+func (s *Service) Restore(ctx context.Context, orgID, reference string) (float64, error) {
+	collection := s.db.Collection("credit_grants")
+	cursor, err := collection.Find(ctx, bson.M{
+		"orgId":           orgID,
+		"draws.reference": reference,
+		"status":          bson.M{"$in": bson.A{models.CreditGrantActive, models.CreditGrantExhausted}},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to find credit grants: %w", err)
+	}
+	var grants []models.CreditGrant
+	if err := cursor.All(ctx, &grants); err != nil {
+		return 0, fmt.Errorf("failed to decode credit grants: %w", err)
+	}
+
+	restored := 0.0
+	for _, grant := range grants {
+		amount := 0.0
+		for _, draw := range grant.Draws {
+			if draw.Reference == reference {
+				amount += draw.Amount
+			}
+		}
+		// Skip grants changed concurrently (e.g. by the expiry sweep)
+		result, err := collection.UpdateOne(ctx,
+			bson.M{"_id": grant.ID, "status": grant.Status, "remaining": grant.Remaining},
+			bson.M{
+				"$set":  bson.M{"remaining": grant.Remaining + amount, "status": models.CreditGrantActive, "updatedAt": time.Now()},
+				"$pull": bson.M{"draws": bson.M{"reference": reference}},
+			},
+		)
+		if err != nil {
+			return restored, fmt.Errorf("failed to restore credit grant: %w", err)
+		}
+		if result.ModifiedCount > 0 {
+			restored += amount
+		}
+	}
+	return restored, nil
+}
+
+// SweepExpired expires grants past their expiry and debits what was left of
+// them from the wallet
+func (s *Service) SweepExpired(ctx context.Context) error {
+	now := time.Now()
+	collection := s.db.Collection("credit_grants")
+	cursor, err := collection.Find(ctx, bson.M{
+		"status":    models.CreditGrantActive,
+		"expiresAt": bson.M{"$lte": now},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to find expired credit grants: %w", err)
+	}
+	var grants []models.CreditGrant
+	if err := cursor.All(ctx, &grants); err != nil {
+		return fmt.Errorf("failed to decode expired credit grants: %w", err)
+	}
+
+	for _, grant := range grants {
+		err := database.Transact(ctx, s.db, s.logger, func(ctx context.Context) error {
+			result, err := collection.UpdateOne(ctx,
+				bson.M{"_id": grant.ID, "status": models.CreditGrantActive, "remaining": grant.Remaining},
+				bson.M{"$set": bson.M{"status": models.CreditGrantExpired, "remaining": 0, "expiredAt": now, "updatedAt": now}},
+			)
+			if err != nil {
+				return fmt.Errorf("failed to expire credit grant: %w", err)
+			}
+			if result.ModifiedCount == 0 || grant.Remaining <= 0 {
+				return nil
+			}
+			_, _, err = s.ledger.Post(ctx, models.LedgerEntry{
+				OrgID:       grant.OrgID,
+				Type:        models.LedgerPromo,
+				Amount:      -grant.Remaining,
+				Reference:   "grant-expiry:" + grant.ID.Hex(),
+				Description: "Expired credit grant (" + grant.Source + ")",
+			})
+			return err
+		})
+		if err != nil {
+			s.logger.Error("Failed to expire credit grant", zap.String("grantId", grant.ID.Hex()), zap.Error(err))
+			continue
+		}
+		s.logger.Info("Expired credit grant",
+			zap.String("orgId", grant.OrgID),
+			zap.String("grantId", grant.ID.Hex()),
+			zap.Float64("remaining", grant.Remaining))
+	}
+	return nil
+}
+
+// ListGrants returns an organization's credit grants, newest first
+func (s *Service) ListGrants(ctx context.Context, orgID, status string) ([]models.CreditGrant, error) {
+	filter := bson.M{"orgId": orgID}
+	if status != "" {
+		filter["status"] = status
+	}
+	cursor, err := s.db.Collection("credit_grants").Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find credit grants: %w", err)
+	}
+	grants := []models.CreditGrant{}
+	if err := cursor.All(ctx, &grants); err != nil {
+		return nil, fmt.Errorf("failed to decode credit grants: %w", err)
+	}
+	return grants, nil
+}
+
+// NormalizeCode returns a promo code in its stored form
+func NormalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// CreatePromoCode stores a new promo code
+func (s *Service) CreatePromoCode(ctx context.Context, promo models.PromoCode) (*models.PromoCode, error) {
+	promo.Code = NormalizeCode(promo.Code)
+	if promo.Code == "" {
+		return nil, fmt.Errorf("code is required")
+	}
+	if promo.Amount <= 0 {
+		return nil, fmt.Errorf("amount must be positive")
+	}
+	if promo.ExpiresAfterDays < 0 || promo.MaxRedemptions < 0 {
+		return nil, fmt.Errorf("expiresAfterDays and maxRedemptions must not be negative")
+	}
+	promo.ID = primitive.NewObjectID()
+	promo.Redemptions = 0
+	promo.Active = true
+	promo.CreatedAt = time.Now()
+	promo.UpdatedAt = promo.CreatedAt
+	if _, err := s.db.Collection("promo_codes").InsertOne(ctx, promo); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, fmt.Errorf("promo code %s already exists", promo.Code)
+		}
+		return nil, fmt.Errorf("failed to store promo code: %w", err)
+	}
+	return &promo, nil
+}
+
+// Redeem grants a promo code's credit to an organization. Each organization
+// can redeem a code once, and a code stops working at its redemption limit.
+func (s *Service) Redeem(ctx context.Context, orgID, code, userID string) (*models.CreditGrant, error) {
+	code = NormalizeCode(code)
+	var grant *models.CreditGrant
+	err := database.Transact(ctx, s.db, s.logger, func(ctx context.Context) error {
+		var promo models.PromoCode
+		err := s.db.Collection("promo_codes").FindOne(ctx, bson.M{"code": code, "active": true}).Decode(&promo)
+		if err == mongo.ErrNoDocuments {
+			return ErrPromoNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to find promo code: %w", err)
+		}
+		now := time.Now()
+		if promo.ValidUntil != nil && now.After(*promo.ValidUntil) {
+			return ErrPromoExpired
+		}
+
+		grantID := primitive.NewObjectID()
+		redemption := models.PromoRedemption{
+			ID:         primitive.NewObjectID(),
+			Code:       code,
+			OrgID:      orgID,
+			GrantID:    grantID,
+			RedeemedBy: userID,
+			RedeemedAt: now,
+		}
+		if _, err := s.db.Collection("promo_redemptions").InsertOne(ctx, redemption); err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				return ErrAlreadyRedeemed
+			}
+			return fmt.Errorf("failed to record redemption: %w", err)
+		}
+		// Without a transaction, undo the redemption if the code can't be used
+		undo := func() {
+			if !database.InTransaction(ctx) {
+				s.db.Collection("promo_redemptions").DeleteOne(ctx, bson.M{"_id": redemption.ID})
+			}
+		}
+
+		filter := bson.M{"_id": promo.ID, "active": true}
+		if promo.MaxRedemptions > 0 {
+			filter["redemptions"] = bson.M{"$lt": promo.MaxRedemptions}
+		}
+		result, err := s.db.Collection("promo_codes").UpdateOne(ctx, filter,
+			bson.M{"$inc": bson.M{"redemptions": 1}, "$set": bson.M{"updatedAt": now}})
+		if err != nil {
+			undo()
+			return fmt.Errorf("failed to count redemption: %w", err)
+		}
+		if result.ModifiedCount == 0 {
+			undo()
+			return ErrPromoExhausted
+		}
+
+		issued := models.CreditGrant{
+			ID:          grantID,
+			OrgID:       orgID,
+			Amount:      promo.Amount,
+			Source:      models.CreditSourcePromo,
+			PromoCode:   code,
+			Priority:    promo.Priority,
+			Description: "Promo code " + code,
+			CreatedBy:   userID,
+		}
+		if promo.ExpiresAfterDays > 0 {
+			expiresAt := now.AddDate(0, 0, promo.ExpiresAfterDays)
+			issued.ExpiresAt = &expiresAt
+		}
+		grant, err = s.Grant(ctx, issued)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return grant, nil
+}
@@ -0,0 +1,314 @@
+package credits
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"freedom-ai/management-server/internal/database/databasetest"
+	"freedom-ai/management-server/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+func newTestService(t *testing.T, db *mongo.Database, orgs ...bson.M) *Service {
+	t.Helper()
+	ctx := context.Background()
+	s := NewService(db, zap.NewNop())
+	if err := s.EnsureIndexes(ctx); err != nil {
+		t.Fatalf("EnsureIndexes: %v", err)
+	}
+	if err := s.ledger.EnsureIndexes(ctx); err != nil {
+		t.Fatalf("ledger EnsureIndexes: %v", err)
+	}
+	for _, org := range orgs {
+		if _, err := db.Collection("organizations").InsertOne(ctx, org); err != nil {
+			t.Fatalf("insert organization: %v", err)
+		}
+	}
+	return s
+}
+
+func walletBalance(t *testing.T, db *mongo.Database, orgID string) float64 {
+	t.Helper()
+	var org models.Organization
+	if err := db.Collection("organizations").FindOne(context.Background(), bson.M{"orgId": orgID}).Decode(&org); err != nil {
+		t.Fatalf("load organization: %v", err)
+	}
+	return org.WalletBalance
+}
+
+func loadGrant(t *testing.T, db *mongo.Database, id primitive.ObjectID) models.CreditGrant {
+	t.Helper()
+	var grant models.CreditGrant
+	if err := db.Collection("credit_grants").FindOne(context.Background(), bson.M{"_id": id}).Decode(&grant); err != nil {
+		t.Fatalf("load grant: %v", err)
+	}
+	return grant
+}
+
+// insertGrant stores an active grant without crediting the wallet
+func insertGrant(t *testing.T, db *mongo.Database, grant models.CreditGrant) primitive.ObjectID {
+	t.Helper()
+	grant.ID = primitive.NewObjectID()
+	grant.Remaining = grant.Amount
+	grant.Status = models.CreditGrantActive
+	if grant.Source == "" {
+		grant.Source = models.CreditSourceManual
+	}
+	if grant.CreatedAt.IsZero() {
+		grant.CreatedAt = time.Now()
+	}
+	if _, err := db.Collection("credit_grants").InsertOne(context.Background(), grant); err != nil {
+		t.Fatalf("insert grant: %v", err)
+	}
+	return grant.ID
+}
+
+func TestValidateGrant(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+	tests := []struct {
+		name    string
+		grant   models.CreditGrant
+		wantErr bool
+	}{
+		{"valid", models.CreditGrant{Amount: 5, Source: models.CreditSourceTrial}, false},
+		{"expiring", models.CreditGrant{Amount: 5, Source: models.CreditSourceManual, ExpiresAt: &future}, false},
+		{"zero amount", models.CreditGrant{Source: models.CreditSourceTrial}, true},
+		{"negative amount", models.CreditGrant{Amount: -1, Source: models.CreditSourceTrial}, true},
+		{"unknown source", models.CreditGrant{Amount: 5, Source: "referral"}, true},
+		{"already expired", models.CreditGrant{Amount: 5, Source: models.CreditSourcePromo, ExpiresAt: &past}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateGrant(tt.grant); (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateGrant() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNormalizeCode(t *testing.T) {
+	if code := NormalizeCode("  welcome10 "); code != "WELCOME10" {
+		t.Fatalf("NormalizeCode() = %q, want WELCOME10", code)
+	}
+}
+
+func TestGrantCreditsTheWallet(t *testing.T) {
+	ctx := context.Background()
+	db := databasetest.Connect(t)
+	s := newTestService(t, db, bson.M{"orgId": "org-1", "walletBalance": 2.0})
+
+	grant, err := s.Grant(ctx, models.CreditGrant{OrgID: "org-1", Amount: 10, Source: models.CreditSourceTrial})
+	if err != nil {
+		t.Fatalf("Grant: %v", err)
+	}
+	if grant.Remaining != 10 || grant.Status != models.CreditGrantActive {
+		t.Fatalf("grant = %+v, want 10 remaining and active", grant)
+	}
+	if balance := walletBalance(t, db, "org-1"); balance != 12 {
+		t.Fatalf("walletBalance = %v, want 12", balance)
+	}
+	var entry models.LedgerEntry
+	if err := db.Collection("wallet_ledger").FindOne(ctx, bson.M{"reference": "grant:" + grant.ID.Hex()}).Decode(&entry); err != nil {
+		t.Fatalf("load ledger entry: %v", err)
+	}
+	if entry.Type != models.LedgerPromo || entry.Amount != 10 {
+		t.Fatalf("ledger entry = %+v, want a promo credit of 10", entry)
+	}
+
+	if _, err := s.Grant(ctx, models.CreditGrant{OrgID: "org-1", Amount: 0, Source: models.CreditSourceTrial}); err == nil {
+		t.Fatal("Grant of 0 succeeded, want an error")
+	}
+}
+
+func TestConsumeDrawsByPriorityThenExpiry(t *testing.T) {
+	ctx := context.Background()
+	db := databasetest.Connect(t)
+	s := newTestService(t, db)
+
+	soon := time.Now().Add(48 * time.Hour)
+	later := time.Now().Add(240 * time.Hour)
+	lapsed := time.Now().Add(-time.Hour)
+	open := insertGrant(t, db, models.CreditGrant{OrgID: "org-1", Amount: 3, CreatedAt: time.Now().Add(-time.Hour)})
+	expiring := insertGrant(t, db, models.CreditGrant{OrgID: "org-1", Amount: 3, ExpiresAt: &soon})
+	priority := insertGrant(t, db, models.CreditGrant{OrgID: "org-1", Amount: 3, ExpiresAt: &later, Priority: 1})
+	expired := insertGrant(t, db, models.CreditGrant{OrgID: "org-1", Amount: 3, ExpiresAt: &lapsed, Priority: 5})
+
+	drawn, err := s.Consume(ctx, "org-1", 5, "billing:run-1")
+	if err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+	if drawn != 5 {
+		t.Fatalf("Consume = %v, want 5", drawn)
+	}
+	want := map[primitive.ObjectID]float64{priority: 0, expiring: 1, open: 3, expired: 3}
+	for id, remaining := range want {
+		if grant := loadGrant(t, db, id); math.Abs(grant.Remaining-remaining) > exhaustedBelow {
+			t.Fatalf("grant %s remaining = %v, want %v", id.Hex(), grant.Remaining, remaining)
+		}
+	}
+	if grant := loadGrant(t, db, priority); grant.Status != models.CreditGrantExhausted {
+		t.Fatalf("drained grant status = %q, want exhausted", grant.Status)
+	}
+
+	// The same reference returns the earlier draw without drawing again
+	again, err := s.Consume(ctx, "org-1", 5, "billing:run-1")
+	if err != nil || again != 5 {
+		t.Fatalf("Consume again = %v, %v, want 5", again, err)
+	}
+	if grant := loadGrant(t, db, open); grant.Remaining != 3 {
+		t.Fatalf("grant remaining after a repeated consume = %v, want 3", grant.Remaining)
+	}
+
+	// More than is left draws what there is
+	drawn, err = s.Consume(ctx, "org-1", 10, "billing:run-2")
+	if err != nil || drawn != 4 {
+		t.Fatalf("Consume beyond the grants = %v, %v, want 4", drawn, err)
+	}
+}
+
+func TestRestoreReactivatesGrants(t *testing.T) {
+	ctx := context.Background()
+	db := databasetest.Connect(t)
+	s := newTestService(t, db)
+
+	id := insertGrant(t, db, models.CreditGrant{OrgID: "org-1", Amount: 4})
+	if _, err := s.Consume(ctx, "org-1", 4, "billing:run-1"); err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+	if grant := loadGrant(t, db, id); grant.Status != models.CreditGrantExhausted {
+		t.Fatalf("status = %q, want exhausted", grant.Status)
+	}
+
+	for i := 0; i < 2; i++ {
+		restored, err := s.Restore(ctx, "org-1", "billing:run-1")
+		if err != nil {
+			t.Fatalf("Restore: %v", err)
+		}
+		if want := []float64{4, 0}[i]; restored != want {
+			t.Fatalf("Restore #%d = %v, want %v", i+1, restored, want)
+		}
+	}
+	grant := loadGrant(t, db, id)
+	if grant.Status != models.CreditGrantActive || grant.Remaining != 4 || len(grant.Draws) != 0 {
+		t.Fatalf("grant = %+v, want active with 4 remaining and no draws", grant)
+	}
+}
+
+func TestSweepExpiredDebitsTheRemainder(t *testing.T) {
+	ctx := context.Background()
+	db := databasetest.Connect(t)
+	s := newTestService(t, db, bson.M{"orgId": "org-1", "walletBalance": 10.0})
+
+	lapsed := time.Now().Add(-time.Minute)
+	later := time.Now().Add(time.Hour)
+	expired := insertGrant(t, db, models.CreditGrant{OrgID: "org-1", Amount: 4, ExpiresAt: &lapsed})
+	current := insertGrant(t, db, models.CreditGrant{OrgID: "org-1", Amount: 4, ExpiresAt: &later})
+
+	for i := 0; i < 2; i++ {
+		if err := s.SweepExpired(ctx); err != nil {
+			t.Fatalf("SweepExpired: %v", err)
+		}
+	}
+	if balance := walletBalance(t, db, "org-1"); balance != 6 {
+		t.Fatalf("walletBalance = %v, want 6", balance)
+	}
+	if grant := loadGrant(t, db, expired); grant.Status != models.CreditGrantExpired || grant.Remaining != 0 || grant.ExpiredAt == nil {
+		t.Fatalf("expired grant = %+v, want expired with nothing remaining", grant)
+	}
+	if grant := loadGrant(t, db, current); grant.Status != models.CreditGrantActive || grant.Remaining != 4 {
+		t.Fatalf("current grant = %+v, want it untouched", grant)
+	}
+}
+
+func TestCreatePromoCodeValidates(t *testing.T) {
+	ctx := context.Background()
+	db := databasetest.Connect(t)
+	s := newTestService(t, db)
+
+	promo, err := s.CreatePromoCode(ctx, models.PromoCode{Code: " welcome ", Amount: 5})
+	if err != nil {
+		t.Fatalf("CreatePromoCode: %v", err)
+	}
+	if promo.Code != "WELCOME" || !promo.Active {
+		t.Fatalf("promo = %+v, want the active code WELCOME", promo)
+	}
+
+	for name, invalid := range map[string]models.PromoCode{
+		"no code":              {Code: "  ", Amount: 5},
+		"no amount":            {Code: "FREE"},
+		"negative expiry days": {Code: "FREE", Amount: 5, ExpiresAfterDays: -1},
+		"negative limit":       {Code: "FREE", Amount: 5, MaxRedemptions: -1},
+		"duplicate":            {Code: "Welcome", Amount: 5},
+	} {
+		if _, err := s.CreatePromoCode(ctx, invalid); err == nil {
+			t.Fatalf("CreatePromoCode(%s) succeeded, want an error", name)
+		}
+	}
+}
+
+func TestRedeem(t *testing.T) {
+	ctx := context.Background()
+	db := databasetest.Connect(t)
+	s := newTestService(t, db,
+		bson.M{"orgId": "org-1", "walletBalance": 0.0},
+		bson.M{"orgId": "org-2", "walletBalance": 0.0},
+	)
+
+	validUntil := time.Now().Add(-time.Hour)
+	for _, promo := range []models.PromoCode{
+		{Code: "ONCE", Amount: 5, MaxRedemptions: 1, ExpiresAfterDays: 30, Priority: 2},
+		{Code: "OLD", Amount: 5, ValidUntil: &validUntil},
+	} {
+		if _, err := s.CreatePromoCode(ctx, promo); err != nil {
+			t.Fatalf("CreatePromoCode: %v", err)
+		}
+	}
+
+	grant, err := s.Redeem(ctx, "org-1", "once", "user-1")
+	if err != nil {
+		t.Fatalf("Redeem: %v", err)
+	}
+	if grant.Source != models.CreditSourcePromo || grant.PromoCode != "ONCE" || grant.Priority != 2 || grant.ExpiresAt == nil {
+		t.Fatalf("grant = %+v, want a promo grant for ONCE with priority 2 and an expiry", grant)
+	}
+	if balance := walletBalance(t, db, "org-1"); balance != 5 {
+		t.Fatalf("walletBalance = %v, want 5", balance)
+	}
+
+	tests := []struct {
+		name  string
+		orgID string
+		code  string
+		want  error
+	}{
+		{"redeemed twice", "org-1", "ONCE", ErrAlreadyRedeemed},
+		{"limit reached", "org-2", "ONCE", ErrPromoExhausted},
+		{"expired", "org-2", "OLD", ErrPromoExpired},
+		{"unknown", "org-2", "NOPE", ErrPromoNotFound},
+	}
+	for _, tt := range tests {
+		if _, err := s.Redeem(ctx, tt.orgID, tt.code, "user-1"); !errors.Is(err, tt.want) {
+			t.Fatalf("Redeem %s = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	// Failed redemptions leave no trace
+	count, err := db.Collection("promo_redemptions").CountDocuments(ctx, bson.M{"orgId": "org-2"})
+	if err != nil {
+		t.Fatalf("count redemptions: %v", err)
+	}
+	if count != 0 {
+		t.Fatalf("org-2 redemptions = %d, want 0", count)
+	}
+	if balance := walletBalance(t, db, "org-2"); balance != 0 {
+		t.Fatalf("org-2 walletBalance = %v, want 0", balance)
+	}
+}
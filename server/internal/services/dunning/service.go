@@ -0,0 +1,282 @@
+package dunning
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"freedom-ai/management-server/internal/models"
+	"freedom-ai/management-server/internal/services/email"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+const day = 24 * time.Hour
+
+type Service struct {
+	db           *mongo.Database
+	logger       *zap.Logger
+	emailService *email.Service
+}
+
+func NewService(db *mongo.Database, logger *zap.Logger) *Service {
+	return &Service{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// SetEmailService sets the email service for dunning notices
+func (s *Service) SetEmailService(emailService *email.Service) {
+	s.emailService = emailService
+}
+
+// EnsureIndexes creates the dunning_events indexes
+func (s *Service) EnsureIndexes(ctx context.Context) error {
+	_, err := s.db.Collection("dunning_events").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "orgId", Value: 1}, {Key: "createdAt", Value: -1}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create dunning_events indexes: %w", err)
+	}
+	return nil
+}
+
+// ProcessDunning advances every organization through the dunning workflow
+func (s *Service) ProcessDunning(ctx context.Context) error {
+	cursor, err := s.db.Collection("organizations").Find(ctx, bson.M{"status": bson.M{"$ne": "inactive"}})
+	if err != nil {
+		return fmt.Errorf("failed to find organizations: %w", err)
+	}
+	var orgs []models.Organization
+	if err := cursor.All(ctx, &orgs); err != nil {
+		return fmt.Errorf("failed to decode organizations: %w", err)
+	}
+
+	for _, org := range orgs {
+		if err := s.evaluate(ctx, org); err != nil {
+			s.logger.Error("Failed to process dunning", zap.String("orgId", org.OrgID), zap.Error(err))
+		}
+	}
+	return nil
+}
+
+// Evaluate advances one organization through the dunning workflow, e.g.
+// right after a top-up so a suspended organization is reactivated at once
+func (s *Service) Evaluate(ctx context.Context, orgID string) error {
+	var org models.Organization
+	if err := s.db.Collection("organizations").FindOne(ctx, bson.M{"orgId": orgID}).Decode(&org); err != nil {
+		return fmt.Errorf("failed to find organization: %w", err)
+	}
+	return s.evaluate(ctx, org)
+}
+
+func (s *Service) evaluate(ctx context.Context, org models.Organization) error {
+	policy := org.EffectiveDunningPolicy()
+	threshold := *policy.Threshold
+	suspendBelow := org.DunningSuspendBelow()
+	state := org.DunningState()
+	now := time.Now()
+
+	if org.WalletBalance >= threshold {
+		if state == models.DunningCurrent {
+			return nil
+		}
+		return s.recover(ctx, org, state, threshold)
+	}
+	if org.Status == "inactive" {
+		return nil
+	}
+
+	status := models.DunningStatus{State: models.DunningCurrent, StartedAt: now}
+	if org.Dunning != nil {
+		status = *org.Dunning
+	}
+	from := state
+	elapsed := now.Sub(status.StartedAt)
+
+	overdue := elapsed >= time.Duration(policy.SuspendAfterDays)*day
+	switch {
+	case state != models.DunningSuspended && overdue && org.WalletBalance < suspendBelow:
+		status.State = models.DunningSuspended
+		status.SuspendedAt = &now
+	case state == models.DunningSuspended && org.WalletBalance >= suspendBelow:
+		// Paid back within the credit limit, but still in dunning
+		status.State = models.DunningPastDue
+		status.SuspendedAt = nil
+	case state == models.DunningCurrent && policy.GraceDays > 0:
+		status.State = models.DunningGrace
+	case (state == models.DunningCurrent || state == models.DunningGrace) && elapsed >= time.Duration(policy.GraceDays)*day:
+		status.State = models.DunningPastDue
+		status.PastDueAt = &now
+	}
+	if status.State != from {
+		return s.transition(ctx, org, from, status, threshold)
+	}
+
+	// Reminders are due ReminderDays after dunning started; only the latest
+	// due one is sent if several fell due at once
+	if state == models.DunningSuspended {
+		return nil
+	}
+	days := append([]int(nil), policy.ReminderDays...)
+	sort.Ints(days)
+	due := 0
+	for _, d := range days {
+		if elapsed >= time.Duration(d)*day {
+			due++
+		}
+	}
+	if due <= status.RemindersSent {
+		return nil
+	}
+	result, err := s.db.Collection("organizations").UpdateOne(ctx,
+		bson.M{"orgId": org.OrgID, "dunning.state": state, "dunning.remindersSent": status.RemindersSent},
+		bson.M{"$set": bson.M{"dunning.remindersSent": due, "dunning.lastReminderAt": now}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record dunning reminder: %w", err)
+	}
+	if result.ModifiedCount == 0 {
+		return nil
+	}
+	s.record(ctx, org, models.DunningEventReminder, state, state, threshold,
+		fmt.Sprintf("reminder %d of %d", due, len(days)))
+	s.notify(org, email.DunningNotice{
+		State:     state,
+		Reminder:  true,
+		Balance:   org.WalletBalance,
+		Threshold: threshold,
+		SuspendAt: status.StartedAt.Add(time.Duration(policy.SuspendAfterDays) * day),
+	})
+	return nil
+}
+
+// transition moves an organization into a dunning state; suspension also
+// suspends the organization, and leaving suspension reactivates it
+func (s *Service) transition(ctx context.Context, org models.Organization, from string, status models.DunningStatus, threshold float64) error {
+	set := bson.M{"dunning": status, "updatedAt": time.Now()}
+	if status.State == models.DunningSuspended {
+		set["status"] = "suspended"
+	} else if from == models.DunningSuspended && org.Status == "suspended" {
+		set["status"] = "active"
+	}
+	result, err := s.db.Collection("organizations").UpdateOne(ctx,
+		bson.M{"orgId": org.OrgID, "dunning.state": stateFilter(from)},
+		bson.M{"$set": set},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update dunning state: %w", err)
+	}
+	if result.ModifiedCount == 0 {
+		// Moved on concurrently
+		return nil
+	}
+
+	reason := fmt.Sprintf("wallet balance %.2f below %.2f", org.WalletBalance, threshold)
+	switch {
+	case status.State == models.DunningSuspended:
+		reason = fmt.Sprintf("wallet balance %.2f below %.2f after %d days", org.WalletBalance, org.DunningSuspendBelow(), org.EffectiveDunningPolicy().SuspendAfterDays)
+	case from == models.DunningSuspended:
+		reason = fmt.Sprintf("wallet balance %.2f back above %.2f; reactivated", org.WalletBalance, org.DunningSuspendBelow())
+	}
+	s.record(ctx, org, models.DunningEventTransition, from, status.State, threshold, reason)
+	policy := org.EffectiveDunningPolicy()
+	s.notify(org, email.DunningNotice{
+		State:     status.State,
+		Balance:   org.WalletBalance,
+		Threshold: threshold,
+		SuspendAt: status.StartedAt.Add(time.Duration(policy.SuspendAfterDays) * day),
+	})
+	return nil
+}
+
+// recover returns an organization whose balance is back above the threshold
+// to current, reactivating it if dunning suspended it
+func (s *Service) recover(ctx context.Context, org models.Organization, from string, threshold float64) error {
+	update := bson.M{
+		"$set":   bson.M{"updatedAt": time.Now()},
+		"$unset": bson.M{"dunning": ""},
+	}
+	if from == models.DunningSuspended && org.Status == "suspended" {
+		update["$set"].(bson.M)["status"] = "active"
+	}
+	result, err := s.db.Collection("organizations").UpdateOne(ctx,
+		bson.M{"orgId": org.OrgID, "dunning.state": from}, update)
+	if err != nil {
+		return fmt.Errorf("failed to clear dunning state: %w", err)
+	}
+	if result.ModifiedCount == 0 {
+		return nil
+	}
+
+	reason := fmt.Sprintf("wallet balance %.2f back above %.2f", org.WalletBalance, threshold)
+	if from == models.DunningSuspended {
+		reason += "; reactivated"
+	}
+	s.record(ctx, org, models.DunningEventTransition, from, models.DunningCurrent, threshold, reason)
+	s.notify(org, email.DunningNotice{
+		State:     models.DunningCurrent,
+		Balance:   org.WalletBalance,
+		Threshold: threshold,
+	})
+	return nil
+}
+
+// ListEvents returns an organization's dunning events, newest first
+func (s *Service) ListEvents(ctx context.Context, orgID string, limit int64) ([]models.DunningEvent, error) {
+	cursor, err := s.db.Collection("dunning_events").Find(ctx, bson.M{"orgId": orgID},
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}).SetLimit(limit))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find dunning events: %w", err)
+	}
+	events := []models.DunningEvent{}
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, fmt.Errorf("failed to decode dunning events: %w", err)
+	}
+	return events, nil
+}
+
+func (s *Service) record(ctx context.Context, org models.Organization, kind, from, to string, threshold float64, reason string) {
+	event := models.DunningEvent{
+		ID:        primitive.NewObjectID(),
+		OrgID:     org.OrgID,
+		Kind:      kind,
+		From:      from,
+		To:        to,
+		Balance:   org.WalletBalance,
+		Threshold: threshold,
+		Reason:    reason,
+		CreatedAt: time.Now(),
+	}
+	if _, err := s.db.Collection("dunning_events").InsertOne(ctx, event); err != nil {
+		s.logger.Warn("Failed to record dunning event", zap.String("orgId", org.OrgID), zap.Error(err))
+	}
+	s.logger.Info("Dunning "+kind,
+		zap.String("orgId", org.OrgID),
+		zap.String("from", from),
+		zap.String("to", to),
+		zap.String("reason", reason))
+}
+
+func (s *Service) notify(org models.Organization, notice email.DunningNotice) {
+	if s.emailService == nil || org.BillingEmail == "" {
+		return
+	}
+	if err := s.emailService.SendDunningNotice(org.BillingEmail, org.Name, notice); err != nil {
+		s.logger.Warn("Failed to send dunning notice", zap.String("orgId", org.OrgID), zap.Error(err))
+	}
+}
+
+// stateFilter matches a stored dunning state; current organizations have none
+func stateFilter(state string) interface{} {
+	if state == models.DunningCurrent {
+		return bson.M{"$in": bson.A{nil, "", models.DunningCurrent}}
+	}
+	return state
+}
@@ -0,0 +1,135 @@
+package dunning
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"freedom-ai/management-server/internal/database/databasetest"
+	"freedom-ai/management-server/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+func newTestService(t *testing.T, db *mongo.Database, org bson.M) *Service {
+	t.Helper()
+	ctx := context.Background()
+	s := NewService(db, zap.NewNop())
+	if err := s.EnsureIndexes(ctx); err != nil {
+		t.Fatalf("EnsureIndexes: %v", err)
+	}
+	if _, err := db.Collection("organizations").InsertOne(ctx, org); err != nil {
+		t.Fatalf("insert organization: %v", err)
+	}
+	return s
+}
+
+func loadOrganization(t *testing.T, db *mongo.Database, orgID string) models.Organization {
+	t.Helper()
+	var org models.Organization
+	if err := db.Collection("organizations").FindOne(context.Background(), bson.M{"orgId": orgID}).Decode(&org); err != nil {
+		t.Fatalf("load organization: %v", err)
+	}
+	return org
+}
+
+// backdate moves the start of an organization's dunning days into the past
+func backdate(t *testing.T, db *mongo.Database, orgID string, days int) {
+	t.Helper()
+	_, err := db.Collection("organizations").UpdateOne(context.Background(), bson.M{"orgId": orgID},
+		bson.M{"$set": bson.M{"dunning.startedAt": time.Now().Add(-time.Duration(days) * day)}})
+	if err != nil {
+		t.Fatalf("backdate dunning: %v", err)
+	}
+}
+
+func setBalance(t *testing.T, db *mongo.Database, orgID string, balance float64) {
+	t.Helper()
+	_, err := db.Collection("organizations").UpdateOne(context.Background(), bson.M{"orgId": orgID},
+		bson.M{"$set": bson.M{"walletBalance": balance}})
+	if err != nil {
+		t.Fatalf("set balance: %v", err)
+	}
+}
+
+func evaluate(t *testing.T, s *Service, orgID string) models.Organization {
+	t.Helper()
+	if err := s.Evaluate(context.Background(), orgID); err != nil {
+		t.Fatalf("Evaluate: %v", err)
+	}
+	return loadOrganization(t, s.db, orgID)
+}
+
+func TestDunningSuspendsAndRecovers(t *testing.T) {
+	db := databasetest.Connect(t)
+	s := newTestService(t, db, bson.M{"orgId": "org-1", "status": "active", "walletBalance": -5.0})
+
+	if org := evaluate(t, s, "org-1"); org.DunningState() != models.DunningGrace {
+		t.Fatalf("state = %q, want grace below a threshold of 0", org.DunningState())
+	}
+	backdate(t, db, "org-1", 3)
+	if org := evaluate(t, s, "org-1"); org.DunningState() != models.DunningPastDue || org.Dunning.PastDueAt == nil {
+		t.Fatalf("state = %q after the grace days, want past_due", org.DunningState())
+	}
+	backdate(t, db, "org-1", 14)
+	org := evaluate(t, s, "org-1")
+	if org.DunningState() != models.DunningSuspended || org.Status != "suspended" {
+		t.Fatalf("state %q, status %q after suspendAfterDays, want suspended", org.DunningState(), org.Status)
+	}
+
+	setBalance(t, db, "org-1", 20)
+	org = evaluate(t, s, "org-1")
+	if org.DunningState() != models.DunningCurrent || org.Dunning != nil || org.Status != "active" {
+		t.Fatalf("state %q, status %q after a top-up, want current and active", org.DunningState(), org.Status)
+	}
+
+	events, err := s.ListEvents(context.Background(), "org-1", 10)
+	if err != nil {
+		t.Fatalf("ListEvents: %v", err)
+	}
+	var path []string
+	for i := len(events) - 1; i >= 0; i-- {
+		if events[i].Kind == models.DunningEventTransition {
+			path = append(path, events[i].To)
+		}
+	}
+	want := []string{models.DunningGrace, models.DunningPastDue, models.DunningSuspended, models.DunningCurrent}
+	if len(path) != len(want) {
+		t.Fatalf("transitions = %v, want %v", path, want)
+	}
+	for i := range want {
+		if path[i] != want[i] {
+			t.Fatalf("transitions = %v, want %v", path, want)
+		}
+	}
+}
+
+func TestDunningSuspendsPostpaidOnlyBeyondCreditLimit(t *testing.T) {
+	db := databasetest.Connect(t)
+	s := newTestService(t, db, bson.M{
+		"orgId": "org-1", "status": "active", "accountMode": models.AccountPostpaid,
+		"creditLimit": 50.0, "walletBalance": -30.0,
+	})
+
+	evaluate(t, s, "org-1")
+	backdate(t, db, "org-1", 30)
+	org := evaluate(t, s, "org-1")
+	if org.DunningState() != models.DunningPastDue || org.Status != "active" {
+		t.Fatalf("state %q, status %q within the credit limit, want past_due and active", org.DunningState(), org.Status)
+	}
+
+	setBalance(t, db, "org-1", -60)
+	if org := evaluate(t, s, "org-1"); org.DunningState() != models.DunningSuspended || org.Status != "suspended" {
+		t.Fatalf("state %q, status %q beyond the credit limit, want suspended", org.DunningState(), org.Status)
+	}
+
+	// Paying back within the limit reactivates the organization, which stays
+	// in dunning until the balance reaches the threshold
+	setBalance(t, db, "org-1", -10)
+	org = evaluate(t, s, "org-1")
+	if org.DunningState() != models.DunningPastDue || org.Status != "active" || org.Dunning.SuspendedAt != nil {
+		t.Fatalf("state %q, status %q back within the credit limit, want past_due and active", org.DunningState(), org.Status)
+	}
+}
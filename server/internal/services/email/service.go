@@ -123,6 +123,96 @@ Freedom AI Team
 	return s.sendEmail(to, subject, body)
 }
 
+// DunningNotice describes a dunning transition or reminder
+type DunningNotice struct {
+	State     string // grace, past_due, suspended or current
+	Reminder  bool
+	Balance   float64
+	Threshold float64
+	SuspendAt time.Time // when the organization will be suspended, while in dunning
+}
+
+// SendDunningNotice sends an email about an organization's dunning state
+func (s *Service) SendDunningNotice(to, orgName string, notice DunningNotice) error {
+	var subject, message string
+	switch notice.State {
+	case "current":
+		subject = "Account Back in Good Standing - Freedom AI"
+		message = fmt.Sprintf("Thank you for your payment. Your wallet balance is $%.2f and your account is in good standing again.", notice.Balance)
+	case "suspended":
+		subject = "Account Suspended - Freedom AI"
+		message = fmt.Sprintf("Your wallet balance of $%.2f has stayed below $%.2f and your account has been suspended. Top up your wallet to reactivate it immediately.", notice.Balance, notice.Threshold)
+	default:
+		subject = "Payment Required - Freedom AI"
+		if notice.Reminder {
+			subject = "Payment Reminder - Freedom AI"
+		} else if notice.State == "past_due" {
+			subject = "Payment Overdue - Freedom AI"
+		}
+		message = fmt.Sprintf("Your wallet balance is $%.2f, below $%.2f. Please top up your wallet before %s to avoid suspension of your account.", notice.Balance, notice.Threshold, notice.SuspendAt.Format("2006-01-02 15:04 MST"))
+	}
+
+	body := fmt.Sprintf(`
+Hello,
+
+Organization "%s": %s
+
+You can top up your wallet at: %s/dashboard/billing
+
+Best regards,
+Freedom AI Team
+`, orgName, message, s.config.CORSOrigin)
+
+	return s.sendEmail(to, subject, body)
+}
+
+// WalletAdjustment describes a manual adjustment, credit note or refund
+type WalletAdjustment struct {
+	Kind       string // adjustment, credit_note or refund
+	Reference  string // credit note number or Stripe refund ID
+	Amount     float64
+	ReasonCode string
+	Note       string
+	Balance    float64 // wallet balance afterwards
+}
+
+// SendWalletAdjustment notifies an organization of a change made to its wallet
+func (s *Service) SendWalletAdjustment(to, orgName string, adjustment WalletAdjustment) error {
+	var subject, message string
+	switch adjustment.Kind {
+	case "credit_note":
+		subject = fmt.Sprintf("Credit Note %s - Freedom AI", adjustment.Reference)
+		message = fmt.Sprintf("Credit note %s of $%.2f has been issued and credited to your wallet.", adjustment.Reference, adjustment.Amount)
+	case "refund":
+		subject = "Refund Issued - Freedom AI"
+		message = fmt.Sprintf("A refund of $%.2f has been issued to your original payment method and deducted from your wallet.", adjustment.Amount)
+	default:
+		subject = "Wallet Adjustment - Freedom AI"
+		if adjustment.Amount >= 0 {
+			message = fmt.Sprintf("Your wallet has been credited $%.2f.", adjustment.Amount)
+		} else {
+			message = fmt.Sprintf("Your wallet has been debited $%.2f.", -adjustment.Amount)
+		}
+	}
+
+	body := fmt.Sprintf(`
+Hello,
+
+Organization "%s": %s
+
+Reason: %s
+Note: %s
+Wallet balance: $%.2f
+
+You can view your billing history at: %s/dashboard/billing
+
+Best regards,
+Freedom AI Team
+`, orgName, message, adjustment.ReasonCode, adjustment.Note, adjustment.Balance, s.config.CORSOrigin)
+
+	return s.sendEmail(to, subject, body)
+}
+
 // SendUserInvitation sends an invitation email to a new user
 func (s *Service) SendUserInvitation(to, userName, orgName, invitationLink string) error {
 	subject := "Invitation to Join Freedom AI"
@@ -145,14 +235,14 @@ Freedom AI Team
 }
 
 type BillingSummary struct {
-	OrgName            string
-	PeriodStart        time.Time
-	PeriodEnd          time.Time
-	TotalTokens        int64
-	TotalCost          float64
+	OrgName             string
+	PeriodStart         time.Time
+	PeriodEnd           time.Time
+	TotalTokens         int64
+	TotalCost           float64
 	WalletBalanceBefore float64
 	WalletBalanceAfter  float64
-	Breakdown          struct {
+	Breakdown           struct {
 		ByAssistant map[string]struct {
 			Tokens int64
 			Cost   float64
@@ -198,4 +288,3 @@ func formatTokens(tokens int64) string {
 	}
 	return fmt.Sprintf("%d", tokens)
 }
-
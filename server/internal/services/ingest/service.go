@@ -0,0 +1,242 @@
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"freedom-ai/management-server/internal/archive"
+	"freedom-ai/management-server/internal/events"
+	"freedom-ai/management-server/internal/metrics"
+	"freedom-ai/management-server/internal/models"
+	"freedom-ai/management-server/internal/services/consumption"
+	"freedom-ai/management-server/internal/services/privacy"
+
+	"go.uber.org/zap"
+)
+
+const (
+	EventTypeRequest  = "request"
+	EventTypeResponse = "response"
+
+	// requestCacheTTL is how long a request waits in Redis for its response (seconds)
+	requestCacheTTL = 3600
+
+	// archiveWaitTimeout bounds how long an HTTP batch waits for its events to be archived
+	archiveWaitTimeout = 30 * time.Second
+)
+
+type RedisClient interface {
+	SetWithTTL(ctx context.Context, key string, value interface{}, ttl int) error
+	Get(ctx context.Context, key string) (string, error)
+	Delete(ctx context.Context, key string) error
+	ZAdd(ctx context.Context, key string, score float64, member string) error
+	ZRem(ctx context.Context, key string, members ...string) error
+}
+
+// Service pairs collector requests with their responses. It is shared by the
+// RabbitMQ consumer and the HTTP ingestion endpoint.
+type Service struct {
+	archive            *archive.Archive
+	redis              RedisClient
+	consumptionService *consumption.Service
+	privacyService     *privacy.Service
+	logger             *zap.Logger
+}
+
+func NewService(redis RedisClient, consumptionService *consumption.Service, privacyService *privacy.Service, logger *zap.Logger) *Service {
+	return &Service{
+		redis:              redis,
+		consumptionService: consumptionService,
+		privacyService:     privacyService,
+		logger:             logger,
+	}
+}
+
+// SetArchive enables archiving of raw events received over HTTP
+func (s *Service) SetArchive(a *archive.Archive) {
+	s.archive = a
+}
+
+// Event is a request or response event in the collector's JSON shape
+type Event struct {
+	Type  string          `json:"type"`
+	Event json.RawMessage `json:"event"`
+}
+
+// Result reports whether a single event was accepted
+type Result struct {
+	Index     int    `json:"index"`
+	Type      string `json:"type"`
+	RequestID string `json:"requestId,omitempty"`
+	Status    string `json:"status"` // accepted or rejected
+	Reason    string `json:"reason,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Archive records a raw event with its prompt and completion content stripped.
+// The returned channel is closed once the event is written, or is nil when
+// archiving is disabled.
+func (s *Service) Archive(source, kind string, schemaVersion int, body []byte) <-chan struct{} {
+	if s.archive == nil {
+		return nil
+	}
+	return s.archive.Record(source, kind, schemaVersion, events.StripContent(body))
+}
+
+// TrackRequest minimises the request's content, caches it until its response
+// arrives and registers it with the orphan sweeper
+func (s *Service) TrackRequest(ctx context.Context, requestData *models.LLMRequestData) {
+	s.privacyService.MinimiseRequest(ctx, requestData)
+
+	key := consumption.RequestCacheKey(requestData.RequestID)
+	requestJSON, _ := json.Marshal(requestData)
+	if err := s.redis.SetWithTTL(ctx, key, string(requestJSON), requestCacheTTL); err != nil {
+		s.logger.Warn("Failed to cache request", zap.String("requestId", requestData.RequestID), zap.Error(err))
+	}
+
+	// Track the request so the orphan sweeper can find it if no response arrives
+	if err := s.redis.ZAdd(ctx, consumption.PendingRequestsKey, float64(time.Now().Unix()), requestData.RequestID); err != nil {
+		s.logger.Warn("Failed to track pending request", zap.String("requestId", requestData.RequestID), zap.Error(err))
+	}
+}
+
+// Pair minimises the response's content and builds the consumption record from
+// it and its cached request, if any
+func (s *Service) Pair(ctx context.Context, responseData *models.LLMResponseData) (models.TokenConsumption, error) {
+	s.privacyService.MinimiseResponse(ctx, responseData)
+	requestData := s.MatchRequest(ctx, responseData.RequestID)
+	return s.consumptionService.BuildRecord(ctx, requestData, responseData)
+}
+
+// MatchRequest returns the cached request for requestID, or nil if there is none
+func (s *Service) MatchRequest(ctx context.Context, requestID string) *models.LLMRequestData {
+	requestJSON, err := s.redis.Get(ctx, consumption.RequestCacheKey(requestID))
+	if err != nil || requestJSON == "" {
+		return nil
+	}
+
+	requestData := &models.LLMRequestData{}
+	if err := json.Unmarshal([]byte(requestJSON), requestData); err != nil {
+		return nil
+	}
+	return requestData
+}
+
+// Complete drops the cached request once its record is stored. It must not be
+// called earlier, so retries can still pair the response.
+func (s *Service) Complete(ctx context.Context, requestID string) {
+	_ = s.redis.Delete(ctx, consumption.RequestCacheKey(requestID))
+	_ = s.redis.ZRem(ctx, consumption.PendingRequestsKey, requestID)
+}
+
+// Ingest decodes, pairs and stores a batch of events in order. Invalid events,
+// and events for an organization other than orgID, are rejected individually;
+// an error is returned only if storing the records fails, in which case the
+// whole batch can safely be resent.
+func (s *Service) Ingest(ctx context.Context, batch []Event, headerVersion int, orgID string) ([]Result, error) {
+	results := make([]Result, len(batch))
+	var records []models.TokenConsumption
+	var archived []<-chan struct{}
+
+	for i, event := range batch {
+		results[i] = Result{Index: i, Type: event.Type, Status: "accepted"}
+
+		switch event.Type {
+		case EventTypeRequest:
+			requestData, err := events.DecodeRequest(event.Event, headerVersion)
+			if err == nil {
+				results[i].RequestID = requestData.RequestID
+				err = checkOrganization(requestData.OrganizationID, orgID)
+			}
+			if err != nil {
+				s.reject(&results[i], event, headerVersion, err)
+				continue
+			}
+			archived = s.appendArchived(archived, s.Archive("http", event.Type, headerVersion, event.Event))
+			s.TrackRequest(ctx, requestData)
+
+		case EventTypeResponse:
+			responseData, err := events.DecodeResponse(event.Event, headerVersion)
+			if err == nil {
+				results[i].RequestID = responseData.RequestID
+				err = checkOrganization(responseData.OrganizationID, orgID)
+			}
+			if err != nil {
+				s.reject(&results[i], event, headerVersion, err)
+				continue
+			}
+			archived = s.appendArchived(archived, s.Archive("http", event.Type, headerVersion, event.Event))
+			record, err := s.Pair(ctx, responseData)
+			if err != nil {
+				return nil, err
+			}
+			records = append(records, record)
+
+		default:
+			s.reject(&results[i], event, headerVersion, &events.ValidationError{
+				Reason: "unknown_event_type",
+				Detail: fmt.Sprintf("type must be %q or %q", EventTypeRequest, EventTypeResponse),
+			})
+		}
+	}
+
+	if err := s.consumptionService.ProcessConsumptionBatch(ctx, records); err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		s.Complete(ctx, record.RequestID)
+	}
+
+	// Only report success once the raw events are archived too
+	if len(archived) > 0 {
+		go s.archive.Flush(context.Background())
+		waitCtx, cancel := context.WithTimeout(ctx, archiveWaitTimeout)
+		defer cancel()
+		if err := archive.Wait(waitCtx, archived...); err != nil {
+			return nil, err
+		}
+	}
+
+	return results, nil
+}
+
+func (s *Service) appendArchived(archived []<-chan struct{}, written <-chan struct{}) []<-chan struct{} {
+	if written == nil {
+		return archived
+	}
+	return append(archived, written)
+}
+
+// checkOrganization rejects events posted with another organization's key
+func checkOrganization(eventOrgID, orgID string) error {
+	if eventOrgID != orgID {
+		return &events.ValidationError{
+			Reason: events.ReasonOrgMismatch,
+			Detail: fmt.Sprintf("API key is not valid for organization %q", eventOrgID),
+		}
+	}
+	return nil
+}
+
+// reject marks the event rejected. Invalid events are archived like the
+// broker's, but events for another organization are not, so replay cannot
+// store them.
+func (s *Service) reject(result *Result, event Event, headerVersion int, err error) {
+	reason := events.Reason(err)
+	if reason != events.ReasonOrgMismatch {
+		// Rejected events are archived on a best-effort basis; nothing is stored for them
+		s.Archive("http", event.Type, headerVersion, event.Event)
+	}
+	metrics.RejectedEvents.Add(reason, 1)
+	s.logger.Warn("Rejected invalid event",
+		zap.Int("index", result.Index),
+		zap.String("type", result.Type),
+		zap.String("reason", reason),
+		zap.Error(err))
+
+	result.Status = "rejected"
+	result.Reason = reason
+	result.Error = err.Error()
+}
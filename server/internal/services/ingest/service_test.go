@@ -0,0 +1,173 @@
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"freedom-ai/management-server/internal/config"
+	"freedom-ai/management-server/internal/database/databasetest"
+	"freedom-ai/management-server/internal/events"
+	"freedom-ai/management-server/internal/models"
+	"freedom-ai/management-server/internal/redis/redistest"
+	"freedom-ai/management-server/internal/services/consumption"
+	"freedom-ai/management-server/internal/services/privacy"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.uber.org/zap"
+)
+
+const (
+	secretPrompt     = "my password is hunter2"
+	secretSystem     = "you are a confidential assistant"
+	secretCompletion = "your password is hunter2"
+)
+
+func TestIngestAppliesContentPolicy(t *testing.T) {
+	for _, policy := range []string{privacy.PolicyNone, privacy.PolicyHashed, privacy.PolicyRedacted} {
+		t.Run(policy, func(t *testing.T) {
+			ctx := context.Background()
+			db := databasetest.Connect(t)
+			rdb, server := redistest.Start(t)
+			logger := zap.NewNop()
+
+			orgID := "org-" + policy
+			if _, err := db.Collection("organizations").InsertOne(ctx, bson.M{"orgId": orgID, "contentPolicy": policy}); err != nil {
+				t.Fatalf("insert organization: %v", err)
+			}
+
+			consumptionService := consumption.NewService(db, &config.Config{}, logger)
+			if err := consumptionService.SeedPriceCatalog(ctx); err != nil {
+				t.Fatalf("SeedPriceCatalog: %v", err)
+			}
+			s := NewService(rdb, consumptionService, privacy.NewService(db, logger), logger)
+
+			requestID := "req-" + policy
+			request := fmt.Sprintf(`{"requestId":%q,"timestamp":"2026-01-01T00:00:00Z","userId":"user-1","organizationId":%q,"model":"gpt-4","systemPrompt":%q,"messages":[{"role":"user","content":%q}]}`,
+				requestID, orgID, secretSystem, secretPrompt)
+			response := fmt.Sprintf(`{"requestId":%q,"timestamp":"2026-01-01T00:00:01Z","userId":"user-1","organizationId":%q,"model":"gpt-4","response":%q,"usage":{"promptTokens":10,"completionTokens":5,"totalTokens":15}}`,
+				requestID, orgID, secretCompletion)
+
+			if _, err := s.Ingest(ctx, []Event{{Type: EventTypeRequest, Event: json.RawMessage(request)}}, 0, orgID); err != nil {
+				t.Fatalf("Ingest request: %v", err)
+			}
+
+			cached, err := server.Get(consumption.RequestCacheKey(requestID))
+			if err != nil {
+				t.Fatalf("cached request: %v", err)
+			}
+			assertNoContent(t, "cached request", cached)
+			var cachedRequest models.LLMRequestData
+			if err := json.Unmarshal([]byte(cached), &cachedRequest); err != nil {
+				t.Fatalf("decode cached request: %v", err)
+			}
+			assertRetained(t, "cached request", policy, cachedRequest.RetainedContent, "user: [redacted 22 chars]")
+
+			if _, err := s.Ingest(ctx, []Event{{Type: EventTypeResponse, Event: json.RawMessage(response)}}, 0, orgID); err != nil {
+				t.Fatalf("Ingest response: %v", err)
+			}
+
+			var raw bson.M
+			if err := db.Collection("token_consumption").FindOne(ctx, bson.M{"requestId": requestID}).Decode(&raw); err != nil {
+				t.Fatalf("stored record: %v", err)
+			}
+			stored, _ := json.Marshal(raw)
+			assertNoContent(t, "stored record", string(stored))
+
+			var record models.TokenConsumption
+			if err := db.Collection("token_consumption").FindOne(ctx, bson.M{"requestId": requestID}).Decode(&record); err != nil {
+				t.Fatalf("decode stored record: %v", err)
+			}
+			if record.Status != "complete" {
+				t.Fatalf("stored record status = %q, want complete", record.Status)
+			}
+			assertRetained(t, "stored request content", policy, record.RequestContent, "user: [redacted 22 chars]")
+			assertRetained(t, "stored response content", policy, record.ResponseContent, "[redacted 24 chars]")
+		})
+	}
+}
+
+func TestIngestRejectsOtherOrganizations(t *testing.T) {
+	ctx := context.Background()
+	db := databasetest.Connect(t)
+	rdb, server := redistest.Start(t)
+	logger := zap.NewNop()
+
+	consumptionService := consumption.NewService(db, &config.Config{}, logger)
+	if err := consumptionService.SeedPriceCatalog(ctx); err != nil {
+		t.Fatalf("SeedPriceCatalog: %v", err)
+	}
+	s := NewService(rdb, consumptionService, privacy.NewService(db, logger), logger)
+
+	event := func(eventType, requestID, orgID string) Event {
+		body := fmt.Sprintf(`{"requestId":%q,"timestamp":"2026-01-01T00:00:00Z","userId":"user-1","organizationId":%q,"model":"gpt-4","usage":{"promptTokens":10,"completionTokens":5,"totalTokens":15}}`,
+			requestID, orgID)
+		return Event{Type: eventType, Event: json.RawMessage(body)}
+	}
+	batch := []Event{
+		event(EventTypeRequest, "req-own", "org-1"),
+		event(EventTypeResponse, "req-own", "org-1"),
+		event(EventTypeRequest, "req-other", "org-2"),
+		event(EventTypeResponse, "req-other", "org-2"),
+		{Type: "usage", Event: json.RawMessage(`{}`)},
+	}
+
+	results, err := s.Ingest(ctx, batch, 0, "org-1")
+	if err != nil {
+		t.Fatalf("Ingest: %v", err)
+	}
+	wantReasons := []string{"", "", events.ReasonOrgMismatch, events.ReasonOrgMismatch, "unknown_event_type"}
+	for i, want := range wantReasons {
+		if results[i].Reason != want {
+			t.Fatalf("result %d = %+v, want reason %q", i, results[i], want)
+		}
+		wantStatus := "accepted"
+		if want != "" {
+			wantStatus = "rejected"
+		}
+		if results[i].Status != wantStatus {
+			t.Fatalf("result %d status = %q, want %q", i, results[i].Status, wantStatus)
+		}
+	}
+
+	if n, _ := db.Collection("token_consumption").CountDocuments(ctx, bson.M{"requestId": "req-own"}); n != 1 {
+		t.Fatalf("stored %d records for the key's organization, want 1", n)
+	}
+	if n, _ := db.Collection("token_consumption").CountDocuments(ctx, bson.M{"organizationId": "org-2"}); n != 0 {
+		t.Fatalf("stored %d records for another organization, want 0", n)
+	}
+	if server.Exists(consumption.RequestCacheKey("req-other")) {
+		t.Fatal("request for another organization was cached")
+	}
+}
+
+func assertNoContent(t *testing.T, what, payload string) {
+	t.Helper()
+	for _, secret := range []string{secretPrompt, secretSystem, secretCompletion} {
+		if strings.Contains(payload, secret) {
+			t.Fatalf("%s contains raw content %q: %s", what, secret, payload)
+		}
+	}
+}
+
+// assertRetained checks what the policy kept. Redacted content must contain
+// wantRedacted; hashed content must be a SHA-256 hex digest.
+func assertRetained(t *testing.T, what, policy string, content *models.RetainedContent, wantRedacted string) {
+	t.Helper()
+	switch policy {
+	case privacy.PolicyNone:
+		if content != nil {
+			t.Fatalf("%s retained %+v under policy none", what, content)
+		}
+	case privacy.PolicyHashed:
+		if content == nil || content.Policy != privacy.PolicyHashed || len(content.Hash) != 64 || content.Redacted != "" {
+			t.Fatalf("%s = %+v, want a SHA-256 digest only", what, content)
+		}
+	case privacy.PolicyRedacted:
+		if content == nil || content.Policy != privacy.PolicyRedacted || content.Hash != "" || !strings.Contains(content.Redacted, wantRedacted) {
+			t.Fatalf("%s = %+v, want redacted text containing %q", what, content, wantRedacted)
+		}
+	}
+}
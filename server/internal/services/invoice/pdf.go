@@ -0,0 +1,90 @@
+package invoice
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"freedom-ai/management-server/internal/models"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+// RenderPDF writes an invoice as a PDF document
+func RenderPDF(invoice *models.Invoice, w io.Writer) error {
+	location, err := time.LoadLocation(invoice.Timezone)
+	if err != nil {
+		location = time.UTC
+	}
+	date := func(t time.Time) string { return t.In(location).Format("2006-01-02") }
+	money := func(amount float64) string { return fmt.Sprintf("%.2f %s", amount, invoice.Currency) }
+
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.AddPage()
+	pdf.SetFont("Arial", "B", 16)
+	pdf.Cell(40, 10, "Invoice "+invoice.Number)
+	pdf.Ln(14)
+
+	pdf.SetFont("Arial", "", 10)
+	pdf.Cell(40, 6, "Organization: "+invoice.OrgName+" ("+invoice.OrgID+")")
+	pdf.Ln(6)
+	if invoice.BillingEmail != "" {
+		pdf.Cell(40, 6, "Billing email: "+invoice.BillingEmail)
+		pdf.Ln(6)
+	}
+	// The period end is exclusive, so show the cycle's last day
+	pdf.Cell(40, 6, fmt.Sprintf("Period: %s to %s (%s)", date(invoice.PeriodStart), date(invoice.PeriodEnd.Add(-time.Nanosecond)), invoice.Timezone))
+	pdf.Ln(6)
+	pdf.Cell(40, 6, "Issued: "+date(invoice.IssuedAt))
+	pdf.Ln(12)
+
+	pdf.SetFont("Arial", "B", 10)
+	pdf.CellFormat(100, 7, "Description", "B", 0, "L", false, 0, "")
+	pdf.CellFormat(25, 7, "Requests", "B", 0, "R", false, 0, "")
+	pdf.CellFormat(30, 7, "Tokens", "B", 0, "R", false, 0, "")
+	pdf.CellFormat(35, 7, "Amount", "B", 1, "R", false, 0, "")
+
+	pdf.SetFont("Arial", "", 10)
+	for _, line := range invoice.Lines {
+		requests, tokens := "", ""
+		if line.Kind == models.InvoiceLineUsage {
+			requests = fmt.Sprintf("%d", line.Requests)
+			tokens = fmt.Sprintf("%d", line.Tokens)
+		}
+		pdf.CellFormat(100, 6, line.Description, "", 0, "L", false, 0, "")
+		pdf.CellFormat(25, 6, requests, "", 0, "R", false, 0, "")
+		pdf.CellFormat(30, 6, tokens, "", 0, "R", false, 0, "")
+		pdf.CellFormat(35, 6, money(line.Amount), "", 1, "R", false, 0, "")
+	}
+
+	pdf.SetFont("Arial", "B", 10)
+	pdf.CellFormat(125, 7, "Total", "T", 0, "L", false, 0, "")
+	pdf.CellFormat(30, 7, fmt.Sprintf("%d", invoice.TotalTokens), "T", 0, "R", false, 0, "")
+	pdf.CellFormat(35, 7, money(invoice.Total), "T", 1, "R", false, 0, "")
+	pdf.Ln(8)
+
+	pdf.Cell(40, 7, "Payments received")
+	pdf.Ln(7)
+	pdf.SetFont("Arial", "", 10)
+	if len(invoice.Payments) == 0 {
+		pdf.Cell(40, 6, "None")
+		pdf.Ln(6)
+	}
+	for _, payment := range invoice.Payments {
+		pdf.CellFormat(30, 6, date(payment.ReceivedAt), "", 0, "L", false, 0, "")
+		pdf.CellFormat(125, 6, payment.Description, "", 0, "L", false, 0, "")
+		pdf.CellFormat(35, 6, money(payment.Amount), "", 1, "R", false, 0, "")
+	}
+	pdf.Ln(6)
+
+	pdf.CellFormat(155, 6, "Opening wallet balance", "", 0, "L", false, 0, "")
+	pdf.CellFormat(35, 6, money(invoice.OpeningBalance), "", 1, "R", false, 0, "")
+	pdf.CellFormat(155, 6, "Closing wallet balance", "", 0, "L", false, 0, "")
+	pdf.CellFormat(35, 6, money(invoice.ClosingBalance), "", 1, "R", false, 0, "")
+	pdf.Ln(10)
+
+	pdf.SetFont("Arial", "", 7)
+	pdf.Cell(40, 4, "Checksum: "+invoice.Checksum)
+
+	return pdf.Output(w)
+}
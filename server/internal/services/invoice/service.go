@@ -0,0 +1,577 @@
+package invoice
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"freedom-ai/management-server/internal/database"
+	"freedom-ai/management-server/internal/models"
+	"freedom-ai/management-server/internal/services/consumption"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+const (
+	currency = "USD"
+	// centTolerance is the smallest difference shown as a reconciliation line
+	centTolerance = 0.005
+)
+
+var (
+	// ErrCycleOpen is returned for a billing cycle that has not closed yet
+	ErrCycleOpen = errors.New("billing cycle has not closed yet")
+	// ErrNoActivity is returned when an organization had no charges or payments in the cycle
+	ErrNoActivity = errors.New("no charges or payments in billing cycle")
+	// ErrBillingIncomplete is returned while a day of the cycle is not billed yet:
+	// its billing run is running or failed, or it has billable consumption but no run
+	ErrBillingIncomplete = errors.New("billing cycle has days not billed yet")
+)
+
+type Service struct {
+	db     *mongo.Database
+	logger *zap.Logger
+}
+
+func NewService(db *mongo.Database, logger *zap.Logger) *Service {
+	return &Service{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// EnsureIndexes creates the invoices indexes
+func (s *Service) EnsureIndexes(ctx context.Context) error {
+	_, err := s.db.Collection("invoices").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "orgId", Value: 1}, {Key: "periodStart", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "number", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create invoices indexes: %w", err)
+	}
+	return nil
+}
+
+// GenerateDueInvoices issues each organization's invoices for its closed
+// billing cycles since its latest invoice, or since its first billing or
+// consumption if it was never invoiced, oldest first. Cycles without activity
+// are skipped. A cycle with days not billed yet is deferred to a later run
+// together with the cycles after it, so invoices are issued in cycle order.
+func (s *Service) GenerateDueInvoices(ctx context.Context) error {
+	cursor, err := s.db.Collection("organizations").Find(ctx, bson.M{})
+	if err != nil {
+		return fmt.Errorf("failed to find organizations: %w", err)
+	}
+	var orgs []models.Organization
+	if err := cursor.All(ctx, &orgs); err != nil {
+		return fmt.Errorf("failed to decode organizations: %w", err)
+	}
+
+	now := time.Now()
+	for _, org := range orgs {
+		cycles, err := s.dueCycles(ctx, org, now)
+		if err != nil {
+			s.logger.Error("Failed to find billing cycles to invoice", zap.String("orgId", org.OrgID), zap.Error(err))
+			continue
+		}
+
+		for _, cycleStart := range cycles {
+			invoice, created, err := s.generate(ctx, org, cycleStart)
+			if errors.Is(err, ErrNoActivity) {
+				continue
+			}
+			if errors.Is(err, ErrBillingIncomplete) {
+				s.logger.Warn("Deferring invoice until billing completes",
+					zap.String("orgId", org.OrgID),
+					zap.Time("periodStart", cycleStart),
+					zap.Error(err))
+				break
+			}
+			if err != nil {
+				s.logger.Error("Failed to generate invoice",
+					zap.String("orgId", org.OrgID),
+					zap.Time("periodStart", cycleStart),
+					zap.Error(err))
+				break
+			}
+			if created {
+				s.logger.Info("Issued invoice", zap.String("orgId", org.OrgID), zap.String("number", invoice.Number), zap.Float64("total", invoice.Total))
+			}
+		}
+	}
+	return nil
+}
+
+// dueCycles returns the starts of an organization's closed billing cycles
+// after its latest invoice, or from the cycle of its first activity if it was
+// never invoiced, oldest first
+func (s *Service) dueCycles(ctx context.Context, org models.Organization, now time.Time) ([]time.Time, error) {
+	calendar := org.Calendar()
+
+	var from time.Time
+	var latest models.Invoice
+	err := s.db.Collection("invoices").FindOne(ctx, bson.M{"orgId": org.OrgID},
+		options.FindOne().SetSort(bson.D{{Key: "periodStart", Value: -1}}).SetProjection(bson.M{"periodStart": 1})).Decode(&latest)
+	switch {
+	case err == nil:
+		from = calendar.NextCycleStart(latest.PeriodStart)
+	case err == mongo.ErrNoDocuments:
+		first, err := s.firstActivity(ctx, org.OrgID)
+		if err != nil || first.IsZero() {
+			return nil, err
+		}
+		from = calendar.CycleStart(first)
+	default:
+		return nil, fmt.Errorf("failed to find latest invoice: %w", err)
+	}
+
+	var cycles []time.Time
+	for start, current := from, calendar.CycleStart(now); start.Before(current); start = calendar.NextCycleStart(start) {
+		cycles = append(cycles, start)
+	}
+	return cycles, nil
+}
+
+// firstActivity returns the start of an organization's earliest billed period
+// or the time of its earliest billable consumption, whichever is first, or
+// zero without either
+func (s *Service) firstActivity(ctx context.Context, orgID string) (time.Time, error) {
+	var first time.Time
+
+	var record models.BillingHistory
+	err := s.db.Collection("billing_history").FindOne(ctx,
+		bson.M{"organizationId": orgID, "status": "completed"},
+		options.FindOne().SetSort(bson.D{{Key: "periodStart", Value: 1}}).SetProjection(bson.M{"periodStart": 1})).Decode(&record)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return first, fmt.Errorf("failed to find first billing: %w", err)
+	}
+	if err == nil {
+		first = record.PeriodStart
+	}
+
+	var usage models.TokenConsumption
+	err = s.db.Collection("token_consumption").FindOne(ctx,
+		bson.M{
+			"organizationId": orgID,
+			"$or":            consumption.BillableConditions(),
+			"totalTokens":    bson.M{"$gt": 0},
+		},
+		options.FindOne().SetSort(bson.D{{Key: "timestamp", Value: 1}}).SetProjection(bson.M{"timestamp": 1})).Decode(&usage)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return first, fmt.Errorf("failed to find first consumption: %w", err)
+	}
+	if err == nil && (first.IsZero() || usage.Timestamp.Before(first)) {
+		first = usage.Timestamp
+	}
+	return first, nil
+}
+
+// Generate issues an organization's invoice for the billing cycle starting in
+// the given month, or returns the existing one (created false)
+func (s *Service) Generate(ctx context.Context, orgID string, year int, month time.Month) (*models.Invoice, bool, error) {
+	var org models.Organization
+	if err := s.db.Collection("organizations").FindOne(ctx, bson.M{"orgId": orgID}).Decode(&org); err != nil {
+		return nil, false, fmt.Errorf("failed to find organization: %w", err)
+	}
+	return s.generate(ctx, org, org.Calendar().CycleFor(year, month))
+}
+
+func (s *Service) generate(ctx context.Context, org models.Organization, cycleStart time.Time) (*models.Invoice, bool, error) {
+	calendar := org.Calendar()
+	cycleEnd := calendar.NextCycleStart(cycleStart)
+	if cycleEnd.After(time.Now()) {
+		return nil, false, ErrCycleOpen
+	}
+
+	if existing, err := s.find(ctx, org.OrgID, cycleStart); err != nil || existing != nil {
+		return existing, false, err
+	}
+
+	// An invoice is final, so it waits for every day of the cycle to be billed
+	unbilled, err := s.unbilledDays(ctx, org, cycleStart, cycleEnd)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(unbilled) > 0 {
+		return nil, false, fmt.Errorf("%w: %d day(s) from %s", ErrBillingIncomplete, len(unbilled), unbilled[0])
+	}
+
+	invoice, err := s.build(ctx, org, cycleStart, cycleEnd)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(invoice.Lines) == 0 && len(invoice.Payments) == 0 {
+		return nil, false, ErrNoActivity
+	}
+
+	// Numbering and insert commit together so numbers have no gaps
+	err = database.Transact(ctx, s.db, s.logger, func(ctx context.Context) error {
+		sequence, err := database.NextSequence(ctx, s.db, "invoice")
+		if err != nil {
+			return err
+		}
+		invoice.ID = primitive.NewObjectID()
+		invoice.Sequence = sequence
+		invoice.Number = fmt.Sprintf("INV-%06d", sequence)
+		invoice.Checksum = Checksum(*invoice)
+		if _, err := s.db.Collection("invoices").InsertOne(ctx, invoice); err != nil {
+			return fmt.Errorf("failed to store invoice: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			// Issued concurrently
+			existing, findErr := s.find(ctx, org.OrgID, cycleStart)
+			return existing, false, findErr
+		}
+		return nil, false, err
+	}
+	return invoice, true, nil
+}
+
+// unbilledDays returns the local days (YYYY-MM-DD) of the cycle whose billing
+// run is running or failed, or that have billable consumption but were never
+// billed, in order
+func (s *Service) unbilledDays(ctx context.Context, org models.Organization, cycleStart, cycleEnd time.Time) ([]string, error) {
+	location := org.Calendar().Location
+	day := func(t time.Time) string { return t.In(location).Format("2006-01-02") }
+	period := bson.M{"$gte": cycleStart, "$lt": cycleEnd}
+
+	billed := make(map[string]bool)
+	pending := make(map[string]bool)
+
+	cursor, err := s.db.Collection("billing_runs").Find(ctx, bson.M{"orgId": org.OrgID, "periodStart": period})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load billing runs: %w", err)
+	}
+	var runs []models.BillingRun
+	if err := cursor.All(ctx, &runs); err != nil {
+		return nil, fmt.Errorf("failed to decode billing runs: %w", err)
+	}
+	for _, run := range runs {
+		if run.Status == models.BillingRunCompleted {
+			billed[day(run.PeriodStart)] = true
+		} else {
+			pending[day(run.PeriodStart)] = true
+		}
+	}
+
+	// Days billed before billing runs existed
+	cursor, err = s.db.Collection("billing_history").Find(ctx, bson.M{
+		"organizationId": org.OrgID,
+		"periodStart":    period,
+		"status":         "completed",
+		"type":           bson.M{"$exists": false},
+	}, options.Find().SetProjection(bson.M{"periodStart": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load billing history: %w", err)
+	}
+	var history []models.BillingHistory
+	if err := cursor.All(ctx, &history); err != nil {
+		return nil, fmt.Errorf("failed to decode billing history: %w", err)
+	}
+	for _, record := range history {
+		billed[day(record.PeriodStart)] = true
+	}
+
+	// Days with consumption that daily billing would charge
+	cursor, err = s.db.Collection("token_consumption").Aggregate(ctx, []bson.M{
+		{"$match": bson.M{
+			"organizationId": org.OrgID,
+			"timestamp":      period,
+			"$or":            consumption.BillableConditions(),
+			"totalTokens":    bson.M{"$gt": 0},
+		}},
+		{"$group": bson.M{"_id": bson.M{"$dateToString": bson.M{
+			"format":   "%Y-%m-%d",
+			"date":     "$timestamp",
+			"timezone": location.String(),
+		}}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find billable days: %w", err)
+	}
+	var days []struct {
+		Day string `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &days); err != nil {
+		return nil, fmt.Errorf("failed to decode billable days: %w", err)
+	}
+	for _, d := range days {
+		if !billed[d.Day] {
+			pending[d.Day] = true
+		}
+	}
+
+	unbilled := make([]string, 0, len(pending))
+	for d := range pending {
+		if !billed[d] {
+			unbilled = append(unbilled, d)
+		}
+	}
+	sort.Strings(unbilled)
+	return unbilled, nil
+}
+
+// build rolls up the cycle's charges, usage and payments into an invoice
+func (s *Service) build(ctx context.Context, org models.Organization, cycleStart, cycleEnd time.Time) (*models.Invoice, error) {
+	invoice := &models.Invoice{
+		OrgID:        org.OrgID,
+		OrgName:      org.Name,
+		BillingEmail: org.BillingEmail,
+		Month:        cycleStart.Format("2006-01"),
+		PeriodStart:  cycleStart.UTC(),
+		PeriodEnd:    cycleEnd.UTC(),
+		Timezone:     org.Calendar().Location.String(),
+		Currency:     currency,
+		Lines:        []models.InvoiceLine{},
+		Payments:     []models.InvoicePayment{},
+		Status:       "issued",
+		IssuedAt:     time.Now().UTC().Truncate(time.Millisecond),
+	}
+
+	// What the wallet was charged: daily usage and re-rating corrections for
+	// days in the cycle, and manual adjustments and credit notes issued in it.
+	// Those may target an earlier, already issued invoice, which stays as it is.
+	cycle := bson.M{"$gte": cycleStart, "$lt": cycleEnd}
+	cursor, err := s.db.Collection("billing_history").Find(ctx, bson.M{
+		"organizationId": org.OrgID,
+		"status":         "completed",
+		"$or": bson.A{
+			bson.M{"type": bson.M{"$exists": false}, "periodStart": cycle},
+			bson.M{"type": models.BillingTypeAdjustment, "reratingId": bson.M{"$exists": true}, "periodStart": cycle},
+			bson.M{
+				"type":        bson.M{"$in": bson.A{models.BillingTypeAdjustment, models.BillingTypeCreditNote}},
+				"reratingId":  bson.M{"$exists": false},
+				"billingDate": cycle,
+			},
+		},
+	}, options.Find().SetSort(bson.D{{Key: "periodStart", Value: 1}, {Key: "billingDate", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load billing history: %w", err)
+	}
+	var history []models.BillingHistory
+	if err := cursor.All(ctx, &history); err != nil {
+		return nil, fmt.Errorf("failed to decode billing history: %w", err)
+	}
+
+	// Refunds are not selected; they are listed with the payments
+	var adjustments []models.InvoiceLine
+	for _, record := range history {
+		invoice.Total += record.TotalCost
+		if record.Type == "" || !record.ReratingID.IsZero() {
+			// Usage, and re-rating corrections already reflected in record costs
+			continue
+		}
+		day := record.PeriodStart.In(org.Calendar().Location).Format("2006-01-02")
+		line := models.InvoiceLine{
+			Kind:        models.InvoiceLineAdjustment,
+			Description: "Adjustment for " + day,
+			Amount:      record.TotalCost,
+		}
+		if record.Type == models.BillingTypeCreditNote {
+			line.Kind = models.InvoiceLineCreditNote
+			line.Description = fmt.Sprintf("Credit note %s for %s", record.Reference, day)
+			if record.InvoiceNumber != "" {
+				line.Description = fmt.Sprintf("Credit note %s against invoice %s", record.Reference, record.InvoiceNumber)
+			}
+		}
+		if record.ReasonCode != "" {
+			line.Description += fmt.Sprintf(" (%s)", record.ReasonCode)
+		}
+		adjustments = append(adjustments, line)
+	}
+
+	usage, err := s.usageLines(ctx, org.OrgID, cycleStart, cycleEnd)
+	if err != nil {
+		return nil, err
+	}
+	invoice.Lines = append(invoice.Lines, usage...)
+	invoice.Lines = append(invoice.Lines, adjustments...)
+
+	// Keep the lines summing to what was charged, e.g. when a day failed billing
+	var linesTotal float64
+	for _, line := range invoice.Lines {
+		linesTotal += line.Amount
+		invoice.TotalTokens += line.Tokens
+	}
+	if difference := invoice.Total - linesTotal; math.Abs(difference) >= centTolerance {
+		invoice.Lines = append(invoice.Lines, models.InvoiceLine{
+			Kind:        models.InvoiceLineReconciliation,
+			Description: "Difference between charged and recorded usage",
+			Amount:      difference,
+		})
+	}
+
+	if err := s.addPayments(ctx, invoice, cycleStart, cycleEnd); err != nil {
+		return nil, err
+	}
+	return invoice, nil
+}
+
+// usageLines sums the cycle's billable consumption per model and assistant type
+func (s *Service) usageLines(ctx context.Context, orgID string, cycleStart, cycleEnd time.Time) ([]models.InvoiceLine, error) {
+	cursor, err := s.db.Collection("token_consumption").Aggregate(ctx, []bson.M{
+		{"$match": bson.M{
+			"organizationId": orgID,
+			"timestamp":      bson.M{"$gte": cycleStart, "$lt": cycleEnd},
+			"$or":            consumption.BillableConditions(),
+			"totalTokens":    bson.M{"$gt": 0},
+		}},
+		{"$group": bson.M{
+			"_id":      bson.M{"model": "$model", "assistantType": "$assistantType"},
+			"tokens":   bson.M{"$sum": "$totalTokens"},
+			"cost":     bson.M{"$sum": "$cost"},
+			"requests": bson.M{"$sum": 1},
+		}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate usage: %w", err)
+	}
+	var results []struct {
+		ID struct {
+			Model         string `bson:"model"`
+			AssistantType string `bson:"assistantType"`
+		} `bson:"_id"`
+		Tokens   int64   `bson:"tokens"`
+		Cost     float64 `bson:"cost"`
+		Requests int64   `bson:"requests"`
+	}
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, fmt.Errorf("failed to decode usage: %w", err)
+	}
+
+	lines := make([]models.InvoiceLine, 0, len(results))
+	for _, result := range results {
+		model := result.ID.Model
+		if model == "" {
+			model = "unknown"
+		}
+		assistantType := result.ID.AssistantType
+		if assistantType == "" {
+			assistantType = "unknown"
+		}
+		lines = append(lines, models.InvoiceLine{
+			Kind:          models.InvoiceLineUsage,
+			Model:         model,
+			AssistantType: assistantType,
+			Description:   fmt.Sprintf("%s (%s)", model, assistantType),
+			Tokens:        result.Tokens,
+			Requests:      result.Requests,
+			Amount:        result.Cost,
+		})
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		if lines[i].Model != lines[j].Model {
+			return lines[i].Model < lines[j].Model
+		}
+		return lines[i].AssistantType < lines[j].AssistantType
+	})
+	return lines, nil
+}
+
+// addPayments lists the cycle's top-ups and refunds and the wallet balances
+// around it from the ledger
+func (s *Service) addPayments(ctx context.Context, invoice *models.Invoice, cycleStart, cycleEnd time.Time) error {
+	ledger := s.db.Collection("wallet_ledger")
+	cursor, err := ledger.Find(ctx, bson.M{
+		"orgId":     invoice.OrgID,
+		"type":      bson.M{"$in": bson.A{models.LedgerTopUpCredit, models.LedgerRefund}},
+		"createdAt": bson.M{"$gte": cycleStart, "$lt": cycleEnd},
+	}, options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}}))
+	if err != nil {
+		return fmt.Errorf("failed to load top-ups: %w", err)
+	}
+	var entries []models.LedgerEntry
+	if err := cursor.All(ctx, &entries); err != nil {
+		return fmt.Errorf("failed to decode top-ups: %w", err)
+	}
+	for _, entry := range entries {
+		invoice.Payments = append(invoice.Payments, models.InvoicePayment{
+			Reference:   entry.Reference,
+			Description: entry.Description,
+			Amount:      entry.Amount,
+			ReceivedAt:  entry.CreatedAt,
+		})
+		invoice.PaymentsTotal += entry.Amount
+	}
+
+	if invoice.OpeningBalance, err = s.balanceBefore(ctx, invoice.OrgID, cycleStart); err != nil {
+		return err
+	}
+	if invoice.ClosingBalance, err = s.balanceBefore(ctx, invoice.OrgID, cycleEnd); err != nil {
+		return err
+	}
+	return nil
+}
+
+// balanceBefore sums an organization's ledger entries posted before t
+func (s *Service) balanceBefore(ctx context.Context, orgID string, t time.Time) (float64, error) {
+	cursor, err := s.db.Collection("wallet_ledger").Aggregate(ctx, []bson.M{
+		{"$match": bson.M{"orgId": orgID, "createdAt": bson.M{"$lt": t}}},
+		{"$group": bson.M{"_id": nil, "balance": bson.M{"$sum": "$amount"}}},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to sum ledger: %w", err)
+	}
+	var results []struct {
+		Balance float64 `bson:"balance"`
+	}
+	if err := cursor.All(ctx, &results); err != nil {
+		return 0, fmt.Errorf("failed to decode ledger sum: %w", err)
+	}
+	if len(results) == 0 {
+		return 0, nil
+	}
+	return results[0].Balance, nil
+}
+
+func (s *Service) find(ctx context.Context, orgID string, cycleStart time.Time) (*models.Invoice, error) {
+	var invoice models.Invoice
+	err := s.db.Collection("invoices").FindOne(ctx, bson.M{"orgId": orgID, "periodStart": cycleStart}).Decode(&invoice)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find invoice: %w", err)
+	}
+	return &invoice, nil
+}
+
+// Checksum hashes an invoice's content, excluding its ID and checksum
+func Checksum(invoice models.Invoice) string {
+	invoice.ID = primitive.NilObjectID
+	invoice.Checksum = ""
+	invoice.PeriodStart = invoice.PeriodStart.UTC()
+	invoice.PeriodEnd = invoice.PeriodEnd.UTC()
+	invoice.IssuedAt = invoice.IssuedAt.UTC()
+	// Normalise a copy; the caller's payments are left as they are
+	if invoice.Payments != nil {
+		payments := make([]models.InvoicePayment, len(invoice.Payments))
+		for i, payment := range invoice.Payments {
+			payment.ReceivedAt = payment.ReceivedAt.UTC()
+			payments[i] = payment
+		}
+		invoice.Payments = payments
+	}
+	data, _ := json.Marshal(invoice)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,203 @@
+package invoice
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"freedom-ai/management-server/internal/database/databasetest"
+	"freedom-ai/management-server/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+func TestChecksumLeavesPaymentsUnchanged(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatalf("load location: %v", err)
+	}
+	received := time.Date(2024, 5, 3, 10, 0, 0, 0, berlin)
+	invoice := models.Invoice{
+		Number:   "INV-000001",
+		Total:    12.5,
+		Payments: []models.InvoicePayment{{Amount: 20, ReceivedAt: received}},
+	}
+
+	sum := Checksum(invoice)
+	if invoice.Payments[0].ReceivedAt.Location() != berlin {
+		t.Fatalf("Checksum changed the caller's payment time to %v", invoice.Payments[0].ReceivedAt)
+	}
+	// The same instant in another zone hashes the same
+	invoice.Payments[0].ReceivedAt = received.UTC()
+	if Checksum(invoice) != sum {
+		t.Fatal("Checksum depends on the payment time zone")
+	}
+	invoice.Total = 13
+	if Checksum(invoice) == sum {
+		t.Fatal("Checksum ignores the invoice total")
+	}
+}
+
+// TestGenerateDueInvoicesCatchesUpDeferredCycles defers a cycle whose billing
+// is incomplete and issues it, with the cycle after it, once billing completes
+// after that cycle has closed too
+func TestGenerateDueInvoicesCatchesUpDeferredCycles(t *testing.T) {
+	ctx := context.Background()
+	db := databasetest.Connect(t)
+	s := NewService(db, zap.NewNop())
+	if err := s.EnsureIndexes(ctx); err != nil {
+		t.Fatalf("EnsureIndexes: %v", err)
+	}
+	if _, err := db.Collection("organizations").InsertOne(ctx, bson.M{"orgId": "org-1", "name": "Acme"}); err != nil {
+		t.Fatalf("insert organization: %v", err)
+	}
+
+	calendar := models.UTCCalendar
+	lastCycle := calendar.CycleStart(calendar.CycleStart(time.Now()).Add(-time.Nanosecond))
+	earlierCycle := calendar.CycleStart(lastCycle.Add(-time.Nanosecond))
+	earlierDay := earlierCycle.AddDate(0, 0, 2)
+	lastDay := lastCycle.AddDate(0, 0, 2)
+
+	for _, day := range []struct {
+		start time.Time
+		cost  float64
+	}{{earlierDay, 2}, {lastDay, 3}} {
+		if _, err := db.Collection("token_consumption").InsertOne(ctx, models.TokenConsumption{
+			RequestID:   day.start.Format("2006-01-02"),
+			Timestamp:   day.start.Add(time.Hour),
+			OrgID:       "org-1",
+			Model:       "gpt-4",
+			TotalTokens: 100,
+			Status:      "complete",
+			Cost:        day.cost,
+		}); err != nil {
+			t.Fatalf("insert consumption: %v", err)
+		}
+	}
+	billDay := func(start time.Time, cost float64) {
+		t.Helper()
+		record := models.BillingHistory{OrganizationID: "org-1", PeriodStart: start, PeriodEnd: start.AddDate(0, 0, 1), TotalTokens: 100, TotalCost: cost, Status: "completed"}
+		if _, err := db.Collection("billing_history").InsertOne(ctx, record); err != nil {
+			t.Fatalf("insert billing history: %v", err)
+		}
+		if _, err := db.Collection("billing_runs").UpdateOne(ctx,
+			bson.M{"orgId": "org-1", "periodStart": start},
+			bson.M{"$set": bson.M{"periodEnd": start.AddDate(0, 0, 1), "status": models.BillingRunCompleted}},
+			options.Update().SetUpsert(true)); err != nil {
+			t.Fatalf("complete billing run: %v", err)
+		}
+	}
+
+	// The earlier cycle's day failed billing; the last cycle is billed
+	if _, err := db.Collection("billing_runs").InsertOne(ctx, models.BillingRun{
+		OrgID: "org-1", PeriodStart: earlierDay, PeriodEnd: earlierDay.AddDate(0, 0, 1), Status: models.BillingRunFailed,
+	}); err != nil {
+		t.Fatalf("insert billing run: %v", err)
+	}
+	billDay(lastDay, 3)
+
+	if err := s.GenerateDueInvoices(ctx); err != nil {
+		t.Fatalf("GenerateDueInvoices: %v", err)
+	}
+	if invoices := listInvoices(t, db); len(invoices) != 0 {
+		t.Fatalf("issued %d invoices while the earlier cycle is not billed, want none", len(invoices))
+	}
+
+	billDay(earlierDay, 2)
+	for i := 0; i < 2; i++ {
+		if err := s.GenerateDueInvoices(ctx); err != nil {
+			t.Fatalf("GenerateDueInvoices: %v", err)
+		}
+	}
+	invoices := listInvoices(t, db)
+	if len(invoices) != 2 {
+		t.Fatalf("issued %d invoices, want 2", len(invoices))
+	}
+	if !invoices[0].PeriodStart.Equal(earlierCycle) || invoices[0].Total != 2 ||
+		!invoices[1].PeriodStart.Equal(lastCycle) || invoices[1].Total != 3 {
+		t.Fatalf("invoices = %+v, want the earlier cycle for 2 then the last for 3", invoices)
+	}
+	if invoices[0].Sequence >= invoices[1].Sequence {
+		t.Fatalf("sequences %d, %d, want them in cycle order", invoices[0].Sequence, invoices[1].Sequence)
+	}
+}
+
+// TestBuildListsCreditNotesWhenIssued puts a credit note against an issued
+// invoice on the invoice of the cycle it was issued in, naming its target
+func TestBuildListsCreditNotesWhenIssued(t *testing.T) {
+	ctx := context.Background()
+	db := databasetest.Connect(t)
+	s := NewService(db, zap.NewNop())
+	org := models.Organization{OrgID: "org-1", Name: "Acme"}
+
+	calendar := models.UTCCalendar
+	earlierCycle := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	lastCycle := calendar.NextCycleStart(earlierCycle)
+	invoiceID := primitive.NewObjectID()
+	for _, record := range []models.BillingHistory{
+		{OrganizationID: "org-1", BillingDate: earlierCycle.AddDate(0, 0, 3), PeriodStart: earlierCycle.AddDate(0, 0, 2), PeriodEnd: earlierCycle.AddDate(0, 0, 3), TotalCost: 5, Status: "completed"},
+		{OrganizationID: "org-1", BillingDate: lastCycle.AddDate(0, 0, 3), PeriodStart: lastCycle.AddDate(0, 0, 2), PeriodEnd: lastCycle.AddDate(0, 0, 3), TotalCost: 3, Status: "completed"},
+		{
+			OrganizationID: "org-1",
+			BillingDate:    lastCycle.AddDate(0, 0, 10),
+			PeriodStart:    earlierCycle,
+			PeriodEnd:      lastCycle,
+			TotalCost:      -2,
+			Status:         "completed",
+			Type:           models.BillingTypeCreditNote,
+			ReasonCode:     models.ReasonBillingError,
+			Reference:      "CN-000001",
+			InvoiceID:      &invoiceID,
+			InvoiceNumber:  "INV-000001",
+		},
+	} {
+		record.ID = primitive.NewObjectID()
+		if _, err := db.Collection("billing_history").InsertOne(ctx, record); err != nil {
+			t.Fatalf("insert billing history: %v", err)
+		}
+	}
+
+	earlier, err := s.build(ctx, org, earlierCycle, lastCycle)
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	if earlier.Total != 5 {
+		t.Fatalf("earlier cycle total = %v, want 5 without the later credit note", earlier.Total)
+	}
+
+	last, err := s.build(ctx, org, lastCycle, calendar.NextCycleStart(lastCycle))
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	if last.Total != 1 {
+		t.Fatalf("last cycle total = %v, want 1", last.Total)
+	}
+	var notes []models.InvoiceLine
+	for _, line := range last.Lines {
+		if line.Kind == models.InvoiceLineCreditNote {
+			notes = append(notes, line)
+		}
+	}
+	want := "Credit note CN-000001 against invoice INV-000001 (billing_error)"
+	if len(notes) != 1 || notes[0].Description != want || notes[0].Amount != -2 {
+		t.Fatalf("credit note lines = %+v, want one of -2 described as %q", notes, want)
+	}
+}
+
+func listInvoices(t *testing.T, db *mongo.Database) []models.Invoice {
+	t.Helper()
+	cursor, err := db.Collection("invoices").Find(context.Background(), bson.M{},
+		options.Find().SetSort(bson.D{{Key: "periodStart", Value: 1}}))
+	if err != nil {
+		t.Fatalf("find invoices: %v", err)
+	}
+	var invoices []models.Invoice
+	if err := cursor.All(context.Background(), &invoices); err != nil {
+		t.Fatalf("decode invoices: %v", err)
+	}
+	return invoices
+}
@@ -0,0 +1,283 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"math"
+	"time"
+
+	"freedom-ai/management-server/internal/metrics"
+	"freedom-ai/management-server/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+// ErrDuplicateReference is returned inside a transaction when the entry's
+// reference was already posted
+var ErrDuplicateReference = errors.New("ledger reference already posted")
+
+// driftTolerance absorbs floating point error when comparing balances
+const driftTolerance = 1e-6
+
+// contraAccounts is the other side of each entry type
+var contraAccounts = map[string]string{
+	models.LedgerUsageDebit:  "revenue",
+	models.LedgerTopUpCredit: "payments",
+	models.LedgerRefund:      "payments",
+	models.LedgerPromo:       "promotions",
+	models.LedgerAdjustment:  "adjustments",
+}
+
+type Service struct {
+	db     *mongo.Database
+	logger *zap.Logger
+}
+
+func NewService(db *mongo.Database, logger *zap.Logger) *Service {
+	return &Service{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// EnsureIndexes creates the ledger indexes
+func (s *Service) EnsureIndexes(ctx context.Context) error {
+	_, err := s.db.Collection("wallet_ledger").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "orgId", Value: 1}, {Key: "createdAt", Value: -1}}},
+		{
+			Keys: bson.D{{Key: "reference", Value: 1}},
+			Options: options.Index().
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{"reference": bson.M{"$gt": ""}}),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create wallet_ledger indexes: %w", err)
+	}
+	return nil
+}
+
+// Post appends an entry and applies it to the cached wallet balance. An entry
+// whose reference was already posted is not applied again; the stored entry is
+// returned with applied false.
+func (s *Service) Post(ctx context.Context, entry models.LedgerEntry) (models.LedgerEntry, bool, error) {
+	contra, ok := contraAccounts[entry.Type]
+	if !ok {
+		return models.LedgerEntry{}, false, fmt.Errorf("unknown ledger entry type %q", entry.Type)
+	}
+	entry.ID = primitive.NewObjectID()
+	entry.ContraAccount = contra
+	entry.CreatedAt = time.Now()
+
+	collection := s.db.Collection("wallet_ledger")
+	if _, err := collection.InsertOne(ctx, entry); err != nil {
+		if mongo.IsDuplicateKeyError(err) && entry.Reference != "" {
+			metrics.Ledger.Add("duplicates", 1)
+			if inTransaction(ctx) {
+				// The failed write aborted the transaction; the caller rolls back
+				return models.LedgerEntry{}, false, fmt.Errorf("%w: %s", ErrDuplicateReference, entry.Reference)
+			}
+			var existing models.LedgerEntry
+			if err := collection.FindOne(ctx, bson.M{"reference": entry.Reference}).Decode(&existing); err != nil {
+				return models.LedgerEntry{}, false, fmt.Errorf("failed to load ledger entry %s: %w", entry.Reference, err)
+			}
+			return existing, false, nil
+		}
+		return models.LedgerEntry{}, false, fmt.Errorf("failed to append ledger entry: %w", err)
+	}
+
+	var org models.Organization
+	err := s.db.Collection("organizations").FindOneAndUpdate(ctx,
+		bson.M{"orgId": entry.OrgID},
+		bson.M{
+			"$inc": bson.M{"walletBalance": entry.Amount},
+			"$set": bson.M{"updatedAt": time.Now()},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&org)
+	if err != nil {
+		// Keep the ledger and the cached balance in step; a transaction rolls
+		// the entry back by itself
+		if inTransaction(ctx) {
+			return models.LedgerEntry{}, false, fmt.Errorf("failed to update wallet balance of %s: %w", entry.OrgID, err)
+		}
+		if _, delErr := collection.DeleteOne(ctx, bson.M{"_id": entry.ID}); delErr != nil {
+			s.logger.Error("Failed to remove unapplied ledger entry", zap.String("entryId", entry.ID.Hex()), zap.Error(delErr))
+		}
+		return models.LedgerEntry{}, false, fmt.Errorf("failed to update wallet balance of %s: %w", entry.OrgID, err)
+	}
+
+	entry.BalanceAfter = org.WalletBalance
+	if _, err := collection.UpdateOne(ctx, bson.M{"_id": entry.ID}, bson.M{"$set": bson.M{"balanceAfter": entry.BalanceAfter}}); err != nil {
+		if inTransaction(ctx) {
+			return models.LedgerEntry{}, false, fmt.Errorf("failed to record balance after ledger entry: %w", err)
+		}
+		s.logger.Warn("Failed to record balance after ledger entry", zap.String("entryId", entry.ID.Hex()), zap.Error(err))
+	}
+
+	if err := s.UpdateRestriction(ctx, org); err != nil {
+		if inTransaction(ctx) {
+			return models.LedgerEntry{}, false, err
+		}
+		s.logger.Warn("Failed to update credit restriction", zap.String("orgId", entry.OrgID), zap.Error(err))
+	}
+
+	metrics.Ledger.Add("entries", 1)
+	return entry, true, nil
+}
+
+// UpdateRestriction restricts an organization whose balance has crossed its
+// credit floor, and lifts the restriction once the balance is back above it
+func (s *Service) UpdateRestriction(ctx context.Context, org models.Organization) error {
+	over := org.OverCreditLimit()
+	if over == org.Restricted {
+		return nil
+	}
+
+	update := bson.M{"$set": bson.M{"restricted": true, "restrictedAt": time.Now()}}
+	if !over {
+		update = bson.M{"$set": bson.M{"restricted": false}, "$unset": bson.M{"restrictedAt": ""}}
+	}
+	// Only the first of concurrent updates records the transition
+	result, err := s.db.Collection("organizations").UpdateOne(ctx,
+		bson.M{"orgId": org.OrgID, "restricted": bson.M{"$ne": over}}, update)
+	if err != nil {
+		return fmt.Errorf("failed to update restriction of %s: %w", org.OrgID, err)
+	}
+	if result.ModifiedCount > 0 {
+		metrics.Ledger.Add("restriction_changes", 1)
+		s.logger.Info("Credit restriction changed",
+			zap.String("orgId", org.OrgID),
+			zap.Bool("restricted", over),
+			zap.String("accountMode", org.Mode()),
+			zap.Float64("walletBalance", org.WalletBalance),
+			zap.Float64("creditFloor", org.CreditFloor()))
+	}
+	return nil
+}
+
+// RecordOpeningBalance records an organization's existing wallet balance as an
+// adjustment without changing the balance. It is a no-op once the organization
+// has any ledger entry.
+func (s *Service) RecordOpeningBalance(ctx context.Context, orgID string, balance float64) error {
+	collection := s.db.Collection("wallet_ledger")
+	count, err := collection.CountDocuments(ctx, bson.M{"orgId": orgID}, options.Count().SetLimit(1))
+	if err != nil {
+		return fmt.Errorf("failed to count ledger entries: %w", err)
+	}
+	if count > 0 || balance == 0 {
+		return nil
+	}
+
+	_, err = collection.InsertOne(ctx, models.LedgerEntry{
+		ID:            primitive.NewObjectID(),
+		OrgID:         orgID,
+		Type:          models.LedgerAdjustment,
+		Amount:        balance,
+		ContraAccount: contraAccounts[models.LedgerAdjustment],
+		BalanceAfter:  balance,
+		Reference:     "opening:" + orgID,
+		Description:   "Opening balance",
+		CreatedAt:     time.Now(),
+	})
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("failed to record opening balance: %w", err)
+	}
+	return nil
+}
+
+// RecordOpeningBalances records opening balances for organizations that
+// predate the ledger
+func (s *Service) RecordOpeningBalances(ctx context.Context) error {
+	cursor, err := s.db.Collection("organizations").Find(ctx, bson.M{"walletBalance": bson.M{"$ne": 0}})
+	if err != nil {
+		return fmt.Errorf("failed to find organizations: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var org models.Organization
+		if err := cursor.Decode(&org); err != nil {
+			return fmt.Errorf("failed to decode organization: %w", err)
+		}
+		if err := s.RecordOpeningBalance(ctx, org.OrgID, org.WalletBalance); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// Drift is a difference between an organization's ledger and its cached balance
+type Drift struct {
+	OrgID         string  `json:"orgId"`
+	LedgerBalance float64 `json:"ledgerBalance"`
+	WalletBalance float64 `json:"walletBalance"`
+	Difference    float64 `json:"difference"` // walletBalance - ledgerBalance
+}
+
+// CheckConsistency compares every organization's cached wallet balance with
+// the sum of its ledger entries and reports the ones that drifted
+func (s *Service) CheckConsistency(ctx context.Context) ([]Drift, error) {
+	cursor, err := s.db.Collection("wallet_ledger").Aggregate(ctx, []bson.M{
+		{"$group": bson.M{"_id": "$orgId", "balance": bson.M{"$sum": "$amount"}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to sum ledger: %w", err)
+	}
+	var sums []struct {
+		OrgID   string  `bson:"_id"`
+		Balance float64 `bson:"balance"`
+	}
+	if err := cursor.All(ctx, &sums); err != nil {
+		return nil, fmt.Errorf("failed to decode ledger sums: %w", err)
+	}
+	ledgerBalances := make(map[string]float64, len(sums))
+	for _, sum := range sums {
+		ledgerBalances[sum.OrgID] = sum.Balance
+	}
+
+	orgCursor, err := s.db.Collection("organizations").Find(ctx, bson.M{},
+		options.Find().SetProjection(bson.M{"orgId": 1, "walletBalance": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find organizations: %w", err)
+	}
+	defer orgCursor.Close(ctx)
+
+	drifts := []Drift{}
+	for orgCursor.Next(ctx) {
+		var org models.Organization
+		if err := orgCursor.Decode(&org); err != nil {
+			return nil, fmt.Errorf("failed to decode organization: %w", err)
+		}
+		ledgerBalance := ledgerBalances[org.OrgID]
+		if math.Abs(org.WalletBalance-ledgerBalance) <= driftTolerance {
+			continue
+		}
+		drifts = append(drifts, Drift{
+			OrgID:         org.OrgID,
+			LedgerBalance: ledgerBalance,
+			WalletBalance: org.WalletBalance,
+			Difference:    org.WalletBalance - ledgerBalance,
+		})
+	}
+	if err := orgCursor.Err(); err != nil {
+		return nil, err
+	}
+
+	driftOrgs := new(expvar.Int)
+	driftOrgs.Set(int64(len(drifts)))
+	metrics.Ledger.Set("drift_orgs", driftOrgs)
+	for _, drift := range drifts {
+		s.logger.Warn("Wallet balance drifted from ledger",
+			zap.String("orgId", drift.OrgID),
+			zap.Float64("walletBalance", drift.WalletBalance),
+			zap.Float64("ledgerBalance", drift.LedgerBalance))
+	}
+	return drifts, nil
+}
@@ -0,0 +1,200 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+
+	"freedom-ai/management-server/internal/database/databasetest"
+	"freedom-ai/management-server/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+func newTestService(t *testing.T, db *mongo.Database, orgs ...bson.M) *Service {
+	t.Helper()
+	ctx := context.Background()
+	s := NewService(db, zap.NewNop())
+	if err := s.EnsureIndexes(ctx); err != nil {
+		t.Fatalf("EnsureIndexes: %v", err)
+	}
+	for _, org := range orgs {
+		if _, err := db.Collection("organizations").InsertOne(ctx, org); err != nil {
+			t.Fatalf("insert organization: %v", err)
+		}
+	}
+	return s
+}
+
+func loadOrganization(t *testing.T, db *mongo.Database, orgID string) models.Organization {
+	t.Helper()
+	var org models.Organization
+	if err := db.Collection("organizations").FindOne(context.Background(), bson.M{"orgId": orgID}).Decode(&org); err != nil {
+		t.Fatalf("load organization: %v", err)
+	}
+	return org
+}
+
+func TestPostRejectsUnknownType(t *testing.T) {
+	// The type is checked before the database is used
+	_, applied, err := NewService(nil, zap.NewNop()).Post(context.Background(), models.LedgerEntry{OrgID: "org-1", Type: "gift", Amount: 1})
+	if err == nil || applied {
+		t.Fatalf("Post = applied %v, err %v, want an error", applied, err)
+	}
+}
+
+func TestPostAppliesEachReferenceOnce(t *testing.T) {
+	ctx := context.Background()
+	db := databasetest.Connect(t)
+	s := newTestService(t, db, bson.M{"orgId": "org-1", "walletBalance": 10.0})
+
+	entry, applied, err := s.Post(ctx, models.LedgerEntry{OrgID: "org-1", Type: models.LedgerTopUpCredit, Amount: 5, Reference: "stripe:pi_1"})
+	if err != nil || !applied {
+		t.Fatalf("Post = applied %v, err %v", applied, err)
+	}
+	if entry.ContraAccount != "payments" || entry.BalanceAfter != 15 {
+		t.Fatalf("entry = %+v, want contra account payments and balance after 15", entry)
+	}
+
+	again, applied, err := s.Post(ctx, models.LedgerEntry{OrgID: "org-1", Type: models.LedgerTopUpCredit, Amount: 5, Reference: "stripe:pi_1"})
+	if err != nil || applied {
+		t.Fatalf("Post with a posted reference = applied %v, err %v, want not applied", applied, err)
+	}
+	if again.ID != entry.ID {
+		t.Fatalf("Post with a posted reference returned %s, want the stored entry %s", again.ID.Hex(), entry.ID.Hex())
+	}
+
+	if balance := loadOrganization(t, db, "org-1").WalletBalance; balance != 15 {
+		t.Fatalf("walletBalance = %v, want 15", balance)
+	}
+	var stored models.LedgerEntry
+	if err := db.Collection("wallet_ledger").FindOne(ctx, bson.M{"_id": entry.ID}).Decode(&stored); err != nil {
+		t.Fatalf("load entry: %v", err)
+	}
+	if stored.BalanceAfter != 15 {
+		t.Fatalf("stored balanceAfter = %v, want 15", stored.BalanceAfter)
+	}
+}
+
+func TestPostRejectsDuplicateReferenceInTransaction(t *testing.T) {
+	ctx := context.Background()
+	db := databasetest.ConnectReplicaSet(t)
+	s := newTestService(t, db, bson.M{"orgId": "org-1", "walletBalance": 0.0})
+
+	if _, _, err := s.Post(ctx, models.LedgerEntry{OrgID: "org-1", Type: models.LedgerUsageDebit, Amount: -2, Reference: "billing:run-1"}); err != nil {
+		t.Fatalf("Post: %v", err)
+	}
+	err := s.Transact(ctx, func(ctx context.Context) error {
+		_, _, err := s.Post(ctx, models.LedgerEntry{OrgID: "org-1", Type: models.LedgerUsageDebit, Amount: -2, Reference: "billing:run-1"})
+		return err
+	})
+	if !errors.Is(err, ErrDuplicateReference) {
+		t.Fatalf("Transact = %v, want ErrDuplicateReference", err)
+	}
+	if balance := loadOrganization(t, db, "org-1").WalletBalance; balance != -2 {
+		t.Fatalf("walletBalance = %v, want -2", balance)
+	}
+}
+
+func TestPostUpdatesRestriction(t *testing.T) {
+	ctx := context.Background()
+	db := databasetest.Connect(t)
+	s := newTestService(t, db, bson.M{"orgId": "org-1", "walletBalance": 5.0, "creditLimit": 10.0, "restricted": false})
+
+	post := func(amount float64) {
+		t.Helper()
+		entryType := models.LedgerTopUpCredit
+		if amount < 0 {
+			entryType = models.LedgerUsageDebit
+		}
+		if _, _, err := s.Post(ctx, models.LedgerEntry{OrgID: "org-1", Type: entryType, Amount: amount}); err != nil {
+			t.Fatalf("Post(%v): %v", amount, err)
+		}
+	}
+
+	// A postpaid organization may go negative down to its credit limit
+	post(-12)
+	if org := loadOrganization(t, db, "org-1"); org.Restricted {
+		t.Fatalf("restricted at %v within a credit limit of 10", org.WalletBalance)
+	}
+	post(-4)
+	org := loadOrganization(t, db, "org-1")
+	if !org.Restricted || org.RestrictedAt == nil {
+		t.Fatalf("not restricted at %v below the credit floor", org.WalletBalance)
+	}
+	post(6)
+	if org := loadOrganization(t, db, "org-1"); org.Restricted || org.RestrictedAt != nil {
+		t.Fatalf("still restricted at %v back at the credit floor", org.WalletBalance)
+	}
+}
+
+func TestRecordOpeningBalance(t *testing.T) {
+	ctx := context.Background()
+	db := databasetest.Connect(t)
+	s := newTestService(t, db,
+		bson.M{"orgId": "org-1", "walletBalance": 25.0},
+		bson.M{"orgId": "org-2", "walletBalance": 0.0},
+	)
+
+	// Running twice records each opening balance once
+	for i := 0; i < 2; i++ {
+		if err := s.RecordOpeningBalances(ctx); err != nil {
+			t.Fatalf("RecordOpeningBalances: %v", err)
+		}
+	}
+	var entries []models.LedgerEntry
+	cursor, err := db.Collection("wallet_ledger").Find(ctx, bson.M{})
+	if err != nil {
+		t.Fatalf("find entries: %v", err)
+	}
+	if err := cursor.All(ctx, &entries); err != nil {
+		t.Fatalf("decode entries: %v", err)
+	}
+	if len(entries) != 1 || entries[0].OrgID != "org-1" || entries[0].Amount != 25 || entries[0].Type != models.LedgerAdjustment {
+		t.Fatalf("entries = %+v, want one opening adjustment of 25 for org-1", entries)
+	}
+	if balance := loadOrganization(t, db, "org-1").WalletBalance; balance != 25 {
+		t.Fatalf("walletBalance = %v, want it unchanged at 25", balance)
+	}
+
+	drifts, err := s.CheckConsistency(ctx)
+	if err != nil {
+		t.Fatalf("CheckConsistency: %v", err)
+	}
+	if len(drifts) != 0 {
+		t.Fatalf("CheckConsistency after opening balances = %+v, want no drift", drifts)
+	}
+}
+
+func TestCheckConsistencyReportsDrift(t *testing.T) {
+	ctx := context.Background()
+	db := databasetest.Connect(t)
+	s := newTestService(t, db,
+		bson.M{"orgId": "org-1", "walletBalance": 0.0},
+		bson.M{"orgId": "org-2", "walletBalance": 0.0},
+	)
+
+	for _, orgID := range []string{"org-1", "org-2"} {
+		if _, _, err := s.Post(ctx, models.LedgerEntry{OrgID: orgID, Type: models.LedgerTopUpCredit, Amount: 0.1}); err != nil {
+			t.Fatalf("Post: %v", err)
+		}
+		if _, _, err := s.Post(ctx, models.LedgerEntry{OrgID: orgID, Type: models.LedgerUsageDebit, Amount: -0.03}); err != nil {
+			t.Fatalf("Post: %v", err)
+		}
+	}
+	// A write that bypasses the ledger
+	if _, err := db.Collection("organizations").UpdateOne(ctx, bson.M{"orgId": "org-2"}, bson.M{"$inc": bson.M{"walletBalance": 1.5}}); err != nil {
+		t.Fatalf("update organization: %v", err)
+	}
+
+	drifts, err := s.CheckConsistency(ctx)
+	if err != nil {
+		t.Fatalf("CheckConsistency: %v", err)
+	}
+	if len(drifts) != 1 || drifts[0].OrgID != "org-2" || math.Abs(drifts[0].Difference-1.5) > driftTolerance {
+		t.Fatalf("drifts = %+v, want org-2 off by 1.5", drifts)
+	}
+}
@@ -0,0 +1,21 @@
+package ledger
+
+import (
+	"context"
+
+	"freedom-ai/management-server/internal/database"
+)
+
+// Transact runs fn in a multi-document transaction so a ledger entry and the
+// records written alongside it (billing history, adjustments) commit together.
+// fn must use the context it is given. On a standalone server, which has no
+// transactions, fn runs without one; each wallet update is still a single
+// atomic $inc.
+func (s *Service) Transact(ctx context.Context, fn func(ctx context.Context) error) error {
+	return database.Transact(ctx, s.db, s.logger, fn)
+}
+
+// inTransaction reports whether ctx carries a session with an open transaction
+func inTransaction(ctx context.Context) bool {
+	return database.InTransaction(ctx)
+}
@@ -2,16 +2,41 @@ package limits
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
 	"freedom-ai/management-server/internal/models"
+	"freedom-ai/management-server/internal/services/consumption"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.uber.org/zap"
 )
 
+var (
+	// ErrCreditExhausted is returned when an organization has no spending headroom left
+	ErrCreditExhausted = errors.New("credit limit reached")
+	// ErrLimitExceeded is returned when a consumption limit would be exceeded
+	ErrLimitExceeded = errors.New("consumption limit exceeded")
+	// ErrSuspended is returned for suspended organizations
+	ErrSuspended = errors.New("organization is suspended")
+)
+
+// CreditStatus is an organization's spending position against its credit floor
+type CreditStatus struct {
+	AccountMode string  `json:"accountMode"`
+	Balance     float64 `json:"balance"`
+	CreditLimit float64 `json:"creditLimit"`
+	CreditFloor float64 `json:"creditFloor"`
+	// Unbilled is the cost of billable consumption not yet charged by daily billing
+	Unbilled   float64 `json:"unbilled"`
+	Headroom   float64 `json:"headroom"`
+	Restricted bool    `json:"restricted"`
+	Blocked    bool    `json:"blocked"`
+}
+
 type Service struct {
 	db     *mongo.Database
 	logger *zap.Logger
@@ -24,7 +49,10 @@ func NewService(db *mongo.Database, logger *zap.Logger) *Service {
 	}
 }
 
-// CheckConsumptionLimits checks if consumption exceeds limits
+// CheckConsumptionLimits checks if consumption exceeds limits. It is advisory:
+// consumption events describe calls that already happened, so ingestion never
+// rejects them. The gateway in front of the models enforces the result by
+// calling GET /limits/check before each call.
 func (s *Service) CheckConsumptionLimits(ctx context.Context, orgID, userID string, tokens int64) error {
 	// Get organization
 	orgCollection := s.db.Collection("organizations")
@@ -34,48 +62,123 @@ func (s *Service) CheckConsumptionLimits(ctx context.Context, orgID, userID stri
 		return fmt.Errorf("failed to find organization: %w", err)
 	}
 
+	if org.Status == "suspended" {
+		return ErrSuspended
+	}
+
+	credit, err := s.CreditStatus(ctx, org)
+	if err != nil {
+		return err
+	}
+	if credit.Blocked {
+		return ErrCreditExhausted
+	}
+
 	limits := org.ConsumptionLimits
+	// Windows follow the organization's timezone and billing cycle
+	calendar := org.Calendar()
+	now := time.Now()
 
 	// Check monthly limit
 	if limits.MonthlyLimit > 0 {
-		monthlyConsumption, err := s.getMonthlyConsumption(ctx, orgID)
+		monthlyConsumption, err := s.getMonthlyConsumption(ctx, orgID, calendar.CycleStart(now))
 		if err != nil {
 			return err
 		}
 		if monthlyConsumption+int64(tokens) > limits.MonthlyLimit {
-			return fmt.Errorf("monthly consumption limit exceeded")
+			return fmt.Errorf("monthly %w", ErrLimitExceeded)
 		}
 	}
 
 	// Check daily limit
 	if limits.DailyLimit > 0 {
-		dailyConsumption, err := s.getDailyConsumption(ctx, orgID)
+		dailyConsumption, err := s.getDailyConsumption(ctx, orgID, calendar.DayStart(now))
 		if err != nil {
 			return err
 		}
 		if dailyConsumption+int64(tokens) > limits.DailyLimit {
-			return fmt.Errorf("daily consumption limit exceeded")
+			return fmt.Errorf("daily %w", ErrLimitExceeded)
 		}
 	}
 
 	// Check per-user limit
 	if limits.PerUserLimit > 0 {
-		userConsumption, err := s.getUserMonthlyConsumption(ctx, userID)
+		userConsumption, err := s.getUserMonthlyConsumption(ctx, userID, calendar.CycleStart(now))
 		if err != nil {
 			return err
 		}
 		if userConsumption+int64(tokens) > limits.PerUserLimit {
-			return fmt.Errorf("per-user consumption limit exceeded")
+			return fmt.Errorf("per-user %w", ErrLimitExceeded)
 		}
 	}
 
 	return nil
 }
 
-func (s *Service) getMonthlyConsumption(ctx context.Context, orgID string) (int64, error) {
-	now := time.Now().UTC()
-	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+// CreditStatus reports an organization's headroom. A prepaid organization is
+// blocked once its balance, less unbilled consumption, reaches zero; a
+// postpaid one once it reaches -CreditLimit. A restricted organization stays
+// blocked until a top-up lifts the restriction.
+func (s *Service) CreditStatus(ctx context.Context, org models.Organization) (CreditStatus, error) {
+	unbilled, err := s.getUnbilledCost(ctx, org)
+	if err != nil {
+		return CreditStatus{}, err
+	}
+	headroom := org.Headroom(unbilled)
+	return CreditStatus{
+		AccountMode: org.Mode(),
+		Balance:     org.WalletBalance,
+		CreditLimit: org.CreditLimit,
+		CreditFloor: org.CreditFloor(),
+		Unbilled:    unbilled,
+		Headroom:    headroom,
+		Restricted:  org.Restricted,
+		Blocked:     org.Restricted || headroom <= 0,
+	}, nil
+}
+
+// getUnbilledCost sums the cost of billable consumption since the end of the
+// organization's last billed day, or since the start of its current day
+func (s *Service) getUnbilledCost(ctx context.Context, org models.Organization) (float64, error) {
+	since := org.Calendar().DayStart(time.Now())
+	var run models.BillingRun
+	err := s.db.Collection("billing_runs").FindOne(ctx,
+		bson.M{"orgId": org.OrgID, "status": models.BillingRunCompleted},
+		options.FindOne().SetSort(bson.D{{Key: "periodEnd", Value: -1}}),
+	).Decode(&run)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return 0, fmt.Errorf("failed to find last billing run: %w", err)
+	}
+	if err == nil && run.PeriodEnd.Before(since) {
+		since = run.PeriodEnd
+	}
 
+	cursor, err := s.db.Collection("token_consumption").Aggregate(ctx, []bson.M{
+		{"$match": bson.M{
+			"organizationId": org.OrgID,
+			"timestamp":      bson.M{"$gte": since},
+			"$or":            consumption.BillableConditions(),
+		}},
+		{"$group": bson.M{"_id": nil, "cost": bson.M{"$sum": "$cost"}}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var results []struct {
+		Cost float64 `bson:"cost"`
+	}
+	if err := cursor.All(ctx, &results); err != nil {
+		return 0, err
+	}
+	if len(results) == 0 {
+		return 0, nil
+	}
+	return results[0].Cost, nil
+}
+
+func (s *Service) getMonthlyConsumption(ctx context.Context, orgID string, monthStart time.Time) (int64, error) {
 	pipeline := []bson.M{
 		{
 			"$match": bson.M{
@@ -111,10 +214,7 @@ func (s *Service) getMonthlyConsumption(ctx context.Context, orgID string) (int6
 	return results[0]["totalTokens"].(int64), nil
 }
 
-func (s *Service) getDailyConsumption(ctx context.Context, orgID string) (int64, error) {
-	now := time.Now().UTC()
-	todayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
-
+func (s *Service) getDailyConsumption(ctx context.Context, orgID string, todayStart time.Time) (int64, error) {
 	pipeline := []bson.M{
 		{
 			"$match": bson.M{
@@ -150,14 +250,11 @@ func (s *Service) getDailyConsumption(ctx context.Context, orgID string) (int64,
 	return results[0]["totalTokens"].(int64), nil
 }
 
-func (s *Service) getUserMonthlyConsumption(ctx context.Context, userID string) (int64, error) {
-	now := time.Now().UTC()
-	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
-
+func (s *Service) getUserMonthlyConsumption(ctx context.Context, userID string, monthStart time.Time) (int64, error) {
 	pipeline := []bson.M{
 		{
 			"$match": bson.M{
-				"userId":   userID,
+				"userId":    userID,
 				"timestamp": bson.M{"$gte": monthStart},
 				"status":    "complete",
 			},
@@ -188,4 +285,3 @@ func (s *Service) getUserMonthlyConsumption(ctx context.Context, userID string)
 
 	return results[0]["totalTokens"].(int64), nil
 }
-
@@ -0,0 +1,183 @@
+package privacy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"freedom-ai/management-server/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+// Content policies. Whatever the policy, raw prompt and completion text is
+// dropped before events are cached, archived or stored.
+const (
+	// PolicyNone keeps nothing of the content (default)
+	PolicyNone = "none"
+	// PolicyHashed keeps a SHA-256 digest of the content
+	PolicyHashed = "hashed"
+	// PolicyRedacted keeps the message structure with text replaced by its length
+	PolicyRedacted = "redacted"
+
+	policyCacheTTL = time.Minute
+)
+
+// ValidPolicy reports whether policy is a known content policy
+func ValidPolicy(policy string) bool {
+	return policy == PolicyNone || policy == PolicyHashed || policy == PolicyRedacted
+}
+
+type cachedPolicy struct {
+	policy    string
+	expiresAt time.Time
+}
+
+// Service minimises collector events according to each organization's content policy
+type Service struct {
+	db     *mongo.Database
+	logger *zap.Logger
+
+	mu       sync.Mutex
+	policies map[string]cachedPolicy
+}
+
+func NewService(db *mongo.Database, logger *zap.Logger) *Service {
+	return &Service{
+		db:       db,
+		logger:   logger,
+		policies: make(map[string]cachedPolicy),
+	}
+}
+
+// Policy returns the organization's content policy, cached for a minute.
+// Lookup failures fall back to PolicyNone.
+func (s *Service) Policy(ctx context.Context, orgID string) string {
+	if s == nil || s.db == nil || orgID == "" {
+		return PolicyNone
+	}
+
+	s.mu.Lock()
+	cached, ok := s.policies[orgID]
+	s.mu.Unlock()
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.policy
+	}
+
+	var org struct {
+		ContentPolicy string `bson:"contentPolicy"`
+	}
+	policy := PolicyNone
+	err := s.db.Collection("organizations").FindOne(
+		ctx,
+		bson.M{"orgId": orgID},
+		options.FindOne().SetProjection(bson.M{"contentPolicy": 1}),
+	).Decode(&org)
+	if err != nil && err != mongo.ErrNoDocuments {
+		s.logger.Warn("Failed to load content policy", zap.String("orgId", orgID), zap.Error(err))
+	} else if ValidPolicy(org.ContentPolicy) {
+		policy = org.ContentPolicy
+	}
+
+	s.mu.Lock()
+	s.policies[orgID] = cachedPolicy{policy: policy, expiresAt: time.Now().Add(policyCacheTTL)}
+	s.mu.Unlock()
+	return policy
+}
+
+// MinimiseRequest drops the prompt content from requestData, keeping only what
+// the organization's policy allows in RetainedContent
+func (s *Service) MinimiseRequest(ctx context.Context, requestData *models.LLMRequestData) {
+	MinimiseRequest(requestData, s.Policy(ctx, requestData.OrganizationID))
+}
+
+// MinimiseResponse drops the completion text from responseData
+func (s *Service) MinimiseResponse(ctx context.Context, responseData *models.LLMResponseData) {
+	MinimiseResponse(responseData, s.Policy(ctx, responseData.OrganizationID))
+}
+
+// MinimiseRequest applies policy to requestData
+func MinimiseRequest(requestData *models.LLMRequestData, policy string) {
+	switch policy {
+	case PolicyHashed:
+		content, _ := json.Marshal(struct {
+			SystemPrompt string        `json:"systemPrompt"`
+			Messages     []interface{} `json:"messages"`
+			Tools        []interface{} `json:"tools"`
+		}{requestData.SystemPrompt, requestData.Messages, requestData.Tools})
+		requestData.RetainedContent = &models.RetainedContent{Policy: PolicyHashed, Hash: digest(content)}
+	case PolicyRedacted:
+		requestData.RetainedContent = &models.RetainedContent{Policy: PolicyRedacted, Redacted: redactRequest(requestData)}
+	default:
+		requestData.RetainedContent = nil
+	}
+
+	requestData.SystemPrompt = ""
+	requestData.Messages = nil
+	requestData.Tools = nil
+}
+
+// MinimiseResponse applies policy to responseData
+func MinimiseResponse(responseData *models.LLMResponseData, policy string) {
+	switch policy {
+	case PolicyHashed:
+		responseData.RetainedContent = &models.RetainedContent{Policy: PolicyHashed, Hash: digest([]byte(responseData.Response))}
+	case PolicyRedacted:
+		responseData.RetainedContent = &models.RetainedContent{Policy: PolicyRedacted, Redacted: redacted(responseData.Response)}
+	default:
+		responseData.RetainedContent = nil
+	}
+
+	responseData.Response = ""
+}
+
+func digest(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+func redacted(text string) string {
+	return fmt.Sprintf("[redacted %d chars]", len([]rune(text)))
+}
+
+// redactRequest describes the prompt as one line per part, e.g. "user: [redacted 42 chars]"
+func redactRequest(requestData *models.LLMRequestData) string {
+	var lines []string
+	if requestData.SystemPrompt != "" {
+		lines = append(lines, "systemPrompt: "+redacted(requestData.SystemPrompt))
+	}
+	for _, message := range requestData.Messages {
+		role := "message"
+		text := ""
+		switch m := message.(type) {
+		case map[string]interface{}:
+			if r, ok := m["role"].(string); ok && r != "" {
+				role = r
+			}
+			if content, ok := m["content"].(string); ok {
+				text = content
+			} else if m["content"] != nil {
+				raw, _ := json.Marshal(m["content"])
+				text = string(raw)
+			}
+		case string:
+			text = m
+		default:
+			raw, _ := json.Marshal(m)
+			text = string(raw)
+		}
+		lines = append(lines, role+": "+redacted(text))
+	}
+	if len(requestData.Tools) > 0 {
+		lines = append(lines, fmt.Sprintf("tools: %d", len(requestData.Tools)))
+	}
+	return strings.Join(lines, "\n")
+}
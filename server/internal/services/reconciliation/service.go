@@ -0,0 +1,95 @@
+package reconciliation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"freedom-ai/management-server/internal/config"
+	"freedom-ai/management-server/internal/metrics"
+	"freedom-ai/management-server/internal/models"
+	"freedom-ai/management-server/internal/services/consumption"
+
+	goredis "github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// sweepBatchSize caps how many pending requests a single sweep inspects
+const sweepBatchSize = 1000
+
+type RedisClient interface {
+	Get(ctx context.Context, key string) (string, error)
+	Delete(ctx context.Context, key string) error
+	ZRangeByScore(ctx context.Context, key string, max float64, limit int64) ([]string, error)
+	ZRem(ctx context.Context, key string, members ...string) error
+}
+
+type Service struct {
+	redis              RedisClient
+	consumptionService *consumption.Service
+	staleAfter         time.Duration
+	logger             *zap.Logger
+}
+
+func NewService(cfg *config.Config, redis RedisClient, consumptionService *consumption.Service, logger *zap.Logger) *Service {
+	return &Service{
+		redis:              redis,
+		consumptionService: consumptionService,
+		staleAfter:         time.Duration(cfg.OrphanedRequestTimeoutMinutes) * time.Minute,
+		logger:             logger,
+	}
+}
+
+// SweepOrphanedRequests persists cached requests that have waited longer than
+// the orphan timeout for a response as request-only consumption records
+func (s *Service) SweepOrphanedRequests(ctx context.Context) error {
+	cutoff := time.Now().Add(-s.staleAfter)
+	requestIDs, err := s.redis.ZRangeByScore(ctx, consumption.PendingRequestsKey, float64(cutoff.Unix()), sweepBatchSize)
+	if err != nil {
+		return fmt.Errorf("failed to list pending requests: %w", err)
+	}
+
+	var persisted, expired int
+	for _, requestID := range requestIDs {
+		key := consumption.RequestCacheKey(requestID)
+		requestJSON, err := s.redis.Get(ctx, key)
+		if err != nil && !errors.Is(err, goredis.Nil) {
+			s.logger.Warn("Failed to load pending request", zap.String("requestId", requestID), zap.Error(err))
+			continue
+		}
+
+		if requestJSON == "" {
+			// The cache entry expired before the sweeper reached it; nothing left to persist
+			expired++
+			metrics.Ingestion.Add("orphaned_requests_expired", 1)
+			s.logger.Warn("Orphaned request expired before reconciliation", zap.String("requestId", requestID))
+			_ = s.redis.ZRem(ctx, consumption.PendingRequestsKey, requestID)
+			continue
+		}
+
+		var requestData models.LLMRequestData
+		if err := json.Unmarshal([]byte(requestJSON), &requestData); err != nil {
+			s.logger.Warn("Dropping unreadable pending request", zap.String("requestId", requestID), zap.Error(err))
+			_ = s.redis.Delete(ctx, key)
+			_ = s.redis.ZRem(ctx, consumption.PendingRequestsKey, requestID)
+			continue
+		}
+
+		if err := s.consumptionService.ProcessConsumption(ctx, &requestData, nil); err != nil {
+			s.logger.Error("Failed to persist orphaned request", zap.String("requestId", requestID), zap.Error(err))
+			continue
+		}
+
+		_ = s.redis.Delete(ctx, key)
+		_ = s.redis.ZRem(ctx, consumption.PendingRequestsKey, requestID)
+		persisted++
+		metrics.Ingestion.Add("orphaned_requests_persisted", 1)
+	}
+
+	s.logger.Info("Orphaned request sweep completed",
+		zap.Int("persisted", persisted),
+		zap.Int("expired", expired))
+	return nil
+}
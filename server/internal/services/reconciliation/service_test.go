@@ -0,0 +1,116 @@
+package reconciliation
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"freedom-ai/management-server/internal/config"
+	"freedom-ai/management-server/internal/database/databasetest"
+	"freedom-ai/management-server/internal/models"
+	"freedom-ai/management-server/internal/redis/redistest"
+	"freedom-ai/management-server/internal/services/consumption"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.uber.org/zap"
+)
+
+func TestSweepPersistsOrphanedRequests(t *testing.T) {
+	ctx := context.Background()
+	db := databasetest.Connect(t)
+	rdb, server := redistest.Start(t)
+	logger := zap.NewNop()
+
+	cfg := &config.Config{OrphanedRequestTimeoutMinutes: 15}
+	consumptionService := consumption.NewService(db, cfg, logger)
+	if err := consumptionService.EnsureIndexes(ctx); err != nil {
+		t.Fatalf("EnsureIndexes: %v", err)
+	}
+	if err := consumptionService.SeedPriceCatalog(ctx); err != nil {
+		t.Fatalf("SeedPriceCatalog: %v", err)
+	}
+	s := NewService(cfg, rdb, consumptionService, logger)
+
+	now := time.Now()
+	stale := now.Add(-time.Hour)
+	request := func(requestID string, at time.Time) *models.LLMRequestData {
+		return &models.LLMRequestData{
+			RequestID:      requestID,
+			Timestamp:      at,
+			UserID:         "user-1",
+			OrganizationID: "org-1",
+			Model:          "gpt-4",
+			TokenCount:     100,
+		}
+	}
+	pending := func(data *models.LLMRequestData, cached bool) {
+		t.Helper()
+		if cached {
+			body, err := json.Marshal(data)
+			if err != nil {
+				t.Fatalf("marshal request: %v", err)
+			}
+			if err := server.Set(consumption.RequestCacheKey(data.RequestID), string(body)); err != nil {
+				t.Fatalf("cache request: %v", err)
+			}
+		}
+		if _, err := server.ZAdd(consumption.PendingRequestsKey, float64(data.Timestamp.Unix()), data.RequestID); err != nil {
+			t.Fatalf("add pending request: %v", err)
+		}
+	}
+	pending(request("orphan", stale), true)
+	pending(request("expired", stale), false)
+	pending(request("waiting", now), true)
+
+	if err := s.SweepOrphanedRequests(ctx); err != nil {
+		t.Fatalf("SweepOrphanedRequests: %v", err)
+	}
+
+	collection := db.Collection("token_consumption")
+	var orphan models.TokenConsumption
+	if err := collection.FindOne(ctx, bson.M{"requestId": "orphan"}).Decode(&orphan); err != nil {
+		t.Fatalf("orphaned request not persisted: %v", err)
+	}
+	if orphan.Status != "request-only" {
+		t.Fatalf("orphan status = %q, want request-only", orphan.Status)
+	}
+	for _, requestID := range []string{"expired", "waiting"} {
+		if n, _ := collection.CountDocuments(ctx, bson.M{"requestId": requestID}); n != 0 {
+			t.Fatalf("%s request persisted %d times, want 0", requestID, n)
+		}
+	}
+
+	members, err := server.ZMembers(consumption.PendingRequestsKey)
+	if err != nil {
+		t.Fatalf("pending requests: %v", err)
+	}
+	if len(members) != 1 || members[0] != "waiting" {
+		t.Fatalf("pending requests = %v, want only the one still waiting", members)
+	}
+	if server.Exists(consumption.RequestCacheKey("orphan")) {
+		t.Fatal("persisted request still cached")
+	}
+
+	// A late response completes the request-only record instead of duplicating it
+	response := &models.LLMResponseData{
+		RequestID:      "orphan",
+		Timestamp:      stale.Add(time.Minute),
+		UserID:         "user-1",
+		OrganizationID: "org-1",
+		Model:          "gpt-4",
+		Usage:          &models.Usage{PromptTokens: 100, CompletionTokens: 20, TotalTokens: 120},
+	}
+	if err := consumptionService.ProcessConsumption(ctx, request("orphan", stale), response); err != nil {
+		t.Fatalf("ProcessConsumption: %v", err)
+	}
+	if n, _ := collection.CountDocuments(ctx, bson.M{"requestId": "orphan"}); n != 1 {
+		t.Fatalf("stored %d records for the orphan, want 1", n)
+	}
+	if err := collection.FindOne(ctx, bson.M{"requestId": "orphan"}).Decode(&orphan); err != nil {
+		t.Fatalf("load completed record: %v", err)
+	}
+	if orphan.Status != "complete" || orphan.TotalTokens != 120 {
+		t.Fatalf("record = %s with %d tokens, want complete with 120", orphan.Status, orphan.TotalTokens)
+	}
+}
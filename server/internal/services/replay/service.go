@@ -0,0 +1,260 @@
+package replay
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"freedom-ai/management-server/internal/archive"
+	"freedom-ai/management-server/internal/events"
+	"freedom-ai/management-server/internal/models"
+	"freedom-ai/management-server/internal/services/consumption"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+const (
+	lookupBatchSize  = 1000
+	maxReportedDiffs = 100
+)
+
+type Service struct {
+	archive            *archive.Archive
+	consumptionService *consumption.Service
+	db                 *mongo.Database
+	logger             *zap.Logger
+}
+
+func NewService(a *archive.Archive, consumptionService *consumption.Service, db *mongo.Database, logger *zap.Logger) *Service {
+	return &Service{
+		archive:            a,
+		consumptionService: consumptionService,
+		db:                 db,
+		logger:             logger,
+	}
+}
+
+// Diff describes how a replayed record differs from the stored one
+type Diff struct {
+	RequestID      string  `json:"requestId"`
+	OrgID          string  `json:"organizationId"`
+	Change         string  `json:"change"` // new or changed
+	OldStatus      string  `json:"oldStatus,omitempty"`
+	NewStatus      string  `json:"newStatus"`
+	OldTotalTokens int     `json:"oldTotalTokens"`
+	NewTotalTokens int     `json:"newTotalTokens"`
+	OldCost        float64 `json:"oldCost"`
+	NewCost        float64 `json:"newCost"`
+}
+
+// Report summarises a replay run
+type Report struct {
+	From      time.Time      `json:"from"`
+	To        time.Time      `json:"to"`
+	Committed bool           `json:"committed"`
+	Events    int            `json:"events"`
+	Rejected  map[string]int `json:"rejected"`
+	Records   int            `json:"records"`
+	New       int            `json:"new"`
+	Changed   int            `json:"changed"`
+	Unchanged int            `json:"unchanged"`
+	Kept      int            `json:"kept"` // stored records more complete than the replayed ones
+	CostDelta float64        `json:"costDelta"`
+	Inserted  int64          `json:"inserted"`
+	Replaced  int64          `json:"replaced"`
+	Diffs     []Diff         `json:"diffs"`
+}
+
+// Run rebuilds consumption records from archived events received in [from, to)
+// and compares them with the stored records. With commit, new and changed
+// records are written; otherwise nothing is modified.
+func (s *Service) Run(ctx context.Context, from, to time.Time, commit bool) (*Report, error) {
+	report := &Report{From: from, To: to, Committed: commit, Rejected: map[string]int{}, Diffs: []Diff{}}
+
+	// Pair within the archive only, so a replay never touches the live request cache
+	requests := make(map[string]*models.LLMRequestData)
+	responses := make(map[string]*models.LLMResponseData)
+	err := s.archive.Scan(ctx, from, to, func(entry archive.Entry) error {
+		report.Events++
+		switch entry.Kind {
+		case "request":
+			requestData, err := events.DecodeRequest(entry.Body, entry.SchemaVersion)
+			if err != nil {
+				report.Rejected[events.Reason(err)]++
+				return nil
+			}
+			requests[requestData.RequestID] = requestData
+		case "response":
+			responseData, err := events.DecodeResponse(entry.Body, entry.SchemaVersion)
+			if err != nil {
+				report.Rejected[events.Reason(err)]++
+				return nil
+			}
+			responses[responseData.RequestID] = responseData
+		default:
+			report.Rejected["unknown_event_type"]++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// A dry run must not advance tier positions
+	build := s.consumptionService.PreviewRecord
+	if commit {
+		build = s.consumptionService.BuildRecord
+	}
+
+	candidates := make(map[string]models.TokenConsumption)
+	for requestID, responseData := range responses {
+		record, err := build(ctx, requests[requestID], responseData)
+		if err != nil {
+			return nil, err
+		}
+		candidates[requestID] = record
+	}
+	for requestID, requestData := range requests {
+		if _, ok := candidates[requestID]; !ok {
+			record, err := build(ctx, requestData, nil)
+			if err != nil {
+				return nil, err
+			}
+			candidates[requestID] = record
+		}
+	}
+
+	requestIDs := make([]string, 0, len(candidates))
+	for requestID := range candidates {
+		requestIDs = append(requestIDs, requestID)
+	}
+	sort.Strings(requestIDs)
+
+	var writes []models.TokenConsumption
+	for start := 0; start < len(requestIDs); start += lookupBatchSize {
+		end := start + lookupBatchSize
+		if end > len(requestIDs) {
+			end = len(requestIDs)
+		}
+
+		existing, err := s.loadExisting(ctx, requestIDs[start:end])
+		if err != nil {
+			return nil, err
+		}
+
+		for _, requestID := range requestIDs[start:end] {
+			record := candidates[requestID]
+			stored, found := existing[requestID]
+
+			// Half of a pair whose other half fell outside the window never
+			// downgrades a stored record, e.g. a complete, billed record to response-only
+			if found && statusRank(record.Status) < statusRank(stored.Status) {
+				report.Kept++
+				continue
+			}
+			report.Records++
+
+			if found && sameRecord(stored, record) {
+				report.Unchanged++
+				continue
+			}
+
+			diff := Diff{
+				RequestID:      requestID,
+				OrgID:          record.OrgID,
+				Change:         "new",
+				NewStatus:      record.Status,
+				NewTotalTokens: record.TotalTokens,
+				NewCost:        record.Cost,
+			}
+			if found {
+				diff.Change = "changed"
+				diff.OldStatus = stored.Status
+				diff.OldTotalTokens = stored.TotalTokens
+				diff.OldCost = stored.Cost
+				record.CreatedAt = stored.CreatedAt
+				// The archive holds no content, so keep what the content policy retained
+				record.RequestContent = stored.RequestContent
+				record.ResponseContent = stored.ResponseContent
+				report.Changed++
+			} else {
+				report.New++
+			}
+			report.CostDelta += diff.NewCost - diff.OldCost
+			if len(report.Diffs) < maxReportedDiffs {
+				report.Diffs = append(report.Diffs, diff)
+			}
+			writes = append(writes, record)
+		}
+	}
+
+	if commit && len(writes) > 0 {
+		report.Inserted, report.Replaced, err = s.consumptionService.ReplaceRecords(ctx, writes)
+		if err != nil {
+			return report, err
+		}
+	}
+
+	s.logger.Info("Archive replay completed",
+		zap.Time("from", from),
+		zap.Time("to", to),
+		zap.Bool("commit", commit),
+		zap.Int("events", report.Events),
+		zap.Int("new", report.New),
+		zap.Int("changed", report.Changed),
+		zap.Int("kept", report.Kept),
+		zap.Float64("costDelta", report.CostDelta))
+	return report, nil
+}
+
+func (s *Service) loadExisting(ctx context.Context, requestIDs []string) (map[string]models.TokenConsumption, error) {
+	cursor, err := s.db.Collection("token_consumption").Find(ctx, bson.M{"requestId": bson.M{"$in": requestIDs}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load stored records: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var records []models.TokenConsumption
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, fmt.Errorf("failed to decode stored records: %w", err)
+	}
+
+	existing := make(map[string]models.TokenConsumption, len(records))
+	for _, record := range records {
+		existing[record.RequestID] = record
+	}
+	return existing, nil
+}
+
+// statusRank orders record statuses by how much of the pair they reflect
+func statusRank(status string) int {
+	switch status {
+	case "complete":
+		return 2
+	case "response-only":
+		return 1
+	}
+	return 0
+}
+
+// sameRecord compares the fields that replay can change
+func sameRecord(a, b models.TokenConsumption) bool {
+	return a.Status == b.Status &&
+		a.Model == b.Model &&
+		a.Provider == b.Provider &&
+		a.OrgID == b.OrgID &&
+		a.UserID == b.UserID &&
+		a.PromptTokens == b.PromptTokens &&
+		a.CompletionTokens == b.CompletionTokens &&
+		a.TotalTokens == b.TotalTokens &&
+		a.TokensEstimated == b.TokensEstimated &&
+		a.Billable == b.Billable &&
+		a.PriceID == b.PriceID &&
+		a.Unpriced == b.Unpriced &&
+		a.RateCardID == b.RateCardID &&
+		math.Abs(a.Cost-b.Cost) < 1e-9
+}
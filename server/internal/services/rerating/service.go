@@ -0,0 +1,590 @@
+package rerating
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"freedom-ai/management-server/internal/models"
+	"freedom-ai/management-server/internal/services/aggregation"
+	"freedom-ai/management-server/internal/services/consumption"
+	"freedom-ai/management-server/internal/services/ledger"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+const writeBatchSize = 1000
+
+// maxUTCOffset bounds how far a local day starts from the UTC midnight of the
+// same date, in either direction
+const maxUTCOffset = 14 * time.Hour
+
+// Run statuses. An applied run is stored as pending before anything is
+// changed and completed once its adjustments and records are written.
+const (
+	RunPending   = "pending"
+	RunCompleted = "completed"
+)
+
+var (
+	// ErrRunNotPending is returned when resuming a run that is not pending
+	ErrRunNotPending = errors.New("re-rating run is not pending")
+	// ErrInvalidWindow is returned when From and To are not dates with From before To
+	ErrInvalidWindow = errors.New("re-rating window must be whole dates with from before to")
+)
+
+type Service struct {
+	db                 *mongo.Database
+	consumptionService *consumption.Service
+	aggregationService *aggregation.Service
+	ledger             *ledger.Service
+	logger             *zap.Logger
+}
+
+func NewService(db *mongo.Database, consumptionService *consumption.Service, aggregationService *aggregation.Service, logger *zap.Logger) *Service {
+	return &Service{
+		db:                 db,
+		consumptionService: consumptionService,
+		aggregationService: aggregationService,
+		ledger:             ledger.NewService(db, logger),
+		logger:             logger,
+	}
+}
+
+// Options selects the records to re-rate and the prices to use. From and To
+// are dates, given as midnight UTC as parsed from YYYY-MM-DD, naming days in
+// each organization's billing calendar, so the window covers the same days
+// daily billing charged.
+type Options struct {
+	OrgID string    // empty re-rates every organization
+	From  time.Time // first day, inclusive
+	To    time.Time // last day, exclusive
+	// PriceID forces a model_prices entry and restricts the run to its model;
+	// zero uses the catalog entry valid at each record's timestamp
+	PriceID primitive.ObjectID
+	// RateCardVersion forces a version of OrgID's rate card; zero uses the
+	// version in force at each record's timestamp
+	RateCardVersion int
+	Apply           bool
+}
+
+// DayDiff is the cost change for one organization and day in its timezone
+type DayDiff struct {
+	OrgID         string    `bson:"orgId" json:"orgId"`
+	Date          time.Time `bson:"date" json:"date"`
+	Records       int       `bson:"records" json:"records"`
+	Changed       int       `bson:"changed" json:"changed"`
+	OldCost       float64   `bson:"oldCost" json:"oldCost"`
+	NewCost       float64   `bson:"newCost" json:"newCost"`
+	Delta         float64   `bson:"delta" json:"delta"`
+	BillableDelta float64   `bson:"billableDelta" json:"billableDelta"` // change in what daily billing charges
+	Billed        bool      `bson:"billed" json:"billed"`
+	Adjustment    float64   `bson:"adjustment" json:"adjustment"` // wallet charge (negative: credit) issued when applied
+}
+
+// Report summarises a re-rating run. Every run is stored in rerating_runs.
+type Report struct {
+	ID              primitive.ObjectID `bson:"_id" json:"id"`
+	OrgID           string             `bson:"orgId,omitempty" json:"orgId,omitempty"`
+	From            time.Time          `bson:"from" json:"from"`
+	To              time.Time          `bson:"to" json:"to"`
+	PriceID         primitive.ObjectID `bson:"priceId,omitempty" json:"priceId,omitempty"`
+	RateCardVersion int                `bson:"rateCardVersion,omitempty" json:"rateCardVersion,omitempty"`
+	Applied         bool               `bson:"applied" json:"applied"`
+	Status          string             `bson:"status" json:"status"`
+	Records         int                `bson:"records" json:"records"`
+	Changed         int                `bson:"changed" json:"changed"`
+	Unpriced        int                `bson:"unpriced" json:"unpriced"`
+	OldCost         float64            `bson:"oldCost" json:"oldCost"`
+	NewCost         float64            `bson:"newCost" json:"newCost"`
+	Delta           float64            `bson:"delta" json:"delta"`
+	Adjustments     float64            `bson:"adjustments" json:"adjustments"`
+	Days            []DayDiff          `bson:"days" json:"days"`
+	CreatedAt       time.Time          `bson:"createdAt" json:"createdAt"`
+	CompletedAt     *time.Time         `bson:"completedAt,omitempty" json:"completedAt,omitempty"`
+}
+
+// validate checks that the window is whole dates with From before To
+func (o Options) validate() error {
+	if !isDate(o.From) || !isDate(o.To) || !o.From.Before(o.To) {
+		return fmt.Errorf("%w: %s to %s", ErrInvalidWindow, o.From.Format(time.RFC3339), o.To.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// covers reports whether t falls on a day of the window in calendar
+func (o Options) covers(calendar models.BillingCalendar, t time.Time) bool {
+	year, month, day := t.In(calendar.Location).Date()
+	date := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	return !date.Before(o.From) && date.Before(o.To)
+}
+
+func isDate(t time.Time) bool {
+	year, month, day := t.UTC().Date()
+	return t.Equal(time.Date(year, month, day, 0, 0, 0, 0, time.UTC))
+}
+
+type dayKey struct {
+	orgID string
+	date  time.Time
+}
+
+// Run recomputes the cost of the records in the window. Without Apply nothing
+// is modified. With Apply, the run is stored as pending with its per-day
+// differences, days already billed get a wallet adjustment for the billable
+// difference instead of a rewritten billing_history entry, and only then are
+// changed records updated and their daily and monthly aggregates rebuilt. A run
+// that stops part way stays pending and is finished by Resume.
+func (s *Service) Run(ctx context.Context, opts Options) (*Report, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	report := &Report{
+		ID:              primitive.NewObjectID(),
+		OrgID:           opts.OrgID,
+		From:            opts.From,
+		To:              opts.To,
+		PriceID:         opts.PriceID,
+		RateCardVersion: opts.RateCardVersion,
+		Applied:         opts.Apply,
+		Status:          RunCompleted,
+		Days:            []DayDiff{},
+		CreatedAt:       time.Now(),
+	}
+
+	filter, price, card, err := s.selection(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := s.db.Collection("token_consumption").Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "timestamp", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query consumption: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	days := make(map[dayKey]*DayDiff)
+	calendars := make(map[string]models.BillingCalendar)
+	for cursor.Next(ctx) {
+		var record models.TokenConsumption
+		if err := cursor.Decode(&record); err != nil {
+			return nil, fmt.Errorf("failed to decode consumption record: %w", err)
+		}
+
+		calendar, err := s.calendar(ctx, record.OrgID, calendars)
+		if err != nil {
+			return nil, err
+		}
+		if !opts.covers(calendar, record.Timestamp) {
+			continue
+		}
+
+		quote, err := s.consumptionService.Reprice(ctx, record, price, card)
+		if err != nil {
+			return nil, err
+		}
+
+		date := calendar.DayStart(record.Timestamp)
+		key := dayKey{orgID: record.OrgID, date: date}
+		day, ok := days[key]
+		if !ok {
+			day = &DayDiff{OrgID: record.OrgID, Date: date}
+			days[key] = day
+		}
+
+		report.Records++
+		day.Records++
+		day.OldCost += record.Cost
+		day.NewCost += quote.Cost
+		if quote.Unpriced {
+			report.Unpriced++
+		}
+		if !changed(record, quote) {
+			continue
+		}
+
+		report.Changed++
+		day.Changed++
+		if consumption.IsBillable(record) {
+			day.BillableDelta += quote.Cost - record.Cost
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read consumption: %w", err)
+	}
+
+	for _, day := range days {
+		day.Delta = day.NewCost - day.OldCost
+		report.OldCost += day.OldCost
+		report.NewCost += day.NewCost
+		report.Days = append(report.Days, *day)
+	}
+	report.Delta = report.NewCost - report.OldCost
+	sort.Slice(report.Days, func(i, j int) bool {
+		if report.Days[i].OrgID != report.Days[j].OrgID {
+			return report.Days[i].OrgID < report.Days[j].OrgID
+		}
+		return report.Days[i].Date.Before(report.Days[j].Date)
+	})
+
+	for i := range report.Days {
+		day := &report.Days[i]
+		billed, err := s.isBilled(ctx, day.OrgID, day.Date)
+		if err != nil {
+			return nil, err
+		}
+		day.Billed = billed
+	}
+
+	if !opts.Apply {
+		if _, err := s.db.Collection("rerating_runs").InsertOne(ctx, report); err != nil {
+			s.logger.Warn("Failed to store re-rating report", zap.String("reratingId", report.ID.Hex()), zap.Error(err))
+		}
+		s.logCompleted(report)
+		return report, nil
+	}
+
+	// The stored differences are what Resume posts adjustments from
+	report.Status = RunPending
+	if _, err := s.db.Collection("rerating_runs").InsertOne(ctx, report); err != nil {
+		return nil, fmt.Errorf("failed to store re-rating run: %w", err)
+	}
+	return s.apply(ctx, report, opts, filter, price, card)
+}
+
+// Resume finishes an applied run that stopped before completing. Adjustments
+// are re-derived from the run's stored differences, so none is posted twice.
+func (s *Service) Resume(ctx context.Context, reratingID primitive.ObjectID) (*Report, error) {
+	var report Report
+	if err := s.db.Collection("rerating_runs").FindOne(ctx, bson.M{"_id": reratingID}).Decode(&report); err != nil {
+		return nil, fmt.Errorf("failed to load re-rating run %s: %w", reratingID.Hex(), err)
+	}
+	if report.Status != RunPending {
+		return nil, fmt.Errorf("%w: %s is %s", ErrRunNotPending, reratingID.Hex(), report.Status)
+	}
+
+	opts := Options{
+		OrgID:           report.OrgID,
+		From:            report.From,
+		To:              report.To,
+		PriceID:         report.PriceID,
+		RateCardVersion: report.RateCardVersion,
+	}
+	filter, price, card, err := s.selection(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("Resuming re-rating", zap.String("reratingId", reratingID.Hex()))
+	report.Adjustments = 0
+	return s.apply(ctx, &report, opts, filter, price, card)
+}
+
+// apply posts the pending run's wallet adjustments, updates the changed
+// records and marks the run completed. Every step is idempotent.
+func (s *Service) apply(ctx context.Context, report *Report, opts Options, filter bson.M, price *models.ModelPrice, card *models.RateCard) (*Report, error) {
+	for i := range report.Days {
+		day := &report.Days[i]
+		if day.Changed == 0 || !day.Billed || math.Abs(day.BillableDelta) <= 1e-9 {
+			continue
+		}
+		if err := s.adjustWallet(ctx, report.ID, day); err != nil {
+			return nil, err
+		}
+		report.Adjustments += day.Adjustment
+	}
+
+	calendars := make(map[string]models.BillingCalendar)
+	if err := s.rewrite(ctx, opts, filter, price, card, report.ID, calendars); err != nil {
+		return nil, err
+	}
+
+	for _, day := range report.Days {
+		if _, err := s.calendar(ctx, day.OrgID, calendars); err != nil {
+			return nil, err
+		}
+	}
+	s.rebuildAggregates(ctx, report.Days, calendars)
+
+	now := time.Now()
+	report.Status = RunCompleted
+	report.CompletedAt = &now
+	_, err := s.db.Collection("rerating_runs").UpdateOne(ctx, bson.M{"_id": report.ID}, bson.M{"$set": bson.M{
+		"status":      report.Status,
+		"completedAt": now,
+		"days":        report.Days,
+		"adjustments": report.Adjustments,
+	}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to complete re-rating run: %w", err)
+	}
+
+	s.logCompleted(report)
+	return report, nil
+}
+
+// selection returns the filter for the records a run may cover and the forced
+// price and rate card, if any. The filter spans every timezone's version of
+// the window's days; Options.covers narrows it to each organization's.
+func (s *Service) selection(ctx context.Context, opts Options) (bson.M, *models.ModelPrice, *models.RateCard, error) {
+	filter := bson.M{"timestamp": bson.M{"$gte": opts.From.Add(-maxUTCOffset), "$lt": opts.To.Add(maxUTCOffset)}}
+	if opts.OrgID != "" {
+		filter["organizationId"] = opts.OrgID
+	}
+
+	var price *models.ModelPrice
+	if !opts.PriceID.IsZero() {
+		price = &models.ModelPrice{}
+		if err := s.db.Collection("model_prices").FindOne(ctx, bson.M{"_id": opts.PriceID}).Decode(price); err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to load price %s: %w", opts.PriceID.Hex(), err)
+		}
+		filter["model"] = price.Model
+	}
+
+	var card *models.RateCard
+	if opts.RateCardVersion > 0 {
+		if opts.OrgID == "" {
+			return nil, nil, nil, fmt.Errorf("a rate card version requires an organization")
+		}
+		card = &models.RateCard{}
+		err := s.db.Collection("rate_cards").FindOne(ctx, bson.M{"orgId": opts.OrgID, "version": opts.RateCardVersion}).Decode(card)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to load rate card version %d: %w", opts.RateCardVersion, err)
+		}
+	}
+	return filter, price, card, nil
+}
+
+// rewrite stores the new pricing of every changed record in the window.
+// Records already updated by an earlier attempt no longer differ and are skipped.
+func (s *Service) rewrite(ctx context.Context, opts Options, filter bson.M, price *models.ModelPrice, card *models.RateCard, reratingID primitive.ObjectID, calendars map[string]models.BillingCalendar) error {
+	cursor, err := s.db.Collection("token_consumption").Find(ctx, filter)
+	if err != nil {
+		return fmt.Errorf("failed to query consumption: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var writes []mongo.WriteModel
+	for cursor.Next(ctx) {
+		var record models.TokenConsumption
+		if err := cursor.Decode(&record); err != nil {
+			return fmt.Errorf("failed to decode consumption record: %w", err)
+		}
+		calendar, err := s.calendar(ctx, record.OrgID, calendars)
+		if err != nil {
+			return err
+		}
+		if !opts.covers(calendar, record.Timestamp) {
+			continue
+		}
+		quote, err := s.consumptionService.Reprice(ctx, record, price, card)
+		if err != nil {
+			return err
+		}
+		if !changed(record, quote) {
+			continue
+		}
+
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": record.ID}).
+			SetUpdate(repricedUpdate(quote, reratingID)))
+		if len(writes) >= writeBatchSize {
+			if err := s.write(ctx, writes); err != nil {
+				return err
+			}
+			writes = writes[:0]
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("failed to read consumption: %w", err)
+	}
+	return s.write(ctx, writes)
+}
+
+func (s *Service) logCompleted(report *Report) {
+	s.logger.Info("Re-rating completed",
+		zap.String("reratingId", report.ID.Hex()),
+		zap.Bool("applied", report.Applied),
+		zap.Int("records", report.Records),
+		zap.Int("changed", report.Changed),
+		zap.Float64("delta", report.Delta),
+		zap.Float64("adjustments", report.Adjustments))
+}
+
+// changed reports whether a quote differs from a record's stored pricing
+func changed(record models.TokenConsumption, quote consumption.Quote) bool {
+	return math.Abs(record.Cost-quote.Cost) > 1e-9 ||
+		record.PriceID != quote.PriceID ||
+		record.RateCardID != quote.RateCardID ||
+		record.RateCardVersion != quote.RateCardVersion ||
+		record.Unpriced != quote.Unpriced
+}
+
+// repricedUpdate sets a record's pricing fields from quote, unsetting those
+// the quote leaves empty
+func repricedUpdate(quote consumption.Quote, reratingID primitive.ObjectID) bson.M {
+	set := bson.M{
+		"cost":       quote.Cost,
+		"reratingId": reratingID,
+		"reratedAt":  time.Now(),
+	}
+	unset := bson.M{}
+	setOrUnset := func(field string, value interface{}, empty bool) {
+		if empty {
+			unset[field] = ""
+		} else {
+			set[field] = value
+		}
+	}
+	setOrUnset("priceId", quote.PriceID, quote.PriceID.IsZero())
+	setOrUnset("rateCardId", quote.RateCardID, quote.RateCardID.IsZero())
+	setOrUnset("rateCardVersion", quote.RateCardVersion, quote.RateCardVersion == 0)
+	setOrUnset("tierCharges", quote.TierCharges, len(quote.TierCharges) == 0)
+	setOrUnset("costByClass", quote.CostByClass, quote.Unpriced)
+	setOrUnset("unpriced", true, !quote.Unpriced)
+
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	return update
+}
+
+func (s *Service) write(ctx context.Context, writes []mongo.WriteModel) error {
+	if len(writes) == 0 {
+		return nil
+	}
+	if _, err := s.db.Collection("token_consumption").BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false)); err != nil {
+		return fmt.Errorf("failed to update re-rated records: %w", err)
+	}
+	return nil
+}
+
+// isBilled reports whether daily billing has already charged the organization for the day
+func (s *Service) isBilled(ctx context.Context, orgID string, date time.Time) (bool, error) {
+	count, err := s.db.Collection("billing_history").CountDocuments(ctx, bson.M{
+		"organizationId": orgID,
+		"periodStart":    date,
+		"status":         "completed",
+		"type":           bson.M{"$exists": false},
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to check billing history: %w", err)
+	}
+	return count > 0, nil
+}
+
+// adjustWallet charges (or credits) the billable difference for a billed day
+// and records it as an adjustment entry in billing_history. An adjustment
+// already posted by an earlier attempt of the run is not posted again.
+func (s *Service) adjustWallet(ctx context.Context, reratingID primitive.ObjectID, day *DayDiff) error {
+	// The wallet change and its billing_history entry commit together
+	err := s.ledger.Transact(ctx, func(ctx context.Context) error {
+		entry, applied, err := s.ledger.Post(ctx, models.LedgerEntry{
+			OrgID:       day.OrgID,
+			Type:        models.LedgerAdjustment,
+			Amount:      -day.BillableDelta,
+			Reference:   fmt.Sprintf("rerating:%s:%s:%s", reratingID.Hex(), day.OrgID, day.Date.Format("2006-01-02")),
+			Description: fmt.Sprintf("Re-rating of %s", day.Date.Format("2006-01-02")),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to adjust wallet of %s: %w", day.OrgID, err)
+		}
+		if !applied {
+			// Without transactions the earlier attempt may have stopped before
+			// recording the adjustment in billing history
+			count, err := s.db.Collection("billing_history").CountDocuments(ctx, bson.M{
+				"organizationId": day.OrgID,
+				"periodStart":    day.Date,
+				"type":           models.BillingTypeAdjustment,
+				"reratingId":     reratingID,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to check billing history: %w", err)
+			}
+			if count > 0 {
+				return nil
+			}
+		}
+
+		adjustment := models.BillingHistory{
+			ID:                  primitive.NewObjectID(),
+			OrganizationID:      day.OrgID,
+			BillingDate:         time.Now(),
+			PeriodStart:         day.Date,
+			PeriodEnd:           day.Date.AddDate(0, 0, 1),
+			TotalCost:           day.BillableDelta,
+			WalletBalanceBefore: entry.BalanceAfter + day.BillableDelta,
+			WalletBalanceAfter:  entry.BalanceAfter,
+			Status:              "completed",
+			Type:                models.BillingTypeAdjustment,
+			ReratingID:          reratingID,
+			CreatedAt:           time.Now(),
+		}
+		if _, err := s.db.Collection("billing_history").InsertOne(ctx, adjustment); err != nil {
+			return fmt.Errorf("failed to record adjustment for %s: %w", day.OrgID, err)
+		}
+		return nil
+	})
+	if errors.Is(err, ledger.ErrDuplicateReference) {
+		// Posted with its billing history entry in an earlier transaction
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+
+	day.Adjustment = day.BillableDelta
+	return nil
+}
+
+// calendar returns an organization's billing calendar, caching it in calendars
+func (s *Service) calendar(ctx context.Context, orgID string, calendars map[string]models.BillingCalendar) (models.BillingCalendar, error) {
+	if calendar, ok := calendars[orgID]; ok {
+		return calendar, nil
+	}
+	var org models.Organization
+	err := s.db.Collection("organizations").FindOne(ctx, bson.M{"orgId": orgID}).Decode(&org)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return models.BillingCalendar{}, fmt.Errorf("failed to load organization %s: %w", orgID, err)
+	}
+	calendars[orgID] = org.Calendar()
+	return calendars[orgID], nil
+}
+
+// rebuildAggregates recomputes the daily and monthly aggregates of changed
+// days that the scheduled aggregation has already produced
+func (s *Service) rebuildAggregates(ctx context.Context, days []DayDiff, calendars map[string]models.BillingCalendar) {
+	now := time.Now()
+
+	months := make(map[dayKey]bool)
+	for _, day := range days {
+		if day.Changed == 0 {
+			continue
+		}
+		calendar := calendars[day.OrgID]
+		if day.Date.Before(calendar.DayStart(now)) {
+			if err := s.aggregationService.AggregateDay(ctx, day.Date, day.OrgID); err != nil {
+				s.logger.Warn("Failed to rebuild daily aggregate", zap.String("orgId", day.OrgID), zap.Time("date", day.Date), zap.Error(err))
+			}
+		}
+		month := calendar.CycleStart(day.Date)
+		if month.Before(calendar.CycleStart(now)) {
+			months[dayKey{orgID: day.OrgID, date: month}] = true
+		}
+	}
+
+	for key := range months {
+		if err := s.aggregationService.AggregateMonth(ctx, key.date, key.orgID); err != nil {
+			s.logger.Warn("Failed to rebuild monthly aggregate", zap.String("orgId", key.orgID), zap.Time("month", key.date), zap.Error(err))
+		}
+	}
+}
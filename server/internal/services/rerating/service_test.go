@@ -0,0 +1,162 @@
+package rerating
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"freedom-ai/management-server/internal/config"
+	"freedom-ai/management-server/internal/database/databasetest"
+	"freedom-ai/management-server/internal/models"
+	"freedom-ai/management-server/internal/services/aggregation"
+	"freedom-ai/management-server/internal/services/consumption"
+	"freedom-ai/management-server/internal/services/ledger"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+func TestResumePostsEachAdjustmentOnce(t *testing.T) {
+	ctx := context.Background()
+	db := databasetest.Connect(t)
+	logger := zap.NewNop()
+	if err := ledger.NewService(db, logger).EnsureIndexes(ctx); err != nil {
+		t.Fatalf("EnsureIndexes: %v", err)
+	}
+
+	day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	price := models.ModelPrice{
+		ID:                   primitive.NewObjectID(),
+		Model:                "gpt-4",
+		PromptPricePer1K:     0.02,
+		CompletionPricePer1K: 0.02,
+		EffectiveFrom:        time.Unix(0, 0).UTC(),
+	}
+	docs := map[string]interface{}{
+		"organizations": bson.M{"orgId": "org-1", "walletBalance": 100.0},
+		"model_prices":  price,
+		"billing_history": models.BillingHistory{
+			ID:             primitive.NewObjectID(),
+			OrganizationID: "org-1",
+			PeriodStart:    day,
+			PeriodEnd:      day.AddDate(0, 0, 1),
+			TotalCost:      0.01,
+			Status:         "completed",
+		},
+		"token_consumption": models.TokenConsumption{
+			ID:               primitive.NewObjectID(),
+			RequestID:        "req-1",
+			Timestamp:        day.Add(time.Hour),
+			OrgID:            "org-1",
+			Model:            "gpt-4",
+			PromptTokens:     500,
+			CompletionTokens: 500,
+			TotalTokens:      1000,
+			Cost:             0.01,
+			Status:           "complete",
+		},
+	}
+	for collection, doc := range docs {
+		if _, err := db.Collection(collection).InsertOne(ctx, doc); err != nil {
+			t.Fatalf("insert into %s: %v", collection, err)
+		}
+	}
+
+	s := NewService(db, consumption.NewService(db, &config.Config{}, logger), aggregation.NewService(db, logger), logger)
+	report, err := s.Run(ctx, Options{OrgID: "org-1", From: day, To: day.AddDate(0, 0, 1), PriceID: price.ID, Apply: true})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if report.Status != RunCompleted || math.Abs(report.Adjustments-0.01) > 1e-9 {
+		t.Fatalf("report = %+v, want a completed run adjusting 0.01", report)
+	}
+
+	if _, err := s.Resume(ctx, report.ID); !errors.Is(err, ErrRunNotPending) {
+		t.Fatalf("Resume of a completed run: %v, want ErrRunNotPending", err)
+	}
+
+	// Resuming a run interrupted after its adjustments must not post them again
+	if _, err := db.Collection("rerating_runs").UpdateOne(ctx, bson.M{"_id": report.ID}, bson.M{"$set": bson.M{"status": RunPending}}); err != nil {
+		t.Fatalf("reset run: %v", err)
+	}
+	resumed, err := s.Resume(ctx, report.ID)
+	if err != nil {
+		t.Fatalf("Resume: %v", err)
+	}
+	if resumed.Status != RunCompleted || math.Abs(resumed.Adjustments-0.01) > 1e-9 {
+		t.Fatalf("resumed report = %+v, want a completed run adjusting 0.01", resumed)
+	}
+
+	entries, err := db.Collection("wallet_ledger").CountDocuments(ctx, bson.M{"orgId": "org-1"})
+	if err != nil {
+		t.Fatalf("count ledger entries: %v", err)
+	}
+	adjustments, err := db.Collection("billing_history").CountDocuments(ctx, bson.M{"reratingId": report.ID})
+	if err != nil {
+		t.Fatalf("count adjustments: %v", err)
+	}
+	if entries != 1 || adjustments != 1 {
+		t.Fatalf("%d ledger entries and %d billing adjustments, want 1 each", entries, adjustments)
+	}
+
+	var org models.Organization
+	if err := db.Collection("organizations").FindOne(ctx, bson.M{"orgId": "org-1"}).Decode(&org); err != nil {
+		t.Fatalf("load organization: %v", err)
+	}
+	if math.Abs(org.WalletBalance-99.99) > 1e-9 {
+		t.Fatalf("wallet balance = %v, want 99.99", org.WalletBalance)
+	}
+}
+
+func TestWindowFollowsOrganizationCalendar(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("timezone data unavailable: %v", err)
+	}
+	calendar := models.BillingCalendar{Location: berlin, AnchorDay: 1}
+	opts := Options{
+		From: time.Date(2026, 5, 1, 0, 0, 0, 0, time.UTC),
+		To:   time.Date(2026, 5, 2, 0, 0, 0, 0, time.UTC),
+	}
+
+	tests := []struct {
+		at       time.Time
+		inBerlin bool
+		inUTC    bool
+	}{
+		// 22:30 UTC on April 30 is already May 1 in Berlin
+		{time.Date(2026, 4, 30, 22, 30, 0, 0, time.UTC), true, false},
+		{time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC), true, true},
+		// 22:30 UTC on May 1 is May 2 in Berlin
+		{time.Date(2026, 5, 1, 22, 30, 0, 0, time.UTC), false, true},
+	}
+	for _, tt := range tests {
+		if got := opts.covers(calendar, tt.at); got != tt.inBerlin {
+			t.Errorf("covers(Berlin, %s) = %v, want %v", tt.at.Format(time.RFC3339), got, tt.inBerlin)
+		}
+		if got := opts.covers(models.UTCCalendar, tt.at); got != tt.inUTC {
+			t.Errorf("covers(UTC, %s) = %v, want %v", tt.at.Format(time.RFC3339), got, tt.inUTC)
+		}
+		// The query window must include every record a calendar covers
+		if tt.at.Before(opts.From.Add(-maxUTCOffset)) || !tt.at.Before(opts.To.Add(maxUTCOffset)) {
+			t.Errorf("%s is outside the query window", tt.at.Format(time.RFC3339))
+		}
+	}
+}
+
+func TestRunRejectsPartialDays(t *testing.T) {
+	s := &Service{logger: zap.NewNop()}
+	day := time.Date(2026, 5, 1, 0, 0, 0, 0, time.UTC)
+	for _, opts := range []Options{
+		{From: day.Add(2 * time.Hour), To: day.AddDate(0, 0, 1)},
+		{From: day, To: day},
+		{From: day.AddDate(0, 0, 1), To: day},
+	} {
+		if _, err := s.Run(context.Background(), opts); !errors.Is(err, ErrInvalidWindow) {
+			t.Fatalf("Run(%s to %s) = %v, want ErrInvalidWindow", opts.From, opts.To, err)
+		}
+	}
+}
@@ -4,13 +4,17 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"math"
 	"time"
 
 	"freedom-ai/management-server/internal/config"
 	"freedom-ai/management-server/internal/models"
+	"freedom-ai/management-server/internal/services/dunning"
+	"freedom-ai/management-server/internal/services/ledger"
 
 	"github.com/stripe/stripe-go/v78"
 	"github.com/stripe/stripe-go/v78/checkout/session"
+	"github.com/stripe/stripe-go/v78/refund"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -18,9 +22,11 @@ import (
 )
 
 type Service struct {
-	config *config.Config
-	db     *mongo.Database
-	logger *zap.Logger
+	config  *config.Config
+	db      *mongo.Database
+	logger  *zap.Logger
+	ledger  *ledger.Service
+	dunning *dunning.Service
 }
 
 func NewService(cfg *config.Config, db *mongo.Database, logger *zap.Logger) *Service {
@@ -29,9 +35,15 @@ func NewService(cfg *config.Config, db *mongo.Database, logger *zap.Logger) *Ser
 		config: cfg,
 		db:     db,
 		logger: logger,
+		ledger: ledger.NewService(db, logger),
 	}
 }
 
+// SetDunningService sets the dunning service that reactivates organizations after top-ups
+func (s *Service) SetDunningService(dunningService *dunning.Service) {
+	s.dunning = dunningService
+}
+
 // CreateCheckoutSession creates a Stripe checkout session for wallet top-up
 func (s *Service) CreateCheckoutSession(ctx context.Context, orgID string, amount float64, successURL, cancelURL string) (*stripe.CheckoutSession, error) {
 	params := &stripe.CheckoutSessionParams{
@@ -65,11 +77,11 @@ func (s *Service) CreateCheckoutSession(ctx context.Context, orgID string, amoun
 
 	// Create top-up transaction record
 	topUp := models.TopUpTransaction{
-		ID:                  primitive.NewObjectID(),
-		OrganizationID:      orgID,
-		Amount:              amount,
+		ID:                    primitive.NewObjectID(),
+		OrganizationID:        orgID,
+		Amount:                amount,
 		StripePaymentIntentID: sess.PaymentIntent.ID,
-		Status:              "pending",
+		Status:                "pending",
 	}
 
 	collection := s.db.Collection("top_up_transactions")
@@ -129,28 +141,54 @@ func (s *Service) processSuccessfulPayment(ctx context.Context, orgID, paymentIn
 		s.logger.Warn("Failed to update top-up transaction", zap.Error(err))
 	}
 
-	// Update organization wallet balance
-	orgCollection := s.db.Collection("organizations")
-	_, err = orgCollection.UpdateOne(
-		ctx,
-		bson.M{"orgId": orgID},
-		bson.M{
-			"$inc": bson.M{"walletBalance": amount},
-			"$set":  bson.M{"updatedAt": time.Now()},
-		},
-	)
+	// Credit the wallet; a payment intent is credited at most once
+	_, applied, err := s.ledger.Post(ctx, models.LedgerEntry{
+		OrgID:       orgID,
+		Type:        models.LedgerTopUpCredit,
+		Amount:      amount,
+		Reference:   "stripe:" + paymentIntentID,
+		Description: "Top-up",
+	})
 	if err != nil {
 		return fmt.Errorf("failed to update wallet balance: %w", err)
 	}
+	if !applied {
+		s.logger.Info("Payment already credited", zap.String("paymentIntentId", paymentIntentID))
+		return nil
+	}
 
 	s.logger.Info("Processed successful payment",
 		zap.String("orgId", orgID),
 		zap.String("paymentIntentId", paymentIntentID),
 		zap.Float64("amount", amount))
 
+	if s.dunning != nil {
+		if err := s.dunning.Evaluate(ctx, orgID); err != nil {
+			s.logger.Warn("Failed to update dunning after payment", zap.String("orgId", orgID), zap.Error(err))
+		}
+	}
+
 	return nil
 }
 
+// RefundPayment refunds amount of a payment intent and returns the Stripe
+// refund ID. The idempotency key makes a retried refund return the original.
+func (s *Service) RefundPayment(ctx context.Context, paymentIntentID string, amount float64, idempotencyKey string, metadata map[string]string) (string, error) {
+	params := &stripe.RefundParams{
+		PaymentIntent: stripe.String(paymentIntentID),
+		Amount:        stripe.Int64(int64(math.Round(amount * 100))), // Convert to cents
+		Metadata:      metadata,
+	}
+	params.Context = ctx
+	params.SetIdempotencyKey(idempotencyKey)
+
+	r, err := refund.New(params)
+	if err != nil {
+		return "", fmt.Errorf("failed to create refund: %w", err)
+	}
+	return r.ID, nil
+}
+
 func (s *Service) processFailedPayment(ctx context.Context, paymentIntentID string) error {
 	collection := s.db.Collection("top_up_transactions")
 	_, err := collection.UpdateOne(
@@ -164,4 +202,3 @@ func (s *Service) processFailedPayment(ctx context.Context, paymentIntentID stri
 	)
 	return err
 }
-
@@ -7,19 +7,33 @@ import (
 	"os"
 	"os/signal"
 	"time"
+	_ "time/tzdata" // organization timezones without system zoneinfo
 
+	"freedom-ai/management-server/internal/archive"
 	"freedom-ai/management-server/internal/config"
 	"freedom-ai/management-server/internal/database"
+	"freedom-ai/management-server/internal/eventsource"
+	"freedom-ai/management-server/internal/kafka"
 	"freedom-ai/management-server/internal/lib/supertokens"
 	"freedom-ai/management-server/internal/middleware"
+	"freedom-ai/management-server/internal/nats"
 	"freedom-ai/management-server/internal/rabbitmq"
 	"freedom-ai/management-server/internal/redis"
 	"freedom-ai/management-server/internal/routes"
+	"freedom-ai/management-server/internal/services/adjustment"
 	"freedom-ai/management-server/internal/services/aggregation"
 	"freedom-ai/management-server/internal/services/autotopup"
 	"freedom-ai/management-server/internal/services/billing"
 	"freedom-ai/management-server/internal/services/consumption"
+	"freedom-ai/management-server/internal/services/credits"
+	"freedom-ai/management-server/internal/services/dunning"
 	"freedom-ai/management-server/internal/services/email"
+	"freedom-ai/management-server/internal/services/ingest"
+	"freedom-ai/management-server/internal/services/invoice"
+	"freedom-ai/management-server/internal/services/ledger"
+	"freedom-ai/management-server/internal/services/privacy"
+	"freedom-ai/management-server/internal/services/reconciliation"
+	"freedom-ai/management-server/internal/services/stripe"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -83,41 +97,140 @@ func main() {
 
 	// Initialize services
 	consumptionService := consumption.NewService(db.Database, cfg, logger)
+	if err := consumptionService.EnsureIndexes(context.Background()); err != nil {
+		logger.Warn("Failed to ensure consumption indexes", zap.Error(err))
+	}
+	if err := consumptionService.SeedPriceCatalog(context.Background()); err != nil {
+		logger.Warn("Failed to prepare model price catalog", zap.Error(err))
+	}
+	if err := consumptionService.SeedModelAliases(context.Background()); err != nil {
+		logger.Warn("Failed to seed model aliases", zap.Error(err))
+	}
+	ledgerService := ledger.NewService(db.Database, logger)
+	if err := ledgerService.EnsureIndexes(context.Background()); err != nil {
+		logger.Warn("Failed to ensure ledger indexes", zap.Error(err))
+	}
+	if err := ledgerService.RecordOpeningBalances(context.Background()); err != nil {
+		logger.Warn("Failed to record opening wallet balances", zap.Error(err))
+	}
 	billingService := billing.NewService(db.Database, logger)
+	if err := billingService.EnsureIndexes(context.Background()); err != nil {
+		logger.Warn("Failed to ensure billing run indexes", zap.Error(err))
+	}
+	invoiceService := invoice.NewService(db.Database, logger)
+	if err := invoiceService.EnsureIndexes(context.Background()); err != nil {
+		logger.Warn("Failed to ensure invoice indexes", zap.Error(err))
+	}
 	emailService := email.NewService(cfg, logger)
-	
+
 	// Initialize real-time consumption service and connect to consumption service
 	realtimeService := consumption.NewRealtimeService(rdb, logger)
 	consumptionService.SetRealtimeService(realtimeService)
-	
+
 	// Set email service for billing and auto-top-up
 	billingService.SetEmailService(emailService)
 
-	// Initialize RabbitMQ consumer (if configured)
+	// Dunning moves organizations with a negative balance towards suspension
+	dunningService := dunning.NewService(db.Database, logger)
+	if err := dunningService.EnsureIndexes(context.Background()); err != nil {
+		logger.Warn("Failed to ensure dunning indexes", zap.Error(err))
+	}
+	dunningService.SetEmailService(emailService)
+
+	// Trial and promotional credit grants
+	creditsService := credits.NewService(db.Database, logger)
+	if err := creditsService.EnsureIndexes(context.Background()); err != nil {
+		logger.Warn("Failed to ensure credit indexes", zap.Error(err))
+	}
+
+	// Manual adjustments, credit notes and refunds
+	adjustmentService := adjustment.NewService(db.Database, logger)
+	if err := adjustmentService.EnsureIndexes(context.Background()); err != nil {
+		logger.Warn("Failed to ensure credit note indexes", zap.Error(err))
+	}
+	adjustmentService.SetEmailService(emailService)
+	adjustmentService.SetDunningService(dunningService)
+
+	// Stripe top-ups and refunds (if configured)
+	var stripeService *stripe.Service
+	if cfg.StripeSecretKey != "" {
+		stripeService = stripe.NewService(cfg, db.Database, logger)
+		stripeService.SetDunningService(dunningService)
+		adjustmentService.SetStripeService(stripeService)
+	}
+
+	// Prompt and completion content is stripped per each organization's content policy
+	privacyService := privacy.NewService(db.Database, logger)
+
+	// Request/response pairing shared by the event source and HTTP ingestion
+	ingestService := ingest.NewService(rdb, consumptionService, privacyService, logger)
+	if err := middleware.EnsureIngestKeyIndexes(context.Background(), db.Database); err != nil {
+		logger.Warn("Failed to ensure ingest key indexes", zap.Error(err))
+	}
+
+	// Raw event archive (if configured)
+	eventArchive, err := archive.New(cfg, logger)
+	if err != nil {
+		logger.Warn("Failed to initialize event archive", zap.Error(err))
+	}
+	if eventArchive != nil {
+		ingestService.SetArchive(eventArchive)
+		go eventArchive.Run(context.Background())
+		logger.Info("Event archive enabled", zap.String("backend", cfg.ArchiveBackend))
+	}
+
+	// Initialize the event source (if configured)
 	var consumer *rabbitmq.Consumer
-	if cfg.RabbitMQURL != "" {
-		consumer, err = rabbitmq.NewConsumer(cfg, db.Database, rdb, consumptionService, logger)
+	var source eventsource.EventSource
+	switch cfg.EventSource {
+	case "rabbitmq":
+		if cfg.RabbitMQURL == "" {
+			logger.Info("RabbitMQ consumer disabled (RabbitMQ URL not configured)")
+			break
+		}
+		consumer, err = rabbitmq.NewConsumer(cfg, db.Database, logger)
 		if err != nil {
 			logger.Warn("Failed to initialize RabbitMQ consumer", zap.Error(err))
 		} else {
-			ctx, cancel := context.WithCancel(context.Background())
-			defer cancel()
-			go func() {
-				if err := consumer.Start(ctx); err != nil {
-					logger.Error("RabbitMQ consumer error", zap.Error(err))
-				}
-			}()
-			logger.Info("RabbitMQ consumer started")
+			source = consumer
 		}
-	} else {
-		logger.Info("RabbitMQ consumer disabled (RabbitMQ URL not configured)")
+	case "kafka":
+		kafkaSource, err := kafka.NewSource(cfg, logger)
+		if err != nil {
+			logger.Warn("Failed to initialize Kafka consumer", zap.Error(err))
+		} else {
+			source = kafkaSource
+		}
+	case "nats":
+		natsSource, err := nats.NewSource(cfg, logger)
+		if err != nil {
+			logger.Warn("Failed to initialize NATS consumer", zap.Error(err))
+		} else {
+			source = natsSource
+		}
+	case "none", "":
+		logger.Info("Event source disabled")
+	default:
+		logger.Warn("Unknown event source, broker ingestion disabled", zap.String("eventSource", cfg.EventSource))
+	}
+
+	if source != nil {
+		processor := eventsource.NewProcessor(cfg, ingestService, consumptionService, logger)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			if err := processor.Run(ctx, source); err != nil {
+				logger.Error("Event source error", zap.String("source", source.Name()), zap.Error(err))
+			}
+		}()
+		logger.Info("Event source started", zap.String("source", source.Name()))
 	}
 
 	// Set up routes
-	routes.SetupRoutes(router, db.Database, cfg, realtimeService, logger)
+	routes.SetupRoutes(router, db.Database, cfg, realtimeService, ingestService, billingService, invoiceService, dunningService, creditsService, adjustmentService, stripeService, consumer, logger)
 
 	// Start scheduled jobs
-	go startScheduledJobs(cfg, billingService, db.Database, rdb, logger)
+	go startScheduledJobs(cfg, billingService, invoiceService, dunningService, creditsService, consumptionService, db.Database, rdb, logger)
 
 	// Start server
 	srv := &http.Server{
@@ -148,110 +261,96 @@ func main() {
 		logger.Fatal("Server forced to shutdown", zap.Error(err))
 	}
 
-	// Close RabbitMQ connection on shutdown
-	if consumer != nil {
-		if err := consumer.Close(); err != nil {
-			logger.Warn("Error closing RabbitMQ connection", zap.Error(err))
+	// Close the event source connection on shutdown
+	if source != nil {
+		if err := source.Close(); err != nil {
+			logger.Warn("Error closing event source", zap.String("source", source.Name()), zap.Error(err))
 		} else {
-			logger.Info("RabbitMQ connection closed")
+			logger.Info("Event source closed", zap.String("source", source.Name()))
+		}
+	}
+
+	// Write out events still buffered for the archive
+	if eventArchive != nil {
+		if err := eventArchive.Flush(ctx); err != nil {
+			logger.Warn("Failed to flush event archive", zap.Error(err))
 		}
 	}
 
 	logger.Info("Server exited")
 }
 
-func startScheduledJobs(cfg *config.Config, billingService *billing.Service, db *mongo.Database, redis *redis.RedisClient, logger *zap.Logger) {
+func startScheduledJobs(cfg *config.Config, billingService *billing.Service, invoiceService *invoice.Service, dunningService *dunning.Service, creditsService *credits.Service, consumptionService *consumption.Service, db *mongo.Database, redis *redis.RedisClient, logger *zap.Logger) {
 	aggregationService := aggregation.NewService(db, logger)
+	reconciliationService := reconciliation.NewService(cfg, redis, consumptionService, logger)
 	autotopupService := autotopup.NewService(cfg, db, logger)
 	emailService := email.NewService(cfg, logger)
 	autotopupService.SetEmailService(emailService)
-	// Daily billing job (runs at 00:00 UTC)
+	autotopupService.SetDunningService(dunningService)
+	// Billing, aggregation, invoicing and dunning jobs (run hourly, on the
+	// hour). Each organization's day and billing cycle close at its local
+	// midnight, so every run bills, aggregates and invoices the periods that
+	// have closed since the last one, then advances dunning on the new balances.
 	go func() {
-		ticker := time.NewTicker(24 * time.Hour)
-		defer ticker.Stop()
-
-		// Calculate time until next midnight UTC
-		now := time.Now().UTC()
-		midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
-		delay := midnight.Sub(now)
-
-		// Wait until midnight
-		time.Sleep(delay)
-
-		// Run immediately
-		logger.Info("Running daily billing job")
-		if err := billingService.ProcessDailyBilling(context.Background()); err != nil {
-			logger.Error("Daily billing job failed", zap.Error(err))
-		}
-
-		// Then run on schedule
-		for range ticker.C {
+		closePeriods := func() {
 			logger.Info("Running daily billing job")
 			if err := billingService.ProcessDailyBilling(context.Background()); err != nil {
 				logger.Error("Daily billing job failed", zap.Error(err))
 			}
+			// Billing draws on credits before the ones that expired are swept
+			if err := creditsService.SweepExpired(context.Background()); err != nil {
+				logger.Error("Credit expiry sweep failed", zap.Error(err))
+			}
+			if err := aggregationService.AggregateDailyConsumption(context.Background()); err != nil {
+				logger.Error("Daily aggregation job failed", zap.Error(err))
+			}
+			if err := aggregationService.AggregateMonthlyConsumption(context.Background()); err != nil {
+				logger.Error("Monthly aggregation job failed", zap.Error(err))
+			}
+			if err := invoiceService.GenerateDueInvoices(context.Background()); err != nil {
+				logger.Error("Invoice generation job failed", zap.Error(err))
+			}
+			if err := dunningService.ProcessDunning(context.Background()); err != nil {
+				logger.Error("Dunning job failed", zap.Error(err))
+			}
 		}
-	}()
-
-	// Daily aggregation job (runs at 01:00 UTC)
-	go func() {
-		ticker := time.NewTicker(24 * time.Hour)
-		defer ticker.Stop()
 
-		// Calculate time until next 01:00 UTC
-		now := time.Now().UTC()
-		nextRun := time.Date(now.Year(), now.Month(), now.Day()+1, 1, 0, 0, 0, time.UTC)
-		delay := nextRun.Sub(now)
+		// Catch up on periods missed while the server was down
+		closePeriods()
 
-		time.Sleep(delay)
+		// Wait until the next full hour
+		now := time.Now()
+		time.Sleep(now.Truncate(time.Hour).Add(time.Hour).Sub(now))
+		closePeriods()
 
-		// Run immediately
-		logger.Info("Running daily aggregation job")
-		if err := aggregationService.AggregateDailyConsumption(context.Background()); err != nil {
-			logger.Error("Daily aggregation job failed", zap.Error(err))
-		}
-
-		// Then run on schedule
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
 		for range ticker.C {
-			logger.Info("Running daily aggregation job")
-			if err := aggregationService.AggregateDailyConsumption(context.Background()); err != nil {
-				logger.Error("Daily aggregation job failed", zap.Error(err))
-			}
+			closePeriods()
 		}
 	}()
 
-	// Monthly aggregation job (runs on first day of each month at 02:00 UTC)
+	// Orphaned request sweep (runs every 5 minutes)
 	go func() {
-		ticker := time.NewTicker(24 * time.Hour)
+		ticker := time.NewTicker(5 * time.Minute)
 		defer ticker.Stop()
 
-		// Calculate time until next 02:00 UTC on first of month
-		now := time.Now().UTC()
-		var nextRun time.Time
-		if now.Day() == 1 && now.Hour() < 2 {
-			nextRun = time.Date(now.Year(), now.Month(), 1, 2, 0, 0, 0, time.UTC)
-		} else {
-			nextMonth := now.AddDate(0, 1, 0)
-			nextRun = time.Date(nextMonth.Year(), nextMonth.Month(), 1, 2, 0, 0, 0, time.UTC)
+		for range ticker.C {
+			if err := reconciliationService.SweepOrphanedRequests(context.Background()); err != nil {
+				logger.Error("Orphaned request sweep failed", zap.Error(err))
+			}
 		}
-		delay := nextRun.Sub(now)
-
-		time.Sleep(delay)
+	}()
 
-		// Run immediately
-		logger.Info("Running monthly aggregation job")
-		if err := aggregationService.AggregateMonthlyConsumption(context.Background()); err != nil {
-			logger.Error("Monthly aggregation job failed", zap.Error(err))
-		}
+	// Ledger consistency check (runs hourly)
+	go func() {
+		ledgerService := ledger.NewService(db, logger)
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
 
-		// Then check daily if it's the first of the month
 		for range ticker.C {
-			now := time.Now().UTC()
-			if now.Day() == 1 && now.Hour() == 2 {
-				logger.Info("Running monthly aggregation job")
-				if err := aggregationService.AggregateMonthlyConsumption(context.Background()); err != nil {
-					logger.Error("Monthly aggregation job failed", zap.Error(err))
-				}
+			if _, err := ledgerService.CheckConsistency(context.Background()); err != nil {
+				logger.Error("Ledger consistency check failed", zap.Error(err))
 			}
 		}
 	}()
@@ -276,4 +375,3 @@ func startScheduledJobs(cfg *config.Config, billingService *billing.Service, db
 		}
 	}()
 }
-